@@ -0,0 +1,120 @@
+// Package alert reports problems detected by collectors, e.g. sessions blocking others,
+// to the log and optionally to a webhook
+package alert
+
+import (
+	"bytes"
+	"context"
+	"elmon/logger"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Config defines alert delivery
+type Config struct {
+	WebhookURL     string            // JSON POST target, empty logs alerts only
+	Headers        map[string]string // e.g. authentication headers
+	RepeatInterval time.Duration     // an active alert is sent again after this time
+}
+
+// Alert is a single problem found by a collector
+type Alert struct {
+	Name         string                 `json:"name"` // e.g. blocking_session
+	Key          string                 `json:"key"`  // tells apart alerts of the same name, e.g. pid of the session
+	Severity     string                 `json:"severity"`
+	ServerName   string                 `json:"server_name"`
+	MetricName   string                 `json:"metric_name"`
+	DatabaseName string                 `json:"database_name,omitempty"`
+	Message      string                 `json:"message"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	Time         time.Time              `json:"time"`
+}
+
+// Notifier delivers alerts. The same alert (name, key, server, database) is not repeated
+// until RepeatInterval passes, so a problem lasting many collection runs is reported once.
+type Notifier struct {
+	config Config
+	client *http.Client
+	log    *logger.Logger
+
+	mutex sync.Mutex
+	sent  map[string]time.Time // alert identity -> last delivery
+}
+
+func NewNotifier(config Config, log *logger.Logger) *Notifier {
+	return &Notifier{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		log:    log,
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// Fire logs the alert and posts it to the webhook, repeated alerts are skipped silently
+func (n *Notifier) Fire(ctx context.Context, alert Alert) error {
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	identity := alert.Name + "/" + alert.Key + "/" + alert.ServerName + "/" + alert.DatabaseName
+
+	n.mutex.Lock()
+	last, ok := n.sent[identity]
+	if ok && alert.Time.Sub(last) < n.config.RepeatInterval {
+		n.mutex.Unlock()
+		return nil
+	}
+	n.sent[identity] = alert.Time
+	// Forget alerts that are no longer repeated
+	for key, sentAt := range n.sent {
+		if alert.Time.Sub(sentAt) > 2*n.config.RepeatInterval {
+			delete(n.sent, key)
+		}
+	}
+	n.mutex.Unlock()
+
+	n.log.Warn("Alert: "+alert.Message, "alert", alert.Name, "alert_key", alert.Key, "severity", alert.Severity,
+		"server_name", alert.ServerName, "metric_name", alert.MetricName)
+	if n.config.WebhookURL == "" {
+		return nil
+	}
+
+	if err := n.post(ctx, alert); err != nil {
+		// Let the next run deliver it
+		n.mutex.Lock()
+		delete(n.sent, identity)
+		n.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// post sends the alert as JSON document
+func (n *Notifier) post(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to serialize alert: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alert request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range n.config.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send alert to webhook: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("alert webhook returned status %d: %s", response.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
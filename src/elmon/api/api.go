@@ -0,0 +1,134 @@
+// Package api is the HTTP API of the collector
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"elmon/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Errors returned by API handlers are mapped to HTTP status codes
+var (
+	ErrInvalid     = errors.New("invalid request")    // 400
+	ErrNotFound    = errors.New("not found")          // 404
+	ErrConflict    = errors.New("conflict")           // 409
+	ErrUnreachable = errors.New("server unreachable") // 502
+)
+
+// maxRequestSize limits request bodies
+const maxRequestSize = 1 << 20
+
+// Server serves the API on a listen address
+type Server struct {
+	Logger     *logger.Logger
+	httpServer *http.Server
+	mux        *http.ServeMux
+	auth       *Auth // nil without authentication
+}
+
+// NewServer creates the API server, handlers are registered before Start
+func NewServer(listen string, log *logger.Logger) *Server {
+	server := &Server{Logger: log, mux: http.NewServeMux()}
+	server.httpServer = &http.Server{
+		Addr:              listen,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       markSocket,
+	}
+	return server
+}
+
+// Start listens on the address and serves requests in background, listen errors are returned at once
+func (server *Server) Start() error {
+	listener, err := net.Listen("tcp", server.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", server.httpServer.Addr, err)
+	}
+	if server.httpServer.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.httpServer.TLSConfig)
+	}
+	go func() {
+		if err := server.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			server.Logger.Error(err, "API server stopped")
+		}
+	}()
+	server.Logger.Info("API server started", "listen", listener.Addr().String())
+	return nil
+}
+
+// StartSocket serves the API on a unix socket as well, accessible to the owner of the process only, so
+// requests of the socket need no authentication. A socket left by a previous process is replaced.
+func (server *Server) StartSocket(path string) error {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict access to control socket %s: %w", path, err)
+	}
+	go func() {
+		if err := server.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			server.Logger.Error(err, "API control socket stopped")
+		}
+	}()
+	server.Logger.Info("API control socket started", "path", path)
+	return nil
+}
+
+// Stop waits for running requests to finish for up to 10 seconds
+func (server *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.httpServer.Shutdown(ctx); err != nil {
+		server.Logger.Error(err, "Failed to stop API server")
+	}
+}
+
+// writeJSON writes value as JSON response with the status
+func (server *Server) writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		server.Logger.Debug("Failed to write API response", "error", err)
+	}
+}
+
+// writeError writes {"error": "..."} response with the status of the error
+func (server *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, ErrUnreachable):
+		status = http.StatusBadGateway
+	}
+	if status == http.StatusInternalServerError {
+		server.Logger.Error(err, "API request failed", "method", r.Method, "path", r.URL.Path)
+	}
+	server.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readJSON decodes request body, unknown fields are rejected
+func readJSON(r *http.Request, target interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("%w: invalid JSON body: %w", ErrInvalid, err)
+	}
+	return nil
+}
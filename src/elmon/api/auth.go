@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"iter"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Roles of API clients
+const (
+	RoleRead     = "read"     // GET endpoints
+	RoleOperator = "operator" // every endpoint, e.g. adding, removing and pausing servers
+)
+
+// Identity is an authenticated API client
+type Identity struct {
+	Name string
+	Role string
+}
+
+// Auth authenticates API clients by bearer token or by the common name of a verified client certificate
+type Auth struct {
+	Tokens  map[string]Identity // bearer token -> client
+	Clients map[string]Identity // certificate common name -> client
+}
+
+// socketKey marks requests of the control socket, which is accessible to the owner of the process only
+type socketKey struct{}
+
+// socketIdentity is the client of the control socket, it needs no credentials
+var socketIdentity = Identity{Name: "control-socket", Role: RoleOperator}
+
+// anonymous is the client of every request when authentication is off
+var anonymous = Identity{Name: "anonymous", Role: RoleOperator}
+
+// RequireAuth makes every endpoint except /healthz, the web UI page and POST /api/push, which has tokens of its
+// own, require a client of a role allowing the request: GET requests need read, others operator.
+func (server *Server) RequireAuth(auth *Auth) {
+	server.auth = auth
+}
+
+// SetTLS serves the listen address over HTTPS. With clientCAFile client certificates signed by the CA are
+// verified and identify clients, clients without a certificate may still use tokens.
+func (server *Server) SetTLS(certFile string, keyFile string, clientCAFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read API client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in API client CA file %s", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	server.httpServer.TLSConfig = tlsConfig
+	return nil
+}
+
+// ServeHTTP authorizes requests and logs control actions, requests changing state, as an audit trail
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isPublic(r) {
+		server.mux.ServeHTTP(w, r)
+		return
+	}
+	identity, ok := server.identify(r)
+	if !ok {
+		server.Logger.Warn("API request rejected, client is not authenticated", "method", r.Method, "path", r.URL.Path,
+			"remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="elmon"`)
+		server.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+		return
+	}
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if !readOnly && identity.Role != RoleOperator {
+		server.Logger.Warn("API control action denied", "client", identity.Name, "role", identity.Role,
+			"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		server.writeJSON(w, http.StatusForbidden, map[string]string{
+			"error": fmt.Sprintf("role %s of client '%s' doesn't allow %s requests", identity.Role, identity.Name, r.Method),
+		})
+		return
+	}
+	if readOnly {
+		server.mux.ServeHTTP(w, r)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	server.mux.ServeHTTP(recorder, r)
+	server.Logger.Info("API control action", "client", identity.Name, "role", identity.Role, "method", r.Method,
+		"path", r.URL.Path, "status", recorder.status, "remote_addr", r.RemoteAddr)
+}
+
+// identify returns the client of the request, a client certificate is preferred over a token
+func (server *Server) identify(r *http.Request) (Identity, bool) {
+	if server.auth == nil {
+		return anonymous, true
+	}
+	if socket, _ := r.Context().Value(socketKey{}).(bool); socket {
+		return socketIdentity, true
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if identity, ok := server.auth.Clients[r.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return identity, true
+		}
+	}
+	if token, ok := matchToken(r, maps.Keys(server.auth.Tokens)); ok {
+		return server.auth.Tokens[token], true
+	}
+	return Identity{}, false
+}
+
+// isPublic reports whether the request needs no client: probes, the web UI page and pushes
+func isPublic(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/ui/", "/":
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	case "/api/push":
+		return true
+	}
+	return false
+}
+
+// matchToken returns the accepted token sent as "Authorization: Bearer <token>". Every token is compared in
+// constant time, so response times don't tell how much of a token matched.
+func matchToken(r *http.Request, accepted iter.Seq[string]) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	matched, found := "", false
+	for candidate := range accepted {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			matched, found = candidate, true
+		}
+	}
+	return matched, found
+}
+
+// markSocket marks connections of the control socket in request contexts
+func markSocket(ctx context.Context, conn net.Conn) context.Context {
+	if conn.LocalAddr().Network() == "unix" {
+		return context.WithValue(ctx, socketKey{}, true)
+	}
+	return ctx
+}
+
+// statusRecorder keeps the status of a response for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Health is returned by GET /healthz
+type Health struct {
+	Status    string          `json:"status"` // "ok", or "unavailable" while the metrics DB is down
+	MetricsDB MetricsDBHealth `json:"metrics_db"`
+}
+
+// MetricsDBHealth is the metrics DB connectivity found by the background ping
+type MetricsDBHealth struct {
+	Up        bool       `json:"up"`
+	Since     *time.Time `json:"since,omitempty"`      // start of the current state, empty without health checks
+	LastError string     `json:"last_error,omitempty"` // error of the last failed ping
+	Outages   uint64     `json:"outages"`              // times the metrics DB went down since the start
+}
+
+// HealthChecker reports the health of the collector
+type HealthChecker interface {
+	Health() Health
+}
+
+// HandleHealth registers GET /healthz, answered with 503 while the metrics DB is down
+func (server *Server) HandleHealth(checker HealthChecker) {
+	server.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		health := checker.Health()
+		status := http.StatusOK
+		if !health.MetricsDB.Up {
+			status = http.StatusServiceUnavailable
+		}
+		server.writeJSON(w, status, health)
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Instance is an elmon instance registered in the metrics DB, returned by GET /api/instances
+type Instance struct {
+	Instance          string     `json:"instance"`
+	Hostname          string     `json:"hostname"`
+	PID               int        `json:"pid"`
+	Version           string     `json:"version"`
+	Shard             *int       `json:"shard"` // null without sharding
+	Shards            int        `json:"shards"`
+	Leader            bool       `json:"leader"`
+	Collecting        bool       `json:"collecting"`
+	Servers           []string   `json:"servers"`
+	StartedAt         time.Time  `json:"started_at"`
+	HeartbeatAt       time.Time  `json:"heartbeat_at"`
+	HeartbeatInterval string     `json:"heartbeat_interval"`
+	StoppedAt         *time.Time `json:"stopped_at,omitempty"`
+	Alive             bool       `json:"alive"` // not stopped and heartbeats are not missed
+}
+
+// InstanceReader reads instances sharing the metrics DB
+type InstanceReader interface {
+	Instances(ctx context.Context) ([]Instance, error)
+}
+
+// HandleInstances registers GET /api/instances, metrics DB queries are cancelled after timeout
+func (server *Server) HandleInstances(reader InstanceReader, timeout time.Duration) {
+	server.mux.HandleFunc("GET /api/instances", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		instances, err := reader.Instances(ctx)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		if instances == nil {
+			instances = []Instance{}
+		}
+		server.writeJSON(w, http.StatusOK, instances)
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+)
+
+// PushRequest is the body of POST /api/push, values of a server sent by an agent
+type PushRequest struct {
+	Server string        `json:"server"`
+	Values []PushedValue `json:"values"`
+}
+
+// PushedValue is a value of a metric, stored the way a collected value is
+type PushedValue struct {
+	Metric   string          `json:"metric"`
+	Database string          `json:"database,omitempty"` // required by metrics with scope database
+	Time     time.Time       `json:"time"`               // RFC 3339 within push.max-age and push.max-future, the metrics DB clock when omitted
+	Value    json.RawMessage `json:"value"`
+}
+
+// PushResult is the response of POST /api/push
+type PushResult struct {
+	Stored int `json:"stored"`
+}
+
+// PushReceiver stores pushed values. Requests with an unknown server or metric or an invalid value are
+// rejected before any value is stored.
+type PushReceiver interface {
+	Push(ctx context.Context, agent string, request PushRequest) (PushResult, error)
+}
+
+// HandlePush registers POST /api/push. Agents authenticate with "Authorization: Bearer <token>", tokens maps
+// accepted tokens to agent names.
+func (server *Server) HandlePush(receiver PushReceiver, tokens map[string]string) {
+	server.mux.HandleFunc("POST /api/push", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := matchToken(r, maps.Keys(tokens))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="elmon"`)
+			server.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing push token"})
+			return
+		}
+		var request PushRequest
+		if err := readJSON(r, &request); err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		if request.Server == "" || len(request.Values) == 0 {
+			server.writeError(w, r, fmt.Errorf("%w: server and values are required", ErrInvalid))
+			return
+		}
+		result, err := receiver.Push(r.Context(), tokens[token], request)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		server.writeJSON(w, http.StatusOK, result)
+	})
+}
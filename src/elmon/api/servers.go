@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+)
+
+// AddServerRequest is the body of POST /api/servers
+type AddServerRequest struct {
+	Server  map[string]interface{} `json:"server"`  // keys of db-servers entries
+	Profile string                 `json:"profile"` // name from metric-profiles
+	Params  map[string]interface{} `json:"params"`  // override params of the profile
+}
+
+// AddedServer is the response of POST /api/servers
+type AddedServer struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Profile   string `json:"profile"`
+	TaskCount int    `json:"task_count"`
+}
+
+// ServerManager adds and removes monitored servers at runtime
+type ServerManager interface {
+	AddServer(request AddServerRequest) (*AddedServer, error)
+	RemoveServer(name string) error
+}
+
+// HandleServers registers POST /api/servers and DELETE /api/servers/{name}
+func (server *Server) HandleServers(manager ServerManager) {
+	server.mux.HandleFunc("POST /api/servers", func(w http.ResponseWriter, r *http.Request) {
+		var request AddServerRequest
+		if err := readJSON(r, &request); err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		added, err := manager.AddServer(request)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		server.writeJSON(w, http.StatusCreated, added)
+	})
+
+	server.mux.HandleFunc("DELETE /api/servers/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if err := manager.RemoveServer(r.PathValue("name")); err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// PausedServer is the response of POST /api/servers/{name}/pause and /resume
+type PausedServer struct {
+	Name      string `json:"name"`
+	Paused    bool   `json:"paused"`
+	TaskCount int    `json:"task_count"` // schedulers paused or resumed
+}
+
+// ServerPauser pauses and resumes all collections of a monitored server
+type ServerPauser interface {
+	PauseServer(name string) (*PausedServer, error)
+	ResumeServer(name string) (*PausedServer, error)
+}
+
+// HandleServerPause registers POST /api/servers/{name}/pause and POST /api/servers/{name}/resume
+func (server *Server) HandleServerPause(pauser ServerPauser) {
+	server.mux.HandleFunc("POST /api/servers/{name}/pause", func(w http.ResponseWriter, r *http.Request) {
+		paused, err := pauser.PauseServer(r.PathValue("name"))
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		server.writeJSON(w, http.StatusOK, paused)
+	})
+
+	server.mux.HandleFunc("POST /api/servers/{name}/resume", func(w http.ResponseWriter, r *http.Request) {
+		resumed, err := pauser.ResumeServer(r.PathValue("name"))
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		server.writeJSON(w, http.StatusOK, resumed)
+	})
+}
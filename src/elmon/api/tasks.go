@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// TaskState is the runtime state of a scheduled task, returned by GET /api/tasks
+type TaskState struct {
+	Server         string     `json:"server"`
+	Metric         string     `json:"metric"`
+	Database       string     `json:"database,omitempty"`
+	Running        bool       `json:"running"` // the scheduler is started
+	Paused         bool       `json:"paused"`  // the next run is disabled
+	Interval       string     `json:"interval"`
+	ActiveRuns     int        `json:"active_runs"`
+	PendingRuns    int        `json:"pending_runs"`
+	CurrentTaskID  uint64     `json:"current_task_id,omitempty"`
+	SkippedRuns    uint64     `json:"skipped_runs"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMs float64    `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	FailedRuns     int        `json:"failed_runs"` // finished runs failed in a row
+	Panics         uint64     `json:"panics"`      // runs ended by a panic since the start
+	Backoff        bool       `json:"backoff"`     // the interval is stretched after failures
+	NextRun        *time.Time `json:"next_run,omitempty"`
+}
+
+// TaskInspector reports the state of scheduled tasks
+type TaskInspector interface {
+	Tasks() []TaskState
+}
+
+// HandleTasks registers GET /api/tasks, the server and metric query parameters filter tasks by name
+func (server *Server) HandleTasks(inspector TaskInspector) {
+	server.mux.HandleFunc("GET /api/tasks", func(w http.ResponseWriter, r *http.Request) {
+		serverName, metricName := r.URL.Query().Get("server"), r.URL.Query().Get("metric")
+		tasks := []TaskState{}
+		for _, task := range inspector.Tasks() {
+			if (serverName == "" || task.Server == serverName) && (metricName == "" || task.Metric == metricName) {
+				tasks = append(tasks, task)
+			}
+		}
+		server.writeJSON(w, http.StatusOK, tasks)
+	})
+}
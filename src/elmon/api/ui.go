@@ -0,0 +1,21 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the built-in web UI, a single page reading the other endpoints of the API
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// HandleUI registers GET /ui/ serving the web UI, the root redirects to it
+func (server *Server) HandleUI() {
+	server.mux.HandleFunc("GET /ui/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(uiPage)
+	})
+	server.mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits of GET /api/values
+const (
+	defaultValueRange = time.Hour
+	defaultValueLimit = 10000
+	maxValueLimit     = 100000
+)
+
+// MetricValue is a stored metric value, returned by GET /api/values and GET /api/values/latest
+type MetricValue struct {
+	Time     time.Time       `json:"time"`
+	Metric   string          `json:"metric,omitempty"` // set by GET /api/values/latest
+	Database string          `json:"database,omitempty"`
+	Value    json.RawMessage `json:"value"`
+	Labels   json.RawMessage `json:"labels,omitempty"`
+}
+
+// ValueSeries is the response of GET /api/values
+type ValueSeries struct {
+	Server    string        `json:"server"`
+	Metric    string        `json:"metric"`
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Values    []MetricValue `json:"values"`
+	Truncated bool          `json:"truncated"` // more values than the limit are stored in the range
+}
+
+// ValueQuery selects values of GET /api/values
+type ValueQuery struct {
+	Server   string
+	Metric   string
+	Database string // empty for all databases
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Latest   bool // latest values first
+}
+
+// StoredMetric is a metric registered in metrics DB, returned by GET /api/metrics
+type StoredMetric struct {
+	Name            string  `json:"name"`
+	Group           string  `json:"group"`
+	Description     string  `json:"description,omitempty"`
+	Unit            string  `json:"unit,omitempty"`
+	ValueType       string  `json:"value_type,omitempty"`
+	CollectionType  string  `json:"collection_type,omitempty"`
+	IntervalSeconds float64 `json:"interval_seconds,omitempty"`
+	Active          bool    `json:"active"`
+}
+
+// StoredServer is a server registered in metrics DB, returned by GET /api/servers
+type StoredServer struct {
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	Driver      string `json:"driver"`
+	Timezone    string `json:"timezone,omitempty"`
+	Active      bool   `json:"active"`
+}
+
+// ValueReader reads collected data from metrics DB. Unknown servers and metrics are reported with ErrNotFound.
+type ValueReader interface {
+	Values(ctx context.Context, query ValueQuery) ([]MetricValue, error)
+	LatestValues(ctx context.Context, server string, metric string) ([]MetricValue, error)
+	Metrics(ctx context.Context) ([]StoredMetric, error)
+	Servers(ctx context.Context) ([]StoredServer, error)
+}
+
+// HandleValues registers read-only endpoints of collected data: GET /api/values, GET /api/values/latest,
+// GET /api/metrics and GET /api/servers. Metrics DB queries are cancelled after timeout.
+func (server *Server) HandleValues(reader ValueReader, timeout time.Duration) {
+	server.mux.HandleFunc("GET /api/values", func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseValueQuery(r)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		// One more value than the limit tells whether the range has more
+		limit := query.Limit
+		query.Limit++
+		values, err := reader.Values(ctx, query)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		series := ValueSeries{Server: query.Server, Metric: query.Metric, From: query.From, To: query.To,
+			Values: values}
+		if len(values) > limit {
+			series.Values, series.Truncated = values[:limit], true
+		}
+		if series.Values == nil {
+			series.Values = []MetricValue{}
+		}
+		server.writeJSON(w, http.StatusOK, series)
+	})
+
+	server.mux.HandleFunc("GET /api/values/latest", func(w http.ResponseWriter, r *http.Request) {
+		serverName := r.URL.Query().Get("server")
+		if serverName == "" {
+			server.writeError(w, r, fmt.Errorf("%w: server is required", ErrInvalid))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		values, err := reader.LatestValues(ctx, serverName, r.URL.Query().Get("metric"))
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		if values == nil {
+			values = []MetricValue{}
+		}
+		server.writeJSON(w, http.StatusOK, values)
+	})
+
+	server.mux.HandleFunc("GET /api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		metrics, err := reader.Metrics(ctx)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		if metrics == nil {
+			metrics = []StoredMetric{}
+		}
+		server.writeJSON(w, http.StatusOK, metrics)
+	})
+
+	server.mux.HandleFunc("GET /api/servers", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		servers, err := reader.Servers(ctx)
+		if err != nil {
+			server.writeError(w, r, err)
+			return
+		}
+		if servers == nil {
+			servers = []StoredServer{}
+		}
+		server.writeJSON(w, http.StatusOK, servers)
+	})
+}
+
+// parseValueQuery reads query parameters of GET /api/values: server and metric are required, from and to
+// are RFC 3339 times, the last hour by default. order is asc (default) or desc.
+func parseValueQuery(r *http.Request) (ValueQuery, error) {
+	params := r.URL.Query()
+	query := ValueQuery{
+		Server:   params.Get("server"),
+		Metric:   params.Get("metric"),
+		Database: params.Get("database"),
+		To:       time.Now(),
+		Limit:    defaultValueLimit,
+	}
+	if query.Server == "" || query.Metric == "" {
+		return query, fmt.Errorf("%w: server and metric are required", ErrInvalid)
+	}
+	if to := params.Get("to"); to != "" {
+		parsed, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return query, fmt.Errorf("%w: to must be an RFC 3339 time: %w", ErrInvalid, err)
+		}
+		query.To = parsed
+	}
+	query.From = query.To.Add(-defaultValueRange)
+	if from := params.Get("from"); from != "" {
+		parsed, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return query, fmt.Errorf("%w: from must be an RFC 3339 time: %w", ErrInvalid, err)
+		}
+		query.From = parsed
+	}
+	if !query.From.Before(query.To) {
+		return query, fmt.Errorf("%w: from must be before to", ErrInvalid)
+	}
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Latest = true
+	default:
+		return query, fmt.Errorf("%w: order must be asc or desc", ErrInvalid)
+	}
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 || parsed > maxValueLimit {
+			return query, fmt.Errorf("%w: limit must be a number from 1 to %d", ErrInvalid, maxValueLimit)
+		}
+		query.Limit = parsed
+	}
+	return query, nil
+}
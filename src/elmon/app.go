@@ -0,0 +1,1278 @@
+package main
+
+import (
+	"context"
+	sqldb "database/sql"
+	"elmon/alert"
+	"elmon/collector"
+	"elmon/config"
+	"elmon/expression"
+	"elmon/leader"
+	"elmon/logger"
+	"elmon/scheduler"
+	"elmon/sink"
+	"elmon/spool"
+	"elmon/sql"
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// application holds configuration and runtime dependencies shared by commands
+type application struct {
+	config *config.AppConfig
+	log    *logger.Logger
+	sqlDir string // directory with init scripts
+
+	timescale  bool                // metric_value is a TimescaleDB hypertable
+	spool      *spool.Spool        // values that failed to be stored, nil when spooling is disabled
+	sinks      []sink.Sink         // additional outputs of collected values
+	sinkGroups map[string][]string // sink name -> metric groups sent to it, empty for all
+
+	metricsDB           sql.Storage
+	connections         map[string]*sqldb.DB            // server name -> connection
+	databaseConnections map[string]map[string]*sqldb.DB // server name -> database name -> connection
+
+	// Lookup maps by server/metric name
+	serverParams    map[string]sql.ConnectionParams
+	serverInfos     map[string]*sql.ServerInfo
+	serverVersions  map[string]int                        // server name -> server_version_num, missing when unknown
+	extensions      map[string]map[string]map[string]bool // server name -> database -> installed extensions
+	serverConfigs   map[string]config.DbConnectionConfig
+	metricInfos     map[string]*sql.MetricInfo
+	metricConfigs   map[string]config.Metric
+	metricGroups    map[string]string // metric name -> metric group name
+	disabledMetrics map[string]bool   // metrics disabled by their own or their group's enabled flag
+	serverRoles     map[string]*collector.ServerRole
+	serverBudgets   map[string]*collector.QueryBudget // nil values for servers without a query budget
+	hostPools       map[string]*collector.HostPool    // servers with several hosts
+
+	// Expression metrics and the latest values they read
+	expressions   map[string]*expression.Expression // metric name -> parsed expression
+	cachedMetrics map[string]bool                   // metrics whose values are kept in valueCache
+	valueCache    *collector.ValueCache
+
+	alerts   *alert.Notifier
+	limits   *collector.Limits          // collections and writes running at once over all tasks
+	elector  *leader.Elector            // nil without leader election
+	dbHealth *collector.MetricsDBHealth // nil without metrics DB health checks
+
+	// serversMutex serializes servers added and removed at runtime, e.g. by discovery
+	serversMutex sync.Mutex
+}
+
+// newApplication loads configuration, initializes logger and prepares lookup maps
+func newApplication(opts *commonOptions) (*application, error) {
+	appConfig, err := config.Load(opts.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Without sinks the top level log settings describe the only output
+	logSinks := appConfig.Log.Sinks
+	if len(logSinks) == 0 {
+		logSinks = []config.LogSinkConfig{appConfig.Log.LogSinkConfig}
+	}
+	var sinks []logger.Config
+	for _, sink := range logSinks {
+		sinks = append(sinks, logger.Config{
+			Level:      sink.Level,
+			Format:     sink.Format,
+			FileName:   sink.File,
+			Stderr:     opts.logStderr,
+			MaxSize:    sink.MaxSize,
+			MaxBackups: sink.MaxBackups,
+			MaxAge:     sink.MaxAge,
+			Compress:   sink.Compress,
+		})
+	}
+	log, err := logger.NewWithSinks(sinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	log = log.WithDedup(logger.DedupConfig{
+		Burst:    appConfig.Log.Dedup.Burst,
+		Interval: appConfig.Log.Dedup.Interval.Duration,
+	})
+	slog.SetDefault(log.Logger)
+	log.Info("Logger started")
+
+	app := &application{
+		config:              appConfig,
+		log:                 log,
+		sqlDir:              opts.sqlDir,
+		connections:         make(map[string]*sqldb.DB),
+		databaseConnections: make(map[string]map[string]*sqldb.DB),
+		serverParams:        make(map[string]sql.ConnectionParams),
+		serverVersions:      make(map[string]int),
+		extensions:          make(map[string]map[string]map[string]bool),
+		serverInfos:         make(map[string]*sql.ServerInfo),
+		serverConfigs:       make(map[string]config.DbConnectionConfig),
+		metricInfos:         make(map[string]*sql.MetricInfo),
+		metricConfigs:       make(map[string]config.Metric),
+		metricGroups:        make(map[string]string),
+		disabledMetrics:     make(map[string]bool),
+		sinkGroups:          make(map[string][]string),
+		serverRoles:         make(map[string]*collector.ServerRole),
+		serverBudgets:       make(map[string]*collector.QueryBudget),
+		hostPools:           make(map[string]*collector.HostPool),
+		expressions:         make(map[string]*expression.Expression),
+		cachedMetrics:       make(map[string]bool),
+		valueCache:          collector.NewValueCache(),
+		alerts: alert.NewNotifier(alert.Config{
+			WebhookURL:     appConfig.Alerts.Webhook.URL,
+			Headers:        appConfig.Alerts.Webhook.Headers,
+			RepeatInterval: appConfig.Alerts.RepeatInterval.Duration,
+		}, log.WithComponent("alert")),
+		limits: collector.NewLimits(appConfig.Limits.MaxConcurrentCollections, appConfig.Limits.MaxInflightWrites),
+	}
+
+	for _, group := range appConfig.Metrics.MetricGroups {
+		for _, metric := range group.Metrics {
+			app.metricConfigs[metric.Name] = metric
+			app.metricGroups[metric.Name] = group.Name
+			app.metricInfos[metric.Name] = &sql.MetricInfo{
+				Name:           metric.Name,
+				Description:    metric.Description,
+				Unit:           metric.Unit,
+				ValueType:      metric.ValueType,
+				CollectionType: metric.CollectionType,
+				Interval:       metric.Interval.Duration,
+			}
+			if !group.IsEnabled() || !metric.IsEnabled() {
+				app.disabledMetrics[metric.Name] = true
+			}
+
+			if metric.CollectionType == "expression" {
+				parsed, err := expression.Parse(metric.Expression)
+				if err != nil {
+					return nil, fmt.Errorf("invalid expression of metric '%s': %w", metric.Name, err)
+				}
+				app.expressions[metric.Name] = parsed
+				app.cachedMetrics[metric.Name] = true // expressions may use other expressions
+				for _, ref := range parsed.References() {
+					app.cachedMetrics[ref.Metric] = true
+				}
+			}
+		}
+	}
+
+	for _, srvCfg := range appConfig.DBServers {
+		app.registerServer(srvCfg, appConfig.MaxQueryTimeout(srvCfg.Name))
+	}
+
+	// Shared role state for cluster members, updated by role check tasks
+	for _, cluster := range appConfig.Clusters {
+		for _, serverName := range cluster.Servers {
+			app.serverRoles[serverName] = &collector.ServerRole{}
+		}
+	}
+
+	return app, nil
+}
+
+// registerServer fills lookup maps of a monitored server. statementTimeout applies when
+// the server doesn't configure its own one.
+func (app *application) registerServer(srvCfg config.DbConnectionConfig, statementTimeout time.Duration) {
+	params := sql.ConnectionParams{
+		Name:                  srvCfg.Name,
+		Driver:                srvCfg.Driver,
+		DSN:                   srvCfg.DSN,
+		Host:                  srvCfg.Host,
+		Port:                  srvCfg.Port,
+		User:                  srvCfg.User,
+		Password:              srvCfg.Password,
+		Auth:                  authParams(srvCfg.Auth),
+		DbName:                srvCfg.DbName,
+		SslMode:               srvCfg.SslMode,
+		SslRootCert:           srvCfg.SslRootCert,
+		SslCert:               srvCfg.SslCert,
+		SslKey:                srvCfg.SslKey,
+		KrbSrvName:            srvCfg.KrbSrvName,
+		KrbSpn:                srvCfg.KrbSpn,
+		MaxOpenConnections:    srvCfg.MaxOpenConnections,
+		MaxIdleConnections:    srvCfg.MaxIdleConnections,
+		ConnectionMaxLifetime: srvCfg.ConnectionMaxLifetime,
+		ConnectionMaxIdleTime: srvCfg.ConnectionMaxIdleTime,
+		ApplicationName:       srvCfg.ApplicationName,
+		StatementTimeout:      srvCfg.StatementTimeout.Duration,
+	}
+	if params.StatementTimeout == 0 {
+		params.StatementTimeout = statementTimeout
+	}
+	// database/sql treats 0 as unlimited, the global limit caps every pool
+	if limit := app.config.Limits.MaxConnectionsPerTarget; limit > 0 && (params.MaxOpenConnections <= 0 || params.MaxOpenConnections > limit) {
+		params.MaxOpenConnections = limit
+	}
+	app.serverParams[params.Name] = params
+
+	info := &sql.ServerInfo{
+		Name:        srvCfg.Name,
+		Driver:      srvCfg.Driver,
+		Environment: srvCfg.Environment,
+		Host:        srvCfg.Host,
+		Port:        srvCfg.Port,
+		SslMode:     srvCfg.SslMode,
+		Timezone:    srvCfg.Timezone,
+	}
+	// Server table requires address, take it from DSN when not configured explicitly
+	if srvCfg.DSN != "" {
+		host, port, dbName := sql.ParseDSNAddress(srvCfg.Driver, srvCfg.DSN)
+		if info.Host == "" {
+			info.Host = host
+		}
+		if info.Port == 0 {
+			info.Port = port
+		}
+		if srvCfg.DbName == "" {
+			srvCfg.DbName = dbName
+		}
+	}
+	app.serverInfos[info.Name] = info
+	app.serverConfigs[info.Name] = srvCfg
+
+	perMinute := srvCfg.MaxQueriesPerMinute
+	if perMinute == 0 {
+		perMinute = app.config.Limits.MaxQueriesPerMinute
+	}
+	app.serverBudgets[info.Name] = collector.NewQueryBudget(perMinute,
+		app.log.WithComponent("collector").With("server_name", info.Name))
+}
+
+// authParams converts authentication settings of a server to connection parameters
+func authParams(auth config.AuthConfig) sql.AuthParams {
+	return sql.AuthParams{
+		Type:          auth.Type,
+		Region:        auth.Region,
+		Command:       auth.Command,
+		CommandArgs:   auth.CommandArgs,
+		TokenLifetime: auth.TokenLifetime.Duration,
+		RefreshBefore: auth.RefreshBefore.Duration,
+	}
+}
+
+// connectMetricsDB opens connection to metrics database
+func (app *application) connectMetricsDB() error {
+	metricsDB := app.config.MetricsDB
+	params := sql.ConnectionParams{
+		Driver:                metricsDB.Driver,
+		DSN:                   metricsDB.DSN,
+		Host:                  metricsDB.Host,
+		Port:                  metricsDB.Port,
+		User:                  metricsDB.User,
+		Password:              metricsDB.Password,
+		Auth:                  authParams(metricsDB.Auth),
+		DbName:                metricsDB.DbName,
+		SslMode:               metricsDB.SslMode,
+		SslRootCert:           metricsDB.SslRootCert,
+		SslCert:               metricsDB.SslCert,
+		SslKey:                metricsDB.SslKey,
+		KrbSrvName:            metricsDB.KrbSrvName,
+		KrbSpn:                metricsDB.KrbSpn,
+		MaxOpenConnections:    metricsDB.MaxOpenConnections,
+		MaxIdleConnections:    metricsDB.MaxIdleConnections,
+		ConnectionMaxLifetime: metricsDB.ConnectionMaxLifetime,
+		ConnectionMaxIdleTime: metricsDB.ConnectionMaxIdleTime,
+		ApplicationName:       metricsDB.ApplicationName,
+		StatementTimeout:      metricsDB.StatementTimeout.Duration,
+	}
+
+	db, err := sql.Connect(app.log, params)
+	if err != nil {
+		return fmt.Errorf("failed to connect to metrics database: %w", err)
+	}
+	storage, err := sql.NewStorage(metricsDB.Driver, db, metricsDB.WriteTimeout.Duration)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	app.metricsDB = storage
+	app.log.Info("Metrics database server connected", "driver", sql.DriverName(params))
+	return nil
+}
+
+// initMetricsDB executes database migrations and saves metrics configuration
+func (app *application) initMetricsDB() error {
+	if err := app.migrateMetricsDB(); err != nil {
+		return err
+	}
+	return app.saveMetrics()
+}
+
+// attachMetricsDB saves metrics configuration of a metrics DB migrated by elmon bootstrap,
+// only the storage already set up is detected
+func (app *application) attachMetricsDB() error {
+	if app.config.Storage.TimescaleDB.Mode != "off" {
+		inUse, err := sql.TimescaleInUse(app.metricsDB.DB())
+		if err != nil {
+			return err
+		}
+		app.timescale = inUse
+	}
+	if err := app.saveMetrics(); err != nil {
+		return fmt.Errorf("%w, migrate the metrics database with elmon bootstrap or run --bootstrap", err)
+	}
+	return nil
+}
+
+// migrateMetricsDB executes the initial SQL script and sets up TimescaleDB storage
+func (app *application) migrateMetricsDB() error {
+	sqlBytes, err := sql.ReadScript(filepath.Join(app.sqlDir, app.metricsDB.InitScript()))
+	if err != nil {
+		return fmt.Errorf("failed to open initial SQL script file: %w", err)
+	}
+	if _, err = app.metricsDB.DB().Exec(string(sqlBytes)); err != nil {
+		return fmt.Errorf("failed to execute initial SQL script: %w", err)
+	}
+	if err := app.metricsDB.UpgradeSchema(context.Background()); err != nil {
+		return err
+	}
+	app.log.Info("Initial SQL script executed successfully")
+
+	return app.initTimescale()
+}
+
+// saveMetrics registers metric groups and metrics, metric IDs are needed by tasks
+func (app *application) saveMetrics() error {
+	metricsForDB := &sql.MetricConfigForDB{}
+	for _, group := range app.config.Metrics.MetricGroups {
+		g := &sql.MetricGroupInfo{Name: group.Name, Description: group.Description}
+		for _, metric := range group.Metrics {
+			g.Metrics = append(g.Metrics, app.metricInfos[metric.Name])
+		}
+		metricsForDB.MetricGroups = append(metricsForDB.MetricGroups, g)
+	}
+	if err := app.metricsDB.SaveMetrics(app.log, metricsForDB); err != nil {
+		return fmt.Errorf("failed to insert metrics into database: %w", err)
+	}
+
+	return nil
+}
+
+// recordConfigVersion saves the configuration this instance starts with and logs its changes since
+// the previous start of the instance
+func (app *application) recordConfigVersion() error {
+	ctx := context.Background()
+	instance := app.configInstance()
+	snapshot := app.config.Snapshot()
+	settings, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to serialize configuration: %w", err)
+	}
+	current := sql.ConfigVersion{
+		Time:       time.Now(),
+		Instance:   instance,
+		AppVersion: version,
+		Hash:       config.SnapshotHash(snapshot),
+		Summary:    "first version",
+		Settings:   settings,
+	}
+
+	previous, err := app.metricsDB.LastConfigVersion(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		var previousSnapshot map[string]interface{}
+		if err := json.Unmarshal(previous.Settings, &previousSnapshot); err != nil {
+			return fmt.Errorf("failed to parse previous configuration: %w", err)
+		}
+		diff := config.DiffSnapshots(previousSnapshot, snapshot)
+		if current.Changes, err = json.Marshal(diff); err != nil {
+			return fmt.Errorf("failed to serialize configuration changes: %w", err)
+		}
+		current.Summary = diff.Summary()
+		if !diff.Empty() {
+			app.log.Info("Configuration changed since the last start", "instance", instance,
+				"previous_start", previous.Time, "changes", current.Summary)
+		}
+	}
+
+	if err := app.metricsDB.SaveConfigVersion(ctx, current); err != nil {
+		return err
+	}
+	app.log.Debug("Configuration version saved", "instance", instance, "config_hash", current.Hash)
+	return nil
+}
+
+// configInstance names the instance in config_version: leader-election instance-id or the host name,
+// with the shard when the servers are sharded
+func (app *application) configInstance() string {
+	instance := app.config.LeaderElection.InstanceID
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "unknown"
+		}
+		instance = hostname
+	}
+	if sharding := app.config.Sharding; sharding.Shards > 0 {
+		instance = fmt.Sprintf("%s/shard-%d", instance, sharding.Shard)
+	}
+	return instance
+}
+
+// initTimescale turns metric_value into a TimescaleDB hypertable when enabled.
+// In auto mode plain PostgreSQL storage is kept if the extension is not available.
+func (app *application) initTimescale() error {
+	timescale := app.config.Storage.TimescaleDB
+	if timescale.Mode == "off" {
+		return nil
+	}
+
+	available, err := sql.TimescaleAvailable(app.metricsDB.DB())
+	if err != nil {
+		return err
+	}
+	if !available {
+		if timescale.Mode == "on" {
+			return fmt.Errorf("timescaledb extension is not available in metrics database")
+		}
+		app.log.Info("TimescaleDB extension is not available, using plain PostgreSQL storage")
+		return nil
+	}
+
+	err = sql.InitTimescale(app.log, app.metricsDB.DB(), sql.TimescaleParams{
+		ChunkInterval:        timescale.ChunkInterval.Duration,
+		CompressAfter:        timescale.CompressAfter.Duration,
+		ContinuousAggregates: timescale.ContinuousAggregates,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize TimescaleDB storage: %w", err)
+	}
+	app.timescale = true
+	return nil
+}
+
+// openSinks creates configured outputs of collected values
+func (app *application) openSinks() error {
+	budget := sink.NewMemoryBudget(int64(app.config.Limits.MaxBufferedMemory) << 20)
+	for _, sinkConfig := range app.config.Storage.Sinks {
+		var output sink.Sink
+		switch sinkConfig.Type {
+		case "kafka":
+			kafkaConfig := sinkConfig.Kafka
+			kafkaSink, err := sink.NewKafkaSink(sinkConfig.Name, sink.KafkaConfig{
+				Brokers:      kafkaConfig.Brokers,
+				Topic:        kafkaConfig.Topic,
+				ClientID:     kafkaConfig.ClientID,
+				RequiredAcks: kafkaConfig.RequiredAcks,
+				BatchTimeout: kafkaConfig.BatchTimeout.Duration,
+				TLS:          kafkaConfig.TLS,
+				Username:     kafkaConfig.Username,
+				Password:     kafkaConfig.Password,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create sink '%s': %w", sinkConfig.Name, err)
+			}
+			output = kafkaSink
+		case "otlp":
+			otlpConfig := sinkConfig.OTLP
+			output = sink.NewOTLPSink(sinkConfig.Name, sink.OTLPConfig{
+				Endpoint:    otlpConfig.Endpoint,
+				Headers:     otlpConfig.Headers,
+				ServiceName: otlpConfig.ServiceName,
+				Prefix:      otlpConfig.Prefix,
+			})
+		case "clickhouse":
+			clickHouseConfig := sinkConfig.ClickHouse
+			clickHouseSink, err := sink.NewClickHouseSink(sinkConfig.Name, sink.ClickHouseConfig{
+				URL:           clickHouseConfig.URL,
+				Database:      clickHouseConfig.Database,
+				Table:         clickHouseConfig.Table,
+				Username:      clickHouseConfig.Username,
+				Password:      clickHouseConfig.Password,
+				BatchSize:     clickHouseConfig.BatchSize,
+				FlushInterval: clickHouseConfig.FlushInterval.Duration,
+				MaxBuffer:     clickHouseConfig.MaxBuffer,
+				AsyncInsert:   clickHouseConfig.AsyncInsert,
+				TTL:           clickHouseConfig.TTL.Duration,
+				Budget:        budget,
+			}, app.log)
+			if err != nil {
+				return fmt.Errorf("failed to create sink '%s': %w", sinkConfig.Name, err)
+			}
+			output = clickHouseSink
+		default:
+			return fmt.Errorf("sink type '%s' is not supported", sinkConfig.Type)
+		}
+		app.sinks = append(app.sinks, output)
+		app.sinkGroups[sinkConfig.Name] = sinkConfig.MetricGroups
+		app.log.Info("Sink created", "sink", sinkConfig.Name, "type", sinkConfig.Type)
+	}
+	return nil
+}
+
+// sinksFor returns sinks receiving values of the metric
+func (app *application) sinksFor(metricName string) []sink.Sink {
+	var outputs []sink.Sink
+	for _, output := range app.sinks {
+		groups := app.sinkGroups[output.Name()]
+		if len(groups) == 0 || slices.Contains(groups, app.metricGroups[metricName]) {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
+
+// startSpool opens the spool of values that failed to be stored and schedules their replay.
+// Returns nil scheduler when spooling is disabled.
+func (app *application) startSpool() (*scheduler.TaskScheduler, error) {
+	spoolConfig := app.config.Storage.Spool
+	if !spoolConfig.Enabled {
+		return nil, nil
+	}
+
+	queue, err := spool.Open(spoolConfig.Dir, spoolConfig.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	app.spool = queue
+
+	log := app.log.WithComponent("spool")
+	if stats := queue.Stats(); stats.Records > 0 {
+		log.Info("Spooled values found, they will be replayed", "spool_records", stats.Records)
+	}
+
+	replayScheduler := scheduler.NewTaskScheduler(spoolConfig.ReplayInterval.Duration, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			// Replay resumes once health checks find the metrics DB up again
+			if !app.dbHealth.Up() {
+				return nil
+			}
+			oldest, err := collector.ReplaySpool(ctx, log, app.metricsDB, queue)
+			// Rollups have passed the times of values spooled during a long outage
+			if !oldest.IsZero() {
+				if rewindErr := app.rewindRollups(oldest); rewindErr != nil {
+					log.Error(rewindErr, "Failed to rewind rollups, replayed values are not aggregated")
+				}
+			}
+			return err
+		}, nil, log)
+	if err := replayScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return replayScheduler, nil
+}
+
+// startHealthCheck pings the metrics DB every health-check-interval. While it is down, values are
+// spooled and the spool is not replayed. On recovery idle connections are dropped, as the outage may have broken them.
+func (app *application) startHealthCheck() (*scheduler.TaskScheduler, error) {
+	metricsDB := app.config.MetricsDB
+	if metricsDB.HealthCheckInterval.Duration == 0 {
+		return nil, nil
+	}
+
+	db := app.metricsDB.DB()
+	app.dbHealth = collector.NewMetricsDBHealth(func() {
+		sql.ResetIdleConnections(db, metricsDB.MaxIdleConnections)
+	})
+	log := app.log.WithComponent("health")
+	healthScheduler := scheduler.NewTaskScheduler(metricsDB.HealthCheckInterval.Duration, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			app.dbHealth.Check(ctx, log, app.metricsDB, metricsDB.WriteTimeout.Duration)
+			return nil
+		}, nil, log)
+	if err := healthScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return healthScheduler, nil
+}
+
+// startRollups creates rollup tables and schedules the rollup job for plain PostgreSQL storage.
+// Returns nil scheduler when rollups are disabled or TimescaleDB continuous aggregates are used.
+func (app *application) startRollups() (*scheduler.TaskScheduler, error) {
+	rollups := app.config.Storage.Rollups
+	if !rollups.Enabled {
+		return nil, nil
+	}
+	if app.timescale {
+		app.log.Info("Rollups are disabled, TimescaleDB continuous aggregates are used instead")
+		return nil, nil
+	}
+
+	if err := sql.InitRollups(app.metricsDB.DB()); err != nil {
+		return nil, err
+	}
+
+	log := app.log.WithComponent("rollup")
+	rollupScheduler := scheduler.NewTaskScheduler(rollups.Interval.Duration, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			if !app.isLeader() {
+				return nil
+			}
+			return sql.RunRollups(ctx, log, app.metricsDB.DB(), rollups.After.Duration)
+		}, nil, log)
+	if err := rollupScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return rollupScheduler, nil
+}
+
+// rewindRollups makes rollups aggregate again the buckets from 'from' on, values older than their
+// watermarks are not aggregated otherwise. Does nothing when rollups are disabled or TimescaleDB is used.
+func (app *application) rewindRollups(from time.Time) error {
+	if !app.config.Storage.Rollups.Enabled || app.timescale {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+	return sql.RewindRollups(ctx, app.metricsDB.DB(), from)
+}
+
+// startCollectionLogCleanup schedules hourly deletion of collection_log rows older than the retention.
+// Returns nil scheduler when the log is disabled or kept forever.
+func (app *application) startCollectionLogCleanup() (*scheduler.TaskScheduler, error) {
+	collectionLog := app.config.Storage.CollectionLog
+	if !collectionLog.Enabled || collectionLog.Retention.Duration == 0 {
+		return nil, nil
+	}
+
+	log := app.log.WithComponent("collection-log")
+	cleanupScheduler := scheduler.NewTaskScheduler(time.Hour, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			if !app.isLeader() {
+				return nil
+			}
+			deleted, err := app.metricsDB.DeleteCollectionLog(ctx, time.Now().Add(-collectionLog.Retention.Duration))
+			if err != nil {
+				return err
+			}
+			log.Debug("Old collection log deleted", "rows", deleted)
+			return nil
+		}, nil, log)
+	if err := cleanupScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return cleanupScheduler, nil
+}
+
+// startInactivePurge schedules hourly deletion of servers and metrics inactive for longer than
+// purge-inactive-after. Returns nil scheduler when they are kept forever.
+func (app *application) startInactivePurge() (*scheduler.TaskScheduler, error) {
+	purgeAfter := app.config.Storage.PurgeInactiveAfter.Duration
+	if purgeAfter == 0 {
+		return nil, nil
+	}
+
+	log := app.log.WithComponent("inactive-purge")
+	purgeScheduler := scheduler.NewTaskScheduler(time.Hour, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			if !app.isLeader() {
+				return nil
+			}
+			servers, metrics, err := app.metricsDB.PurgeInactive(ctx, time.Now().Add(-purgeAfter))
+			if err != nil {
+				return err
+			}
+			if servers > 0 || metrics > 0 {
+				log.Info("Inactive servers and metrics purged", "servers", servers, "metrics", metrics)
+			}
+			return nil
+		}, nil, log)
+	if err := purgeScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return purgeScheduler, nil
+}
+
+// startLeaderElection puts the collector on standby and starts competing for the leader lease,
+// the collector collects while this instance is the leader. Returns nil without leader election.
+func (app *application) startLeaderElection(metricCollector *collector.Collector) (*leader.Elector, error) {
+	election := app.config.LeaderElection
+	if !election.Enabled {
+		return nil, nil
+	}
+	instanceID := election.InstanceID
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for instance-id: %w", err)
+		}
+		instanceID = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+
+	metricCollector.SetStandby(true)
+	// Instances of a shard compete only with each other
+	leaseName := "leader"
+	if sharding := app.config.Sharding; sharding.Shards > 0 {
+		leaseName = fmt.Sprintf("leader-shard-%d", sharding.Shard)
+	}
+	app.elector = &leader.Elector{
+		Name:      leaseName,
+		Holder:    instanceID,
+		TTL:       election.Lease.Duration,
+		Store:     app.metricsDB,
+		OnElected: func() { metricCollector.SetStandby(false) },
+		OnDemoted: func() { metricCollector.SetStandby(true) },
+		Logger:    app.log.WithComponent("leader"),
+	}
+	if err := app.elector.Start(); err != nil {
+		return nil, err
+	}
+	return app.elector, nil
+}
+
+// isLeader reports whether this instance runs jobs shared by all instances, e.g. rollups.
+// With sharding they run on the leader of shard 0.
+func (app *application) isLeader() bool {
+	if app.config.Sharding.Shards > 0 && app.config.Sharding.Shard != 0 {
+		return false
+	}
+	return app.elector == nil || app.elector.IsLeader()
+}
+
+// connectServers connects to monitored servers and discovers their databases.
+// With no names given all configured servers of the shard are connected. Disabled servers are skipped.
+func (app *application) connectServers(serverNames ...string) error {
+	if len(serverNames) == 0 {
+		for _, srvCfg := range app.config.DBServers {
+			if !app.inShard(srvCfg.Name) {
+				app.log.Debug("Server belongs to another shard, skipping", "server_name", srvCfg.Name)
+				continue
+			}
+			serverNames = append(serverNames, srvCfg.Name)
+		}
+	}
+	var allServerParams []sql.ConnectionParams
+	var pooledServers []string
+	for _, serverName := range serverNames {
+		srvCfg := app.serverConfigs[serverName]
+		if !srvCfg.IsEnabled() {
+			app.log.Info("Server is disabled, skipping", "server_name", serverName)
+			continue
+		}
+		if len(srvCfg.Hosts) > 0 {
+			pooledServers = append(pooledServers, serverName)
+			continue
+		}
+		if params, ok := app.serverParams[serverName]; ok {
+			allServerParams = append(allServerParams, params)
+		}
+	}
+
+	connections, err := sql.ConnectAll(app.log, allServerParams)
+	if err != nil {
+		return fmt.Errorf("failed to establish connections to database servers: %w", err)
+	}
+	for name, conn := range connections {
+		app.connections[name] = conn
+	}
+	for _, name := range pooledServers {
+		pool, err := app.connectHosts(name)
+		if err != nil {
+			return fmt.Errorf("failed to establish connections to database servers: %w", err)
+		}
+		app.hostPools[name] = pool
+		app.connections[name] = pool.DB()
+		connections[name] = app.connections[name]
+	}
+	app.log.Info("Connection to database servers established", "server_count", len(connections))
+
+	// Timezone is stored with the server, servers without a configured one report their own
+	for name, conn := range connections {
+		info := app.serverInfos[name]
+		if info == nil || app.serverConfigs[name].Timezone != "" {
+			continue
+		}
+		timezone, err := sql.GetServerTimezone(conn, sql.DriverName(app.serverParams[name]),
+			app.config.Metrics.Global.DefaultQueryTimeout.Duration)
+		if err != nil {
+			app.log.Warn("Failed to read server timezone, the stored one is kept", "server_name", name, "error", err)
+			continue
+		}
+		info.Timezone = timezone
+	}
+
+	// Metrics with sql-versions run the SQL file of the PostgreSQL version, detected once per connection
+	for name, conn := range connections {
+		version, err := sql.GetServerVersion(conn, sql.DriverName(app.serverParams[name]),
+			app.config.Metrics.Global.DefaultQueryTimeout.Duration)
+		if err != nil {
+			app.log.Warn("Failed to read server version, sql-versions of metrics are not used", "server_name", name,
+				"error", err)
+			continue
+		}
+		if version > 0 {
+			app.serverVersions[name] = version
+			app.log.Debug("Server version detected", "server_name", name, "server_version_num", version)
+		}
+	}
+
+	// Discover databases on servers with discover-databases enabled
+	for _, params := range allServerParams {
+		srvCfg := app.serverConfigs[params.Name]
+		if !srvCfg.DiscoverDatabases {
+			continue
+		}
+		databases, err := sql.DiscoverDatabases(connections[srvCfg.Name], srvCfg.Driver, srvCfg.ExcludeDatabases,
+			app.config.Metrics.Global.DefaultQueryTimeout.Duration)
+		if err != nil {
+			return fmt.Errorf("failed to discover databases on server '%s': %w", srvCfg.Name, err)
+		}
+		dbConns, err := sql.ConnectDatabases(app.log, params, connections[srvCfg.Name], databases)
+		if err != nil {
+			return fmt.Errorf("failed to connect to discovered databases on server '%s': %w", srvCfg.Name, err)
+		}
+		app.databaseConnections[srvCfg.Name] = dbConns
+		app.log.Info("Databases discovered", "server_name", srvCfg.Name, "databases", databases)
+	}
+
+	return nil
+}
+
+// connectHosts opens connections to every host of a server with several hosts and detects their roles.
+// Hosts may be down, the host check finds them up later, but at least one must be reachable.
+func (app *application) connectHosts(serverName string) (*collector.HostPool, error) {
+	srvCfg := app.serverConfigs[serverName]
+	pool := &collector.HostPool{
+		ServerName:   serverName,
+		Interval:     srvCfg.HostCheckInterval.Duration,
+		QueryTimeout: app.config.Metrics.Global.DefaultQueryTimeout.Duration,
+		Logger:       app.log.WithComponent("collector").With("server_name", serverName),
+	}
+	for _, address := range srvCfg.Hosts {
+		// Hosts are host:port after validation
+		host, port, _ := net.SplitHostPort(address)
+		params := app.serverParams[serverName]
+		params.Host = host
+		params.Port, _ = strconv.Atoi(port)
+		conn, err := sql.Open(params)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to open connection to host '%s' of server %s: %w", address, serverName, err)
+		}
+		pool.Hosts = append(pool.Hosts, &collector.PoolHost{Address: address, DB: conn})
+	}
+	if err := pool.Check(context.Background()); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	app.log.Info("Successfully connected", "server_name", serverName, "hosts", srvCfg.Hosts)
+	return pool, nil
+}
+
+// saveServers saves server information to metrics database
+func (app *application) saveServers() error {
+	var serversToSave []*sql.ServerInfo
+	for _, info := range app.serverInfos {
+		serversToSave = append(serversToSave, info)
+	}
+	if err := app.metricsDB.SaveServers(app.log, serversToSave); err != nil {
+		return fmt.Errorf("failed to save servers to metrics DB: %w", err)
+	}
+	app.log.Info("Servers loaded to metrics DB")
+	return nil
+}
+
+// deactivateRemovedServers marks servers of the shard missing from configuration and from servers added
+// through the API inactive. Servers of discovery sources are activated again once they are discovered.
+func (app *application) deactivateRemovedServers() error {
+	managed, err := app.metricsDB.LoadManagedServers()
+	if err != nil {
+		return fmt.Errorf("failed to load servers added through the API: %w", err)
+	}
+	configured := make(map[string]bool)
+	for _, srvCfg := range app.config.DBServers {
+		configured[srvCfg.Name] = true
+	}
+	for _, server := range managed {
+		configured[server.Name] = true
+	}
+
+	// Servers of other shards are kept by the instances of their shards
+	deactivated, err := app.metricsDB.DeactivateServers(context.Background(), func(name string) bool {
+		return configured[name] || !app.inShard(name)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deactivate removed servers: %w", err)
+	}
+	if len(deactivated) > 0 {
+		app.log.Info("Servers removed from configuration deactivated", "servers", deactivated)
+	}
+	return nil
+}
+
+// buildMetricTasks creates metric tasks based on server-metric mappings.
+// include filters tasks by server and metric name, nil includes everything.
+func (app *application) buildMetricTasks(include func(serverName, metricName string) bool) []*collector.MetricTask {
+	var metricTasks []*collector.MetricTask
+
+	for _, mapping := range app.config.ServerMetricsMap {
+		metricTasks = append(metricTasks, app.buildServerTasks(mapping, include)...)
+	}
+
+	return metricTasks
+}
+
+// buildServerTasks creates metric tasks of a single server-metric mapping
+func (app *application) buildServerTasks(mapping config.ServerMetricsMapping, include func(serverName, metricName string) bool) []*collector.MetricTask {
+	log := app.log
+	var metricTasks []*collector.MetricTask
+
+	serverInfo, ok := app.serverInfos[mapping.Name]
+	if !ok {
+		log.Warn("Server from mapping not found in server list, skipping", "server_name", mapping.Name)
+		return nil
+	}
+	if srvCfg := app.serverConfigs[serverInfo.Name]; !srvCfg.IsEnabled() {
+		return nil
+	}
+
+	for _, metricOverride := range mapping.Metrics {
+		metricOverride = mapping.MetricSettings(metricOverride)
+		if include != nil && !include(serverInfo.Name, metricOverride.Name) {
+			continue
+		}
+		if app.disabledMetrics[metricOverride.Name] || !metricOverride.IsEnabled() {
+			log.Debug("Metric is disabled, skipping", "server_name", serverInfo.Name, "metric_name", metricOverride.Name)
+			continue
+		}
+
+		targetDBConn, ok := app.connections[serverInfo.Name]
+		if !ok {
+			log.Warn("Active connection for server not found, skipping", "server_name", mapping.Name)
+			break
+		}
+
+		metricInfo, ok := app.metricInfos[metricOverride.Name]
+		if !ok {
+			log.Warn("Metric from mapping not found in metric list, skipping", "metric_name", metricOverride.Name)
+			continue
+		}
+
+		baseMetricConfig := app.metricConfigs[metricOverride.Name]
+		if baseMetricConfig.CollectionType == "bundle" {
+			log.Debug("Metric is stored by the metric it is split from, skipping", "server_name", serverInfo.Name,
+				"metric_name", metricOverride.Name)
+			continue
+		}
+		if baseMetricConfig.CollectionType == "push" {
+			log.Debug("Metric values are pushed by agents, skipping", "server_name", serverInfo.Name,
+				"metric_name", metricOverride.Name)
+			continue
+		}
+
+		// Per-database metrics fan out to every discovered database,
+		// server level metrics run once on the configured connection
+		targets := map[string]*sqldb.DB{"": targetDBConn}
+		if baseMetricConfig.Scope == "database" {
+			targets = map[string]*sqldb.DB{app.serverConfigs[serverInfo.Name].DbName: targetDBConn}
+			if dbConns, ok := app.databaseConnections[serverInfo.Name]; ok {
+				targets = dbConns
+			}
+		}
+
+		for databaseName, databaseConn := range targets {
+			// Every record of the task carries server, metric and database names
+			taskLog := log.WithComponent("collector").With("server_name", serverInfo.Name, "metric_name", metricInfo.Name)
+			if databaseName != "" {
+				taskLog = taskLog.With("database_name", databaseName)
+			}
+
+			// Create task combining base and overridden parameters
+			task := &collector.MetricTask{
+				ServerName:       serverInfo.Name,
+				MetricName:       metricInfo.Name,
+				MetricID:         metricInfo.DbMetricID,
+				DatabaseName:     databaseName,
+				Driver:           sql.DriverName(app.serverParams[serverInfo.Name]),
+				RequiredRole:     baseMetricConfig.Role,
+				Role:             app.serverRoles[serverInfo.Name],
+				CollectionType:   baseMetricConfig.CollectionType,
+				ValueType:        baseMetricConfig.ValueType,
+				StorageMode:      baseMetricConfig.StorageMode,
+				Labels:           baseMetricConfig.Labels,
+				SQLFile:          baseMetricConfig.SQLFileFor(serverInfo.Driver, app.serverVersions[serverInfo.Name]),
+				BackfillSQL:      baseMetricConfig.BackfillSQLFile,
+				GoFunction:       baseMetricConfig.GoFunction,
+				Command:          baseMetricConfig.Command,
+				CommandArgs:      baseMetricConfig.CommandArgs,
+				HTTP:             newHTTPProbe(baseMetricConfig.HTTP),
+				Forecast:         newForecast(baseMetricConfig.Forecast),
+				Expression:       app.expressions[metricInfo.Name],
+				ExpressionMaxAge: baseMetricConfig.MaxAge.Duration,
+				Interval:         metricOverride.Interval.Duration, // Apply overrides
+				MaxRetries:       metricOverride.MaxRetries,
+				RetryDelay:       metricOverride.RetryDelay.Duration,
+				MissedTick:       app.config.Metrics.Global.MissedTick,
+				AllowOverlap:     app.config.Metrics.Global.AllowOverlap,
+				BackoffAfter:     app.config.Metrics.Global.BackoffAfter,
+				MaxBackoff:       app.config.Metrics.Global.MaxBackoffInterval.Duration,
+				Priority:         baseMetricConfig.Priority,
+				QueryTimeout:     metricOverride.QueryTimeout.Duration,
+				ReadOnly:         app.config.Metrics.Global.ReadOnlyTransaction,
+				ReloadSQL:        app.config.Metrics.Global.ReloadSQL,
+				RejectWriteSQL:   app.config.Metrics.Global.RejectWriteSQL,
+				Logger:           taskLog,
+				TargetDB:         databaseConn,
+				MetricsDB:        app.metricsDB,
+				Spool:            app.spool,
+				Sinks:            app.sinksFor(metricInfo.Name),
+				SkipMetricsDB:    !app.config.Storage.WriteMetricsDB,
+				CollectionLog:    app.config.Storage.CollectionLog.Enabled,
+				Limits:           app.limits,
+				Budget:           app.serverBudgets[serverInfo.Name],
+				Hosts:            app.hostPools[serverInfo.Name],
+				HostPolicy:       baseMetricConfig.HostPolicy,
+				DBHealth:         app.dbHealth,
+				TimeSource:       app.config.Storage.TimeSource,
+			}
+			if task.CollectionType == "go_func" && !collector.GoFunctionSupports(task.GoFunction, task.Driver) {
+				task.Logger.Warn("Go function doesn't support the driver of the server, skipping",
+					"go_function", task.GoFunction, "driver", task.Driver)
+				continue
+			}
+			if task.CollectionType == "go_func" {
+				// Server address is available to functions like collectPatroni
+				task.Params = collector.TemplateParams(
+					map[string]interface{}{"host": serverInfo.Host, "port": serverInfo.Port},
+					mapping.Params,
+					metricOverride.Params,
+				)
+				task.State = &collector.TaskState{}
+				task.Alerts = app.alerts
+			}
+			if task.CollectionType == "forecast" {
+				// The threshold param of a server overrides the threshold of the forecast
+				task.Params = collector.TemplateParams(mapping.Params, metricOverride.Params)
+				task.Alerts = app.alerts
+			}
+			// Server timeouts are set with PostgreSQL functions, PgBouncer and MySQL keep client-side timeouts
+			task.ServerTimeouts = app.config.Metrics.Global.ServerTimeouts && task.Driver == sql.DriverPostgres
+			if baseMetricConfig.Kind == "counter" {
+				task.Counter = collector.NewCounterState()
+			}
+			if app.cachedMetrics[metricInfo.Name] {
+				task.Cache = app.valueCache
+			}
+			// Server ID is known only after servers are saved to metrics DB
+			if serverInfo.ID != nil {
+				task.ServerID = *serverInfo.ID
+			}
+
+			// Use global/base values if overrides are not provided
+			if task.Interval == 0 {
+				task.Interval = baseMetricConfig.Interval.Duration
+			}
+			if task.MaxRetries == 0 {
+				task.MaxRetries = baseMetricConfig.MaxRetries
+			}
+			if task.RetryDelay == 0 {
+				task.RetryDelay = baseMetricConfig.RetryDelay.Duration
+			}
+			if task.QueryTimeout == 0 {
+				task.QueryTimeout = baseMetricConfig.QueryTimeout.Duration
+			}
+
+			// A metric reading a missing extension would fail on every run
+			if len(baseMetricConfig.RequiresExtensions) > 0 && task.Driver == sql.DriverPostgres {
+				if !app.checkExtensions(task, baseMetricConfig.RequiresExtensions) {
+					continue
+				}
+			}
+
+			// Render SQL template once with server built-ins and configured params
+			if task.CollectionType == "sql" {
+				srvCfg := app.serverConfigs[serverInfo.Name]
+				if databaseName != "" {
+					srvCfg.DbName = databaseName
+				}
+				task.SQLParams = collector.TemplateParams(
+					map[string]interface{}{
+						"server_name":   srvCfg.Name,
+						"environment":   srvCfg.Environment,
+						"host":          srvCfg.Host,
+						"port":          srvCfg.Port,
+						"database_name": srvCfg.DbName,
+					},
+					mapping.Params,
+					metricOverride.Params,
+				)
+				if task.SQLFile == "" {
+					task.Logger.Error(fmt.Errorf("no sql-versions or sql-files entry matches the server and sql-file is not set"),
+						"Metric has no SQL file for the server, skipping", "driver", task.Driver,
+						"server_version_num", app.serverVersions[serverInfo.Name])
+					continue
+				}
+				if err := task.RenderSQL(); err != nil {
+					task.Logger.Error(err, "Failed to render metric SQL, skipping")
+					continue
+				}
+				if app.config.Metrics.Global.RejectWriteSQL {
+					if err := sql.CheckReadOnlySQL(task.SQLScript); err != nil {
+						task.Logger.Error(err, "Metric SQL is not read-only, skipping", "file", task.SQLFile)
+						continue
+					}
+				}
+				task.ResultLimits = sql.ResultLimits{
+					MaxBytes: baseMetricConfig.MaxResultBytes,
+					MaxRows:  baseMetricConfig.MaxRows,
+					Truncate: baseMetricConfig.ResultLimit == "truncate",
+				}
+				// PgBouncer admin console doesn't support the extended query protocol, statements
+				// are prepared on a single connection pool while hosts of a host pool change per run
+				if app.config.Metrics.Global.PreparedStatements && task.Driver != sql.DriverPgBouncer && task.Hosts == nil {
+					task.Prepared = &sql.PreparedScript{}
+				}
+			}
+
+			if len(baseMetricConfig.Split) > 0 {
+				task.Parts = app.bundleParts(task, baseMetricConfig.Split)
+			}
+
+			metricTasks = append(metricTasks, task)
+		}
+	}
+
+	return metricTasks
+}
+
+// bundleParts creates tasks storing keys split from the bundle task value, disabled metrics are left out
+func (app *application) bundleParts(bundle *collector.MetricTask, splits []config.BundleSplit) []collector.BundlePart {
+	var parts []collector.BundlePart
+	for _, split := range splits {
+		if app.disabledMetrics[split.Metric] {
+			continue
+		}
+		metricConfig := app.metricConfigs[split.Metric]
+
+		part := *bundle
+		part.MetricName = split.Metric
+		part.MetricID = app.metricInfos[split.Metric].DbMetricID
+		part.ValueType = metricConfig.ValueType
+		part.StorageMode = metricConfig.StorageMode
+		part.Labels = metricConfig.Labels
+		part.Sinks = app.sinksFor(split.Metric)
+		part.Parts = nil
+		part.Counter = nil
+		if metricConfig.Kind == "counter" {
+			part.Counter = collector.NewCounterState()
+		}
+		part.Cache = nil
+		if app.cachedMetrics[split.Metric] {
+			part.Cache = app.valueCache
+		}
+		part.Logger = app.log.WithComponent("collector").With("server_name", part.ServerName, "metric_name", part.MetricName)
+		if part.DatabaseName != "" {
+			part.Logger = part.Logger.With("database_name", part.DatabaseName)
+		}
+		parts = append(parts, collector.BundlePart{Key: split.Key, Task: &part})
+	}
+	return parts
+}
+
+// addRoleChecks schedules role detection for every connected cluster member
+func (app *application) addRoleChecks(metricCollector *collector.Collector) {
+	for _, cluster := range app.config.Clusters {
+		for _, serverName := range cluster.Servers {
+			conn, ok := app.connections[serverName]
+			if !ok {
+				continue
+			}
+			metricCollector.AddRoleCheck(&collector.RoleCheckTask{
+				ServerName:   serverName,
+				ClusterName:  cluster.Name,
+				ServerID:     *app.serverInfos[serverName].ID,
+				Interval:     cluster.RoleCheckInterval.Duration,
+				QueryTimeout: app.config.Metrics.Global.DefaultQueryTimeout.Duration,
+				Role:         app.serverRoles[serverName],
+				Logger:       app.log.WithComponent("topology").With("server_name", serverName, "cluster", cluster.Name),
+				TargetDB:     conn,
+				MetricsDB:    app.metricsDB,
+			})
+		}
+	}
+}
+
+// addHostChecks schedules host checks of every connected server with several hosts
+func (app *application) addHostChecks(metricCollector *collector.Collector) error {
+	for _, pool := range app.hostPools {
+		if err := metricCollector.AddHostCheck(pool); err != nil {
+			return fmt.Errorf("failed to schedule host checks of server '%s': %w", pool.ServerName, err)
+		}
+	}
+	return nil
+}
+
+// close releases all database connections
+func (app *application) close() {
+	// Wait for a server being added or removed at runtime
+	app.serversMutex.Lock()
+	defer app.serversMutex.Unlock()
+
+	for serverName, dbConns := range app.databaseConnections {
+		for _, conn := range dbConns {
+			if conn != app.connections[serverName] {
+				conn.Close()
+			}
+		}
+	}
+	for serverName, conn := range app.connections {
+		// Connections of host pools are closed with their pools
+		if _, pooled := app.hostPools[serverName]; !pooled {
+			conn.Close()
+		}
+	}
+	for _, pool := range app.hostPools {
+		pool.Close()
+	}
+	if app.metricsDB != nil {
+		app.metricsDB.DB().Close()
+	}
+	for _, output := range app.sinks {
+		if err := output.Close(); err != nil {
+			app.log.Error(err, "Failed to close sink", "sink", output.Name())
+		}
+	}
+	app.log.Close()
+}
+
+// reopenLogOnSignal reopens the log file on SIGHUP, so that logrotate can move it away
+func (app *application) reopenLogOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := app.log.Reopen(); err != nil {
+				stdlog.Printf("ERROR: failed to reopen log file: %v", err)
+				continue
+			}
+			app.log.Info("Log file reopened")
+		}
+	}()
+}
+
+// newForecast converts the forecast of a metric, nil for other collection types
+func newForecast(cfg *config.ForecastConfig) *collector.Forecast {
+	if cfg == nil {
+		return nil
+	}
+	return collector.NewForecast(cfg.Metric, cfg.Key, cfg.Window.Duration, cfg.MinPoints, cfg.Threshold,
+		cfg.ThresholdKey, cfg.AlertWithin.Duration)
+}
+
+// newHTTPProbe converts metric http config into collector probe settings
+func newHTTPProbe(cfg *config.HttpProbeConfig) *collector.HTTPProbe {
+	if cfg == nil {
+		return nil
+	}
+	return &collector.HTTPProbe{
+		URL:            cfg.URL,
+		Method:         cfg.Method,
+		Headers:        cfg.Headers,
+		Body:           cfg.Body,
+		ExpectedStatus: cfg.ExpectedStatus,
+		JSONPath:       cfg.JSONPath,
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"elmon/logger"
+	"elmon/report"
+	"elmon/scheduler"
+	"elmon/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+	"time"
+)
+
+// availabilitySender delivers summaries of reports.availability, nil when they go nowhere
+func (app *application) availabilitySender() *report.Sender {
+	reports := app.config.Reports.Availability
+	sender := &report.Sender{WebhookURL: reports.Webhook.URL, Headers: reports.Webhook.Headers}
+	if email := reports.Email; email.Host != "" {
+		sender.Email = &report.Email{Host: email.Host, Port: email.Port, Username: email.Username,
+			Password: email.Password, From: email.From, To: email.To}
+	}
+	if !sender.Enabled() {
+		return nil
+	}
+	return sender
+}
+
+// computeAvailability computes availability of every active server in the month starting at start
+// and stores it in availability_report
+func (app *application) computeAvailability(ctx context.Context, start time.Time, end time.Time,
+	now time.Time) ([]sql.AvailabilityReport, error) {
+	reports := app.config.Reports.Availability
+	// A value covers two intervals of the metric at most, a missed run doesn't count as unmonitored
+	interval := app.metricConfigs[reports.Metric].Interval.Duration
+	if interval == 0 {
+		interval = app.config.Metrics.Global.DefaultInterval.Duration
+	}
+	maxGap := 2 * interval
+
+	db := app.metricsDB.DB()
+	servers, err := sql.ReadServers(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	until := end
+	if now.Before(until) {
+		until = now
+	}
+	values, err := sql.ReadServerMetricValues(ctx, db, reports.Metric, start.Add(-maxGap), until)
+	if err != nil {
+		return nil, err
+	}
+	serverValues := make(map[string][]sql.ServerValue)
+	for _, value := range values {
+		serverValues[value.ServerName] = append(serverValues[value.ServerName], value)
+	}
+
+	var computed []sql.AvailabilityReport
+	for _, server := range servers {
+		if !server.Active {
+			continue
+		}
+		computed = append(computed, report.Availability(server.Name, serverValues[server.Name], start, end,
+			maxGap, now))
+	}
+	if err := sql.SaveAvailabilityReports(ctx, db, computed); err != nil {
+		return nil, err
+	}
+	return computed, nil
+}
+
+// runAvailabilityReports recomputes the running month and the previous one until it is complete,
+// then sends the summary of the previous month once
+func (app *application) runAvailabilityReports(ctx context.Context, log *logger.Logger, now time.Time) error {
+	reports := app.config.Reports.Availability
+	location, err := time.LoadLocation(reports.Timezone)
+	if err != nil {
+		return err
+	}
+	currentStart, currentEnd := report.Month(now, location)
+	previousStart := currentStart.AddDate(0, -1, 0)
+
+	db := app.metricsDB.DB()
+	previous, err := sql.ReadAvailabilityReports(ctx, db, previousStart)
+	if err != nil {
+		return err
+	}
+	summary := report.NewSummary(previous, previousStart, currentStart, reports.Target)
+	if len(previous) == 0 || !summary.Complete {
+		if _, err := app.computeAvailability(ctx, previousStart, currentStart, now); err != nil {
+			return err
+		}
+		if previous, err = sql.ReadAvailabilityReports(ctx, db, previousStart); err != nil {
+			return err
+		}
+		summary = report.NewSummary(previous, previousStart, currentStart, reports.Target)
+		log.Info("Availability of the previous month computed", "period_start", previousStart, "servers",
+			len(previous))
+	}
+	if _, err := app.computeAvailability(ctx, currentStart, currentEnd, now); err != nil {
+		return err
+	}
+
+	sender := app.availabilitySender()
+	if sender == nil || len(previous) == 0 || !summary.Complete {
+		return nil
+	}
+	var delivered report.Delivery
+	for _, server := range previous {
+		if server.NotifiedAt != nil {
+			return nil
+		}
+		delivered.Webhook = delivered.Webhook || server.WebhookNotifiedAt != nil
+		delivered.Email = delivered.Email || server.EmailNotifiedAt != nil
+	}
+	// A month before monitoring started has nothing to tell
+	if summary.Monitored() {
+		// Channels reached are recorded even when another one fails, only the failed one is tried again
+		sent, sendErr := sender.Send(ctx, summary, delivered)
+		if err := app.markDelivered(ctx, previousStart, delivered, sent, now); err != nil {
+			return errors.Join(sendErr, err)
+		}
+		if sendErr != nil {
+			return sendErr
+		}
+		log.Info("Availability summary sent", "period_start", previousStart)
+	}
+	return sql.MarkAvailabilityNotified(ctx, db, previousStart, now)
+}
+
+// markDelivered records channels of sent that were not in delivered before
+func (app *application) markDelivered(ctx context.Context, periodStart time.Time, delivered report.Delivery,
+	sent report.Delivery, now time.Time) error {
+	db := app.metricsDB.DB()
+	if sent.Webhook && !delivered.Webhook {
+		if err := sql.MarkAvailabilityDelivered(ctx, db, periodStart, "webhook", now); err != nil {
+			return err
+		}
+	}
+	if sent.Email && !delivered.Email {
+		if err := sql.MarkAvailabilityDelivered(ctx, db, periodStart, "email", now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startAvailabilityReports schedules computation of monthly availability every reports.availability interval.
+// Returns nil scheduler when the report is disabled.
+func (app *application) startAvailabilityReports() (*scheduler.TaskScheduler, error) {
+	reports := app.config.Reports.Availability
+	if !reports.Enabled {
+		return nil, nil
+	}
+
+	log := app.log.WithComponent("availability-report")
+	reportScheduler := scheduler.NewTaskScheduler(reports.Interval.Duration, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			if !app.isLeader() {
+				return nil
+			}
+			err := app.runAvailabilityReports(ctx, log, time.Now())
+			if err != nil {
+				log.Error(err, "Availability report failed")
+			}
+			return err
+		}, nil, log)
+	if err := reportScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return reportScheduler, nil
+}
+
+// slaReportCommand computes availability of servers in a month, stores and prints it.
+// The summary is sent with --send.
+func slaReportCommand(args []string) {
+	flags := flag.NewFlagSet("sla-report", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	month := flags.String("month", "", "month as YYYY-MM, default: the previous month")
+	format := flags.String("format", "text", "output format: text or json")
+	send := flags.Bool("send", false, "send the summary to the webhook and by email of reports.availability")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "--format must be text or json")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Keep stdout for the report only, logs go to stderr
+	opts.logStderr = true
+
+	app, err := newApplication(opts)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	log := app.log
+	defer app.close()
+	reports := app.config.Reports.Availability
+	location, err := time.LoadLocation(reports.Timezone)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	now := time.Now()
+	start, end := report.Month(now, location)
+	start, end = start.AddDate(0, -1, 0), start
+	if *month != "" {
+		parsed, err := time.ParseInLocation("2006-01", *month, location)
+		if err != nil {
+			stdlog.Fatalf("FATAL: --month must be YYYY-MM: %v", err)
+		}
+		if start, end = report.Month(parsed, location); start.After(now) {
+			stdlog.Fatalf("FATAL: --month must not be in the future")
+		}
+	}
+	if _, ok := app.metricConfigs[reports.Metric]; !ok {
+		stdlog.Fatalf("FATAL: reports.availability metric '%s' is not configured", reports.Metric)
+	}
+	sender := app.availabilitySender()
+	if *send && sender == nil {
+		stdlog.Fatalf("FATAL: --send requires reports.availability webhook or email")
+	}
+
+	if err := app.connectMetricsDB(); err != nil {
+		log.Error(err, "error connecting to metrics database server")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if err := app.initMetricsDB(); err != nil {
+		log.Error(err, "error initializing metrics database")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	ctx := context.Background()
+	computed, err := app.computeAvailability(ctx, start, end, now)
+	if err != nil {
+		log.Error(err, "Failed to compute availability")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+
+	summary := report.NewSummary(computed, start, end, reports.Target)
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(summary)
+	} else {
+		err = summary.WriteText(os.Stdout)
+	}
+	if err != nil {
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+
+	if *send {
+		if _, err := sender.Send(ctx, summary, report.Delivery{}); err != nil {
+			log.Error(err, "Failed to send availability summary")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+		// The collector doesn't send the summary of a complete month again
+		if summary.Complete {
+			if err := sql.MarkAvailabilityNotified(ctx, app.metricsDB.DB(), start, time.Now()); err != nil {
+				log.Error(err, "Failed to mark availability summary sent")
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"elmon/collector"
+	"elmon/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+	"time"
+)
+
+// Limits of elmon backfill
+const (
+	maxBackfillWindows = 100000
+	maxBackfillValues  = 1000000 // stored values read to find windows which have one
+)
+
+// backfillCommand recomputes values of a metric of a server for past windows with the backfill-sql-file
+// of the metric and stores them with historical times. Windows which already have a value are skipped
+// unless --force is given.
+func backfillCommand(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	serverName := flags.String("server", "", "server name from db-servers (required)")
+	metricName := flags.String("metric", "", "metric name from metrics with backfill-sql-file (required)")
+	fromFlag := flags.String("from", "", "start of the first window, RFC 3339 time (required)")
+	toFlag := flags.String("to", "", "end of the last window, RFC 3339 time (required)")
+	step := flags.Duration("step", 0, "length of a window, default: interval of the metric on the server")
+	force := flags.Bool("force", false, "recompute windows which already have stored values as well, replacing them")
+	dryRun := flags.Bool("dry-run", false, "print values instead of storing them, the metrics database is not used")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	if *serverName == "" || *metricName == "" || *fromFlag == "" || *toFlag == "" {
+		fmt.Fprintln(os.Stderr, "--server, --metric, --from and --to are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		stdlog.Fatalf("FATAL: --from must be an RFC 3339 time: %v", err)
+	}
+	to, err := time.Parse(time.RFC3339, *toFlag)
+	if err != nil {
+		stdlog.Fatalf("FATAL: --to must be an RFC 3339 time: %v", err)
+	}
+	if !from.Before(to) {
+		stdlog.Fatalf("FATAL: --from must be before --to")
+	}
+	if to.After(time.Now()) {
+		stdlog.Fatalf("FATAL: --to must not be in the future")
+	}
+	if *step < 0 {
+		stdlog.Fatalf("FATAL: --step must be positive")
+	}
+
+	// Keep stdout for values of dry runs only, logs go to stderr
+	opts.logStderr = true
+
+	app, err := newApplication(opts)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	log := app.log
+	defer app.close()
+	kerberosScheduler, err := app.startKerberos()
+	if err != nil {
+		log.Error(err, "Failed to obtain Kerberos ticket")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if kerberosScheduler != nil {
+		defer kerberosScheduler.Stop()
+	}
+
+	if metricConfig, ok := app.metricConfigs[*metricName]; !ok || metricConfig.BackfillSQLFile == "" {
+		stdlog.Fatalf("Fatal error: metric '%s' has no backfill-sql-file", *metricName)
+	}
+	if !*dryRun {
+		if err := app.connectMetricsDB(); err != nil {
+			log.Error(err, "error connecting to metrics database server")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+		if err := app.initMetricsDB(); err != nil {
+			log.Error(err, "error initializing metrics database")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+	}
+	if err := app.connectServers(*serverName); err != nil {
+		log.Error(err, "Error establishing connection to database server", "server_name", *serverName)
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if !*dryRun {
+		if err := app.saveServers(); err != nil {
+			log.Error(err, "error saving servers to metrics DB")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+		if err := app.openSinks(); err != nil {
+			log.Error(err, "Failed to open sinks")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+		// Partitions are created for the current and coming months only, values of past windows need older ones
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.MetricsDB.WriteTimeout.Duration)
+		err := app.metricsDB.CreateMetricPartitions(ctx, from, to)
+		cancel()
+		if err != nil {
+			log.Error(err, "Failed to create metric_value partitions of the backfilled months")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+	}
+
+	tasks := app.buildMetricTasks(func(server, metric string) bool {
+		return server == *serverName && metric == *metricName
+	})
+	if len(tasks) == 0 {
+		stdlog.Fatalf("Fatal error: metric '%s' is not mapped to server '%s' in servers-metrics-map", *metricName, *serverName)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	failed := false
+	var rewindFrom time.Time // start of the first window with values stored or cleared
+	for _, task := range tasks {
+		windowStep := *step
+		if windowStep == 0 {
+			windowStep = task.Interval
+		}
+		if windows := to.Sub(from) / windowStep; windows > maxBackfillWindows {
+			stdlog.Fatalf("Fatal error: %d windows of %s exceed the limit of %d, use a longer --step", windows,
+				windowStep, maxBackfillWindows)
+		}
+		var filled map[time.Time]bool
+		if !*dryRun && !*force {
+			if filled, err = app.filledWindows(task, from, to, windowStep); err != nil {
+				log.Error(err, "Failed to read stored values")
+				stdlog.Fatalf("Fatal error: %v", err)
+			}
+		}
+
+		var windowEnd time.Time
+		task.DryRun = *dryRun
+		if *dryRun {
+			task.OnValue = func(task *collector.MetricTask, value json.RawMessage) {
+				encoder.Encode(backfilledValue{
+					Server:   task.ServerName,
+					Metric:   task.MetricName,
+					Database: task.DatabaseName,
+					Time:     windowEnd,
+					Value:    value,
+				})
+			}
+		}
+		stored, skipped, empty, failures := 0, 0, 0, 0
+		for start := from; start.Before(to); start = start.Add(windowStep) {
+			windowEnd = start.Add(windowStep)
+			if windowEnd.After(to) {
+				windowEnd = to
+			}
+			if filled[start] {
+				skipped++
+				continue
+			}
+			if *force && !*dryRun {
+				if err := app.clearWindow(task, start, windowEnd); err != nil {
+					task.Logger.Error(err, "Failed to delete stored values of the window", "window_start", start.UTC())
+					failures++
+					continue
+				}
+			}
+			ok, err := task.Backfill(context.Background(), start, windowEnd)
+			switch {
+			case err != nil:
+				failures++
+			case ok:
+				stored++
+			default:
+				empty++
+			}
+			if (ok || *force) && !*dryRun && (rewindFrom.IsZero() || start.Before(rewindFrom)) {
+				rewindFrom = start
+			}
+		}
+		task.Logger.Info("Backfill finished", "from", from, "to", to, "step", windowStep.String(), "stored", stored,
+			"skipped", skipped, "empty", empty, "failed", failures)
+		failed = failed || failures > 0
+	}
+	if !rewindFrom.IsZero() {
+		if err := app.rewindRollups(rewindFrom); err != nil {
+			log.Error(err, "Failed to rewind rollups, backfilled windows are not aggregated")
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// filledWindows returns starts of windows which already have a value of the task stored. A value stored
+// at the end of a window belongs to it, the way backfilled values are stored.
+func (app *application) filledWindows(task *collector.MetricTask, from time.Time, to time.Time,
+	step time.Duration) (map[time.Time]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+	values, err := sql.ReadMetricValues(ctx, app.metricsDB.DB(), sql.ValueQuery{
+		ServerName:   task.ServerName,
+		MetricName:   task.MetricName,
+		DatabaseName: task.DatabaseName,
+		From:         from,
+		To:           to.Add(time.Second),
+		Limit:        maxBackfillValues,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filled := make(map[time.Time]bool)
+	for _, value := range values {
+		if !value.Time.After(from) || value.Time.After(to) {
+			continue
+		}
+		window := (value.Time.Sub(from) - 1) / step
+		filled[from.Add(window*step)] = true
+	}
+	return filled, nil
+}
+
+// clearWindow deletes values of the task stored in the window, so recomputed ones replace them
+func (app *application) clearWindow(task *collector.MetricTask, start time.Time, end time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+	_, err := sql.DeleteMetricValues(ctx, app.metricsDB.DB(), task.ServerName, task.MetricName, task.DatabaseName,
+		start, end)
+	return err
+}
+
+// backfilledValue is printed by backfill --dry-run for every value
+type backfilledValue struct {
+	Server   string          `json:"server"`
+	Metric   string          `json:"metric"`
+	Database string          `json:"database,omitempty"`
+	Time     time.Time       `json:"time"`
+	Value    json.RawMessage `json:"value"`
+}
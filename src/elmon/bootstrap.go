@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"elmon/grafana"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+	"time"
+)
+
+// bootstrapCommand migrates the metrics DB, registers servers and metrics and provisions Grafana,
+// then exits. It suits init containers, collectors started with run don't repeat it.
+func bootstrapCommand(args []string) {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	noGrafana := flags.Bool("no-grafana", false, "don't provision the Grafana data source and dashboard")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	app, err := newApplication(opts)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	log := app.log
+	defer app.close()
+	kerberosScheduler, err := app.startKerberos()
+	if err != nil {
+		log.Error(err, "Failed to obtain Kerberos ticket")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if kerberosScheduler != nil {
+		defer kerberosScheduler.Stop()
+	}
+
+	if err := app.connectMetricsDB(); err != nil {
+		log.Error(err, "error connecting to metrics database server")
+		stdlog.Fatalf("Fatal error connecting to metrics SQL server: %v", err)
+	}
+	if err := app.initMetricsDB(); err != nil {
+		log.Error(err, "error initializing metrics database")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if err := app.saveServers(); err != nil {
+		log.Error(err, "error saving servers to metrics DB")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if !*noGrafana {
+		if err := app.provisionGrafana(); err != nil {
+			log.Error(err, "Failed to provision Grafana")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+	}
+	log.Info("Bootstrap completed")
+}
+
+// provisionGrafana creates or updates the metrics DB data source and imports the dashboard
+func (app *application) provisionGrafana() error {
+	grafanaConfig := app.config.Grafana
+	timeout := time.Duration(grafanaConfig.Timeout) * time.Second
+	client := grafana.NewClient(grafanaConfig.Url, grafanaConfig.Token, timeout)
+	ctx := context.Background()
+
+	dataSource := grafanaConfig.DataSource
+	uid, err := client.EnsureDataSource(ctx, grafana.DataSource{
+		Name:     dataSource.Name,
+		URL:      dataSource.URL,
+		Database: dataSource.Database,
+		User:     dataSource.User,
+		Password: dataSource.Password,
+		SSLMode:  dataSource.SSLMode,
+	})
+	if err != nil {
+		return err
+	}
+	app.log.Info("Grafana data source provisioned", "datasource", dataSource.Name, "uid", uid)
+
+	dashboard := grafanaConfig.Dashboard
+	raw, err := os.ReadFile(dashboard.File)
+	if err != nil {
+		return fmt.Errorf("failed to read dashboard file: %w", err)
+	}
+	err = client.ImportDashboard(ctx, grafana.Dashboard{
+		Title:     dashboard.Name,
+		JSON:      raw,
+		Input:     dashboard.Input,
+		Overwrite: dashboard.Overwrite,
+	}, uid)
+	if err != nil {
+		return err
+	}
+	app.log.Info("Grafana dashboard imported", "dashboard", dashboard.Name, "file", dashboard.File)
+	return nil
+}
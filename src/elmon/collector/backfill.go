@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"elmon/sql"
+	"fmt"
+	"time"
+)
+
+// Backfill recomputes the value of the window from start to end with BackfillSQL and stores it at end,
+// the time a collection covering the window would have run. The window bounds are rendered into the SQL
+// as window_start and window_end, RFC 3339 times in UTC. Returns false when the query returned NULL.
+// Windows of a counter metric must be backfilled in time order.
+func (task *MetricTask) Backfill(ctx context.Context, start time.Time, end time.Time) (bool, error) {
+	run := *task
+	run.collectedValues = 0
+	run.historical = true
+	run.TimeSource = "target"
+	run.Logger = task.Logger.With("window_start", start.UTC(), "window_end", end.UTC())
+
+	// Servers with several hosts backfill on the first host of the policy
+	if task.Hosts != nil {
+		hosts, err := task.Hosts.candidates(task.HostPolicy)
+		if err != nil {
+			return false, err
+		}
+		run.TargetDB = hosts[0].DB
+		run.Logger = run.Logger.With("host", hosts[0].Address)
+	}
+
+	script, err := RenderSQLFile(task.BackfillSQL, TemplateParams(task.SQLParams, map[string]interface{}{
+		"window_start": start.UTC().Format(time.RFC3339Nano),
+		"window_end":   end.UTC().Format(time.RFC3339Nano),
+	}))
+	if err == nil && task.RejectWriteSQL {
+		if err = sql.CheckReadOnlySQL(script); err != nil {
+			err = fmt.Errorf("backfill SQL file '%s': %w", task.BackfillSQL, err)
+		}
+	}
+	if err != nil {
+		run.Logger.Error(err, "Error rendering backfill SQL")
+		return false, err
+	}
+
+	queryStart := time.Now()
+	value, err := sql.ExecuteMetricValueGetScript(run.TargetDB, script, task.QueryTimeout, task.execOptions(),
+		task.ResultLimits)
+	if err != nil {
+		run.Logger.Error(err, "Error querying backfill value from target server")
+		return false, err
+	}
+	if value == nil {
+		return false, nil
+	}
+	if err := run.storeValue(ctx, value, time.Since(queryStart), end); err != nil {
+		run.Logger.Error(err, "Error storing backfilled value")
+		return false, err
+	}
+	return true, nil
+}
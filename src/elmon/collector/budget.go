@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"container/heap"
+	"context"
+	"elmon/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// budgetBurst is the share of a minute's budget which can start at once, the rest is spread over the minute
+const budgetBurst = 6
+
+// QueryBudget limits collections started on a target server per minute, e.g. to protect a small instance
+// from a mapping with many short intervals. Runs over the budget wait, runs of higher Priority first, so
+// lower-priority metrics are collected less often. Shared by tasks of the server, a nil QueryBudget is unlimited.
+type QueryBudget struct {
+	mutex     sync.Mutex
+	perMinute int
+	burst     float64
+	tokens    float64
+	updated   time.Time
+	waiters   slotWaiters
+	seq       uint64
+	timer     *time.Timer // hands out tokens to waiters, nil when none is pending
+	exhausted time.Time   // start of the current exhaustion, zero when runs don't wait
+	delayed   int         // runs which waited since exhausted
+	logger    *logger.Logger
+}
+
+// NewQueryBudget creates a budget of collections per minute, nil for 0
+func NewQueryBudget(perMinute int, log *logger.Logger) *QueryBudget {
+	if perMinute <= 0 {
+		return nil
+	}
+	burst := max(1, float64(perMinute)/budgetBurst)
+	return &QueryBudget{perMinute: perMinute, burst: burst, tokens: burst, updated: time.Now(), logger: log}
+}
+
+// acquire takes a run from the budget, waiting while it is exhausted
+func (budget *QueryBudget) acquire(ctx context.Context, task *MetricTask) error {
+	if budget == nil {
+		return nil
+	}
+	budget.mutex.Lock()
+	budget.refill(time.Now())
+	if len(budget.waiters) == 0 && budget.tokens >= 1 {
+		budget.tokens--
+		budget.mutex.Unlock()
+		return nil
+	}
+	budget.seq++
+	waiter := &slotWaiter{priority: task.Priority, seq: budget.seq, ready: make(chan struct{})}
+	heap.Push(&budget.waiters, waiter)
+	budget.delayed++
+	if budget.exhausted.IsZero() {
+		budget.exhausted = time.Now()
+		budget.logger.Warn("Query budget of the server is exhausted, lower priority metrics are delayed",
+			"queries_per_minute", budget.perMinute)
+	}
+	budget.schedule()
+	budget.mutex.Unlock()
+
+	task.Logger.Debug("Waiting for the query budget of the server", "queries_per_minute", budget.perMinute,
+		"priority", task.Priority)
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		budget.mutex.Lock()
+		defer budget.mutex.Unlock()
+		if waiter.index >= 0 {
+			heap.Remove(&budget.waiters, waiter.index)
+		} else {
+			budget.tokens++ // handed over together with the cancellation, give it back
+			budget.dispatch()
+		}
+		return fmt.Errorf("query budget of %d per minute is exhausted: %w", budget.perMinute, ctx.Err())
+	}
+}
+
+// refill adds tokens earned since the last update, up to the burst
+func (budget *QueryBudget) refill(now time.Time) {
+	earned := now.Sub(budget.updated).Minutes() * float64(budget.perMinute)
+	budget.tokens = min(budget.burst, budget.tokens+earned)
+	budget.updated = now
+}
+
+// dispatch hands tokens to waiters in priority order and reports the end of exhaustion
+func (budget *QueryBudget) dispatch() {
+	budget.refill(time.Now())
+	for len(budget.waiters) > 0 && budget.tokens >= 1 {
+		budget.tokens--
+		waiter := heap.Pop(&budget.waiters).(*slotWaiter)
+		close(waiter.ready)
+	}
+	if len(budget.waiters) > 0 {
+		budget.schedule()
+		return
+	}
+	if !budget.exhausted.IsZero() {
+		budget.logger.Info("Query budget of the server is no longer exhausted", "queries_per_minute", budget.perMinute,
+			"delayed_runs", budget.delayed, "exhausted_for", time.Since(budget.exhausted).Round(time.Second).String())
+		budget.exhausted, budget.delayed = time.Time{}, 0
+	}
+}
+
+// schedule starts the timer handing out the next token, unless it is already pending
+func (budget *QueryBudget) schedule() {
+	if budget.timer != nil {
+		return
+	}
+	wait := time.Duration((1 - budget.tokens) / float64(budget.perMinute) * float64(time.Minute))
+	budget.timer = time.AfterFunc(max(wait, time.Millisecond), func() {
+		budget.mutex.Lock()
+		defer budget.mutex.Unlock()
+		budget.timer = nil
+		budget.dispatch()
+	})
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	elsql "elmon/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BundlePart is a metric stored from a key of the object collected by a bundle metric
+type BundlePart struct {
+	Key  string
+	Task *MetricTask // storage settings of the part metric, copied for every value
+}
+
+// storeParts stores keys of the bundle value as separate metrics. Scalars are stored as {"value": ...} and objects
+// as they are. Labels returned by the bundle apply to every part without labels of its own. Missing and null
+// keys are skipped, a part that fails doesn't stop the others. Metrics DB writes of all parts are done in one
+// transaction, so a partly stored bundle never appears.
+func (task *MetricTask) storeParts(ctx context.Context, value json.RawMessage, duration time.Duration,
+	targetTime time.Time) error {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(value, &document); err != nil || document == nil {
+		payload := string(value)
+		if len(payload) > maxPayloadInError {
+			payload = payload[:maxPayloadInError] + "..."
+		}
+		err := fmt.Errorf("value of a bundle metric must be a JSON object, got %s", payload)
+		task.Logger.Error(err, "Invalid bundle metric value")
+		return err
+	}
+
+	labels := document[labelsKey]
+	batch := &valueBatch{}
+	var errs []error
+	for _, part := range task.Parts {
+		raw, ok := document[part.Key]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		partValue, err := bundlePartValue(raw, labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key '%s': %w", part.Key, err))
+			continue
+		}
+		run := *part.Task
+		run.DryRun = task.DryRun
+		run.OnValue = task.OnValue
+		run.batch = batch
+		if err := run.storeValue(ctx, partValue, duration, targetTime); err != nil {
+			errs = append(errs, fmt.Errorf("metric '%s' of key '%s': %w", run.MetricName, part.Key, err))
+		}
+	}
+	if err := task.writeBatch(ctx, batch); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// valueBatch is a list of values written to the metrics DB together
+type valueBatch struct {
+	rows  []elsql.MetricValueRow
+	parts []batchPart // part of every row, spools its value when the batch fails
+}
+
+type batchPart struct {
+	task        *MetricTask
+	collectedAt time.Time
+}
+
+// add queues a value stored at storedAt, zero for the metrics DB clock, and spooled with collectedAt
+func (batch *valueBatch) add(task *MetricTask, storedAt time.Time, collectedAt time.Time, value json.RawMessage,
+	labels map[string]string, duration time.Duration, targetTime time.Time) {
+	batch.rows = append(batch.rows, elsql.MetricValueRow{
+		ValueTime:    storedAt,
+		MetricID:     task.MetricID,
+		ServerID:     task.ServerID,
+		DatabaseName: task.DatabaseName,
+		Value:        value,
+		Labels:       labels,
+		Duration:     duration,
+		TargetTime:   targetTime,
+		Latest:       task.StorageMode == "latest",
+	})
+	batch.parts = append(batch.parts, batchPart{task: task, collectedAt: collectedAt})
+}
+
+// writeBatch writes the batch in one transaction with a single write slot. When the metrics DB can't be
+// reached, values of parts with a spool are spooled, latest values are dropped as the next collection
+// replaces them. Values rejected by a reachable metrics DB are not spooled.
+func (task *MetricTask) writeBatch(ctx context.Context, batch *valueBatch) error {
+	if len(batch.rows) == 0 {
+		return nil
+	}
+	slotCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+	err := task.DBHealth.Err()
+	if err == nil {
+		var release func()
+		if release, err = task.Limits.acquireWrite(slotCtx, task); err == nil {
+			err = task.MetricsDB.WriteMetricValues(ctx, task.Logger, batch.rows)
+			release()
+			if err != nil && task.writeRejected(ctx, err) {
+				task.Logger.Error(err, "Bundle values rejected by metrics DB", "values", len(batch.rows))
+				return err
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	task.Logger.Error(err, "Failed to write bundle values", "values", len(batch.rows))
+
+	var errs []error
+	for i, row := range batch.rows {
+		part := batch.parts[i]
+		if part.task.Spool == nil || row.Latest {
+			errs = append(errs, fmt.Errorf("metric '%s': %w", part.task.MetricName, err))
+			continue
+		}
+		if spoolErr := part.task.spoolValue(err, part.collectedAt, row.Value, row.Labels, row.Duration,
+			row.TargetTime); spoolErr != nil {
+			errs = append(errs, fmt.Errorf("metric '%s': %w", part.task.MetricName, spoolErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// bundlePartValue wraps a scalar into a value object and adds bundle labels to it
+func bundlePartValue(raw json.RawMessage, labels json.RawMessage) (json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil || object == nil {
+		object = map[string]json.RawMessage{"value": raw}
+	} else if _, ok := object[labelsKey]; ok || labels == nil {
+		return raw, nil // kept as collected
+	}
+	if labels != nil {
+		object[labelsKey] = labels
+	}
+	return json.Marshal(object)
+}
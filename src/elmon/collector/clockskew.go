@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"elmon/alert"
+	"elmon/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// clockOffset reads a clock and returns its offset from the collector clock and the round trip of the
+// read. The collector time is taken in the middle of the round trip, so query latency is taken out.
+func clockOffset(read func() (time.Time, error)) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	clock, err := read()
+	if err != nil {
+		return 0, 0, err
+	}
+	roundTrip := time.Since(start)
+	return clock.Sub(start.Add(roundTrip / 2)), roundTrip, nil
+}
+
+// collectClockSkew stores clock skew of the target server against the metrics DB: "value" is the skew
+// in seconds, positive when the target clock is ahead, and "error_seconds" is how far off the skew may
+// be because of query latency. Offsets of both clocks from the collector clock are stored as well.
+// An alert is fired when the skew exceeds "max_skew" param (e.g. 1s), skew corrupts correlation of
+// time series of different servers.
+func collectClockSkew(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	maxSkew, err := durationParam(task.Params, "max_skew", 0)
+	if err != nil {
+		log.Error(err, "Invalid collectClockSkew params")
+		return err
+	}
+	if task.Driver == sql.DriverPgBouncer {
+		err := fmt.Errorf("collectClockSkew needs a server with a clock, PgBouncer has none")
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	targetOffset, targetRoundTrip, err := clockOffset(func() (time.Time, error) {
+		return sql.GetServerTime(task.TargetDB, task.Driver, task.QueryTimeout)
+	})
+	if err != nil {
+		log.Error(err, "Error reading target server clock")
+		return err
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+	metricsDBOffset, metricsDBRoundTrip, err := clockOffset(func() (time.Time, error) {
+		return task.MetricsDB.Now(queryCtx)
+	})
+	if err != nil {
+		log.Error(err, "Error reading metrics DB clock")
+		return err
+	}
+
+	skew := targetOffset - metricsDBOffset
+	errorMargin := (targetRoundTrip + metricsDBRoundTrip) / 2
+	value, err := json.Marshal(map[string]interface{}{
+		"value":                     skew.Seconds(),
+		"error_seconds":             errorMargin.Seconds(),
+		"target_offset_seconds":     targetOffset.Seconds(),
+		"metrics_db_offset_seconds": metricsDBOffset.Seconds(),
+	})
+	if err != nil {
+		log.Error(err, "Error serializing clock skew")
+		return err
+	}
+	if err := task.storeValue(ctx, value, targetRoundTrip, time.Now().Add(targetOffset)); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	// Skew within the error margin may be latency only
+	if maxSkew > 0 && time.Duration(math.Abs(float64(skew)))-errorMargin > maxSkew && task.Alerts != nil &&
+		!task.DryRun {
+		err := task.Alerts.Fire(queryCtx, alert.Alert{
+			Name:       "clock_skew",
+			Severity:   "warning",
+			ServerName: task.ServerName,
+			MetricName: task.MetricName,
+			Message: fmt.Sprintf("clock of server '%s' is %s off the metrics DB clock", task.ServerName,
+				skew.Round(time.Millisecond)),
+			Details: map[string]interface{}{
+				"skew_seconds":  skew.Seconds(),
+				"error_seconds": errorMargin.Seconds(),
+				"max_skew":      maxSkew.String(),
+			},
+		})
+		if err != nil {
+			log.Error(err, "Failed to send clock skew alert")
+		}
+	}
+	return nil
+}
@@ -2,21 +2,38 @@
 package collector
 
 import (
+	"context"
 	"elmon/logger"
 	"elmon/scheduler"
-	"fmt"
+	"encoding/json"
+	"sync"
+	"time"
 )
 
 type ServerMetricScheduler struct {
-	ServerName string
-	MetricName string
-	Scheduler  *scheduler.TaskScheduler
+	ServerName   string
+	MetricName   string
+	DatabaseName string // empty for server level metrics
+	Scheduler    *scheduler.TaskScheduler
+}
+
+// SchedulerState is the runtime state of a scheduled task
+type SchedulerState struct {
+	ServerName   string
+	MetricName   string
+	DatabaseName string
+	scheduler.State
 }
 
 // Collector handles metric collection from servers and storage into metrics database
 type Collector struct {
 	Logger     *logger.Logger
 	Schedulers []ServerMetricScheduler
+
+	mutex   sync.Mutex // guards Schedulers once the collector is started
+	started bool
+	paused  map[string]bool // servers whose schedulers are paused
+	standby bool            // all schedulers are paused while another instance is the leader
 }
 
 // Collector constructor
@@ -25,40 +42,209 @@ func NewCollector(
 	log *logger.Logger,
 ) *Collector {
 
-	var schedulers []ServerMetricScheduler
+	collector := &Collector{
+		Logger: log,
+		paused: make(map[string]bool),
+	}
 	for _, task := range tasks {
-		// Create scheduler with universal task
-		sch := scheduler.NewTaskScheduler(
-			task.Interval,
-			task.MaxRetries,
-			task.RetryDelay,
-			ProcessMetric, // Our executor function
-			task,          // Task payload
-			task.Logger,
-		)
-		schedulers = append(schedulers, ServerMetricScheduler{
-			ServerName: task.ServerName,
-			MetricName: task.MetricName,
-			Scheduler:  sch,
-		})
+		task.collector = collector
+		collector.Schedulers = append(collector.Schedulers, newMetricScheduler(task))
+	}
+	return collector
+}
+
+// newMetricScheduler creates scheduler with universal task, every attempt and skipped run is recorded in collection_status
+func newMetricScheduler(task *MetricTask) ServerMetricScheduler {
+	var sch *scheduler.TaskScheduler
+	sch = scheduler.NewTaskScheduler(
+		task.Interval,
+		task.MaxRetries,
+		task.RetryDelay,
+		func(ctx context.Context, payload interface{}) error {
+			err := ProcessMetric(ctx, payload) // Our executor function
+			task.recordStatus(ctx, err, sch.NextRun())
+			return err
+		},
+		task, // Task payload
+		task.Logger,
+	)
+	sch.AllowOverlap = task.AllowOverlap
+	sch.MissedTick = task.MissedTick
+	sch.BackoffAfter = task.BackoffAfter
+	sch.MaxBackoffInterval = task.MaxBackoff
+	sch.OnSkip = func() { task.recordSkip(sch.NextRun()) }
+	return ServerMetricScheduler{
+		ServerName:   task.ServerName,
+		MetricName:   task.MetricName,
+		DatabaseName: task.DatabaseName,
+		Scheduler:    sch,
+	}
+}
+
+// AddTasks schedules tasks of a server added at runtime, they start at once if the collector is running
+func (collector *Collector) AddTasks(tasks []*MetricTask) error {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	var added []ServerMetricScheduler
+	for _, task := range tasks {
+		task.collector = collector
+		sch := newMetricScheduler(task)
+		if collector.standby || collector.paused[task.ServerName] {
+			sch.Scheduler.Pause()
+		}
+		if collector.started {
+			if err := sch.Scheduler.Start(); err != nil {
+				for _, running := range added {
+					running.Scheduler.Stop()
+				}
+				return err
+			}
+		}
+		added = append(added, sch)
 	}
+	collector.Schedulers = append(collector.Schedulers, added...)
+	return nil
+}
+
+// RemoveServer stops and removes all schedulers of the server, returns their number
+func (collector *Collector) RemoveServer(serverName string) int {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
 
-	return &Collector{
-		Logger:     log,
-		Schedulers: schedulers,
+	kept := collector.Schedulers[:0]
+	removed := 0
+	for _, sch := range collector.Schedulers {
+		if sch.ServerName == serverName {
+			sch.Scheduler.Stop()
+			removed++
+			continue
+		}
+		kept = append(kept, sch)
 	}
+	clear(collector.Schedulers[len(kept):])
+	collector.Schedulers = kept
+	delete(collector.paused, serverName)
+	return removed
+}
+
+// PauseServer stops starting collections of all schedulers of the server until ResumeServer,
+// tasks added for the server meanwhile start paused. Returns the number of paused schedulers.
+func (collector *Collector) PauseServer(serverName string) int {
+	return collector.setServerPaused(serverName, true)
+}
+
+// ResumeServer starts collections of the server paused with PauseServer again from their next tick,
+// on standby they start once the collector leaves it. Returns the number of resumed schedulers.
+func (collector *Collector) ResumeServer(serverName string) int {
+	return collector.setServerPaused(serverName, false)
+}
+
+// setServerPaused pauses or resumes schedulers of the server while holding the mutex,
+// so no scheduler of the server is added or removed in between
+func (collector *Collector) setServerPaused(serverName string, paused bool) int {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	count := 0
+	for _, sch := range collector.Schedulers {
+		if sch.ServerName != serverName {
+			continue
+		}
+		if paused {
+			sch.Scheduler.Pause()
+		} else if !collector.standby {
+			sch.Scheduler.Resume()
+		}
+		count++
+	}
+	if paused {
+		collector.paused[serverName] = true
+	} else {
+		delete(collector.paused, serverName)
+	}
+	collector.Logger.Info("Server collection paused state changed", "server_name", serverName, "paused", paused,
+		"scheduler_count", count)
+	return count
+}
+
+// SetStandby pauses all schedulers while another instance collects, leaving standby resumes
+// schedulers of servers not paused with PauseServer. Tasks added on standby start paused.
+func (collector *Collector) SetStandby(standby bool) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	collector.standby = standby
+	for _, sch := range collector.Schedulers {
+		if standby || collector.paused[sch.ServerName] {
+			sch.Scheduler.Pause()
+		} else {
+			sch.Scheduler.Resume()
+		}
+	}
+	collector.Logger.Info("Collector standby state changed", "standby", standby, "scheduler_count", len(collector.Schedulers))
+}
+
+// IsServerPaused reports whether collections of the server are paused
+func (collector *Collector) IsServerPaused(serverName string) bool {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return collector.paused[serverName]
+}
+
+// AddRoleCheck detects server role once synchronously, so metric tasks start
+// with a known role, and schedules periodic re-detection
+func (collector *Collector) AddRoleCheck(task *RoleCheckTask) {
+	if err := ProcessRoleCheck(context.Background(), task); err != nil {
+		task.Logger.Warn("Initial role detection failed, role-specific metrics will run until role is known")
+	}
+
+	sch := scheduler.NewTaskScheduler(
+		task.Interval,
+		0,
+		0,
+		ProcessRoleCheck,
+		task,
+		task.Logger,
+	)
+	collector.Schedulers = append(collector.Schedulers, ServerMetricScheduler{
+		ServerName: task.ServerName,
+		MetricName: "role_check",
+		Scheduler:  sch,
+	})
+}
+
+// State returns the state of every scheduled task in scheduling order
+func (collector *Collector) State() []SchedulerState {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	states := make([]SchedulerState, 0, len(collector.Schedulers))
+	for _, sch := range collector.Schedulers {
+		states = append(states, SchedulerState{
+			ServerName:   sch.ServerName,
+			MetricName:   sch.MetricName,
+			DatabaseName: sch.DatabaseName,
+			State:        sch.Scheduler.State(),
+		})
+	}
+	return states
 }
 
 // Start all schedulers
 func (collector *Collector) Start() error {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
 	for i := range collector.Schedulers {
 		scheduler := collector.Schedulers[i]
 		if err := scheduler.Scheduler.Start(); err != nil {
-			scheduler.Scheduler.Logger.Error(err, fmt.Sprintf("Error starting scheduler for server '%s' metric '%s'", scheduler.ServerName, scheduler.MetricName))
+			scheduler.Scheduler.Logger.Error(err, "Error starting scheduler")
 			return err
 		}
 	}
 
+	collector.started = true
 	collector.Logger.Info("All schedulers started")
 
 	return nil
@@ -66,9 +252,49 @@ func (collector *Collector) Start() error {
 
 // Stop all schedulers
 func (collector *Collector) Stop() {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
 	for i := range collector.Schedulers {
 		scheduler := collector.Schedulers[i]
 		scheduler.Scheduler.Stop()
 	}
+	collector.started = false
 	collector.Logger.Info("All schedulers stopped")
-}
\ No newline at end of file
+}
+
+// collectTaskPanics stores runs ended by a panic since the start, value is the total over all scheduled
+// tasks and tasks lists the counts of tasks which panicked by "server/metric[/database]"
+func collectTaskPanics(ctx context.Context, task *MetricTask) error {
+	var total uint64
+	tasks := make(map[string]uint64)
+	if task.collector != nil {
+		for _, state := range task.collector.State() {
+			if state.Panics == 0 {
+				continue
+			}
+			name := state.ServerName + "/" + state.MetricName
+			if state.DatabaseName != "" {
+				name += "/" + state.DatabaseName
+			}
+			tasks[name] = state.Panics
+			total += state.Panics
+		}
+	}
+
+	value, err := json.Marshal(map[string]interface{}{
+		"value": total,
+		"tasks": tasks,
+	})
+	if err != nil {
+		task.Logger.Error(err, "Error serializing task panics")
+		return err
+	}
+
+	err = task.storeValue(ctx, value, 0, time.Time{})
+	if err != nil {
+		task.Logger.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Suffixes of keys added next to every number of counter values
+const (
+	deltaSuffix = "_delta"
+	rateSuffix  = "_rate"
+)
+
+// CounterState remembers the previous sample of a "counter" metric task
+type CounterState struct {
+	mutex    sync.Mutex
+	time     time.Time
+	previous map[string]json.Number // dotted key path -> number
+}
+
+func NewCounterState() *CounterState {
+	return &CounterState{}
+}
+
+// Apply adds "<key>_delta" and "<key>_rate" (per second) next to every number of the value object,
+// nested objects included. The first sample is returned unchanged. A number lower than before
+// means the counter was reset, e.g. by a server restart, then the delta is the number itself.
+func (c *CounterState) Apply(value json.RawMessage, collectedAt time.Time) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber() // large counters stay exact
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("counter value is not valid JSON: %w", err)
+	}
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return value, nil // arrays have no stable keys to compare
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := make(map[string]json.Number)
+	elapsed := collectedAt.Sub(c.time).Seconds()
+	first := c.previous == nil
+	addDeltas(object, "", func(path string, number json.Number) (json.Number, json.Number, bool) {
+		current[path] = number
+		previous, ok := c.previous[path]
+		if first || !ok || elapsed <= 0 {
+			return "", "", false
+		}
+		delta := counterDelta(previous, number)
+		deltaFloat, _ := strconv.ParseFloat(string(delta), 64)
+		rate := json.Number(strconv.FormatFloat(deltaFloat/elapsed, 'f', -1, 64))
+		return delta, rate, true
+	})
+	c.previous = current
+	c.time = collectedAt
+
+	if first {
+		return value, nil
+	}
+	result, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize counter value: %w", err)
+	}
+	return result, nil
+}
+
+// addDeltas walks object numbers and adds delta and rate keys returned by compute
+func addDeltas(object map[string]interface{}, prefix string,
+	compute func(path string, number json.Number) (json.Number, json.Number, bool)) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		path := prefix + key
+		switch typed := object[key].(type) {
+		case json.Number:
+			if delta, rate, ok := compute(path, typed); ok {
+				object[key+deltaSuffix] = delta
+				object[key+rateSuffix] = rate
+			}
+		case map[string]interface{}:
+			addDeltas(typed, path+".", compute)
+		}
+	}
+}
+
+// counterDelta subtracts numbers exactly when both are integers, a decrease is a reset
+func counterDelta(previous, current json.Number) json.Number {
+	previousInt, previousErr := previous.Int64()
+	currentInt, currentErr := current.Int64()
+	if previousErr == nil && currentErr == nil {
+		if currentInt < previousInt {
+			return current
+		}
+		return json.Number(strconv.FormatInt(currentInt-previousInt, 10))
+	}
+
+	previousFloat, _ := previous.Float64()
+	currentFloat, _ := current.Float64()
+	if currentFloat < previousFloat {
+		return current
+	}
+	return json.Number(strconv.FormatFloat(currentFloat-previousFloat, 'f', -1, 64))
+}
@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"context"
+	"elmon/logger"
+	elsql "elmon/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrMetricsDBDown is returned for writes skipped while the metrics DB fails health checks
+var ErrMetricsDBDown = errors.New("metrics DB is unreachable")
+
+// MetricsDBHealth is the metrics DB connectivity found by a background ping. While the metrics DB is down,
+// values are spooled at once instead of waiting for write-timeout, and the spool is not replayed.
+type MetricsDBHealth struct {
+	repair func() // called on recovery, e.g. to drop connections broken by the outage
+
+	mutex     sync.Mutex
+	down      bool
+	since     time.Time // start of the current state
+	lastError string
+	outages   uint64
+}
+
+// MetricsDBState is a snapshot of MetricsDBHealth
+type MetricsDBState struct {
+	Up        bool
+	Since     time.Time // start of the current state
+	LastError string    // error of the last failed ping, kept after recovery
+	Outages   uint64    // times the metrics DB went down since the start
+}
+
+// NewMetricsDBHealth assumes the metrics DB is up, repair is called when it recovers from an outage
+func NewMetricsDBHealth(repair func()) *MetricsDBHealth {
+	return &MetricsDBHealth{repair: repair, since: time.Now()}
+}
+
+// Err returns ErrMetricsDBDown with the ping error while the metrics DB is down, nil health is always up
+func (health *MetricsDBHealth) Err() error {
+	if health == nil {
+		return nil
+	}
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if !health.down {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrMetricsDBDown, health.lastError)
+}
+
+// Up reports whether the last ping succeeded
+func (health *MetricsDBHealth) Up() bool {
+	return health.Err() == nil
+}
+
+func (health *MetricsDBHealth) State() MetricsDBState {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return MetricsDBState{
+		Up:        !health.down,
+		Since:     health.since,
+		LastError: health.lastError,
+		Outages:   health.outages,
+	}
+}
+
+// Check pings the metrics DB within timeout and logs state changes
+func (health *MetricsDBHealth) Check(ctx context.Context, log *logger.Logger, storage elsql.Storage,
+	timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := storage.Ping(ctx)
+
+	health.mutex.Lock()
+	wasDown, downSince := health.down, health.since
+	health.down = err != nil
+	if err != nil {
+		health.lastError = err.Error()
+	}
+	if health.down != wasDown {
+		health.since = time.Now()
+		if health.down {
+			health.outages++
+		}
+	}
+	health.mutex.Unlock()
+
+	switch {
+	case err != nil && !wasDown:
+		log.Error(err, "Metrics DB is unreachable, values are spooled until it recovers")
+	case err == nil && wasDown:
+		if health.repair != nil {
+			health.repair()
+		}
+		log.Info("Metrics DB recovered", "down_seconds", time.Since(downSince).Seconds())
+	}
+}
+
+// collectMetricsDBHealth stores metrics DB health seen by this collector, value is 1 while it is up.
+// Values collected during an outage are stored through the spool.
+func collectMetricsDBHealth(ctx context.Context, task *MetricTask) error {
+	state := MetricsDBState{Up: true}
+	if task.DBHealth != nil {
+		state = task.DBHealth.State()
+	}
+	up, downSeconds := 1, 0.0
+	if !state.Up {
+		up, downSeconds = 0, time.Since(state.Since).Seconds()
+	}
+
+	value, err := json.Marshal(map[string]interface{}{
+		"value":        up,
+		"outages":      state.Outages,
+		"down_seconds": downSeconds,
+	})
+	if err != nil {
+		task.Logger.Error(err, "Error serializing metrics DB health")
+		return err
+	}
+
+	err = task.storeValue(ctx, value, 0, time.Time{})
+	if err != nil {
+		task.Logger.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"context"
+	"elmon/expression"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ValueCache keeps the latest value of metrics used by expressions, per server and database
+type ValueCache struct {
+	mutex  sync.RWMutex
+	values map[valueCacheKey]cachedValue
+}
+
+type valueCacheKey struct {
+	server, database, metric string
+}
+
+type cachedValue struct {
+	time  time.Time
+	value json.RawMessage
+}
+
+func NewValueCache() *ValueCache {
+	return &ValueCache{values: make(map[valueCacheKey]cachedValue)}
+}
+
+// Set replaces the latest value of the metric
+func (c *ValueCache) Set(server, database, metric string, value json.RawMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[valueCacheKey{server, database, metric}] = cachedValue{time: time.Now(), value: value}
+}
+
+// Get returns the latest value of the metric and its collection time
+func (c *ValueCache) Get(server, database, metric string) (json.RawMessage, time.Time, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	cached, ok := c.values[valueCacheKey{server, database, metric}]
+	return cached.value, cached.time, ok
+}
+
+// executeExpressionMetric computes the value from latest values of other metrics of the same
+// server and database. Nothing is stored while a referenced value is missing or too old.
+func executeExpressionMetric(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	if task.Cache == nil || task.Expression == nil {
+		err := fmt.Errorf("expression is not configured for metric '%s'", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	maxAge := task.ExpressionMaxAge
+	if maxAge == 0 {
+		maxAge = 3 * task.Interval
+	}
+
+	start := time.Now()
+	result, err := task.Expression.Evaluate(func(ref expression.Reference) (float64, error) {
+		return task.lookupReference(ref, maxAge)
+	})
+	duration := time.Since(start)
+	if errors.Is(err, expression.ErrNoValue) {
+		log.Debug("Expression value skipped", "expression", task.Expression.String(), "reason", err)
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Error evaluating metric expression", "expression", task.Expression.String())
+		return err
+	}
+
+	value, err := json.Marshal(map[string]float64{"value": result})
+	if err != nil {
+		log.Error(err, "Error serializing expression value")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, time.Time{}); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
+
+// lookupReference returns number of the referenced value collected within maxAge
+func (task *MetricTask) lookupReference(ref expression.Reference, maxAge time.Duration) (float64, error) {
+	value, collectedAt, ok := task.Cache.Get(task.ServerName, task.DatabaseName, ref.Metric)
+	if !ok {
+		return 0, fmt.Errorf("'%s' is not collected yet: %w", ref.Metric, expression.ErrNoValue)
+	}
+	if age := time.Since(collectedAt); age > maxAge {
+		return 0, fmt.Errorf("'%s' was collected %s ago: %w", ref.Metric, age.Round(time.Second), expression.ErrNoValue)
+	}
+
+	var node interface{}
+	if err := json.Unmarshal(value, &node); err != nil {
+		return 0, fmt.Errorf("value of '%s' is not valid JSON: %w", ref.Metric, err)
+	}
+	for _, key := range ref.Path {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("'%s' is not found in value of '%s'", ref, ref.Metric)
+		}
+		if node, ok = object[key]; !ok {
+			return 0, fmt.Errorf("'%s' is not found in value of '%s'", ref, ref.Metric)
+		}
+	}
+
+	switch typed := node.(type) {
+	case float64:
+		return typed, nil
+	case bool:
+		if typed {
+			return 1, nil
+		}
+		return 0, nil
+	case nil:
+		return 0, fmt.Errorf("'%s' is null: %w", ref, expression.ErrNoValue)
+	default:
+		return 0, fmt.Errorf("'%s' is not a number", ref)
+	}
+}
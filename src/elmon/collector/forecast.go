@@ -0,0 +1,275 @@
+package collector
+
+import (
+	"context"
+	"elmon/alert"
+	elsql "elmon/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxForecastValues caps stored values read for a forecast, a metric collected every 10s
+// has about 60000 in a week
+const maxForecastValues = 100000
+
+// Forecast fits a line to stored values of another metric of the same server and database
+// and estimates when they reach Threshold
+type Forecast struct {
+	Metric       string
+	Path         []string // keys of the number in values, e.g. [value]
+	Window       time.Duration
+	MinPoints    int
+	Threshold    float64 // 0 takes ThresholdKey or the threshold param
+	ThresholdKey string  // key of the latest value holding the limit
+	AlertWithin  time.Duration
+}
+
+// NewForecast returns the forecast of a metric reading the dotted key of values of the source metric
+func NewForecast(metric string, key string, window time.Duration, minPoints int, threshold float64,
+	thresholdKey string, alertWithin time.Duration) *Forecast {
+	return &Forecast{
+		Metric:       metric,
+		Path:         strings.Split(key, "."),
+		Window:       window,
+		MinPoints:    minPoints,
+		Threshold:    threshold,
+		ThresholdKey: thresholdKey,
+		AlertWithin:  alertWithin,
+	}
+}
+
+// forecastValue is stored by "forecast" metrics, value is null while the values don't grow
+type forecastValue struct {
+	Value       *float64   `json:"value"` // days until the threshold is reached, 0 when it is
+	SlopePerDay float64    `json:"slope_per_day"`
+	Current     float64    `json:"current"` // the line at the time of the forecast
+	Last        float64    `json:"last"`
+	Threshold   float64    `json:"threshold"`
+	ReachedAt   *time.Time `json:"reached_at"`
+	Points      int        `json:"points"`
+	R2          float64    `json:"r2"` // share of the variance explained by the line, 1 for a perfect fit
+}
+
+// executeForecastMetric fits a line by least squares to values of the source metric stored within
+// the window and stores the days left until the line reaches the threshold. Nothing is stored while
+// fewer than MinPoints values are available.
+func executeForecastMetric(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	forecast := task.Forecast
+	if forecast == nil {
+		err := fmt.Errorf("forecast is not configured for metric '%s'", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+	if task.MetricsDB == nil {
+		err := fmt.Errorf("forecast of metric '%s' requires the metrics database", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	start := time.Now()
+	queryCtx := ctx
+	if task.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, task.QueryTimeout)
+		defer cancel()
+	}
+	values, err := elsql.ReadMetricValues(queryCtx, task.MetricsDB.DB(), elsql.ValueQuery{
+		ServerName:   task.ServerName,
+		MetricName:   forecast.Metric,
+		DatabaseName: task.DatabaseName,
+		From:         start.Add(-forecast.Window),
+		To:           start.Add(time.Second),
+		Limit:        maxForecastValues,
+		Descending:   true,
+	})
+	if err != nil {
+		log.Error(err, "Error reading values of the forecast metric", "forecast_metric", forecast.Metric)
+		return err
+	}
+
+	var times, points []float64
+	for _, stored := range values {
+		if number, ok := numberAt(stored.Value, forecast.Path); ok {
+			times = append(times, stored.Time.Sub(start).Hours()/24)
+			points = append(points, number)
+		}
+	}
+	if len(points) < forecast.MinPoints {
+		log.Debug("Forecast skipped, not enough values", "forecast_metric", forecast.Metric, "values", len(points),
+			"min_points", forecast.MinPoints)
+		return nil
+	}
+
+	threshold, err := task.forecastThreshold(values[0].Value)
+	if err != nil {
+		log.Error(err, "Forecast threshold is not available")
+		return err
+	}
+
+	// Values are read latest first
+	result := fitForecast(times, points, threshold, start)
+	result.Last = points[0]
+	if result.Last >= threshold {
+		reached := 0.0
+		result.Value, result.ReachedAt = &reached, nil
+	}
+	duration := time.Since(start)
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		log.Error(err, "Error serializing forecast value")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, time.Time{}); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	task.alertForecast(ctx, result)
+	return nil
+}
+
+// fitForecast fits a line to the points, times are days relative to now
+func fitForecast(times []float64, points []float64, threshold float64, now time.Time) forecastValue {
+	n := float64(len(points))
+	var meanTime, meanPoint float64
+	for i := range points {
+		meanTime += times[i] / n
+		meanPoint += points[i] / n
+	}
+	var covariance, timeVariance, pointVariance float64
+	for i := range points {
+		covariance += (times[i] - meanTime) * (points[i] - meanPoint)
+		timeVariance += (times[i] - meanTime) * (times[i] - meanTime)
+		pointVariance += (points[i] - meanPoint) * (points[i] - meanPoint)
+	}
+
+	result := forecastValue{Threshold: threshold, Points: len(points), Current: meanPoint}
+	if timeVariance > 0 {
+		result.SlopePerDay = covariance / timeVariance
+		result.Current = meanPoint - result.SlopePerDay*meanTime
+	}
+	if pointVariance > 0 && timeVariance > 0 {
+		result.R2 = covariance * covariance / (timeVariance * pointVariance)
+	} else if timeVariance > 0 {
+		result.R2 = 1 // constant values lie on the line
+	}
+
+	switch {
+	case result.Current >= threshold:
+		days := 0.0
+		result.Value = &days
+	case result.SlopePerDay > 0:
+		days := (threshold - result.Current) / result.SlopePerDay
+		reachedAt := now.Add(time.Duration(days * 24 * float64(time.Hour))).UTC().Truncate(time.Second)
+		days = math.Round(days*100) / 100
+		result.Value = &days
+		result.ReachedAt = &reachedAt
+	}
+	return result
+}
+
+// forecastThreshold returns the threshold param of the server, the threshold of the forecast
+// or the number at ThresholdKey of the latest value, in that order
+func (task *MetricTask) forecastThreshold(latest json.RawMessage) (float64, error) {
+	forecast := task.Forecast
+	if raw, ok := task.Params["threshold"]; ok {
+		if number, ok := raw.(float64); ok && number > 0 {
+			return number, nil
+		}
+		threshold, err := sizeParam(task.Params, "threshold", 0)
+		if err != nil {
+			return 0, err
+		}
+		return float64(threshold), nil
+	}
+	if forecast.Threshold > 0 {
+		return forecast.Threshold, nil
+	}
+	if forecast.ThresholdKey != "" {
+		if number, ok := numberAt(latest, strings.Split(forecast.ThresholdKey, ".")); ok {
+			return number, nil
+		}
+		return 0, fmt.Errorf("'%s' is not a number in the latest value of '%s'", forecast.ThresholdKey, forecast.Metric)
+	}
+	return 0, fmt.Errorf("forecast of metric '%s' has no threshold, set threshold, threshold-key or the threshold param",
+		task.MetricName)
+}
+
+// alertForecast fires an alert when the threshold is reached within AlertWithin
+func (task *MetricTask) alertForecast(ctx context.Context, result forecastValue) {
+	forecast := task.Forecast
+	if task.Alerts == nil || task.DryRun || forecast.AlertWithin == 0 || result.Value == nil {
+		return
+	}
+	days := *result.Value
+	if days*24*float64(time.Hour) >= float64(forecast.AlertWithin) {
+		return
+	}
+
+	severity := "warning"
+	message := fmt.Sprintf("%s reaches %s in %s days", forecast.Metric,
+		formatNumber(result.Threshold), formatNumber(days))
+	if days == 0 {
+		severity = "critical"
+		message = fmt.Sprintf("%s reached %s", forecast.Metric, formatNumber(result.Threshold))
+	}
+	details := map[string]interface{}{
+		"days":          days,
+		"slope_per_day": result.SlopePerDay,
+		"last":          result.Last,
+		"threshold":     result.Threshold,
+	}
+	if result.ReachedAt != nil {
+		details["reached_at"] = result.ReachedAt
+	}
+	err := task.Alerts.Fire(ctx, alert.Alert{
+		Name:         "capacity_forecast",
+		Key:          forecast.Metric,
+		Severity:     severity,
+		ServerName:   task.ServerName,
+		MetricName:   task.MetricName,
+		DatabaseName: task.DatabaseName,
+		Message:      message,
+		Details:      details,
+	})
+	if err != nil {
+		task.Logger.Error(err, "Failed to send capacity forecast alert")
+	}
+}
+
+// numberAt returns the number at the keys of a JSON object, booleans count as 0 and 1
+func numberAt(value json.RawMessage, path []string) (float64, bool) {
+	var node interface{}
+	if err := json.Unmarshal(value, &node); err != nil {
+		return 0, false
+	}
+	for _, key := range path {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		if node, ok = object[key]; !ok {
+			return 0, false
+		}
+	}
+	switch typed := node.(type) {
+	case float64:
+		return typed, true
+	case bool:
+		if typed {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// formatNumber formats numbers of alert messages without trailing zeros
+func formatNumber(number float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", number), "0"), ".")
+}
@@ -1,11 +1,17 @@
 package collector
 
 import (
+	"bytes"
 	"context"
+	"elmon/scheduler"
+	"elmon/sink"
 	"elmon/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"time"
 )
 
 // ProcessMetric - implementation of scheduler.TaskFunc
@@ -16,12 +22,66 @@ func ProcessMetric(ctx context.Context, taskPayload interface{}) error {
 		return fmt.Errorf("invalid task payload type: expected *MetricTask")
 	}
 
-	// Select collection method based on CollectionType
+	// Every run works on its own copy, records of the run carry the scheduler task id
+	run := *task
+	run.collectedValues = 0
+	taskID, ok := scheduler.TaskIDFromContext(ctx)
+	if ok {
+		run.Logger = task.Logger.With("task_id", taskID)
+	}
+	task = &run
+
+	// Skip primary-only metrics on replicas and vice versa
+	if task.Role != nil && !task.Role.Matches(task.RequiredRole) {
+		task.Logger.Debug("Metric skipped due to server role",
+			"role", task.Role.Get(), "required_role", task.RequiredRole)
+		return nil
+	}
+
+	// Waiting for a slot ends when the run is aborted, e.g. by stopping the scheduler
+	start := time.Now()
+	// Expressions and forecasts don't query the target, they are not counted in its budget
+	if task.CollectionType != "expression" && task.CollectionType != "forecast" {
+		if err := task.Budget.acquire(ctx, task); err != nil {
+			task.Logger.Warn("Metric collection skipped, query budget of the server is exhausted", "error", err)
+			task.recordAttempt(ctx, taskID, start, err)
+			return err
+		}
+	}
+	release, err := task.Limits.acquireCollection(ctx, task)
+	if err != nil {
+		task.Logger.Warn("Metric collection skipped, too many collections are running", "error", err)
+		task.recordAttempt(ctx, taskID, start, err)
+		return err
+	}
+	defer release()
+
+	// A panicking collector fails this run only and is recorded as any other failure
+	err = scheduler.Protect(task.Logger, func() error {
+		if task.Hosts != nil && (task.CollectionType == "sql" || task.CollectionType == "go_func") {
+			return collectOnHosts(ctx, task)
+		}
+		return collect(ctx, task)
+	})
+	task.recordAttempt(ctx, taskID, start, err)
+	return err
+}
+
+// collect runs the collection method of CollectionType
+func collect(ctx context.Context, task *MetricTask) error {
 	switch task.CollectionType {
 	case "sql":
-		return executeSQLMetric(task)
+		return executeSQLMetric(ctx, task)
 	case "go_func":
-		return executeGoFuncMetric(task) // <--- Updated to call the new function
+		return executeGoFuncMetric(ctx, task) // <--- Updated to call the new function
+	case "command":
+		return executeCommandMetric(ctx, task)
+	case "http":
+		return executeHTTPMetric(ctx, task)
+	case "expression":
+		return executeExpressionMetric(ctx, task)
+	case "forecast":
+		return executeForecastMetric(ctx, task)
 	default:
 		err := fmt.Errorf("collection type '%s' not implemented yet for metric '%s'",
 			task.CollectionType, task.MetricName)
@@ -30,26 +90,244 @@ func ProcessMetric(ctx context.Context, taskPayload interface{}) error {
 	}
 }
 
-// executeSQLMetric performs SQL metric collection
-func executeSQLMetric(task *MetricTask) error {
-	log := task.Logger
-	sqlScript, err := os.ReadFile(task.SQLFile)
+// storeValue writes collected value into metrics database and sinks together with the time spent
+// collecting it and the target server time (zero when unknown)
+func (task *MetricTask) storeValue(ctx context.Context, value json.RawMessage, duration time.Duration,
+	targetTime time.Time) error {
+	task.collectedValues++
+	if len(task.Parts) > 0 {
+		return task.storeParts(ctx, value, duration, targetTime)
+	}
+	if task.OnValue != nil {
+		task.OnValue(task, value)
+	}
+	stripped, labels, err := task.splitLabels(value)
 	if err != nil {
-		log.Error(err, "Error reading SQL file", "metric", task.MetricName, "file", task.SQLFile)
+		task.Logger.Error(err, "Invalid metric value labels", "value", string(value))
 		return err
 	}
+	value = stripped
+	// Values not matching value-type are rejected, dry runs report them as well
+	if err := validateValue(task.ValueType, value); err != nil {
+		task.Logger.Error(err, "Invalid metric value")
+		return err
+	}
+	collectedAt := time.Now()
+	if task.Counter != nil {
+		sampledAt := collectedAt
+		if task.historical {
+			sampledAt = targetTime
+		}
+		if value, err = task.Counter.Apply(value, sampledAt); err != nil {
+			task.Logger.Error(err, "Error calculating counter delta")
+			return err
+		}
+	}
+	// Expressions of the same server read the latest value
+	if task.Cache != nil {
+		task.Cache.Set(task.ServerName, task.DatabaseName, task.MetricName, value)
+	}
+	if task.DryRun {
+		return nil
+	}
+	// Sinks and the spool have no metrics DB clock, they use the collector clock instead
+	storedAt := task.valueTime(collectedAt, targetTime)
+	if !storedAt.IsZero() {
+		collectedAt = storedAt
+	}
+	// Historical values are stored at the end of their window, the target clock was not read
+	if task.historical {
+		targetTime = time.Time{}
+	}
+	// Sink failures fail the collection only when sinks are the only storage
+	sinkErr := task.writeSinks(ctx, collectedAt, value, labels, duration, targetTime)
+	if task.SkipMetricsDB {
+		return sinkErr
+	}
+	if task.batch != nil {
+		task.batch.add(task, storedAt, collectedAt, value, labels, duration, targetTime)
+		return nil
+	}
+	slotCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+	release := func() {}
+	err = task.DBHealth.Err()
+	if err == nil {
+		release, err = task.Limits.acquireWrite(slotCtx, task)
+	}
+	if err != nil {
+		if task.Spool != nil && task.StorageMode != "latest" {
+			return task.spoolValue(err, collectedAt, value, labels, duration, targetTime)
+		}
+		return err
+	}
+	defer release()
+	// Latest values are not spooled, the next collection replaces them anyway
+	if task.StorageMode == "latest" {
+		return task.MetricsDB.UpsertLatestMetricValue(ctx, task.Logger, task.MetricID, task.ServerID,
+			task.DatabaseName, value, labels, duration, targetTime)
+	}
+	err = task.MetricsDB.InsertMetricValue(ctx, task.Logger, storedAt, task.MetricID, task.ServerID,
+		task.DatabaseName, value, labels, duration, targetTime)
+	if err != nil && task.Spool != nil && !task.writeRejected(ctx, err) {
+		return task.spoolValue(err, collectedAt, value, labels, duration, targetTime)
+	}
+	return err
+}
+
+// valueTime returns metric_value time of a value by TimeSource, zero stands for the metrics DB clock
+func (task *MetricTask) valueTime(collectedAt time.Time, targetTime time.Time) time.Time {
+	switch task.TimeSource {
+	case "collector":
+		return collectedAt
+	case "target":
+		return targetTime
+	}
+	return time.Time{}
+}
+
+// writeSinks sends value to every sink, failures are logged and returned together
+func (task *MetricTask) writeSinks(ctx context.Context, collectedAt time.Time, value json.RawMessage,
+	labels map[string]string, duration time.Duration, targetTime time.Time) error {
+	if len(task.Sinks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
 
-	value, err := sql.ExecuteMetricValueGetScript(task.TargetDB, string(sqlScript), task.QueryTimeout)
+	sinkValue := sink.Value{
+		Time:         collectedAt,
+		ServerName:   task.ServerName,
+		MetricName:   task.MetricName,
+		DatabaseName: task.DatabaseName,
+		ServerID:     task.ServerID,
+		MetricID:     task.MetricID,
+		Value:        value,
+		Labels:       labels,
+		Duration:     duration,
+		TargetTime:   targetTime,
+	}
+	var errs []error
+	for _, output := range task.Sinks {
+		if err := output.Write(ctx, sinkValue); err != nil {
+			task.Logger.Error(err, "Error writing metric value to sink", "sink", output.Name())
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// execOptions returns the transaction settings of metric SQL
+func (task *MetricTask) execOptions() sql.ExecOptions {
+	return sql.ExecOptions{ReadOnly: task.ReadOnly, ServerTimeouts: task.ServerTimeouts}
+}
+
+// targetTime returns current time of the target server, zero if it cannot be determined
+func (task *MetricTask) targetTime() time.Time {
+	serverTime, err := sql.GetServerTime(task.TargetDB, task.Driver, task.QueryTimeout)
 	if err != nil {
-		log.Error(err, "Error querying metric from target server", "metric", task.MetricName, "server", task.ServerName)
+		task.Logger.Warn("Failed to get target server time", "error", err)
+		return time.Time{}
+	}
+	return serverTime
+}
+
+// recordStatus stores the result of a collection attempt in collection_status table.
+// Status update failures are logged only and never fail the collection itself. The status of an
+// aborted run is stored as well, only the write timeout applies.
+func (task *MetricTask) recordStatus(ctx context.Context, runErr error, nextRun time.Time) {
+	if task.DryRun || task.MetricsDB == nil || !task.DBHealth.Up() {
+		return
+	}
+	_ = task.MetricsDB.UpsertCollectionStatus(context.WithoutCancel(ctx), task.Logger, task.ServerID, task.MetricID,
+		task.DatabaseName, runErr, nextRun)
+}
+
+// Statuses of collection attempts in collection_log table
+const (
+	AttemptSuccess = "success" // at least one value was collected
+	AttemptEmpty   = "empty"   // the collection returned NULL or no output, nothing was stored
+	AttemptError   = "error"
+	AttemptTimeout = "timeout" // query-timeout or the wait for a collection slot expired
+)
+
+// recordAttempt stores the outcome of a collection attempt in collection_log table when it is enabled
+func (task *MetricTask) recordAttempt(ctx context.Context, taskID uint64, start time.Time, runErr error) {
+	if !task.CollectionLog || task.DryRun || task.MetricsDB == nil || !task.DBHealth.Up() {
+		return
+	}
+	status := AttemptSuccess
+	switch {
+	case errors.Is(runErr, context.DeadlineExceeded):
+		status = AttemptTimeout
+	case runErr != nil:
+		status = AttemptError
+	case task.collectedValues == 0:
+		status = AttemptEmpty
+	}
+	_ = task.MetricsDB.InsertCollectionLog(context.WithoutCancel(ctx), task.Logger, task.ServerID, task.MetricID,
+		task.DatabaseName, taskID, start, time.Since(start), status, runErr)
+}
+
+// recordSkip counts a run skipped because the previous one was still active in collection_status table
+func (task *MetricTask) recordSkip(nextRun time.Time) {
+	if task.DryRun || task.MetricsDB == nil || !task.DBHealth.Up() {
+		return
+	}
+	_ = task.MetricsDB.RecordSkippedRun(context.Background(), task.Logger, task.ServerID, task.MetricID,
+		task.DatabaseName, nextRun)
+}
+
+// executeSQLMetric performs SQL metric collection
+func executeSQLMetric(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	sqlScript := task.SQLScript
+	if task.ReloadSQL {
+		var err error
+		sqlScript, err = task.reloadSQL()
+		if err != nil {
+			log.Error(err, "Failed to reload changed SQL file, the previous version is used", "file", task.SQLFile)
+		}
+	}
+	if sqlScript == "" {
+		content, err := sql.ReadScript(task.SQLFile)
+		if err != nil {
+			log.Error(err, "Error reading SQL file", "file", task.SQLFile)
+			return err
+		}
+		sqlScript = string(content)
+	}
+
+	// Truncated results are stored anyway, the warning tells how much was dropped
+	limits := task.ResultLimits
+	limits.OnTruncate = func(keptRows int, size int) {
+		log.Warn("Metric result truncated", "kept_rows", keptRows, "result_bytes", size,
+			"max_rows", limits.MaxRows, "max_result_bytes", limits.MaxBytes)
+	}
+
+	start := time.Now()
+	var value json.RawMessage
+	var err error
+	if task.Driver == sql.DriverPgBouncer {
+		// PgBouncer admin console answers SHOW commands only
+		value, err = sql.ExecuteShowCommand(task.TargetDB, sqlScript, task.QueryTimeout, limits)
+	} else if task.Prepared != nil {
+		value, err = task.Prepared.Execute(task.TargetDB, sqlScript, task.QueryTimeout, task.execOptions(), limits)
+	} else {
+		value, err = sql.ExecuteMetricValueGetScript(task.TargetDB, sqlScript, task.QueryTimeout, task.execOptions(), limits)
+	}
+	duration := time.Since(start)
+	if err != nil {
+		log.Error(err, "Error querying metric from target server")
 		return err
 	}
 
 	// Skip NULL values
 	if value != nil {
-		err = sql.InsertMetricValue(log, task.MetricsDB, task.MetricID, task.ServerID, value)
+		err = task.storeValue(ctx, value, duration, task.targetTime())
 		if err != nil {
-			log.Error(err, "Error inserting metric value into metrics DB", "metric", task.MetricName)
+			log.Error(err, "Error inserting metric value into metrics DB")
 			return err
 		}
 	}
@@ -57,11 +335,90 @@ func executeSQLMetric(task *MetricTask) error {
 	return nil
 }
 
+// executeCommandMetric runs an external executable and stores its JSON stdout as metric value
+func executeCommandMetric(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+
+	cmdCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(cmdCtx, task.Command, task.CommandArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Let scripts know which server the value will be stored for
+	cmd.Env = append(os.Environ(),
+		"ELMON_SERVER_NAME="+task.ServerName,
+		"ELMON_METRIC_NAME="+task.MetricName,
+	)
+
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+	if err != nil {
+		if cmdCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("command timed out after %s: %w", task.QueryTimeout, cmdCtx.Err())
+		}
+		log.Error(err, "Error executing metric command", "command", task.Command, "stderr", stderr.String())
+		return err
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	// Empty output is treated the same way as NULL SQL result
+	if len(output) == 0 {
+		return nil
+	}
+	if !json.Valid(output) {
+		err := fmt.Errorf("command output is not valid JSON")
+		log.Error(err, "Error parsing metric command output", "command", task.Command)
+		return err
+	}
+
+	err = task.storeValue(ctx, json.RawMessage(output), duration, time.Time{})
+	if err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	return nil
+}
+
+// GoFunctionSupports reports whether a Go function can collect from servers of the driver. Functions
+// not listed read PostgreSQL views and would fail on every run elsewhere.
+func GoFunctionSupports(function string, driver string) bool {
+	switch function {
+	case "collectSpoolDepth", "collectMetricsDBHealth", "collectTaskPanics", "collectPatroni":
+		// The target server is not queried
+		return true
+	case "collectPostgresUptime", "collectClockSkew":
+		return driver == sql.DriverPostgres || driver == sql.DriverMySQL
+	}
+	return driver == sql.DriverPostgres
+}
+
 // executeGoFuncMetric selects and executes the appropriate Go function metric collector
-func executeGoFuncMetric(task *MetricTask) error {
+func executeGoFuncMetric(ctx context.Context, task *MetricTask) error {
 	switch task.GoFunction {
 	case "collectPostgresUptime":
-		return collectPostgresUptime(task)
+		return collectPostgresUptime(ctx, task)
+	case "collectSpoolDepth":
+		return collectSpoolDepth(ctx, task)
+	case "collectMetricsDBHealth":
+		return collectMetricsDBHealth(ctx, task)
+	case "collectTaskPanics":
+		return collectTaskPanics(ctx, task)
+	case "collectClockSkew":
+		return collectClockSkew(ctx, task)
+	case "collectStatementDeltas":
+		return collectStatementDeltas(ctx, task)
+	case "collectLockTree":
+		return collectLockTree(ctx, task)
+	case "collectLongTransactions":
+		return collectLongTransactions(ctx, task)
+	case "collectReplicationSlots":
+		return collectReplicationSlots(ctx, task)
+	case "collectPatroni":
+		return collectPatroni(ctx, task)
 	default:
 		err := fmt.Errorf("go function '%s' not implemented yet for metric '%s'",
 			task.GoFunction, task.MetricName)
@@ -70,37 +427,46 @@ func executeGoFuncMetric(task *MetricTask) error {
 	}
 }
 
-// collectPostgresUptime executes the PostgreSQL uptime query.
+// collectPostgresUptime executes the PostgreSQL uptime query, MySQL servers report their Uptime status.
 // It inserts the result or a default 0 uptime if the connection/query fails.
-func collectPostgresUptime(task *MetricTask) error {
+func collectPostgresUptime(ctx context.Context, task *MetricTask) error {
 	log := task.Logger
 	
 	// --- 1. Define SQL for Uptime ---
-	// This query calculates the difference in seconds between the current time and the postmaster start time.
+	// This query calculates the difference in whole seconds between the current time and the postmaster start time.
 	const uptimeSQL = `
-		SELECT jsonb_build_object('value', EXTRACT(EPOCH FROM (NOW() - pg_postmaster_start_time()))) AS metric_value;
+		SELECT jsonb_build_object('value', EXTRACT(EPOCH FROM (NOW() - pg_postmaster_start_time()))::bigint) AS metric_value;
 	`
 	
 	// --- 2. Attempt to query the actual Uptime ---
-	value, err := sql.ExecuteMetricValueGetScript(task.TargetDB, uptimeSQL, task.QueryTimeout)
+	start := time.Now()
+	var value json.RawMessage
+	var err error
+	if task.Driver == sql.DriverMySQL {
+		var uptime int64
+		if uptime, err = sql.GetMySQLUptime(task.TargetDB, task.QueryTimeout); err == nil {
+			value = json.RawMessage(fmt.Sprintf(`{"value": %d}`, uptime))
+		}
+	} else {
+		value, err = sql.ExecuteMetricValueGetScript(task.TargetDB, uptimeSQL, task.QueryTimeout, task.execOptions(),
+			sql.ResultLimits{})
+	}
+	duration := time.Since(start)
 
 	// --- 3. Handle connection/query failure (The main requirement) ---
 	if err != nil {
-		log.Warn("Failed to collect actual PostgreSQL uptime. Inserting 0 as uptime value.", 
-			"server", task.ServerName, 
-			"metric", task.MetricName, 
+		log.Warn("Failed to collect actual PostgreSQL uptime. Inserting 0 as uptime value.",
 			"error", err)
 
 		// Create a JSON object with uptime 0. This structure should match the successful SQL query's output.
 		zeroUptimeValue := json.RawMessage(`{"value": 0}`)
 		
 		// Insert the zero uptime value into the metrics database
-		insertErr := sql.InsertMetricValue(log, task.MetricsDB, task.MetricID, task.ServerID, zeroUptimeValue)
+		// Target time is unknown as the server is not reachable
+		insertErr := task.storeValue(ctx, zeroUptimeValue, duration, time.Time{})
 		if insertErr != nil {
 			// This is a critical failure: couldn't insert 0 value.
-			log.Error(insertErr, "CRITICAL: Failed to insert zero uptime value after connection error", 
-				"server", task.ServerName, 
-				"metric", task.MetricName)
+			log.Error(insertErr, "CRITICAL: Failed to insert zero uptime value after connection error")
 			return insertErr
 		}
 		
@@ -111,9 +477,9 @@ func collectPostgresUptime(task *MetricTask) error {
 	// --- 4. Handle successful query ---
 	// If value is nil, it means the query returned 0 rows (handled in ExecuteMetricValueGetScript, but unlikely here).
 	if value != nil {
-		err = sql.InsertMetricValue(log, task.MetricsDB, task.MetricID, task.ServerID, value)
+		err = task.storeValue(ctx, value, duration, task.targetTime())
 		if err != nil {
-			log.Error(err, "Error inserting actual uptime value into metrics DB", "metric", task.MetricName)
+			log.Error(err, "Error inserting actual uptime value into metrics DB")
 			return err
 		}
 	}
@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"elmon/scheduler"
+	elsql "elmon/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Host policies of metrics, they choose the hosts of a HostPool a collection runs on
+const (
+	HostPolicyPrimaryOnly   = "primary-only"   // the primary only, collections fail while it is down
+	HostPolicyPreferReplica = "prefer-replica" // replicas in turn, the primary when no replica is up
+	HostPolicyAny           = "any"            // all hosts in turn
+)
+
+// PoolHost is a host of a server with several hosts together with its last detected state
+type PoolHost struct {
+	Address string // host:port
+	DB      *sql.DB
+
+	mutex sync.Mutex
+	state hostState
+}
+
+// hostState is the last detected state of a PoolHost
+type hostState struct {
+	checked bool
+	up      bool
+	role    string // the last role detected, kept while the host is down
+}
+
+// get returns the last detected state
+func (host *PoolHost) get() hostState {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	return host.state
+}
+
+// set stores the detected state and returns the previous one, role is kept when empty
+func (host *PoolHost) set(up bool, role string) hostState {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	previous := host.state
+	host.state.checked, host.state.up = true, up
+	if role != "" {
+		host.state.role = role
+	}
+	return previous
+}
+
+// HostPool routes collections of a server listing its primary and replicas to the hosts allowed by
+// the host policy of the metric. Availability and roles of hosts are detected by Check, a collection
+// failing on an unreachable host moves on to the next one.
+type HostPool struct {
+	ServerName string
+	Hosts      []*PoolHost
+
+	Interval     time.Duration // of host checks
+	QueryTimeout time.Duration // of pings and role detection
+
+	Logger *logger.Logger
+
+	next atomic.Uint64 // rotates hosts sharing the load
+}
+
+// Check pings every host and detects its role, changes are logged. Returns an error
+// when no host is up.
+func (pool *HostPool) Check(ctx context.Context) error {
+	up := 0
+	for _, host := range pool.Hosts {
+		log := pool.Logger.With("host", host.Address)
+		role, err := pool.detectRole(ctx, host)
+		if err != nil {
+			if previous := host.set(false, ""); previous.up || !previous.checked {
+				log.Warn("Host is down, collections move to other hosts", "error", err)
+			} else {
+				log.Debug("Host is still down", "error", err)
+			}
+			continue
+		}
+		up++
+		previous := host.set(true, role)
+		switch {
+		case previous.role == "":
+			log.Info("Host role detected", "role", role)
+		case previous.role != role:
+			log.Warn("Host role changed", "previous_role", previous.role, "role", role)
+		case !previous.up:
+			log.Info("Host is up again", "role", role)
+		}
+	}
+	if up == 0 {
+		return fmt.Errorf("no host of server '%s' is reachable", pool.ServerName)
+	}
+	return nil
+}
+
+// detectRole returns the role of a host, pinging it first so a dead host fails within QueryTimeout
+func (pool *HostPool) detectRole(ctx context.Context, host *PoolHost) (string, error) {
+	pingCtx, cancel := context.WithTimeout(ctx, pool.QueryTimeout)
+	defer cancel()
+	if err := host.DB.PingContext(pingCtx); err != nil {
+		return "", err
+	}
+	return elsql.GetServerRole(host.DB, pool.QueryTimeout)
+}
+
+// DB returns the connection of the primary, of the first host up when no primary is up
+// and of the first host when all are down
+func (pool *HostPool) DB() *sql.DB {
+	var firstUp *sql.DB
+	for _, host := range pool.Hosts {
+		state := host.get()
+		if !state.up {
+			continue
+		}
+		if state.role == elsql.RolePrimary {
+			return host.DB
+		}
+		if firstUp == nil {
+			firstUp = host.DB
+		}
+	}
+	if firstUp != nil {
+		return firstUp
+	}
+	return pool.Hosts[0].DB
+}
+
+// candidates returns hosts a collection of the policy tries in order
+func (pool *HostPool) candidates(policy string) ([]*PoolHost, error) {
+	var primaries, replicas, all []*PoolHost
+	for _, host := range pool.Hosts {
+		state := host.get()
+		if !state.up {
+			continue
+		}
+		all = append(all, host)
+		switch state.role {
+		case elsql.RolePrimary:
+			primaries = append(primaries, host)
+		case elsql.RoleReplica:
+			replicas = append(replicas, host)
+		}
+	}
+
+	var hosts []*PoolHost
+	switch policy {
+	case HostPolicyPreferReplica:
+		hosts = append(pool.rotate(replicas), primaries...)
+	case HostPolicyAny:
+		hosts = pool.rotate(all)
+	default:
+		hosts = primaries
+	}
+	if len(hosts) == 0 {
+		if policy == HostPolicyPrimaryOnly || policy == "" {
+			return nil, fmt.Errorf("no primary host of server '%s' is available", pool.ServerName)
+		}
+		return nil, fmt.Errorf("no host of server '%s' is available", pool.ServerName)
+	}
+	return hosts, nil
+}
+
+// rotate returns hosts starting from the next one in turn, so runs are spread over them
+func (pool *HostPool) rotate(hosts []*PoolHost) []*PoolHost {
+	if len(hosts) < 2 {
+		return hosts
+	}
+	start := int(pool.next.Add(1) % uint64(len(hosts)))
+	return append(hosts[start:len(hosts):len(hosts)], hosts[:start]...)
+}
+
+// unreachable pings a host whose collection failed, a host not answering is marked down
+// until the next check finds it up
+func (pool *HostPool) unreachable(ctx context.Context, host *PoolHost) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, pool.QueryTimeout)
+	defer cancel()
+	err := host.DB.PingContext(pingCtx)
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if previous := host.set(false, ""); previous.up {
+		pool.Logger.Warn("Host is down, collections move to other hosts", "host", host.Address, "error", err)
+	}
+	return true
+}
+
+// Close closes connections of all hosts
+func (pool *HostPool) Close() {
+	for _, host := range pool.Hosts {
+		host.DB.Close()
+	}
+}
+
+// ProcessHostCheck - implementation of scheduler.TaskFunc for host checks of a *HostPool
+func ProcessHostCheck(ctx context.Context, taskPayload interface{}) error {
+	pool, ok := taskPayload.(*HostPool)
+	if !ok {
+		return fmt.Errorf("invalid task payload type: expected *HostPool")
+	}
+	err := pool.Check(ctx)
+	if err != nil {
+		pool.Logger.Error(err, "All hosts of the server are down")
+	}
+	return err
+}
+
+// collectOnHosts runs the collection on hosts allowed by the host policy of the task in turn,
+// moving to the next host while the current one is unreachable. Runs which stored values
+// already are not repeated.
+func collectOnHosts(ctx context.Context, task *MetricTask) error {
+	hosts, err := task.Hosts.candidates(task.HostPolicy)
+	if err != nil {
+		task.Logger.Warn("Metric collection skipped", "host_policy", task.HostPolicy, "error", err)
+		return err
+	}
+	log := task.Logger
+	for i, host := range hosts {
+		task.TargetDB = host.DB
+		task.Logger = log.With("host", host.Address)
+		err = collect(ctx, task)
+		if err == nil || i == len(hosts)-1 || task.collectedValues > 0 || !task.Hosts.unreachable(ctx, host) {
+			return err
+		}
+		task.Logger.Warn("Host is unreachable, collecting on the next host", "next_host", hosts[i+1].Address,
+			"error", err)
+	}
+	return err
+}
+
+// AddHostCheck schedules host checks of a server with several hosts. The first check runs
+// when the pool is connected, so the scheduler starts with known hosts.
+func (collector *Collector) AddHostCheck(pool *HostPool) error {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	sch := scheduler.NewTaskScheduler(pool.Interval, 0, 0, ProcessHostCheck, pool, pool.Logger)
+	if collector.standby || collector.paused[pool.ServerName] {
+		sch.Pause()
+	}
+	if collector.started {
+		if err := sch.Start(); err != nil {
+			return err
+		}
+	}
+	collector.Schedulers = append(collector.Schedulers, ServerMetricScheduler{
+		ServerName: pool.ServerName,
+		MetricName: "host_check",
+		Scheduler:  sch,
+	})
+	return nil
+}
@@ -0,0 +1,162 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpProbeResult is the JSON value stored for "http" collection type
+type httpProbeResult struct {
+	Success        bool            `json:"success"`
+	Status         int             `json:"status"`
+	ResponseTimeMs float64         `json:"response_time_ms"`
+	Value          json.RawMessage `json:"value,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// executeHTTPMetric performs HTTP request and stores status, response time and extracted value.
+// Failed probes are stored with success=false instead of being retried, like db_uptime does.
+func executeHTTPMetric(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	probe := task.HTTP
+	if probe == nil {
+		err := fmt.Errorf("http probe settings are missing for metric '%s'", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	start := time.Now()
+	result := runHTTPProbe(ctx, probe, task.QueryTimeout)
+	duration := time.Since(start)
+	if !result.Success {
+		log.Warn("HTTP probe failed",
+			"url", probe.URL, "status", result.Status, "error", result.Error)
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		log.Error(err, "Error serializing HTTP probe result")
+		return err
+	}
+
+	err = task.storeValue(ctx, value, duration, time.Time{})
+	if err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	return nil
+}
+
+// runHTTPProbe executes the request and evaluates response against probe expectations
+func runHTTPProbe(ctx context.Context, probe *HTTPProbe, timeout time.Duration) httpProbeResult {
+	var result httpProbeResult
+
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if probe.Body != "" {
+		body = strings.NewReader(probe.Body)
+	}
+	request, err := http.NewRequestWithContext(reqCtx, probe.Method, probe.URL, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for name, value := range probe.Headers {
+		request.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		result.ResponseTimeMs = float64(time.Since(start).Microseconds()) / 1000
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	result.ResponseTimeMs = float64(time.Since(start).Microseconds()) / 1000
+	result.Status = response.StatusCode
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read response body: %v", err)
+		return result
+	}
+
+	if response.StatusCode != probe.ExpectedStatus {
+		result.Error = fmt.Sprintf("unexpected status %d, expected %d", response.StatusCode, probe.ExpectedStatus)
+		return result
+	}
+
+	if probe.JSONPath != "" {
+		value, err := extractJSONPath(responseBody, probe.JSONPath)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Value = value
+	}
+
+	result.Success = true
+	return result
+}
+
+// extractJSONPath returns the element addressed by a simple JSONPath
+// expression like $.members[0].state
+func extractJSONPath(document []byte, path string) (json.RawMessage, error) {
+	var current interface{}
+	if err := json.Unmarshal(document, &current); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+
+	tokens, err := splitJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("json-path '%s': key '%s' not found", path, token)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("json-path '%s': invalid array index '%s'", path, token)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("json-path '%s': cannot descend into scalar at '%s'", path, token)
+		}
+	}
+
+	return json.Marshal(current)
+}
+
+// splitJSONPath converts $.a.b[0] into [a b 0]
+func splitJSONPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json-path must start with '$': '%s'", path)
+	}
+	rest := strings.ReplaceAll(path[1:], "[", ".")
+	rest = strings.ReplaceAll(rest, "]", "")
+
+	var tokens []string
+	for _, token := range strings.Split(rest, ".") {
+		if token != "" {
+			tokens = append(tokens, strings.Trim(token, `'"`))
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// labelsKey is the key of the value object holding labels returned by the collection
+const labelsKey = "labels"
+
+// splitLabels removes "labels" object from the value and merges it over labels configured for the metric.
+// Label values must be strings, numbers and booleans are converted to text.
+func (task *MetricTask) splitLabels(value json.RawMessage) (json.RawMessage, map[string]string, error) {
+	labels := maps.Clone(task.Labels)
+	// Cheap check first, most values have no labels
+	if !bytes.Contains(value, []byte(`"`+labelsKey+`"`)) {
+		return value, labels, nil
+	}
+
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(value, &document); err != nil {
+		return value, labels, nil // not an object, labels are not possible
+	}
+	rawLabels, ok := document[labelsKey]
+	if !ok {
+		return value, labels, nil
+	}
+
+	var returned map[string]interface{}
+	if err := json.Unmarshal(rawLabels, &returned); err != nil || returned == nil {
+		return nil, nil, fmt.Errorf("'%s' of metric value must be a JSON object, got %s", labelsKey, rawLabels)
+	}
+	if labels == nil {
+		labels = make(map[string]string, len(returned))
+	}
+	for name, labelValue := range returned {
+		switch typed := labelValue.(type) {
+		case string:
+			labels[name] = typed
+		case float64:
+			labels[name] = strconv.FormatFloat(typed, 'f', -1, 64)
+		case bool:
+			labels[name] = strconv.FormatBool(typed)
+		case nil:
+			delete(labels, name) // null removes configured label
+		default:
+			return nil, nil, fmt.Errorf("label '%s' of metric value must be a string, number or boolean, got %v", name, labelValue)
+		}
+	}
+
+	delete(document, labelsKey)
+	stripped, err := json.Marshal(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize metric value without labels: %w", err)
+	}
+	return stripped, labels, nil
+}
@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Limits caps collections and metrics DB writes running at once over all tasks, so a large
+// mapping can't start thousands of queries together. A nil Limits is unlimited.
+// When all slots are taken, tasks of higher Priority get free slots first.
+type Limits struct {
+	collections *prioritySlots // nil when unlimited
+	writes      *prioritySlots
+}
+
+// NewLimits creates limits shared by tasks, 0 means unlimited
+func NewLimits(maxCollections int, maxWrites int) *Limits {
+	return &Limits{
+		collections: newPrioritySlots(maxCollections),
+		writes:      newPrioritySlots(maxWrites),
+	}
+}
+
+// acquireCollection waits for a free collection slot, the returned function frees it
+func (limits *Limits) acquireCollection(ctx context.Context, task *MetricTask) (func(), error) {
+	if limits == nil {
+		return func() {}, nil
+	}
+	return limits.collections.acquire(ctx, task, "collection")
+}
+
+// acquireWrite waits for a free metrics DB write slot, the returned function frees it
+func (limits *Limits) acquireWrite(ctx context.Context, task *MetricTask) (func(), error) {
+	if limits == nil {
+		return func() {}, nil
+	}
+	return limits.writes.acquire(ctx, task, "write")
+}
+
+// prioritySlots is a semaphore handing a freed slot to the waiter of the highest priority,
+// waiters of equal priority get slots in arrival order
+type prioritySlots struct {
+	mutex   sync.Mutex
+	max     int
+	used    int
+	waiters slotWaiters
+	seq     uint64 // arrival order of waiters
+}
+
+// newPrioritySlots returns max slots, nil for 0
+func newPrioritySlots(max int) *prioritySlots {
+	if max <= 0 {
+		return nil
+	}
+	return &prioritySlots{max: max}
+}
+
+func (slots *prioritySlots) acquire(ctx context.Context, task *MetricTask, kind string) (func(), error) {
+	if slots == nil {
+		return func() {}, nil
+	}
+	slots.mutex.Lock()
+	if slots.used < slots.max && len(slots.waiters) == 0 {
+		slots.used++
+		slots.mutex.Unlock()
+		return slots.release, nil
+	}
+	slots.seq++
+	waiter := &slotWaiter{priority: task.Priority, seq: slots.seq, ready: make(chan struct{})}
+	heap.Push(&slots.waiters, waiter)
+	slots.mutex.Unlock()
+
+	task.Logger.Debug("Waiting for a free "+kind+" slot", "limit", slots.max, "priority", task.Priority)
+	select {
+	case <-waiter.ready:
+		return slots.release, nil
+	case <-ctx.Done():
+		slots.mutex.Lock()
+		defer slots.mutex.Unlock()
+		if waiter.index >= 0 {
+			heap.Remove(&slots.waiters, waiter.index)
+		} else {
+			// The slot was handed over together with the cancellation, pass it on
+			slots.releaseLocked()
+		}
+		return nil, fmt.Errorf("no free %s slot: %w", kind, ctx.Err())
+	}
+}
+
+// release hands the slot to the first waiter or frees it
+func (slots *prioritySlots) release() {
+	slots.mutex.Lock()
+	defer slots.mutex.Unlock()
+	slots.releaseLocked()
+}
+
+func (slots *prioritySlots) releaseLocked() {
+	if len(slots.waiters) == 0 {
+		slots.used--
+		return
+	}
+	waiter := heap.Pop(&slots.waiters).(*slotWaiter)
+	close(waiter.ready)
+}
+
+// slotWaiter is a task waiting for a slot, index is its position in slotWaiters, -1 once it got a slot
+type slotWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// slotWaiters implements heap.Interface, the highest priority and then the earliest waiter first
+type slotWaiters []*slotWaiter
+
+func (w slotWaiters) Len() int { return len(w) }
+
+func (w slotWaiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w slotWaiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *slotWaiters) Push(x interface{}) {
+	waiter := x.(*slotWaiter)
+	waiter.index = len(*w)
+	*w = append(*w, waiter)
+}
+
+func (w *slotWaiters) Pop() interface{} {
+	old := *w
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	waiter.index = -1
+	*w = old[:len(old)-1]
+	return waiter
+}
@@ -0,0 +1,231 @@
+package collector
+
+import (
+	"context"
+	"elmon/alert"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// lockSession is a session of the lock wait graph, blocking or blocked
+type lockSession struct {
+	PID             int     `json:"pid"`
+	BlockedBy       []int   `json:"blocked_by"` // sessions this one waits for, empty for root blockers
+	RootPID         int     `json:"root_pid"`   // blocker at the head of the chain
+	Depth           int     `json:"depth"`      // 0 for root blockers
+	BlockingCount   int     `json:"blocking_count"`
+	User            string  `json:"user"`
+	Application     string  `json:"application"`
+	ClientAddress   string  `json:"client_address"`
+	Database        string  `json:"database"`
+	State           string  `json:"state"`
+	WaitEventType   string  `json:"wait_event_type"`
+	WaitEvent       string  `json:"wait_event"`
+	XactAgeSeconds  float64 `json:"xact_age_seconds"`
+	StateAgeSeconds float64 `json:"state_age_seconds"` // time waiting for blocked sessions
+	Query           string  `json:"query"`
+	BackendStart    string  `json:"backend_start"`
+}
+
+// lockTreeSQL returns sessions blocking others or waiting for them, one JSON document per row
+const lockTreeSQL = `
+	with blocked as (
+		select pid, pg_blocking_pids(pid) as blocked_by
+		from pg_stat_activity
+		where cardinality(pg_blocking_pids(pid)) > 0
+	)
+	select json_build_object(
+		'pid', a.pid,
+		'blocked_by', coalesce(b.blocked_by, '{}'),
+		'user', coalesce(a.usename, ''),
+		'application', a.application_name,
+		'client_address', coalesce(host(a.client_addr), ''),
+		'database', coalesce(a.datname, ''),
+		'state', coalesce(a.state, ''),
+		'wait_event_type', coalesce(a.wait_event_type, ''),
+		'wait_event', coalesce(a.wait_event, ''),
+		'xact_age_seconds', coalesce(extract(epoch from now() - a.xact_start), 0),
+		'state_age_seconds', coalesce(extract(epoch from now() - a.state_change), 0),
+		'query', left(a.query, 1000),
+		'backend_start', a.backend_start
+	)
+	from pg_stat_activity a
+	left join blocked b on b.pid = a.pid
+	where b.pid is not null
+		or a.pid in (select unnest(blocked_by) from blocked)`
+
+// collectLockTree stores the lock wait graph: "value" is the number of blocked sessions and
+// "sessions" lists blockers and blocked sessions with their chain. With "alert_after" param
+// an alert is fired when a root blocker keeps a session waiting longer than that.
+func collectLockTree(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+
+	alertAfter, err := durationParam(task.Params, "alert_after", 0)
+	if err != nil {
+		log.Error(err, "Invalid collectLockTree params")
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := task.TargetDB.QueryContext(queryCtx, lockTreeSQL)
+	if err != nil {
+		log.Error(err, "Error querying lock wait graph")
+		return err
+	}
+	defer rows.Close()
+
+	sessions := make(map[int]*lockSession)
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			log.Error(err, "Error scanning lock wait graph")
+			return err
+		}
+		var session lockSession
+		if err := json.Unmarshal(document, &session); err != nil {
+			log.Error(err, "Error parsing lock wait graph")
+			return err
+		}
+		sessions[session.PID] = &session
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(err, "Error reading lock wait graph")
+		return err
+	}
+	duration := time.Since(start)
+
+	ordered := buildLockChains(sessions)
+	blockedCount := 0
+	for _, session := range ordered {
+		if len(session.BlockedBy) > 0 {
+			blockedCount++
+		}
+	}
+
+	value, err := json.Marshal(map[string]interface{}{"value": blockedCount, "sessions": ordered})
+	if err != nil {
+		log.Error(err, "Error serializing lock wait graph")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, task.targetTime()); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	if alertAfter > 0 {
+		task.alertBlockers(queryCtx, ordered, alertAfter)
+	}
+	return nil
+}
+
+// buildLockChains links sessions to their root blockers and returns them ordered by chain.
+// Sessions waiting in a cycle or behind one (deadlock before detection) are their own roots.
+func buildLockChains(sessions map[int]*lockSession) []*lockSession {
+	// rootOf returns the root blocker and the distance to it, false for a chain ending in a cycle
+	var rootOf func(pid int, visited map[int]bool) (int, int, bool)
+	rootOf = func(pid int, visited map[int]bool) (int, int, bool) {
+		if visited[pid] {
+			return pid, 0, false
+		}
+		session, ok := sessions[pid]
+		if !ok || len(session.BlockedBy) == 0 {
+			return pid, 0, true
+		}
+		visited[pid] = true
+		// The first blocker is followed, it is usually the lock holder
+		root, depth, ok := rootOf(session.BlockedBy[0], visited)
+		return root, depth + 1, ok
+	}
+
+	ordered := make([]*lockSession, 0, len(sessions))
+	for pid, session := range sessions {
+		root, depth, ok := rootOf(pid, map[int]bool{})
+		if !ok {
+			root, depth = pid, 0
+		}
+		session.RootPID, session.Depth = root, depth
+		if session.BlockedBy == nil {
+			session.BlockedBy = []int{}
+		}
+		if session.Depth > 0 {
+			if root, ok := sessions[session.RootPID]; ok {
+				root.BlockingCount++
+			}
+		}
+		ordered = append(ordered, session)
+	}
+	slices.SortFunc(ordered, func(a, b *lockSession) int {
+		if a.RootPID != b.RootPID {
+			return a.RootPID - b.RootPID
+		}
+		if a.Depth != b.Depth {
+			return a.Depth - b.Depth
+		}
+		return a.PID - b.PID
+	})
+	return ordered
+}
+
+// alertBlockers fires an alert for every root blocker keeping a session waiting longer than alertAfter
+func (task *MetricTask) alertBlockers(ctx context.Context, sessions []*lockSession, alertAfter time.Duration) {
+	if task.Alerts == nil || task.DryRun {
+		return
+	}
+	longestWait := make(map[int]float64)
+	for _, session := range sessions {
+		if session.Depth > 0 {
+			longestWait[session.RootPID] = max(longestWait[session.RootPID], session.StateAgeSeconds)
+		}
+	}
+	for _, session := range sessions {
+		wait, ok := longestWait[session.PID]
+		if session.Depth != 0 || !ok || wait < alertAfter.Seconds() {
+			continue
+		}
+		err := task.Alerts.Fire(ctx, alert.Alert{
+			Name: "blocking_session",
+			// Backend start tells apart sessions reusing the pid
+			Key:        strconv.Itoa(session.PID) + "@" + session.BackendStart,
+			Severity:   "warning",
+			ServerName: task.ServerName,
+			MetricName: task.MetricName,
+			Message: fmt.Sprintf("session %d blocks %d sessions for %s", session.PID, session.BlockingCount,
+				time.Duration(wait*float64(time.Second)).Round(time.Second)),
+			Details: map[string]interface{}{
+				"pid":            session.PID,
+				"user":           session.User,
+				"application":    session.Application,
+				"database":       session.Database,
+				"blocking_count": session.BlockingCount,
+				"wait_seconds":   wait,
+				"query":          session.Query,
+			},
+		})
+		if err != nil {
+			task.Logger.Error(err, "Failed to send blocking session alert", "pid", session.PID)
+		}
+	}
+}
+
+// durationParam returns duration param of a go function such as "30s", zero or missing means default
+func durationParam(params map[string]interface{}, name string, defaultValue time.Duration) (time.Duration, error) {
+	raw, ok := params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	text, ok := raw.(string)
+	if !ok {
+		return 0, fmt.Errorf("param '%s' must be a duration such as 30s, got %v", name, raw)
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("param '%s' must be a duration such as 30s, got '%s'", name, text)
+	}
+	return value, nil
+}
@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPatroniPort is the default port of Patroni REST API
+const defaultPatroniPort = 8008
+
+// patroniMember is a member of Patroni GET /cluster response
+type patroniMember struct {
+	Name           string      `json:"name"`
+	Role           string      `json:"role"`  // leader, standby_leader, sync_standby or replica
+	State          string      `json:"state"` // running, streaming, stopped, starting, ...
+	Host           string      `json:"host"`
+	Port           int         `json:"port"`
+	Timeline       int         `json:"timeline"`
+	Lag            interface{} `json:"lag"` // bytes behind the leader, "unknown" when Patroni can't tell
+	PendingRestart bool        `json:"pending_restart"`
+}
+
+// patroniCluster is Patroni GET /cluster response
+type patroniCluster struct {
+	Scope   string          `json:"scope"` // reported by Patroni 3.0 and later
+	Members []patroniMember `json:"members"`
+}
+
+// collectPatroni reads cluster state from Patroni REST API ("url" param, default http://<server host>:8008)
+// and stores the member running on this server together with the whole cluster: "value" is 1 when
+// the member is healthy. The member is found by the server host, resolved to addresses if needed.
+func collectPatroni(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+
+	host, _ := task.Params["host"].(string)
+	// A unix socket directory doesn't name the member, the "host" param has to
+	if strings.HasPrefix(host, "/") {
+		err := fmt.Errorf("param 'host' with the member address is required for a server connected through "+
+			"unix socket %s", host)
+		log.Error(err, "Invalid collectPatroni params")
+		return err
+	}
+	baseURL, _ := task.Params["url"].(string)
+	if baseURL == "" {
+		if host == "" {
+			err := fmt.Errorf("param 'url' is required when the server host is unknown")
+			log.Error(err, "Invalid collectPatroni params")
+			return err
+		}
+		baseURL = "http://" + net.JoinHostPort(host, strconv.Itoa(defaultPatroniPort))
+	}
+	port, _ := task.Params["port"].(int)
+
+	queryCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	cluster, err := fetchPatroniCluster(queryCtx, strings.TrimSuffix(baseURL, "/")+"/cluster")
+	duration := time.Since(start)
+	if err != nil {
+		log.Error(err, "Error reading Patroni cluster state", "url", baseURL)
+		return err
+	}
+
+	member, err := findPatroniMember(queryCtx, cluster.Members, host, port)
+	if err != nil {
+		log.Error(err, "Server is not a member of Patroni cluster", "url", baseURL)
+		return err
+	}
+
+	var leader *patroniMember
+	unhealthy := 0
+	for i := range cluster.Members {
+		if cluster.Members[i].Role == "leader" || cluster.Members[i].Role == "standby_leader" {
+			leader = &cluster.Members[i]
+		}
+		if !patroniMemberHealthy(cluster.Members[i]) {
+			unhealthy++
+		}
+	}
+
+	healthy := 0
+	if patroniMemberHealthy(*member) {
+		healthy = 1
+	}
+	result := map[string]interface{}{
+		"value":             healthy,
+		"scope":             cluster.Scope,
+		"member":            member.Name,
+		"role":              member.Role,
+		"state":             member.State,
+		"timeline":          member.Timeline,
+		"lag_bytes":         patroniLag(member.Lag),
+		"pending_restart":   member.PendingRestart,
+		"leader":            "",
+		"members_count":     len(cluster.Members),
+		"unhealthy_members": unhealthy,
+		"members":           cluster.Members,
+	}
+	if leader != nil {
+		result["leader"] = leader.Name
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		log.Error(err, "Error serializing Patroni cluster state")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, time.Time{}); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
+
+// fetchPatroniCluster requests and parses GET /cluster
+func fetchPatroniCluster(ctx context.Context, url string) (*patroniCluster, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Patroni request: %w", err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Patroni REST API: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Patroni response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Patroni REST API returned status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var cluster patroniCluster
+	if err := json.Unmarshal(body, &cluster); err != nil {
+		return nil, fmt.Errorf("failed to parse Patroni cluster state: %w", err)
+	}
+	if len(cluster.Members) == 0 {
+		return nil, fmt.Errorf("Patroni reported no cluster members")
+	}
+	return &cluster, nil
+}
+
+// findPatroniMember returns the member running on host. Members report addresses, so a host name
+// is resolved when it doesn't match directly. Port tells apart members sharing the host.
+func findPatroniMember(ctx context.Context, members []patroniMember, host string, port int) (*patroniMember, error) {
+	if host == "" {
+		return nil, fmt.Errorf("server host is unknown")
+	}
+	addresses := map[string]bool{strings.ToLower(host): true}
+	match := func() []*patroniMember {
+		var found []*patroniMember
+		for i := range members {
+			if addresses[strings.ToLower(members[i].Host)] {
+				found = append(found, &members[i])
+			}
+		}
+		return found
+	}
+
+	found := match()
+	if len(found) == 0 {
+		resolved, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("host '%s' doesn't match any member and can't be resolved: %w", host, err)
+		}
+		for _, address := range resolved {
+			addresses[address] = true
+		}
+		found = match()
+	}
+	if len(found) > 1 && port != 0 {
+		var samePort []*patroniMember
+		for _, member := range found {
+			if member.Port == port {
+				samePort = append(samePort, member)
+			}
+		}
+		found = samePort
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no Patroni member runs on host '%s'", host)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("several Patroni members run on host '%s' port %d", host, port)
+	}
+}
+
+// patroniMemberHealthy reports whether the member serves queries: leaders are "running",
+// replicas are "streaming" (Patroni 3) or "running" (older versions)
+func patroniMemberHealthy(member patroniMember) bool {
+	return member.State == "running" || member.State == "streaming"
+}
+
+// patroniLag returns replica lag in bytes, nil when unknown or for leaders
+func patroniLag(lag interface{}) interface{} {
+	if number, ok := lag.(float64); ok {
+		return int64(number)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CheckValue reports whether a value received from outside, e.g. pushed by an agent, would be rejected by
+// StoreValue, so a request can be rejected before any of its values is stored
+func (task *MetricTask) CheckValue(value json.RawMessage) error {
+	if len(task.Parts) > 0 {
+		var document map[string]json.RawMessage
+		if err := json.Unmarshal(value, &document); err != nil || document == nil {
+			return fmt.Errorf("value of a bundle metric must be a JSON object")
+		}
+		return nil
+	}
+	stripped, _, err := task.splitLabels(value)
+	if err != nil {
+		return err
+	}
+	return validateValue(task.ValueType, stripped)
+}
+
+// StoreValue stores a value received from outside the way collected values are stored: labels, counters,
+// sinks, the spool and limits apply. valueTime is stored as the target server time and, with TimeSource
+// "target", as the value time, zero stands for the metrics DB clock. Calls must not run at once.
+func (task *MetricTask) StoreValue(ctx context.Context, value json.RawMessage, valueTime time.Time) error {
+	return task.storeValue(ctx, value, 0, valueTime)
+}
@@ -0,0 +1,238 @@
+package collector
+
+import (
+	"context"
+	"elmon/alert"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replicationSlot is a row of the stored value
+type replicationSlot struct {
+	Name          string     `json:"slot_name"`
+	Type          string     `json:"slot_type"`
+	Database      string     `json:"database"`
+	Plugin        string     `json:"plugin"`
+	Active        bool       `json:"active"`
+	ActivePID     *int       `json:"active_pid"`
+	RetainedBytes int64      `json:"retained_bytes"` // WAL kept for the slot
+	WalStatus     string     `json:"wal_status"`     // reserved, extended, unreserved or lost, empty before PostgreSQL 13
+	SafeWalSize   *int64     `json:"safe_wal_size"`  // bytes left before max_slot_wal_keep_size removes WAL
+	InactiveSince *time.Time `json:"inactive_since"` // reported by PostgreSQL 17 and later
+	InactiveFor   int64      `json:"inactive_seconds"`
+}
+
+// replicationSlotsSQL returns slots as JSON documents. Columns added by later versions are read
+// through to_jsonb, so the query works on every supported version.
+const replicationSlotsSQL = `
+	select json_build_object(
+		'slot_name', s.slot_name,
+		'slot_type', s.slot_type,
+		'database', coalesce(s.database, ''),
+		'plugin', coalesce(s.plugin, ''),
+		'active', s.active,
+		'active_pid', s.active_pid,
+		'retained_bytes', coalesce(pg_wal_lsn_diff(
+			case when pg_is_in_recovery() then pg_last_wal_receive_lsn() else pg_current_wal_lsn() end,
+			s.restart_lsn), 0)::bigint,
+		'wal_status', coalesce(to_jsonb(s) ->> 'wal_status', ''),
+		'safe_wal_size', (to_jsonb(s) ->> 'safe_wal_size')::bigint,
+		'inactive_since', (to_jsonb(s) ->> 'inactive_since')::timestamptz
+	)
+	from pg_replication_slots s
+	order by s.slot_name`
+
+// collectReplicationSlots stores replication slots with the WAL they retain: "value" is the largest
+// retained size in bytes, "inactive" the number of inactive slots. Alerts are fired for slots retaining
+// more than "max_retained_wal" param (e.g. 10GB), inactive longer than "inactive_after" (e.g. 1h),
+// and for slots which lost required WAL or are about to lose it.
+func collectReplicationSlots(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	if task.State == nil {
+		err := fmt.Errorf("task state is not initialized for metric '%s'", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	maxRetained, err := sizeParam(task.Params, "max_retained_wal", 0)
+	if err != nil {
+		log.Error(err, "Invalid collectReplicationSlots params")
+		return err
+	}
+	inactiveAfter, err := durationParam(task.Params, "inactive_after", 0)
+	if err != nil {
+		log.Error(err, "Invalid collectReplicationSlots params")
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := task.TargetDB.QueryContext(queryCtx, replicationSlotsSQL)
+	if err != nil {
+		log.Error(err, "Error querying replication slots")
+		return err
+	}
+	defer rows.Close()
+
+	slots := []*replicationSlot{}
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			log.Error(err, "Error scanning replication slots")
+			return err
+		}
+		var slot replicationSlot
+		if err := json.Unmarshal(document, &slot); err != nil {
+			log.Error(err, "Error parsing replication slots")
+			return err
+		}
+		slots = append(slots, &slot)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(err, "Error reading replication slots")
+		return err
+	}
+	duration := time.Since(start)
+
+	// PostgreSQL doesn't tell how long a slot is inactive before version 17, then the collector
+	// remembers when it saw the slot inactive first
+	now := time.Now()
+	task.State.mutex.Lock()
+	inactiveSince, _ := task.State.value.(map[string]time.Time)
+	current := make(map[string]time.Time)
+	for _, slot := range slots {
+		if slot.Active {
+			continue
+		}
+		since, ok := inactiveSince[slot.Name]
+		if slot.InactiveSince != nil {
+			since = *slot.InactiveSince
+		} else if !ok {
+			since = now
+		}
+		current[slot.Name] = since
+		slot.InactiveFor = int64(now.Sub(since).Seconds())
+	}
+	task.State.value = current
+	task.State.mutex.Unlock()
+
+	var maxRetainedBytes int64
+	for _, slot := range slots {
+		maxRetainedBytes = max(maxRetainedBytes, slot.RetainedBytes)
+	}
+	value, err := json.Marshal(map[string]interface{}{"value": maxRetainedBytes, "inactive": len(current), "slots": slots})
+	if err != nil {
+		log.Error(err, "Error serializing replication slots")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, task.targetTime()); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	task.alertSlots(queryCtx, slots, maxRetained, inactiveAfter)
+	return nil
+}
+
+// alertSlots fires alerts for slots exceeding the thresholds, zero threshold disables its alert
+func (task *MetricTask) alertSlots(ctx context.Context, slots []*replicationSlot, maxRetained int64, inactiveAfter time.Duration) {
+	if task.Alerts == nil || task.DryRun {
+		return
+	}
+	fire := func(name, severity string, slot *replicationSlot, message string) {
+		err := task.Alerts.Fire(ctx, alert.Alert{
+			Name:       name,
+			Key:        slot.Name,
+			Severity:   severity,
+			ServerName: task.ServerName,
+			MetricName: task.MetricName,
+			Message:    message,
+			Details: map[string]interface{}{
+				"slot_name":        slot.Name,
+				"slot_type":        slot.Type,
+				"database":         slot.Database,
+				"active":           slot.Active,
+				"retained_bytes":   slot.RetainedBytes,
+				"wal_status":       slot.WalStatus,
+				"inactive_seconds": slot.InactiveFor,
+			},
+		})
+		if err != nil {
+			task.Logger.Error(err, "Failed to send replication slot alert", "slot_name", slot.Name)
+		}
+	}
+
+	for _, slot := range slots {
+		switch slot.WalStatus {
+		case "lost":
+			fire("replication_slot_lost", "critical", slot,
+				fmt.Sprintf("replication slot '%s' lost required WAL, its consumer must be rebuilt", slot.Name))
+		case "unreserved":
+			fire("replication_slot_unreserved", "critical", slot,
+				fmt.Sprintf("replication slot '%s' will lose required WAL at the next checkpoint", slot.Name))
+		}
+		if maxRetained > 0 && slot.RetainedBytes >= maxRetained {
+			fire("replication_slot_retained_wal", "warning", slot,
+				fmt.Sprintf("replication slot '%s' retains %s of WAL", slot.Name, formatSize(slot.RetainedBytes)))
+		}
+		if inactiveAfter > 0 && !slot.Active && time.Duration(slot.InactiveFor)*time.Second >= inactiveAfter {
+			fire("replication_slot_inactive", "warning", slot,
+				fmt.Sprintf("replication slot '%s' is inactive for %s", slot.Name, time.Duration(slot.InactiveFor)*time.Second))
+		}
+	}
+}
+
+// sizeUnits are suffixes accepted by sizeParam, largest first
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"kB", 1 << 10}, {"KB", 1 << 10}, {"B", 1},
+}
+
+// sizeParam returns size param of a go function in bytes, a plain number or a string such as
+// "512MB" or "10GB" (units of 1024 like PostgreSQL settings)
+func sizeParam(params map[string]interface{}, name string, defaultValue int64) (int64, error) {
+	raw, ok := params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch typed := raw.(type) {
+	case int:
+		if typed >= 0 {
+			return int64(typed), nil
+		}
+	case int64:
+		if typed >= 0 {
+			return typed, nil
+		}
+	case string:
+		text := strings.TrimSpace(typed)
+		multiplier := int64(1)
+		for _, unit := range sizeUnits {
+			if strings.HasSuffix(text, unit.suffix) {
+				text, multiplier = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix)), unit.bytes
+				break
+			}
+		}
+		if value, err := strconv.ParseInt(text, 10, 64); err == nil && value >= 0 {
+			return value * multiplier, nil
+		}
+	}
+	return 0, fmt.Errorf("param '%s' must be a size such as 10GB, got %v", name, raw)
+}
+
+// formatSize formats bytes with the largest unit of sizeUnits
+func formatSize(bytes int64) string {
+	for _, unit := range sizeUnits {
+		if bytes >= unit.bytes && unit.bytes > 1 {
+			return strconv.FormatFloat(float64(bytes)/float64(unit.bytes), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"elmon/logger"
+	"elmon/spool"
+	elsql "elmon/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// spoolValue keeps a value that failed to be inserted until the metrics DB recovers.
+// The collection is treated as successful once the value is spooled.
+func (task *MetricTask) spoolValue(insertErr error, collectedAt time.Time, value json.RawMessage,
+	labels map[string]string, duration time.Duration, targetTime time.Time) error {
+	err := task.Spool.Append(spool.Record{
+		Time:         collectedAt,
+		ServerID:     task.ServerID,
+		MetricID:     task.MetricID,
+		DatabaseName: task.DatabaseName,
+		Value:        value,
+		Labels:       labels,
+		Duration:     duration,
+		TargetTime:   targetTime,
+	})
+	if err != nil {
+		if errors.Is(err, spool.ErrFull) {
+			task.Logger.Error(err, "Metric value dropped, spool size limit reached")
+		} else {
+			task.Logger.Error(err, "Failed to spool metric value")
+		}
+		return insertErr
+	}
+
+	task.Logger.Warn("Metric value spooled until metrics DB recovers", "spool_records", task.Spool.Stats().Records)
+	return nil
+}
+
+// writeRejected reports whether a failed write was rejected by a reachable metrics DB, e.g. for a missing
+// partition or a violated constraint. Such values are not spooled, replay would drop them later anyway.
+// Timeouts are treated as an unreachable metrics DB.
+func (task *MetricTask) writeRejected(ctx context.Context, writeErr error) bool {
+	if errors.Is(writeErr, context.DeadlineExceeded) || errors.Is(writeErr, context.Canceled) {
+		return false
+	}
+	pingCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), task.QueryTimeout)
+	defer cancel()
+	return task.MetricsDB.Ping(pingCtx) == nil
+}
+
+// ReplaySpool writes spooled values into metrics database, it runs periodically while spooling is enabled.
+// A value rejected while the metrics DB is reachable would block the spool forever, it is dropped.
+// Returns the time of the oldest written value, zero when none was written.
+func ReplaySpool(ctx context.Context, log *logger.Logger, storage elsql.Storage, queue *spool.Spool) (time.Time, error) {
+	var oldest time.Time
+	replayed, err := queue.Replay(ctx, func(record spool.Record) error {
+		insertErr := storage.InsertMetricValue(ctx, log, record.Time, record.MetricID, record.ServerID,
+			record.DatabaseName, record.Value, record.Labels, record.Duration, record.TargetTime)
+		if insertErr == nil {
+			if oldest.IsZero() || record.Time.Before(oldest) {
+				oldest = record.Time
+			}
+			return nil
+		}
+		if pingErr := storage.Ping(ctx); pingErr != nil {
+			return insertErr
+		}
+		log.Error(insertErr, "Spooled metric value rejected by metrics DB, dropped",
+			"server_id", record.ServerID, "metric_id", record.MetricID)
+		return nil
+	})
+
+	stats := queue.Stats()
+	if replayed > 0 || stats.Records > 0 {
+		log.Info("Spool replayed", "replayed", replayed, "spool_records", stats.Records,
+			"spool_bytes", stats.Bytes, "spool_dropped", stats.Dropped)
+	}
+	if err != nil {
+		return oldest, fmt.Errorf("spool replay stopped: %w", err)
+	}
+	return oldest, nil
+}
+
+// collectSpoolDepth stores spool depth of this collector, value is the number of waiting records
+func collectSpoolDepth(ctx context.Context, task *MetricTask) error {
+	var stats spool.Stats
+	if task.Spool != nil {
+		stats = task.Spool.Stats()
+	}
+
+	value, err := json.Marshal(map[string]interface{}{
+		"value":   stats.Records,
+		"bytes":   stats.Bytes,
+		"dropped": stats.Dropped,
+	})
+	if err != nil {
+		task.Logger.Error(err, "Error serializing spool depth")
+		return err
+	}
+
+	err = task.storeValue(ctx, value, 0, time.Time{})
+	if err != nil {
+		task.Logger.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
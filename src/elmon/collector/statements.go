@@ -0,0 +1,221 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTopStatements is the number of statements stored when "top" param is not set
+const defaultTopStatements = 20
+
+// maxStatementText limits query text stored with every statement
+const maxStatementText = 1000
+
+// statementCounters are cumulative pg_stat_statements counters of one statement
+type statementCounters struct {
+	database string
+	queryID  string
+	query    string
+	calls    int64
+	timeMs   float64
+	rows     int64
+}
+
+// statementDelta is a row of the stored value
+type statementDelta struct {
+	QueryID     string  `json:"queryid"`
+	Database    string  `json:"database"`
+	Calls       int64   `json:"calls"`
+	TotalTimeMs float64 `json:"total_time_ms"`
+	MeanTimeMs  float64 `json:"mean_time_ms"`
+	Rows        int64   `json:"rows"`
+	Query       string  `json:"query"`
+}
+
+// collectStatementDeltas snapshots pg_stat_statements and stores the top statements by execution time
+// since the previous snapshot. Rows of one queryid in a database are merged (different users and
+// nesting levels), statements without queryid are identified by their text. Nothing is stored on
+// the first run as there is nothing to compare with.
+func collectStatementDeltas(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+	if task.State == nil {
+		err := fmt.Errorf("task state is not initialized for metric '%s'", task.MetricName)
+		log.Error(err, "Metric collection error")
+		return err
+	}
+
+	top, err := intParam(task.Params, "top", defaultTopStatements)
+	if err != nil {
+		log.Error(err, "Invalid collectStatementDeltas params")
+		return err
+	}
+
+	start := time.Now()
+	snapshot, err := snapshotStatements(task)
+	duration := time.Since(start)
+	if err != nil {
+		log.Error(err, "Error reading pg_stat_statements")
+		return err
+	}
+
+	task.State.mutex.Lock()
+	previous, _ := task.State.value.(map[string]statementCounters)
+	task.State.value = snapshot
+	task.State.mutex.Unlock()
+	if previous == nil {
+		log.Debug("First pg_stat_statements snapshot taken", "statements", len(snapshot))
+		return nil
+	}
+
+	deltas := diffStatements(previous, snapshot)
+	slices.SortFunc(deltas, func(a, b statementDelta) int {
+		switch {
+		case a.TotalTimeMs > b.TotalTimeMs:
+			return -1
+		case a.TotalTimeMs < b.TotalTimeMs:
+			return 1
+		default:
+			return int(b.Calls - a.Calls)
+		}
+	})
+	if len(deltas) > top {
+		deltas = deltas[:top]
+	}
+
+	value, err := json.Marshal(deltas)
+	if err != nil {
+		log.Error(err, "Error serializing statement deltas")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, task.targetTime()); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+	return nil
+}
+
+// snapshotStatements reads current counters keyed by database and normalized queryid
+func snapshotStatements(task *MetricTask) (map[string]statementCounters, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), task.QueryTimeout)
+	defer cancel()
+
+	// pg_stat_statements 1.8 (PostgreSQL 13) renamed total_time to total_exec_time
+	var hasExecTime bool
+	err := task.TargetDB.QueryRowContext(ctx, `
+		select exists (
+			select 1 from pg_attribute
+			where attrelid = 'pg_stat_statements'::regclass and attname = 'total_exec_time'
+		)`).Scan(&hasExecTime)
+	if err != nil {
+		return nil, fmt.Errorf("pg_stat_statements is not available, create the extension first: %w", err)
+	}
+	timeColumn := "total_time"
+	if hasExecTime {
+		timeColumn = "total_exec_time"
+	}
+
+	rows, err := task.TargetDB.QueryContext(ctx, fmt.Sprintf(`
+		select coalesce(s.queryid::text, ''), d.datname, min(s.query),
+			sum(s.calls)::bigint, sum(s.%s)::float8, sum(s.rows)::bigint
+		from pg_stat_statements s
+		join pg_database d on d.oid = s.dbid
+		group by d.datname, s.queryid, case when s.queryid is null then s.query end`, timeColumn))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pg_stat_statements: %w", err)
+	}
+	defer rows.Close()
+
+	snapshot := make(map[string]statementCounters)
+	for rows.Next() {
+		var counters statementCounters
+		if err := rows.Scan(&counters.queryID, &counters.database, &counters.query,
+			&counters.calls, &counters.timeMs, &counters.rows); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_stat_statements row: %w", err)
+		}
+		counters.query = normalizeStatementText(counters.query)
+		if counters.queryID == "" {
+			counters.queryID = textQueryID(counters.query)
+		}
+		snapshot[counters.database+"/"+counters.queryID] = counters
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	return snapshot, nil
+}
+
+// diffStatements returns statements executed between snapshots. Counters lower than before mean
+// the statement was reset or evicted and counted again, the current counters are the delta then.
+func diffStatements(previous, current map[string]statementCounters) []statementDelta {
+	var deltas []statementDelta
+	for key, now := range current {
+		delta := statementDelta{
+			QueryID:     now.queryID,
+			Database:    now.database,
+			Calls:       now.calls,
+			TotalTimeMs: now.timeMs,
+			Rows:        now.rows,
+			Query:       now.query,
+		}
+		if before, ok := previous[key]; ok && now.calls >= before.calls {
+			delta.Calls = now.calls - before.calls
+			delta.TotalTimeMs = now.timeMs - before.timeMs
+			delta.Rows = now.rows - before.rows
+		}
+		if delta.Calls <= 0 {
+			continue
+		}
+		delta.MeanTimeMs = delta.TotalTimeMs / float64(delta.Calls)
+		deltas = append(deltas, delta)
+	}
+	return deltas
+}
+
+// normalizeStatementText collapses whitespace and truncates long statements
+func normalizeStatementText(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if runes := []rune(query); len(runes) > maxStatementText {
+		query = string(runes[:maxStatementText]) + "..."
+	}
+	return query
+}
+
+// textQueryID identifies statements reported without queryid by their text
+func textQueryID(query string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(query))
+	return "text:" + strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// intParam returns integer param of a go function, params arrive as YAML scalars
+func intParam(params map[string]interface{}, name string, defaultValue int) (int, error) {
+	raw, ok := params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch typed := raw.(type) {
+	case int:
+		if typed > 0 {
+			return typed, nil
+		}
+	case int64:
+		if typed > 0 {
+			return int(typed), nil
+		}
+	case float64:
+		if typed > 0 && typed == float64(int(typed)) {
+			return int(typed), nil
+		}
+	case string:
+		if value, err := strconv.Atoi(typed); err == nil && value > 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("param '%s' must be a positive integer, got %v", name, raw)
+}
@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"bytes"
+	"elmon/sql"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// RenderSQLFile reads SQL file and renders Go-template placeholders like {{.DatabaseName}}
+// with the supplied values. Missing values are reported as errors instead of rendering "<no value>".
+func RenderSQLFile(path string, data map[string]interface{}) (string, error) {
+	content, err := sql.ReadScript(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SQL file '%s': %w", path, err)
+	}
+
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse SQL template '%s': %w", path, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render SQL template '%s': %w", path, err)
+	}
+
+	return rendered.String(), nil
+}
+
+// RenderSQL renders SQLFile with SQLParams into SQLScript before the task is scheduled
+func (task *MetricTask) RenderSQL() error {
+	info, statErr := os.Stat(task.SQLFile)
+	script, err := RenderSQLFile(task.SQLFile, task.SQLParams)
+	if err != nil {
+		return err
+	}
+	task.SQLScript = script
+	if task.ReloadSQL {
+		task.sqlSource = &sqlSource{script: script}
+		if statErr == nil {
+			task.sqlSource.modTime = info.ModTime()
+		}
+	}
+	return nil
+}
+
+// reloadSQL returns SQL of the task, rendered again when SQLFile changed since the last rendering.
+// A version that fails to render or to pass the write check is reported once and the previous SQL
+// is kept. Embedded scripts and removed files never change.
+func (task *MetricTask) reloadSQL() (string, error) {
+	source := task.sqlSource
+	if source == nil {
+		return task.SQLScript, nil
+	}
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	info, err := os.Stat(task.SQLFile)
+	if err != nil || info.ModTime().Equal(source.modTime) {
+		return source.script, nil
+	}
+	source.modTime = info.ModTime()
+
+	script, err := RenderSQLFile(task.SQLFile, task.SQLParams)
+	if err == nil && task.RejectWriteSQL {
+		err = sql.CheckReadOnlySQL(script)
+	}
+	if err != nil {
+		return source.script, err
+	}
+	source.script = script
+	task.Logger.Info("SQL file changed, metric SQL reloaded", "file", task.SQLFile)
+	return script, nil
+}
+
+// CheckSQLFile verifies that SQL file exists and is a valid template
+func CheckSQLFile(path string) error {
+	content, err := sql.ReadScript(path)
+	if err != nil {
+		return fmt.Errorf("failed to read SQL file '%s': %w", path, err)
+	}
+	if _, err := template.New(path).Parse(string(content)); err != nil {
+		return fmt.Errorf("failed to parse SQL template '%s': %w", path, err)
+	}
+	return nil
+}
+
+// TemplateParams merges parameter maps into template data. Later maps win.
+// Configuration keys arrive lowercased from the config loader, so every key is
+// also exposed in CamelCase (min_age -> MinAge) to allow {{.MinAge}} in templates.
+func TemplateParams(maps ...map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{})
+	for _, m := range maps {
+		for key, value := range m {
+			data[key] = value
+			data[camelCase(key)] = value
+		}
+	}
+	return data
+}
+
+// camelCase converts snake_case or kebab-case key to CamelCase
+func camelCase(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool {
+		return r == '_' || r == '-'
+	})
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
+}
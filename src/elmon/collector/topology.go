@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"elmon/scheduler"
+	elsql "elmon/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ServerRole holds the last detected role of a cluster member.
+// It is shared between the role check task and metric tasks of the server.
+type ServerRole struct {
+	mutex sync.RWMutex
+	role  string
+}
+
+// Get returns detected role or empty string if the role is unknown yet
+func (serverRole *ServerRole) Get() string {
+	serverRole.mutex.RLock()
+	defer serverRole.mutex.RUnlock()
+	return serverRole.role
+}
+
+// set stores detected role and returns the previous one
+func (serverRole *ServerRole) set(role string) string {
+	serverRole.mutex.Lock()
+	defer serverRole.mutex.Unlock()
+	previous := serverRole.role
+	serverRole.role = role
+	return previous
+}
+
+// Matches reports whether a metric requiring the given role may run.
+// Unknown role (detection failed) does not block collection.
+func (serverRole *ServerRole) Matches(requiredRole string) bool {
+	if requiredRole == "" || requiredRole == "any" {
+		return true
+	}
+	role := serverRole.Get()
+	return role == "" || role == requiredRole
+}
+
+// RoleCheckTask periodically detects the role of a cluster member
+type RoleCheckTask struct {
+	ServerName  string
+	ClusterName string
+	ServerID    int
+
+	Interval     time.Duration
+	QueryTimeout time.Duration
+
+	Role *ServerRole
+
+	Logger    *logger.Logger
+	TargetDB  *sql.DB
+	MetricsDB elsql.Storage
+}
+
+// ProcessRoleCheck - implementation of scheduler.TaskFunc for role detection.
+// Role changes, including the first detection, are recorded in the metrics DB.
+func ProcessRoleCheck(ctx context.Context, taskPayload interface{}) error {
+	task, ok := taskPayload.(*RoleCheckTask)
+	if !ok {
+		return fmt.Errorf("invalid task payload type: expected *RoleCheckTask")
+	}
+	log := task.Logger
+	if taskID, ok := scheduler.TaskIDFromContext(ctx); ok {
+		log = log.With("task_id", taskID)
+	}
+
+	role, err := elsql.GetServerRole(task.TargetDB, task.QueryTimeout)
+	if err != nil {
+		log.Error(err, "Error detecting server role")
+		return err
+	}
+
+	previous := task.Role.set(role)
+	if previous == role {
+		return nil
+	}
+
+	if previous != "" {
+		log.Warn("Server role changed", "previous_role", previous, "role", role)
+	} else {
+		log.Info("Server role detected", "role", role)
+	}
+
+	return task.MetricsDB.InsertServerRoleChange(ctx, log, task.ServerID, task.ClusterName, role, previous)
+}
@@ -0,0 +1,232 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"elmon/alert"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default limits of collectLongTransactions
+const (
+	defaultMaxTransactionAge = 5 * time.Minute
+	defaultMaxIdleAge        = time.Minute
+)
+
+// reasonIdleInTransaction is reported for idle sessions, others are "long_transaction"
+const reasonIdleInTransaction = "idle_in_transaction"
+
+// longTransaction is a session whose transaction exceeds the limits
+type longTransaction struct {
+	PID             int     `json:"pid"`
+	Reason          string  `json:"reason"` // long_transaction or idle_in_transaction
+	User            string  `json:"user"`
+	Application     string  `json:"application"`
+	ClientAddress   string  `json:"client_address"`
+	Database        string  `json:"database"`
+	State           string  `json:"state"`
+	XactAgeSeconds  float64 `json:"xact_age_seconds"`
+	StateAgeSeconds float64 `json:"state_age_seconds"`
+	Query           string  `json:"query"` // the running statement or the last one of an idle session
+	BackendStart    string  `json:"backend_start"`
+	Terminated      bool    `json:"terminated"`
+}
+
+// longTransactionsSQL returns client sessions exceeding $1 seconds in a transaction
+// or $2 seconds idle in a transaction, one JSON document per row
+const longTransactionsSQL = `
+	select json_build_object(
+		'pid', pid,
+		'reason', case
+			when state like 'idle in transaction%' and now() - state_change >= make_interval(secs => $2)
+			then 'idle_in_transaction' else 'long_transaction' end,
+		'user', coalesce(usename, ''),
+		'application', application_name,
+		'client_address', coalesce(host(client_addr), ''),
+		'database', coalesce(datname, ''),
+		'state', coalesce(state, ''),
+		'xact_age_seconds', extract(epoch from now() - xact_start),
+		'state_age_seconds', coalesce(extract(epoch from now() - state_change), 0),
+		'query', left(query, 1000),
+		'backend_start', backend_start
+	)
+	from pg_stat_activity
+	where backend_type = 'client backend'
+		and pid <> pg_backend_pid()
+		and xact_start is not null
+		and (now() - xact_start >= make_interval(secs => $1)
+			or state like 'idle in transaction%' and now() - state_change >= make_interval(secs => $2))
+	order by xact_start`
+
+// terminateTransactionSQL terminates the session if it is still the same one and still exceeds the limit
+const terminateTransactionSQL = `
+	select pg_terminate_backend(pid)
+	from pg_stat_activity
+	where pid = $1 and backend_start = $2::timestamptz
+		and case $3
+			when 'idle_in_transaction'
+			then state like 'idle in transaction%' and now() - state_change >= make_interval(secs => $4)
+			else now() - xact_start >= make_interval(secs => $4) end`
+
+// collectLongTransactions stores sessions whose transaction is older than "max_transaction_age" param
+// (default 5m) or idle in transaction longer than "max_idle_age" (default 1m). "value" is their number.
+// An alert is fired for every such session. With "terminate: true" they are also terminated.
+func collectLongTransactions(ctx context.Context, task *MetricTask) error {
+	log := task.Logger
+
+	maxTransactionAge, err := durationParam(task.Params, "max_transaction_age", defaultMaxTransactionAge)
+	if err != nil {
+		log.Error(err, "Invalid collectLongTransactions params")
+		return err
+	}
+	maxIdleAge, err := durationParam(task.Params, "max_idle_age", defaultMaxIdleAge)
+	if err != nil {
+		log.Error(err, "Invalid collectLongTransactions params")
+		return err
+	}
+	terminate, err := boolParam(task.Params, "terminate", false)
+	if err != nil {
+		log.Error(err, "Invalid collectLongTransactions params")
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, task.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := task.TargetDB.QueryContext(queryCtx, longTransactionsSQL, maxTransactionAge.Seconds(), maxIdleAge.Seconds())
+	if err != nil {
+		log.Error(err, "Error querying long transactions")
+		return err
+	}
+	defer rows.Close()
+
+	sessions := []*longTransaction{}
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			log.Error(err, "Error scanning long transactions")
+			return err
+		}
+		var session longTransaction
+		if err := json.Unmarshal(document, &session); err != nil {
+			log.Error(err, "Error parsing long transactions")
+			return err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(err, "Error reading long transactions")
+		return err
+	}
+	rows.Close()
+	duration := time.Since(start)
+
+	// Ad-hoc runs only report sessions
+	if terminate && !task.DryRun {
+		for _, session := range sessions {
+			limit := maxTransactionAge
+			if session.Reason == reasonIdleInTransaction {
+				limit = maxIdleAge
+			}
+			session.Terminated, err = task.terminateSession(queryCtx, session, limit)
+			if err != nil {
+				log.Error(err, "Failed to terminate session", "pid", session.PID, "reason", session.Reason)
+			}
+		}
+	}
+
+	value, err := json.Marshal(map[string]interface{}{"value": len(sessions), "sessions": sessions})
+	if err != nil {
+		log.Error(err, "Error serializing long transactions")
+		return err
+	}
+	if err := task.storeValue(ctx, value, duration, task.targetTime()); err != nil {
+		log.Error(err, "Error inserting metric value into metrics DB")
+		return err
+	}
+
+	task.alertLongTransactions(queryCtx, sessions)
+	return nil
+}
+
+// terminateSession terminates the backend of the session, false when it has finished the transaction meanwhile
+func (task *MetricTask) terminateSession(ctx context.Context, session *longTransaction, limit time.Duration) (bool, error) {
+	var terminated bool
+	err := task.TargetDB.QueryRowContext(ctx, terminateTransactionSQL,
+		session.PID, session.BackendStart, session.Reason, limit.Seconds()).Scan(&terminated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if terminated {
+		task.Logger.Warn("Session terminated", "pid", session.PID, "reason", session.Reason, "user", session.User,
+			"application", session.Application, "database", session.Database)
+	}
+	return terminated, nil
+}
+
+// alertLongTransactions fires an alert for every reported session
+func (task *MetricTask) alertLongTransactions(ctx context.Context, sessions []*longTransaction) {
+	if task.Alerts == nil || task.DryRun {
+		return
+	}
+	for _, session := range sessions {
+		age := session.XactAgeSeconds
+		description := "transaction of session %d is open for %s"
+		if session.Reason == reasonIdleInTransaction {
+			age = session.StateAgeSeconds
+			description = "session %d is idle in transaction for %s"
+		}
+		message := fmt.Sprintf(description, session.PID, time.Duration(age*float64(time.Second)).Round(time.Second))
+		if session.Terminated {
+			message += ", terminated"
+		}
+		err := task.Alerts.Fire(ctx, alert.Alert{
+			Name: session.Reason,
+			// Backend start tells apart sessions reusing the pid
+			Key:        strconv.Itoa(session.PID) + "@" + session.BackendStart,
+			Severity:   "warning",
+			ServerName: task.ServerName,
+			MetricName: task.MetricName,
+			Message:    message,
+			Details: map[string]interface{}{
+				"pid":              session.PID,
+				"user":             session.User,
+				"application":      session.Application,
+				"client_address":   session.ClientAddress,
+				"database":         session.Database,
+				"xact_age_seconds": session.XactAgeSeconds,
+				"query":            session.Query,
+				"terminated":       session.Terminated,
+			},
+		})
+		if err != nil {
+			task.Logger.Error(err, "Failed to send long transaction alert", "pid", session.PID)
+		}
+	}
+}
+
+// boolParam returns boolean param of a go function, "true" and "false" strings are accepted as well
+func boolParam(params map[string]interface{}, name string, defaultValue bool) (bool, error) {
+	raw, ok := params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch typed := raw.(type) {
+	case bool:
+		return typed, nil
+	case string:
+		if value, err := strconv.ParseBool(strings.TrimSpace(typed)); err == nil {
+			return value, nil
+		}
+	}
+	return false, fmt.Errorf("param '%s' must be true or false, got %v", name, raw)
+}
@@ -2,7 +2,14 @@ package collector
 
 import (
 	"database/sql"
+	"elmon/alert"
+	"elmon/expression"
 	"elmon/logger"
+	"elmon/sink"
+	"elmon/spool"
+	elsql "elmon/sql"
+	"encoding/json"
+	"sync"
 	"time"
 )
 
@@ -15,21 +22,145 @@ type MetricTask struct {
 	ServerID   int
 	MetricID   int
 
+	// DatabaseName is set for per-database metrics and stored with every value
+	DatabaseName string
+
+	// Driver of the target server, "postgres", "mysql" or "pgbouncer"
+	Driver string
+
 	// Execution parameters
-	CollectionType string // "sql" or "go_func"
-	SQLFile        string // File path for "sql" type
-	GoFunction     string // Function name for "go_func" type
+	CollectionType string                 // "sql", "go_func", "command", "http", "expression", "forecast" or "push"
+	SQLFile        string                 // File path for "sql" type
+	SQLScript      string                 // Rendered SQL for "sql" type, read from SQLFile when empty
+	SQLParams      map[string]interface{} // Template values of SQLFile, see RenderSQL
+	BackfillSQL    string                 // File path of SQL recomputing values of past windows, see Backfill
+	GoFunction     string                 // Function name for "go_func" type
+	Params         map[string]interface{} // servers-metrics-map params, read by "go_func" functions
+	State          *TaskState             // Data kept between runs by "go_func" functions
+	Command        string                 // Executable path for "command" type
+	CommandArgs    []string               // Executable arguments for "command" type
+	HTTP           *HTTPProbe             // Request settings for "http" type
+
+	// Expression of "expression" type, evaluated over values of the same server and database
+	// collected within ExpressionMaxAge (3 intervals when zero)
+	Expression       *expression.Expression
+	ExpressionMaxAge time.Duration
+
+	// Forecast of "forecast" type, fitted to stored values of another metric of the same server and database
+	Forecast *Forecast
+
+	// Cluster role filter, Role is nil for servers outside of clusters
+	RequiredRole string // "any", "primary" or "replica"
+	Role         *ServerRole
+
+	// Hosts of a server listing its primary and replicas, "sql" and "go_func" types run on
+	// the hosts chosen by HostPolicy instead of TargetDB. Nil for servers with a single host.
+	Hosts      *HostPool
+	HostPolicy string // HostPolicy* constant
+
+	// Scheduler parameters, MissedTick is a scheduler.MissedTick* policy for ticks
+	// coming while the previous run is active
+	Interval     time.Duration
+	MaxRetries   int
+	RetryDelay   time.Duration
+	MissedTick   string
+	AllowOverlap bool
 
-	// Scheduler parameters
-	Interval   time.Duration
-	MaxRetries int
-	RetryDelay time.Duration
+	// Failed runs in a row after which the interval is stretched up to MaxBackoff, 0 disables
+	BackoffAfter int
+	MaxBackoff   time.Duration
+
+	// Priority orders tasks waiting for Limits slots and the Budget, higher first
+	Priority int
+
+	// ValueType is checked against every collected value: int, int64, float, bool, string or table
+	ValueType string
+
+	// Parts store keys of the collected object as separate metrics instead of storing the object itself
+	Parts []BundlePart
+
+	// Counter keeps the previous sample of cumulative metrics, nil for gauges
+	Counter *CounterState
+
+	// StorageMode is "timeseries" (append every value) or "latest" (keep only the last value)
+	StorageMode string
+
+	// Labels are stored with every value, labels returned in the value override them
+	Labels map[string]string
 
 	// Query parameters
-	QueryTimeout time.Duration
+	QueryTimeout   time.Duration
+	ReadOnly       bool // Run SQL inside a read-only transaction
+	ReloadSQL      bool // Render SQLFile again before a run when the file changed
+	RejectWriteSQL bool // Keep the previous SQL when a reloaded file contains write statements
+	ServerTimeouts bool // Let PostgreSQL enforce QueryTimeout with SET LOCAL statement_timeout and lock_timeout
+
+	// TimeSource is the clock of metric_value time: "metrics-db", "collector" or "target"
+	TimeSource string
+
+	// ResultLimits cap values returned by "sql" type queries
+	ResultLimits elsql.ResultLimits
+
+	// Prepared runs SQL through a statement prepared once, nil runs it as a plain query
+	Prepared *elsql.PreparedScript
+
+	// Ad-hoc execution: OnValue receives every collected value, DryRun skips writing to metrics DB
+	OnValue func(task *MetricTask, value json.RawMessage)
+	DryRun  bool
 
 	// Runtime dependencies
 	Logger    *logger.Logger
-	TargetDB  *sql.DB // Connection to monitored server
-	MetricsDB *sql.DB // Connection to metrics storage database
-}
\ No newline at end of file
+	TargetDB  *sql.DB          // Connection to monitored server
+	MetricsDB elsql.Storage    // Metrics storage database
+	Spool     *spool.Spool     // Values that failed to be stored, nil disables spooling
+	Sinks     []sink.Sink      // Additional outputs of collected values
+	Cache     *ValueCache      // Latest values used by expressions, nil when the metric is not used by any
+	Alerts    *alert.Notifier  // Problems detected by "go_func" functions and forecasts
+	Limits    *Limits          // Collections and writes running at once, shared by all tasks
+	Budget    *QueryBudget     // Collections per minute of the target server, shared by its tasks
+	DBHealth  *MetricsDBHealth // Metrics DB writes are skipped while it is down, nil without health checks
+
+	// SkipMetricsDB stores values in sinks only
+	SkipMetricsDB bool
+
+	// CollectionLog records every attempt in collection_log table
+	CollectionLog bool
+
+	// collectedValues counts values passed to storeValue by the current run
+	collectedValues int
+
+	// historical runs store values at past target times, counter rates are computed over them
+	historical bool
+
+	// sqlSource is the SQL rendered last, shared by runs of the task when ReloadSQL is set
+	sqlSource *sqlSource
+
+	// batch queues metrics DB writes of bundle parts, written in one transaction by storeParts
+	batch *valueBatch
+
+	// collector schedules the task, its scheduler states are read by collectTaskPanics
+	collector *Collector
+}
+
+// TaskState keeps data of a task between its runs
+type TaskState struct {
+	mutex sync.Mutex
+	value interface{}
+}
+
+// sqlSource is the latest rendering of a reloadable SQL file
+type sqlSource struct {
+	mutex   sync.Mutex
+	script  string
+	modTime time.Time // of the file when the script was rendered
+}
+
+// HTTPProbe describes an HTTP request performed by the "http" collection type
+type HTTPProbe struct {
+	URL            string
+	Method         string
+	Headers        map[string]string
+	Body           string
+	ExpectedStatus int
+	JSONPath       string // Optional path of the value extracted from JSON response
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// maxPayloadInError limits the metric value quoted in validation errors
+const maxPayloadInError = 512
+
+// validateValue checks that collected value matches value-type of the metric.
+// Scalar types expect an object with "value" key of that type, null meaning no data,
+// other keys are not checked. "table" expects an object or an array of objects.
+func validateValue(valueType string, value json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber() // keeps integers exact
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return valueTypeError(valueType, value, "value is not valid JSON")
+	}
+
+	if valueType == "table" {
+		switch typed := document.(type) {
+		case map[string]interface{}:
+			return nil
+		case []interface{}:
+			for i, row := range typed {
+				if _, ok := row.(map[string]interface{}); !ok {
+					return valueTypeError(valueType, value, fmt.Sprintf("row %d is %s, expected an object", i, jsonType(row)))
+				}
+			}
+			return nil
+		default:
+			return valueTypeError(valueType, value, fmt.Sprintf("value is %s, expected an object or an array of objects",
+				jsonType(document)))
+		}
+	}
+
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return valueTypeError(valueType, value, fmt.Sprintf("value is %s, expected an object with \"value\" key",
+			jsonType(document)))
+	}
+	scalar, ok := object["value"]
+	if !ok {
+		return valueTypeError(valueType, value, "\"value\" key is missing")
+	}
+	if scalar == nil {
+		return nil
+	}
+
+	switch valueType {
+	case "int", "int64":
+		number, ok := scalar.(json.Number)
+		if !ok {
+			return valueTypeError(valueType, value, fmt.Sprintf("\"value\" is %s, expected an integer", jsonType(scalar)))
+		}
+		// numeric columns may render integers as 3.0
+		rational, ok := new(big.Rat).SetString(number.String())
+		if !ok || !rational.IsInt() || !rational.Num().IsInt64() {
+			return valueTypeError(valueType, value, fmt.Sprintf("\"value\" %s is not a 64-bit integer", number))
+		}
+	case "float":
+		if _, ok := scalar.(json.Number); !ok {
+			return valueTypeError(valueType, value, fmt.Sprintf("\"value\" is %s, expected a number", jsonType(scalar)))
+		}
+	case "bool":
+		if _, ok := scalar.(bool); !ok {
+			return valueTypeError(valueType, value, fmt.Sprintf("\"value\" is %s, expected a boolean", jsonType(scalar)))
+		}
+	case "string":
+		if _, ok := scalar.(string); !ok {
+			return valueTypeError(valueType, value, fmt.Sprintf("\"value\" is %s, expected a string", jsonType(scalar)))
+		}
+	}
+	return nil
+}
+
+// valueTypeError describes the mismatch together with the offending payload
+func valueTypeError(valueType string, value json.RawMessage, reason string) error {
+	payload := string(value)
+	if len(payload) > maxPayloadInError {
+		payload = payload[:maxPayloadInError] + "..."
+	}
+	return fmt.Errorf("metric value doesn't match value-type '%s': %s, value: %s", valueType, reason, payload)
+}
+
+// jsonType names decoded JSON node type for error messages
+func jsonType(node interface{}) string {
+	switch typed := node.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "number " + typed.String()
+	case string:
+		return "a string"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
@@ -2,14 +2,19 @@
 package config
 
 import (
-	"bytes"
 	"database/sql"
+	"elmon/expression"
+	"elmon/selector"
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata" // server timezones are checked in images without a timezone database
 
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/joho/godotenv"
@@ -24,42 +29,390 @@ type AppConfig struct {
 	DBServers        []DbConnectionConfig   `mapstructure:"db-servers"`
 	Metrics          MetricsConfig          `mapstructure:"metrics"`
 	ServerMetricsMap []ServerMetricsMapping `mapstructure:"servers-metrics-map"`
+	Clusters         []ClusterConfig        `mapstructure:"clusters"`
+	Storage          StorageConfig          `mapstructure:"storage"`
+	Alerts           AlertsConfig           `mapstructure:"alerts"`
+	Reports          ReportsConfig          `mapstructure:"reports"`
+	Discovery        DiscoveryConfig        `mapstructure:"discovery"`
+	MetricProfiles   []MetricProfile        `mapstructure:"metric-profiles"`
+	API              APIConfig              `mapstructure:"api"`
+	Limits           LimitsConfig           `mapstructure:"limits"`
+	Kerberos         KerberosConfig         `mapstructure:"kerberos"`
+	LeaderElection   LeaderElectionConfig   `mapstructure:"leader-election"`
+	Sharding         ShardingConfig         `mapstructure:"sharding"`
+	Instances        InstancesConfig        `mapstructure:"instances"`
+
+	settings map[string]interface{} // settings the configuration was decoded from, see Snapshot
+}
+
+// ShardingConfig splits monitored servers among instances with the same configuration,
+// every server is collected by the instance of the shard its name hashes to
+type ShardingConfig struct {
+	Shards int `mapstructure:"shards"` // number of instances, 0 disables sharding
+	Shard  int `mapstructure:"shard"`  // shard of the instance, from 0 to shards-1
+}
+
+// InstancesConfig registers every running instance in elmon_instance of the metrics DB, so instances
+// sharing it, e.g. shards and standbys of leader election, can be seen together
+type InstancesConfig struct {
+	HeartbeatInterval Duration `mapstructure:"heartbeat-interval"` // 0 disables the registry, default: 30s
+	ForgetAfter       Duration `mapstructure:"forget-after"`       // rows of instances without heartbeats are deleted after this time, default: 168h
+}
+
+// LeaderElectionConfig lets one of instances sharing the metrics DB collect while the others
+// stay on standby, the leader holds a lease stored in the metrics DB
+type LeaderElectionConfig struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	InstanceID string   `mapstructure:"instance-id"` // default: hostname and process id
+	Lease      Duration `mapstructure:"lease"`       // a standby takes over this long after the leader stops renewing, default: 30s
+}
+
+// KerberosConfig keeps a Kerberos ticket obtained from a keytab for GSSAPI connections
+type KerberosConfig struct {
+	Keytab          string   `mapstructure:"keytab"`           // empty leaves tickets to the environment
+	Principal       string   `mapstructure:"principal"`        // e.g. elmon@EXAMPLE.COM
+	CredentialCache string   `mapstructure:"credential-cache"` // sets KRB5CCNAME, default: from the environment
+	RefreshInterval Duration `mapstructure:"refresh-interval"` // default: 1h
+	Kinit           string   `mapstructure:"kinit"`            // default: kinit
+}
+
+// LimitsConfig caps resources used by the collector as a whole, 0 means unlimited
+type LimitsConfig struct {
+	MaxConcurrentCollections int `mapstructure:"max-concurrent-collections"` // collections running at once, default: 100
+	MaxConnectionsPerTarget  int `mapstructure:"max-connections-per-target"` // caps max-open-connections of every server
+	MaxInflightWrites        int `mapstructure:"max-inflight-writes"`        // values written to metrics DB at once
+	MaxBufferedMemory        int `mapstructure:"max-buffered-memory"`        // megabytes of values buffered by sinks
+	MaxQueriesPerMinute      int `mapstructure:"max-queries-per-minute"`     // collections started per minute on every server
+}
+
+// MetricProfile is a named set of metrics assigned to servers added at runtime
+type MetricProfile struct {
+	Name    string                 `mapstructure:"name"`
+	Params  map[string]interface{} `mapstructure:"params"`
+	Metrics []ServerMetricOverride `mapstructure:"metrics"`
+}
+
+// APIConfig defines the HTTP API of the collector
+type APIConfig struct {
+	Listen        string        `mapstructure:"listen"`         // e.g. 127.0.0.1:8080, empty disables the API
+	ControlSocket string        `mapstructure:"control-socket"` // unix socket serving the API as well, used by elmon status
+	QueryTimeout  Duration      `mapstructure:"query-timeout"`  // limit of metrics DB queries of read endpoints, default: 30s
+	UI            bool          `mapstructure:"ui"`             // serve the built-in web UI on /ui/, default: true
+	Push          PushConfig    `mapstructure:"push"`
+	Auth          APIAuthConfig `mapstructure:"auth"`     // no authentication when empty
+	TLS           APITLSConfig  `mapstructure:"tls"`      // plain HTTP when empty
+	EnvVars       []string      `mapstructure:"env-vars"` // variables servers added through the API may refer to, default: none
+}
+
+// APIAuthConfig lists API clients and their roles: read allows GET endpoints, operator allows every endpoint.
+// /healthz, the web UI page and POST /api/push (authenticated by push tokens) are open to everyone.
+type APIAuthConfig struct {
+	Tokens  []APIToken  `mapstructure:"tokens"`  // bearer tokens
+	Clients []APIClient `mapstructure:"clients"` // client certificates verified by tls.client-ca-file
+}
+
+// APIToken is a bearer token of an API client, the name identifies the client in audit logs
+type APIToken struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+	Role  string `mapstructure:"role"` // read or operator, default: read
+}
+
+// APIClient is an API client authenticated by the common name of its certificate
+type APIClient struct {
+	CommonName string `mapstructure:"common-name"`
+	Role       string `mapstructure:"role"` // read or operator, default: read
+}
+
+// APITLSConfig serves the API over HTTPS, with client-ca-file client certificates are verified (mTLS)
+type APITLSConfig struct {
+	CertFile     string `mapstructure:"cert-file"`
+	KeyFile      string `mapstructure:"key-file"`
+	ClientCAFile string `mapstructure:"client-ca-file"` // clients without a certificate still can use tokens
+}
+
+// PushConfig enables POST /api/push, agents which can't be reached by the collector send values with a token
+type PushConfig struct {
+	Tokens    []PushToken `mapstructure:"tokens"`     // empty disables the endpoint
+	MaxAge    Duration    `mapstructure:"max-age"`    // oldest accepted time of a value, default: 24h
+	MaxFuture Duration    `mapstructure:"max-future"` // accepted time ahead of the collector clock, default: 5m
+}
+
+// PushToken is a bearer token of an agent, the name identifies the agent in logs
+type PushToken struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+}
+
+// DiscoveryConfig defines external sources of monitored servers, in addition to db-servers
+type DiscoveryConfig struct {
+	Interval Duration                `mapstructure:"interval"` // how often sources are queried, default: 1m
+	Timeout  Duration                `mapstructure:"timeout"`  // limit of a single source query, default: 30s
+	Sources  []DiscoverySourceConfig `mapstructure:"sources"`
+}
+
+// DiscoverySourceConfig defines an inventory source and how its servers are monitored
+type DiscoverySourceConfig struct {
+	Name       string                    `mapstructure:"name"`
+	Type       string                    `mapstructure:"type"` // sql, dns-srv, consul, kubernetes
+	SQL        DiscoverySQLConfig        `mapstructure:"sql"`
+	DNSSRV     DiscoveryDNSSRVConfig     `mapstructure:"dns-srv"`
+	Consul     DiscoveryConsulConfig     `mapstructure:"consul"`
+	Kubernetes DiscoveryKubernetesConfig `mapstructure:"kubernetes"`
+
+	// Discovered servers get connection settings from Server and metrics from Metrics
+	NamePrefix string                 `mapstructure:"name-prefix"` // prepended to discovered names
+	Server     DbConnectionConfig     `mapstructure:"server"`      // host, port and name come from the source
+	Params     map[string]interface{} `mapstructure:"params"`
+	Metrics    []ServerMetricOverride `mapstructure:"metrics"`
+}
+
+// DiscoverySQLConfig lists servers with a query on the metrics database
+type DiscoverySQLConfig struct {
+	Query string `mapstructure:"query"` // returns name, host and optionally port, dbname, environment columns
+}
+
+// DiscoveryDNSSRVConfig lists servers from DNS SRV records
+type DiscoveryDNSSRVConfig struct {
+	Name string `mapstructure:"name"` // e.g. _postgresql._tcp.db.example.com
+}
+
+// DiscoveryConsulConfig lists healthy instances of a Consul service
+type DiscoveryConsulConfig struct {
+	Address    string `mapstructure:"address"` // default: http://127.0.0.1:8500
+	Service    string `mapstructure:"service"`
+	Tag        string `mapstructure:"tag"`
+	Datacenter string `mapstructure:"datacenter"`
+	Token      string `mapstructure:"token"`
+}
+
+// DiscoveryKubernetesConfig lists ready pods matching a label selector, using the in-cluster service account
+type DiscoveryKubernetesConfig struct {
+	Namespace     string `mapstructure:"namespace"` // default: namespace of the collector pod
+	LabelSelector string `mapstructure:"label-selector"`
+	Port          int    `mapstructure:"port"` // default: 5432
+}
+
+// AlertsConfig defines delivery of alerts fired by built-in collectors, alerts are always logged
+type AlertsConfig struct {
+	Webhook        AlertWebhookConfig `mapstructure:"webhook"`
+	RepeatInterval Duration           `mapstructure:"repeat-interval"` // an active alert is sent again after this time, default: 1h
+}
+
+// AlertWebhookConfig defines the endpoint receiving alerts as JSON POST requests
+type AlertWebhookConfig struct {
+	URL     string            `mapstructure:"url"` // empty disables the webhook
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// ReportsConfig defines reports computed from stored metric values
+type ReportsConfig struct {
+	Availability AvailabilityReportConfig `mapstructure:"availability"`
+}
+
+// AvailabilityReportConfig defines the monthly availability report of servers, computed from an up/down metric
+// and stored in availability_report. The summary of a completed month is sent to the webhook and by email once.
+type AvailabilityReportConfig struct {
+	Enabled  bool               `mapstructure:"enabled"`
+	Metric   string             `mapstructure:"metric"`   // 0 or false is down, a falling number is a restart, default: db_uptime
+	Target   float64            `mapstructure:"target"`   // availability in percent servers should meet, default: 99.9
+	Timezone string             `mapstructure:"timezone"` // months start at midnight in this timezone, default: UTC
+	Interval Duration           `mapstructure:"interval"` // how often the running month is recomputed, default: 1h
+	Webhook  AlertWebhookConfig `mapstructure:"webhook"`  // summary of completed months as JSON POST requests
+	Email    ReportEmailConfig  `mapstructure:"email"`
+}
+
+// ReportEmailConfig defines the SMTP server mailing report summaries, STARTTLS is used when the server offers it
+type ReportEmailConfig struct {
+	Host     string   `mapstructure:"host"` // empty disables email
+	Port     int      `mapstructure:"port"` // default: 587
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+// StorageConfig defines how metric values are kept in the metrics database
+type StorageConfig struct {
+	TimescaleDB TimescaleConfig `mapstructure:"timescaledb"`
+	Rollups     RollupConfig    `mapstructure:"rollups"`
+	Spool       SpoolConfig     `mapstructure:"spool"`
+
+	CollectionLog CollectionLogConfig `mapstructure:"collection-log"`
+
+	// Values are written to metrics DB and to every sink, metrics DB can be turned off
+	// when sinks are the only storage. Servers and metrics are registered in metrics DB anyway.
+	WriteMetricsDB bool         `mapstructure:"write-metrics-db"` // default: true
+	Sinks          []SinkConfig `mapstructure:"sinks"`
+	// TimeSource is the clock of metric_value time: metrics-db (default), collector or target.
+	// Values without a known target time fall back to the metrics DB clock.
+	TimeSource string `mapstructure:"time-source"`
+	// Servers and metrics removed from configuration are marked inactive, they are deleted with their
+	// status and latest values after PurgeInactiveAfter. Zero keeps them forever.
+	PurgeInactiveAfter Duration `mapstructure:"purge-inactive-after"`
+}
+
+// SinkConfig defines an additional output of collected metric values
+type SinkConfig struct {
+	Name         string                `mapstructure:"name"`
+	Type         string                `mapstructure:"type"`          // kafka, otlp, clickhouse
+	MetricGroups []string              `mapstructure:"metric-groups"` // groups sent to the sink, empty for all
+	Kafka        *KafkaSinkConfig      `mapstructure:"kafka"`         // settings for type 'kafka'
+	OTLP         *OTLPSinkConfig       `mapstructure:"otlp"`          // settings for type 'otlp'
+	ClickHouse   *ClickHouseSinkConfig `mapstructure:"clickhouse"`    // settings for type 'clickhouse'
+}
+
+// ClickHouseSinkConfig defines ClickHouse table of sink type 'clickhouse'. Values are inserted
+// in batches through the HTTP interface, the table is created on startup if it doesn't exist.
+type ClickHouseSinkConfig struct {
+	URL           string   `mapstructure:"url"`      // HTTP interface, e.g. http://clickhouse:8123
+	Database      string   `mapstructure:"database"` // default: default
+	Table         string   `mapstructure:"table"`    // default: metric_value
+	Username      string   `mapstructure:"username"`
+	Password      string   `mapstructure:"password"`
+	BatchSize     int      `mapstructure:"batch-size"`     // rows per insert, default: 10000
+	FlushInterval Duration `mapstructure:"flush-interval"` // longest wait of a row for its batch, default: 5s
+	MaxBuffer     int      `mapstructure:"max-buffer"`     // rows kept while ClickHouse is unavailable, default: 10 batches
+	AsyncInsert   bool     `mapstructure:"async-insert"`   // use server side async_insert as well
+	TTL           Duration `mapstructure:"ttl"`            // retention of a newly created table, 0 keeps values forever
+}
+
+// OTLPSinkConfig defines OpenTelemetry collector endpoint of sink type 'otlp'
+type OTLPSinkConfig struct {
+	Endpoint    string            `mapstructure:"endpoint"` // OTLP/HTTP URL, e.g. http://otel-collector:4318/v1/metrics
+	Headers     map[string]string `mapstructure:"headers"`
+	ServiceName string            `mapstructure:"service-name"` // service.name resource attribute, default: elmon
+	Prefix      string            `mapstructure:"prefix"`       // metric name prefix, default: elmon
+}
+
+// KafkaSinkConfig defines Kafka producer of sink type 'kafka'
+type KafkaSinkConfig struct {
+	Brokers      []string `mapstructure:"brokers"`
+	Topic        string   `mapstructure:"topic"`
+	ClientID     string   `mapstructure:"client-id"`     // default: elmon
+	RequiredAcks string   `mapstructure:"required-acks"` // none, one or all, default: all
+	BatchTimeout Duration `mapstructure:"batch-timeout"` // default: 50ms
+	TLS          bool     `mapstructure:"tls"`
+	Username     string   `mapstructure:"username"` // SASL/PLAIN, empty disables authentication
+	Password     string   `mapstructure:"password"`
+}
+
+// SpoolConfig defines the on-disk buffer of values that failed to be written into metrics DB
+type SpoolConfig struct {
+	Enabled        bool     `mapstructure:"enabled"`
+	Dir            string   `mapstructure:"dir"`             // spool directory, default: spool
+	MaxSize        int      `mapstructure:"max-size"`        // megabytes, new values are dropped above it, 0 means unlimited, default: 100
+	ReplayInterval Duration `mapstructure:"replay-interval"` // how often spooled values are replayed, default: 30s
+}
+
+// CollectionLogConfig enables collection_log table recording the outcome of every collection attempt
+type CollectionLogConfig struct {
+	Enabled   bool     `mapstructure:"enabled"`
+	Retention Duration `mapstructure:"retention"` // attempts older than this are deleted hourly, 0 keeps them forever, default: 168h
+}
+
+// RollupConfig defines hourly/daily rollups of metric_value for plain PostgreSQL storage.
+// With TimescaleDB continuous aggregates are used instead.
+type RollupConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	After    Duration `mapstructure:"after"`    // aggregate values older than this, default: 24h
+	Interval Duration `mapstructure:"interval"` // how often the rollup job runs, default: 1h
+}
+
+// TimescaleConfig defines TimescaleDB usage for metric_value table
+type TimescaleConfig struct {
+	Mode                 string   `mapstructure:"mode"`                  // off, auto (use if extension is available) or on, default: off
+	ChunkInterval        Duration `mapstructure:"chunk-interval"`        // hypertable chunk size, default: 24h
+	CompressAfter        Duration `mapstructure:"compress-after"`        // compress chunks older than this, 0 disables compression, default: 168h
+	ContinuousAggregates bool     `mapstructure:"continuous-aggregates"` // maintain hourly and daily rollups, default: true
 }
 
-// LogConfig defines logging parameters
+// LogConfig defines logging parameters.
+// Top level fields describe the only sink unless sinks are listed.
 type LogConfig struct {
+	LogSinkConfig `mapstructure:",squash"`
+	Sinks         []LogSinkConfig `mapstructure:"sinks"` // several outputs with independent levels and formats
+	Dedup         LogDedupConfig  `mapstructure:"dedup"` // limits repeated warnings and errors
+}
+
+// LogDedupConfig limits identical warnings and errors, e.g. while a target server is down
+type LogDedupConfig struct {
+	Burst    int      `mapstructure:"burst"`    // identical records written per interval, 0 disables deduplication
+	Interval Duration `mapstructure:"interval"` // suppressed records are summarized once per interval, default: 5m
+}
+
+// LogSinkConfig defines a single log output
+type LogSinkConfig struct {
 	Level  string `mapstructure:"level"`  // debug, info, warn, error
 	Format string `mapstructure:"format"` // json, text
-	File   string `mapstructure:"file"`
+	File   string `mapstructure:"file"`   // empty for console output
+
+	// Rotation of the log file
+	MaxSize    int  `mapstructure:"max-size"`    // megabytes, 0 disables rotation
+	MaxBackups int  `mapstructure:"max-backups"` // rotated files to keep, 0 keeps all
+	MaxAge     int  `mapstructure:"max-age"`     // days to keep rotated files, 0 keeps forever
+	Compress   bool `mapstructure:"compress"`    // gzip rotated files
 }
 
 // DbConnectionConfig defines database connection parameters
 type DbConnectionConfig struct {
-	Name                  string `mapstructure:"name"`
-	Environment           string `mapstructure:"environment"`
-	Host                  string `mapstructure:"host"`
-	Port                  int    `mapstructure:"port"`
-	User                  string `mapstructure:"user"`
-	Password              string `mapstructure:"password"`
-	DbName                string `mapstructure:"dbname"`
-	SslMode               string `mapstructure:"ssl-mode"`                 // default: disable
-	MaxOpenConnections    int    `mapstructure:"max-open-connections"`     // default: 100
-	MaxIdleConnections    int    `mapstructure:"max-idle-connections"`     // default: 50
-	ConnectionMaxLifetime int    `mapstructure:"connection-max-lifetime"`  // default: 3600s
-	ConnectionMaxIdleTime int    `mapstructure:"connection-max-idle-time"` // default: 1800s
+	Name                  string            `mapstructure:"name"`
+	Driver                string            `mapstructure:"driver"`  // postgres, mysql or sqlite (metrics-db only), default: postgres
+	Enabled               *bool             `mapstructure:"enabled"` // false keeps the server in config without connecting to it, default: true
+	DSN                   string            `mapstructure:"dsn"`     // verbatim connection string, overrides host/port/user/password/dbname/ssl-*
+	Environment           string            `mapstructure:"environment"`
+	Host                  string            `mapstructure:"host"`
+	Port                  int               `mapstructure:"port"`
+	User                  string            `mapstructure:"user"`
+	Password              string            `mapstructure:"password"`
+	DbName                string            `mapstructure:"dbname"`
+	SslMode               string            `mapstructure:"ssl-mode"`                 // default: disable
+	SslRootCert           string            `mapstructure:"ssl-root-cert"`            // CA certificate file for verify-ca/verify-full
+	SslCert               string            `mapstructure:"ssl-cert"`                 // client certificate file for mutual TLS
+	SslKey                string            `mapstructure:"ssl-key"`                  // client private key file for mutual TLS
+	Auth                  AuthConfig        `mapstructure:"auth"`                     // token authentication instead of password
+	KrbSrvName            string            `mapstructure:"krb-srv-name"`             // Kerberos service name, default: postgres
+	KrbSpn                string            `mapstructure:"krb-spn"`                  // Kerberos service principal, overrides krb-srv-name
+	MaxOpenConnections    int               `mapstructure:"max-open-connections"`     // default: 100
+	MaxIdleConnections    int               `mapstructure:"max-idle-connections"`     // default: 50
+	ConnectionMaxLifetime int               `mapstructure:"connection-max-lifetime"`  // default: 3600s
+	ConnectionMaxIdleTime int               `mapstructure:"connection-max-idle-time"` // default: 1800s
+	ApplicationName       string            `mapstructure:"application-name"`         // default: elmon
+	StatementTimeout      Duration          `mapstructure:"statement-timeout"`        // default: longest query-timeout of mapped metrics
+	WriteTimeout          Duration          `mapstructure:"write-timeout"`            // metrics-db only: limit of a single write, default: 10s
+	HealthCheckInterval   Duration          `mapstructure:"health-check-interval"`    // metrics-db only: ping interval, 0 disables, default: 10s
+	DiscoverDatabases     bool              `mapstructure:"discover-databases"`       // fan out per-database metrics to all databases
+	ExcludeDatabases      []string          `mapstructure:"exclude-databases"`        // databases skipped by discovery
+	Tags                  map[string]string `mapstructure:"tags"`                     // matched by applies-to selectors of metric groups
+	Timezone              string            `mapstructure:"timezone"`                 // IANA name stored with the server, default: read from the server
+	MaxQueriesPerMinute   int               `mapstructure:"max-queries-per-minute"`   // overrides limits.max-queries-per-minute
+	Hosts                 []string          `mapstructure:"hosts"`                    // primary and replicas as host[:port], instead of host and port
+	HostCheckInterval     Duration          `mapstructure:"host-check-interval"`      // availability and role check of hosts, default: 30s
+	CreateExtensions      bool              `mapstructure:"create-extensions"`        // create missing extensions required by mapped metrics, default: false
 
 	// These fields are not populated from config but used at runtime
 	SqlServerId   *int
 	SqlConnection *sql.DB
 }
 
+// AuthConfig replaces the static password with short-lived tokens, requested again before they expire.
+// With the password type and an empty password, PostgreSQL connections use ~/.pgpass.
+type AuthConfig struct {
+	Type          string   `mapstructure:"type"`           // password, aws-iam, gcp-iam or command, default: password
+	Region        string   `mapstructure:"region"`         // aws-iam: region of the instance, default: AWS_REGION
+	Command       string   `mapstructure:"command"`        // command: executable printing the token
+	CommandArgs   []string `mapstructure:"args"`
+	TokenLifetime Duration `mapstructure:"token-lifetime"` // command: validity of a printed token, default: 15m
+	RefreshBefore Duration `mapstructure:"refresh-before"` // default: 1m
+}
+
 // GrafanaConfig defines Grafana connection parameters
 type GrafanaConfig struct {
 	Url        string             `mapstructure:"url"`
 	Token      string             `mapstructure:"token"`
 	Timeout    int                `mapstructure:"timeout"` // in seconds, default: 30
-	DataSource *GrafanaDataSource `mapstrurcture:"datasource"`
-	Dashboard  *GrafanaDashboard  `mapstrucrure:"dashboard"`
+	DataSource *GrafanaDataSource `mapstructure:"datasource"`
+	Dashboard  *GrafanaDashboard  `mapstructure:"dashboard"`
 }
 
 //Grafana data source config
@@ -69,7 +422,7 @@ type GrafanaDataSource struct {
 	Database string `mapstructure:"database"`// Database Name
 	User     string `mapstructure:"user"`     
 	Password string `mapstructure:"password"`
-	SSLMode  string `mapstructure:"ssl-mode"`// disable or require, default disable
+	SSLMode  string `mapstructure:"ssl-mode"`// libpq sslmode, default disable
 }
 
 //Grafana dashboard config
@@ -81,6 +434,14 @@ type GrafanaDashboard struct {
 
 }
 
+// ClusterConfig groups a primary server and its replicas.
+// Roles are detected at runtime, so members can switch places after failover.
+type ClusterConfig struct {
+	Name              string   `mapstructure:"name"`
+	Servers           []string `mapstructure:"servers"`             // db-servers names
+	RoleCheckInterval Duration `mapstructure:"role-check-interval"` // default: 30s
+}
+
 // MetricsConfig represents configuration for metrics collection
 type MetricsConfig struct {
 	Version      string        `mapstructure:"version"`
@@ -95,45 +456,211 @@ type GlobalConfig struct {
 	DefaultQueryTimeout Duration `mapstructure:"default-query-timeout"`
 	DefaultMaxRetries   int      `mapstructure:"default-max-retries"`
 	DefaultRetryDelay   Duration `mapstructure:"default-retry-delay"`
+	ReadOnlyTransaction bool     `mapstructure:"read-only-transaction"`  // run metric SQL in read-only transactions
+	ServerTimeouts      bool     `mapstructure:"server-timeouts"`        // PostgreSQL enforces query-timeout with SET LOCAL statement_timeout and lock_timeout
+	RejectWriteSQL      bool     `mapstructure:"reject-write-sql"`       // reject SQL files with DML/DDL at startup
+	ReloadSQL           bool     `mapstructure:"reload-sql"`             // render SQL files again when they change on disk
+	ExplainOnStart      string   `mapstructure:"explain-on-start"`       // off, warn, skip or fail, default: off
+	PreparedStatements  bool     `mapstructure:"prepared-statements"`    // reuse a prepared statement per task instead of parsing SQL on every run
+	MissedTick          string   `mapstructure:"missed-tick"`            // skip, queue or run-late for ticks during an active run, default: skip
+	AllowOverlap        bool     `mapstructure:"allow-overlap"`          // start runs of a task while the previous one is active
+	BackoffAfter        int      `mapstructure:"backoff-after-failures"` // failed runs in a row that stretch the interval, 0 disables, default: 0
+	MaxBackoffInterval  Duration `mapstructure:"max-backoff-interval"`   // cap of the stretched interval, default: 30m
 }
 
 // MetricGroup represents a group of related metrics
 type MetricGroup struct {
 	Name        string   `mapstructure:"name"`
 	Description string   `mapstructure:"description"`
-	Enabled     bool     `mapstructure:"enabled"`
+	Enabled     *bool    `mapstructure:"enabled"`    // false silences all metrics of the group, default: true
+	AppliesTo   string   `mapstructure:"applies-to"` // selector of server tags, matching servers collect all metrics of the group
 	Metrics     []Metric `mapstructure:"metrics"`
 }
 
 // Metric defines a single metric to collect
 type Metric struct {
-	Name           string   `mapstructure:"name"`
-	Description    string   `mapstructure:"description"`
-	ValueType      string   `mapstructure:"value-type"`      // int, float, string, bool, table
-	Interval       Duration `mapstructure:"interval"`
-	CollectionType string   `mapstructure:"collection-type"` // sql, go_func
-	SQLFile        string   `mapstructure:"sql-file"`
-	GoFunction     string   `mapstructure:"go-function"`
-	QueryTimeout   Duration `mapstructure:"query-timeout"`
-	MaxRetries     int      `mapstructure:"max-retries"`
-	RetryDelay     Duration `mapstructure:"retry-delay"`
-	Unit           string   `mapstructure:"unit"`
-	DbMetricId     int      // Populated at runtime
+	Name               string            `mapstructure:"name"`
+	Description        string            `mapstructure:"description"`
+	Enabled            *bool             `mapstructure:"enabled"`    // false skips the metric on all servers, default: true
+	ValueType          string            `mapstructure:"value-type"` // int, int64, float, string, bool, table
+	Interval           Duration          `mapstructure:"interval"`
+	CollectionType     string            `mapstructure:"collection-type"` // sql, go_func, command, http, expression, forecast, bundle
+	SQLFile            string            `mapstructure:"sql-file"`
+	SQLFiles           map[string]string `mapstructure:"sql-files"`    // Driver specific overrides of sql-file
+	SQLVersions        []VersionedSQL    `mapstructure:"sql-versions"` // PostgreSQL version specific overrides of sql-file, the first match wins
+	GoFunction         string            `mapstructure:"go-function"`
+	Command            string            `mapstructure:"command"` // Executable path for collection-type 'command'
+	CommandArgs        []string          `mapstructure:"args"`
+	HTTP               *HttpProbeConfig  `mapstructure:"http"`       // Probe settings for collection-type 'http'
+	Expression         string            `mapstructure:"expression"` // e.g. blks_hit / (blks_hit + blks_read), for collection-type 'expression'
+	MaxAge             Duration          `mapstructure:"max-age"`    // oldest value used by the expression, default: 3 intervals
+	Forecast           *ForecastConfig   `mapstructure:"forecast"`   // Growth of another metric for collection-type 'forecast'
+	QueryTimeout       Duration          `mapstructure:"query-timeout"`
+	MaxRetries         int               `mapstructure:"max-retries"`
+	RetryDelay         Duration          `mapstructure:"retry-delay"`
+	Unit               string            `mapstructure:"unit"`
+	Scope              string            `mapstructure:"scope"`               // server or database, default: server
+	Role               string            `mapstructure:"role"`                // any, primary or replica, default: any
+	StorageMode        string            `mapstructure:"storage-mode"`        // timeseries or latest, default: timeseries
+	Kind               string            `mapstructure:"kind"`                // gauge or counter (cumulative, delta and rate are added), default: gauge
+	Labels             map[string]string `mapstructure:"labels"`              // stored with every value, e.g. tablespace: pg_default
+	Priority           int               `mapstructure:"priority"`            // higher runs first when limits.max-concurrent-collections is reached, default: 0
+	Split              []BundleSplit     `mapstructure:"split"`               // keys of the collected object stored as separate metrics
+	MaxResultBytes     int               `mapstructure:"max-result-bytes"`    // size of the SQL result, 0 means unlimited
+	MaxRows            int               `mapstructure:"max-rows"`            // elements of an array SQL result, 0 means unlimited
+	ResultLimit        string            `mapstructure:"result-limit"`        // fail or truncate results over the limits, default: fail
+	HostPolicy         string            `mapstructure:"host-policy"`         // host of servers with hosts: primary-only, prefer-replica or any, default: primary-only
+	BackfillSQLFile    string            `mapstructure:"backfill-sql-file"`   // SQL of `elmon backfill`, rendered per window with window_start and window_end
+	RequiresExtensions []string          `mapstructure:"requires-extensions"` // PostgreSQL extensions read by the metric, e.g. pg_stat_statements
+	DbMetricId         int               // Populated at runtime
+}
+
+// BundleSplit stores a key of the object collected by a bundle metric as a metric of collection-type 'bundle'
+type BundleSplit struct {
+	Key    string `mapstructure:"key"`
+	Metric string `mapstructure:"metric"`
+}
+
+// VersionedSQL is the SQL file of a metric for PostgreSQL servers of a version range. Versions are
+// major versions such as 14 or 9.6, or minor ones such as 13.4, both bounds are inclusive.
+type VersionedSQL struct {
+	MinVersion PostgresVersion `mapstructure:"min-version"` // empty for no lower bound
+	MaxVersion PostgresVersion `mapstructure:"max-version"` // empty for no upper bound, 13 includes every 13.x
+	SQLFile    string          `mapstructure:"sql-file"`
+
+	minVersionNum int // lowest server_version_num of the range
+	maxVersionNum int // highest server_version_num of the range, 0 for no upper bound
+}
+
+// Validate checks the version range
+func (v *VersionedSQL) Validate() error {
+	if v.SQLFile == "" {
+		return fmt.Errorf("sql-file is required in sql-versions")
+	}
+	if v.MinVersion == "" && v.MaxVersion == "" {
+		return fmt.Errorf("min-version or max-version is required in sql-versions, use sql-file for all versions")
+	}
+	var err error
+	if v.MinVersion != "" {
+		if v.minVersionNum, _, err = ParsePostgresVersion(string(v.MinVersion)); err != nil {
+			return fmt.Errorf("invalid min-version: %w", err)
+		}
+	}
+	if v.MaxVersion != "" {
+		if _, v.maxVersionNum, err = ParsePostgresVersion(string(v.MaxVersion)); err != nil {
+			return fmt.Errorf("invalid max-version: %w", err)
+		}
+		if v.maxVersionNum < v.minVersionNum {
+			return fmt.Errorf("max-version %s is lower than min-version %s", v.MaxVersion, v.MinVersion)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a server_version_num is in the range
+func (v *VersionedSQL) Matches(versionNum int) bool {
+	return versionNum >= v.minVersionNum && (v.maxVersionNum == 0 || versionNum <= v.maxVersionNum)
+}
+
+// ParsePostgresVersion returns the lowest and the highest server_version_num of a PostgreSQL version,
+// e.g. 14 gives 140000 and 149999, 9.6 gives 90600 and 90699, 13.4 gives 130004 twice
+func ParsePostgresVersion(version string) (int, int, error) {
+	var parts []int
+	for _, part := range strings.Split(strings.TrimSpace(version), ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || number > 99 {
+			return 0, 0, fmt.Errorf("'%s' is not a PostgreSQL version such as 14, 9.6 or 13.4", version)
+		}
+		parts = append(parts, number)
+	}
+	// Since PostgreSQL 10 the major version is a single number, 9.6 and older have two
+	major := parts[0]
+	switch {
+	case major >= 10 && len(parts) == 1:
+		return major * 10000, major*10000 + 9999, nil
+	case major >= 10 && len(parts) == 2:
+		return major*10000 + parts[1], major*10000 + parts[1], nil
+	case major < 10 && len(parts) == 2:
+		return major*10000 + parts[1]*100, major*10000 + parts[1]*100 + 99, nil
+	case major < 10 && len(parts) == 3:
+		number := major*10000 + parts[1]*100 + parts[2]
+		return number, number, nil
+	}
+	return 0, 0, fmt.Errorf("'%s' is not a PostgreSQL version such as 14, 9.6 or 13.4", version)
+}
+
+// ForecastConfig defines collection-type 'forecast': a line fitted to stored values of another metric
+// tells how many days are left until they reach the threshold
+type ForecastConfig struct {
+	Metric       string   `mapstructure:"metric"`        // metric of the same scope, e.g. database_size
+	Key          string   `mapstructure:"key"`           // number in its values, e.g. total, default: value
+	Window       Duration `mapstructure:"window"`        // history the line is fitted to, default: 168h
+	MinPoints    int      `mapstructure:"min-points"`    // values needed for a forecast, default: 10
+	Threshold    float64  `mapstructure:"threshold"`     // limit of the values, the threshold param of a server wins
+	ThresholdKey string   `mapstructure:"threshold-key"` // key of the latest value holding the limit, e.g. max_connections
+	AlertWithin  Duration `mapstructure:"alert-within"`  // alert when the limit is closer, 0 disables alerts
+}
+
+// Validate checks the forecast and sets defaults
+func (c *ForecastConfig) Validate() error {
+	if c.Metric == "" {
+		return fmt.Errorf("forecast metric is required")
+	}
+	if c.Key == "" {
+		c.Key = "value"
+	}
+	if c.Window.Duration < 0 || c.AlertWithin.Duration < 0 || c.MinPoints < 0 {
+		return fmt.Errorf("forecast window, min-points and alert-within must not be negative")
+	}
+	if c.Window.Duration == 0 {
+		c.Window.Duration = 168 * time.Hour
+	}
+	if c.MinPoints == 0 {
+		c.MinPoints = 10
+	}
+	if c.MinPoints < 2 {
+		return fmt.Errorf("forecast min-points must be at least 2")
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("forecast threshold must not be negative")
+	}
+	return nil
+}
+
+// HttpProbeConfig defines an HTTP request performed by collection-type 'http'
+type HttpProbeConfig struct {
+	URL            string            `mapstructure:"url"`
+	Method         string            `mapstructure:"method"` // default: GET
+	Headers        map[string]string `mapstructure:"headers"`
+	Body           string            `mapstructure:"body"`
+	ExpectedStatus int               `mapstructure:"expected-status"` // default: 200
+	JSONPath       string            `mapstructure:"json-path"`       // e.g. $.state or $.members[0].lag
 }
 
 // ServerMetricsMapping links a server with a set of metrics to collect
 type ServerMetricsMapping struct {
-	Name    string                   `mapstructure:"name"`
+	Name    string                 `mapstructure:"name"`
+	Profile string                 `mapstructure:"profile"` // metrics of the profile, layered under metrics below
+	Exclude []string               `mapstructure:"exclude"` // metrics of the profile or applies-to groups not collected on the server
+	Params  map[string]interface{} `mapstructure:"params"`  // SQL template values for all metrics of the server
 	Metrics []ServerMetricOverride `mapstructure:"metrics"`
+
+	// Defaults for all metrics of the server, a metric entry setting the value wins
+	DefaultInterval     Duration `mapstructure:"default-interval"`
+	DefaultMaxRetries   int      `mapstructure:"default-max-retries"`
+	DefaultRetryDelay   Duration `mapstructure:"default-retry-delay"`
+	DefaultQueryTimeout Duration `mapstructure:"default-query-timeout"`
 }
 
 // ServerMetricOverride allows overriding metric parameters for a specific server
 type ServerMetricOverride struct {
-	Name         string   `mapstructure:"name"`
-	Interval     Duration `mapstructure:"interval"`
-	MaxRetries   int      `mapstructure:"max-retries"`
-	RetryDelay   Duration `mapstructure:"retry-delay"`
-	QueryTimeout Duration `mapstructure:"query-timeout"`
+	Name         string                 `mapstructure:"name"`
+	Enabled      *bool                  `mapstructure:"enabled"` // false skips the metric on this server, default: true
+	Interval     Duration               `mapstructure:"interval"`
+	MaxRetries   int                    `mapstructure:"max-retries"`
+	RetryDelay   Duration               `mapstructure:"retry-delay"`
+	QueryTimeout Duration               `mapstructure:"query-timeout"`
+	Params       map[string]interface{} `mapstructure:"params"` // SQL template values for this metric
 }
 
 // Duration wrapper around time.Duration for proper YAML unmarshaling
@@ -148,6 +675,29 @@ func (d *Duration) UnmarshalText(text []byte) error {
 	return err
 }
 
+// PostgresVersion is a PostgreSQL version such as 14 or "9.6". Major versions since 10 may be unquoted,
+// versions with a dot must be quoted.
+type PostgresVersion string
+
+// postgresVersionHook is a mapstructure hook turning YAML integers into PostgresVersion. YAML reads an
+// unquoted 13.10 as the number 13.1, so numbers with a fraction are rejected instead of guessed.
+func postgresVersionHook() mapstructure.DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if t != reflect.TypeOf(PostgresVersion("")) {
+			return data, nil
+		}
+		switch number := data.(type) {
+		case int:
+			return PostgresVersion(strconv.Itoa(number)), nil
+		case uint64:
+			return PostgresVersion(strconv.FormatUint(number, 10)), nil
+		case float64:
+			return nil, fmt.Errorf("PostgreSQL version %v must be quoted, YAML reads an unquoted 13.10 as 13.1", number)
+		}
+		return data, nil
+	}
+}
+
 // customDurationHook is a mapstructure hook for parsing time strings
 func customDurationHook() mapstructure.DecodeHookFunc {
 	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
@@ -167,24 +717,42 @@ func customDurationHook() mapstructure.DecodeHookFunc {
 
 // Load reads, deserializes and validates configuration file
 func Load(configPath string) (*AppConfig, error) {
+	config, err := Read(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate entire configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Configuration loaded successfully from %s\n", configPath)
+	return config, nil
+}
+
+// Read reads and deserializes configuration file without validation.
+// Use Check or Validate on the result.
+func Read(configPath string) (*AppConfig, error) {
 	// Load .env file for secrets
 	if err := godotenv.Load(); err != nil {
-		fmt.Println("INFO: .env file not found, using system environment variables for secrets")
+		fmt.Fprintln(os.Stderr, "INFO: .env file not found, using system environment variables for secrets")
 	}
 
-	// Read raw file
-	rawContent, err := os.ReadFile(configPath)
+	// Read file together with included files
+	settings, err := readConfigTree(configPath, make(map[string]bool))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file '%s': %w", configPath, err)
+		return nil, err
 	}
 
-	// Expand environment variables of format ${VAR}
-	expandedContent := os.ExpandEnv(string(rawContent))
+	// Override values with ELMON_* environment variables
+	if err := applyEnvOverrides(settings); err != nil {
+		return nil, err
+	}
 
 	// Initialize Viper
 	v := viper.New()
-	v.SetConfigType("yaml")
-	if err := v.ReadConfig(bytes.NewBufferString(expandedContent)); err != nil {
+	if err := v.MergeConfigMap(settings); err != nil {
 		return nil, fmt.Errorf("failed to parse configuration: %w", err)
 	}
 
@@ -197,22 +765,152 @@ func Load(configPath string) (*AppConfig, error) {
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		Result:     &config,
 		TagName:    "mapstructure",
-		DecodeHook: mapstructure.ComposeDecodeHookFunc(customDurationHook()),
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(customDurationHook(), postgresVersionHook()),
+		// Unknown keys are most likely typos, fail instead of silently using defaults
+		ErrorUnused: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create decoder: %w", err)
 	}
-	if err := decoder.Decode(v.AllSettings()); err != nil {
+	settings = v.AllSettings()
+	if err := decoder.Decode(settings); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	config.settings = settings
+	config.applyProfiles()
 
-	// Validate entire configuration
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	return &config, nil
+}
+
+// applySelectors adds metrics of groups whose applies-to selector matches tags of db-servers.
+// It runs in Check once servers have their names, invalid selectors are skipped and reported.
+func (cfg *AppConfig) applySelectors() {
+	for _, server := range cfg.DBServers {
+		if server.Name == "" {
+			continue
+		}
+		index := slices.IndexFunc(cfg.ServerMetricsMap, func(mapping ServerMetricsMapping) bool { return mapping.Name == server.Name })
+		if index < 0 {
+			if len(cfg.SelectedMetrics(&ServerMetricsMapping{}, server.SelectorTags())) == 0 {
+				continue
+			}
+			cfg.ServerMetricsMap = append(cfg.ServerMetricsMap, ServerMetricsMapping{Name: server.Name})
+			index = len(cfg.ServerMetricsMap) - 1
+		}
+		mapping := &cfg.ServerMetricsMap[index]
+		mapping.Metrics = append(mapping.Metrics, cfg.SelectedMetrics(mapping, server.SelectorTags())...)
 	}
+}
 
-	fmt.Printf("Configuration loaded successfully from %s\n", configPath)
-	return &config, nil
+// SelectedMetrics returns metrics of groups whose applies-to selector matches the tags,
+// except metrics the mapping lists or excludes. They run with settings of the metric definitions.
+func (cfg *AppConfig) SelectedMetrics(mapping *ServerMetricsMapping, tags map[string]string) []ServerMetricOverride {
+	var selected []ServerMetricOverride
+	for _, group := range cfg.Metrics.MetricGroups {
+		if group.AppliesTo == "" {
+			continue
+		}
+		groupSelector, err := selector.Parse(group.AppliesTo)
+		if err != nil || !groupSelector.Matches(tags) {
+			continue
+		}
+		for _, metric := range group.Metrics {
+			isMetric := func(override ServerMetricOverride) bool { return override.Name == metric.Name }
+			if slices.Contains(mapping.Exclude, metric.Name) || slices.ContainsFunc(mapping.Metrics, isMetric) ||
+				slices.ContainsFunc(selected, isMetric) {
+				continue
+			}
+			selected = append(selected, ServerMetricOverride{Name: metric.Name})
+		}
+	}
+	return selected
+}
+
+// SelectorTags returns tags matched by applies-to selectors: configured tags
+// and built-in name, environment and driver unless configured explicitly
+func (c *DbConnectionConfig) SelectorTags() map[string]string {
+	tags := map[string]string{"name": c.Name, "environment": c.Environment, "driver": c.Driver}
+	for key, value := range c.Tags {
+		tags[strings.ToLower(key)] = value
+	}
+	return tags
+}
+
+// applyProfiles expands profiles referenced by servers-metrics-map entries: profile metrics come first,
+// server metrics add to them or override their settings field by field, excluded metrics are dropped.
+// Unknown profiles are left for Check to report.
+func (cfg *AppConfig) applyProfiles() {
+	for i := range cfg.ServerMetricsMap {
+		mapping := &cfg.ServerMetricsMap[i]
+		profile, ok := cfg.Profile(mapping.Profile)
+		if mapping.Profile == "" || !ok {
+			continue
+		}
+
+		params := make(map[string]interface{})
+		maps.Copy(params, profile.Params)
+		maps.Copy(params, mapping.Params)
+		mapping.Params = params
+
+		overrides := make(map[string]ServerMetricOverride)
+		for _, metric := range mapping.Metrics {
+			overrides[metric.Name] = metric
+		}
+		var metrics []ServerMetricOverride
+		for _, metric := range profile.Metrics {
+			if slices.Contains(mapping.Exclude, metric.Name) {
+				continue
+			}
+			if override, ok := overrides[metric.Name]; ok {
+				metric = metric.layer(override)
+				delete(overrides, metric.Name)
+			}
+			metrics = append(metrics, metric)
+		}
+		for _, metric := range mapping.Metrics {
+			if _, added := overrides[metric.Name]; added {
+				metrics = append(metrics, metric)
+			}
+		}
+		mapping.Metrics = metrics
+	}
+}
+
+// layer returns the metric settings with non-zero settings of override applied on top
+func (m ServerMetricOverride) layer(override ServerMetricOverride) ServerMetricOverride {
+	if override.Enabled != nil {
+		m.Enabled = override.Enabled
+	}
+	if override.Interval.Duration != 0 {
+		m.Interval = override.Interval
+	}
+	if override.MaxRetries != 0 {
+		m.MaxRetries = override.MaxRetries
+	}
+	if override.RetryDelay.Duration != 0 {
+		m.RetryDelay = override.RetryDelay
+	}
+	if override.QueryTimeout.Duration != 0 {
+		m.QueryTimeout = override.QueryTimeout
+	}
+	params := make(map[string]interface{})
+	maps.Copy(params, m.Params)
+	maps.Copy(params, override.Params)
+	m.Params = params
+	return m
+}
+
+// MetricSettings returns settings of a metric entry of the mapping, unset values are taken
+// from server defaults. Values still unset fall back to the metric definition.
+func (mapping *ServerMetricsMapping) MetricSettings(metric ServerMetricOverride) ServerMetricOverride {
+	defaults := ServerMetricOverride{
+		Name:         metric.Name,
+		Interval:     mapping.DefaultInterval,
+		MaxRetries:   mapping.DefaultMaxRetries,
+		RetryDelay:   mapping.DefaultRetryDelay,
+		QueryTimeout: mapping.DefaultQueryTimeout,
+	}
+	return defaults.layer(metric)
 }
 
 // setDefaults sets default values for Viper
@@ -220,6 +918,9 @@ func setDefaults(v *viper.Viper) {
 	// Log
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.format", "json")
+	// Metrics DB
+	v.SetDefault("metrics-db.write-timeout", "10s")
+	v.SetDefault("metrics-db.health-check-interval", "10s")
 	// Grafana
 	v.SetDefault("grafana.timeout", 30)
 	// Metrics
@@ -228,113 +929,986 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("metrics.global.default-query-timeout", "10s")
 	v.SetDefault("metrics.global.default-max-retries", 0)
 	v.SetDefault("metrics.global.default-retry-delay", "5s")
+	v.SetDefault("metrics.global.explain-on-start", "off")
+	v.SetDefault("metrics.global.missed-tick", "skip")
+	v.SetDefault("metrics.global.max-backoff-interval", "30m")
+	// Storage
+	v.SetDefault("storage.timescaledb.mode", "off")
+	v.SetDefault("storage.timescaledb.chunk-interval", "24h")
+	v.SetDefault("storage.timescaledb.compress-after", "168h")
+	v.SetDefault("storage.timescaledb.continuous-aggregates", true)
+	v.SetDefault("storage.rollups.after", "24h")
+	v.SetDefault("storage.rollups.interval", "1h")
+	v.SetDefault("storage.spool.dir", "spool")
+	v.SetDefault("storage.spool.max-size", 100)
+	v.SetDefault("storage.spool.replay-interval", "30s")
+	v.SetDefault("storage.write-metrics-db", true)
+	v.SetDefault("storage.time-source", "metrics-db")
+	v.SetDefault("storage.purge-inactive-after", "0s")
+	v.SetDefault("storage.collection-log.retention", "168h")
+	// Alerts
+	v.SetDefault("alerts.repeat-interval", "1h")
+	// Reports
+	v.SetDefault("reports.availability.metric", "db_uptime")
+	v.SetDefault("reports.availability.target", 99.9)
+	v.SetDefault("reports.availability.timezone", "UTC")
+	v.SetDefault("reports.availability.interval", "1h")
+	v.SetDefault("reports.availability.email.port", 587)
+	// Discovery
+	v.SetDefault("discovery.interval", "1m")
+	v.SetDefault("discovery.timeout", "30s")
+	// API
+	v.SetDefault("api.query-timeout", "30s")
+	v.SetDefault("api.ui", true)
+	v.SetDefault("api.push.max-age", "24h")
+	v.SetDefault("api.push.max-future", "5m")
+	// Limits
+	v.SetDefault("limits.max-concurrent-collections", 100)
+	// Leader election
+	v.SetDefault("leader-election.lease", "30s")
+	// Instances
+	v.SetDefault("instances.heartbeat-interval", "30s")
+	v.SetDefault("instances.forget-after", "168h")
+	// Kerberos
+	v.SetDefault("kerberos.refresh-interval", "1h")
+	v.SetDefault("kerberos.kinit", "kinit")
 }
 
 // Validate runs all validation checks for loaded configuration
+// and returns the first problem found
 func (cfg *AppConfig) Validate() error {
+	if problems := cfg.Check(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// Check runs all validation checks for loaded configuration
+// and returns every problem found instead of failing on the first one
+func (cfg *AppConfig) Check() []error {
+	var problems []error
+
 	if err := cfg.Log.Validate(); err != nil {
-		return fmt.Errorf("log config validation failed: %w", err)
+		problems = append(problems, fmt.Errorf("log config validation failed: %w", err))
 	}
 	if err := cfg.MetricsDB.Validate(); err != nil {
-		return fmt.Errorf("metrics-db config validation failed: %w", err)
+		problems = append(problems, fmt.Errorf("metrics-db config validation failed: %w", err))
+	} else if cfg.MetricsDB.Driver != "postgres" && cfg.MetricsDB.Driver != "sqlite" {
+		problems = append(problems, fmt.Errorf("metrics-db config validation failed: only 'postgres' and 'sqlite' drivers are supported for metrics database"))
+	} else if cfg.MetricsDB.Driver == "sqlite" && (cfg.Storage.TimescaleDB.Mode != "off" || cfg.Storage.Rollups.Enabled) {
+		problems = append(problems, fmt.Errorf("storage config validation failed: timescaledb and rollups require 'postgres' metrics database"))
+	} else if len(cfg.MetricsDB.Hosts) > 0 {
+		problems = append(problems, fmt.Errorf("metrics-db config validation failed: hosts are supported for monitored servers only"))
+	}
+	if cfg.MetricsDB.WriteTimeout.Duration <= 0 {
+		problems = append(problems, fmt.Errorf("metrics-db config validation failed: write-timeout must be positive"))
+	}
+	if cfg.MetricsDB.HealthCheckInterval.Duration < 0 {
+		problems = append(problems, fmt.Errorf("metrics-db config validation failed: health-check-interval must not be negative"))
 	}
 	if err := cfg.Grafana.Validate(); err != nil {
-		return fmt.Errorf("grafana config validation failed: %w", err)
+		problems = append(problems, fmt.Errorf("grafana config validation failed: %w", err))
 	}
 
 	// Validate server list
 	serverNames := make(map[string]bool)
+	pgbouncerServers := make(map[string]bool)
+	hostPoolServers := make(map[string]bool)
 	for i := range cfg.DBServers {
 		srv := &cfg.DBServers[i]
 		if err := srv.Validate(); err != nil {
-			return fmt.Errorf("db-server at index %d ('%s') validation failed: %w", i, srv.Name, err)
+			problems = append(problems, fmt.Errorf("db-server at index %d ('%s') validation failed: %w", i, srv.Name, err))
+		} else if srv.Driver == "sqlite" {
+			problems = append(problems, fmt.Errorf("db-server at index %d ('%s') validation failed: 'sqlite' driver is supported for metrics database only", i, srv.Name))
 		}
 		if serverNames[srv.Name] {
-			return fmt.Errorf("duplicate db server name found: '%s'", srv.Name)
+			problems = append(problems, fmt.Errorf("duplicate db server name found: '%s'", srv.Name))
 		}
 		serverNames[srv.Name] = true
+		if srv.Driver == "pgbouncer" {
+			pgbouncerServers[srv.Name] = true
+		}
+		if len(srv.Hosts) > 0 {
+			hostPoolServers[srv.Name] = true
+		}
 	}
 
 	// Validate metrics
-	if err := cfg.Metrics.Validate(); err != nil {
-		return fmt.Errorf("metrics config validation failed: %w", err)
+	for _, err := range cfg.Metrics.check() {
+		problems = append(problems, fmt.Errorf("metrics config validation failed: %w", err))
 	}
+	cfg.applySelectors()
 
 	// Validate server-metrics mapping
 	metricNames := cfg.Metrics.GetAllMetricNames()
-	if err := validateServerMetricsMap(cfg.ServerMetricsMap, serverNames, metricNames); err != nil {
-		return fmt.Errorf("servers-metrics-map validation failed: %w", err)
+	profiles := make(map[string]MetricProfile)
+	for _, profile := range cfg.MetricProfiles {
+		profiles[profile.Name] = profile
+	}
+	for _, err := range validateServerMetricsMap(cfg.ServerMetricsMap, serverNames, metricNames, profiles) {
+		problems = append(problems, fmt.Errorf("servers-metrics-map validation failed: %w", err))
 	}
 
-	return nil
-}
-
-// --- Individual validation functions ---
-
-func (c *LogConfig) Validate() error {
-	validLevels := []string{"debug", "info", "warn", "error"}
-	if !slices.Contains(validLevels, strings.ToLower(c.Level)) {
-		return fmt.Errorf("invalid log level: '%s'", c.Level)
+	// Validate clusters
+	for _, err := range validateClusters(cfg.Clusters, serverNames) {
+		problems = append(problems, fmt.Errorf("clusters validation failed: %w", err))
 	}
-	validFormats := []string{"json", "text"}
-	if !slices.Contains(validFormats, strings.ToLower(c.Format)) {
-		return fmt.Errorf("invalid log format: '%s'", c.Format)
+	// Roles are detected with PostgreSQL functions, PgBouncer has none
+	for _, cluster := range cfg.Clusters {
+		for _, server := range cluster.Servers {
+			if pgbouncerServers[server] {
+				problems = append(problems, fmt.Errorf("clusters validation failed: server '%s' of cluster '%s' uses driver 'pgbouncer'", server, cluster.Name))
+			}
+			if hostPoolServers[server] {
+				problems = append(problems, fmt.Errorf("clusters validation failed: server '%s' of cluster '%s' has hosts, its role differs per host", server, cluster.Name))
+			}
+		}
 	}
-	return nil
-}
 
-func (c *DbConnectionConfig) Validate() error {
-	if c.Host == "" {
-		return fmt.Errorf("host is required")
+	if err := cfg.Storage.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("storage config validation failed: %w", err))
 	}
-	if c.Port <= 0 || c.Port > 65535 {
-		return fmt.Errorf("invalid port: %d", c.Port)
+	if err := cfg.Alerts.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("alerts config validation failed: %w", err))
 	}
-	if c.User == "" {
-		return fmt.Errorf("user is required")
+	if err := cfg.Reports.Availability.Validate(cfg.Metrics); err != nil {
+		problems = append(problems, fmt.Errorf("reports config validation failed: availability: %w", err))
 	}
-	if c.DbName == "" {
-		return fmt.Errorf("dbname is required")
+	for _, err := range cfg.Discovery.check(metricNames) {
+		problems = append(problems, fmt.Errorf("discovery config validation failed: %w", err))
 	}
-	if c.Name == "" {
-		c.Name = fmt.Sprintf("%s:%d_%s", c.Host, c.Port, c.DbName)
+	for _, err := range checkMetricProfiles(cfg.MetricProfiles, metricNames) {
+		problems = append(problems, fmt.Errorf("metric-profiles validation failed: %w", err))
 	}
-	if c.SslMode == "" {
-		c.SslMode = "disable"
+	if err := cfg.API.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("api config validation failed: %w", err))
 	}
-
-	return nil
-}
-
-func (c *GrafanaConfig) Validate() error {
-	if c.Url == "" {
-		return fmt.Errorf("url is required")
+	if err := cfg.Limits.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("limits config validation failed: %w", err))
 	}
-	if c.Token == "" {
-		return fmt.Errorf("token is required")
+	if err := cfg.Kerberos.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("kerberos config validation failed: %w", err))
 	}
-	if c.Timeout <= 0 {
-		return fmt.Errorf("timeout must be positive: %d", c.Timeout)
+	if err := cfg.LeaderElection.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("leader-election config validation failed: %w", err))
 	}
-	if c.DataSource == nil {
-		return fmt.Errorf("there is no grafana data source section")
+	if err := cfg.Sharding.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("sharding config validation failed: %w", err))
 	}
-	if c.Dashboard == nil {
-		return fmt.Errorf("there is no grafana dashboard section")
+	if err := cfg.Instances.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("instances config validation failed: %w", err))
 	}
-	if err:=c.DataSource.Validate();err!=nil{
-		return err
+
+	// Sinks may be limited to metric groups
+	groupNames := make(map[string]bool)
+	for _, group := range cfg.Metrics.MetricGroups {
+		groupNames[group.Name] = true
+	}
+	for _, sink := range cfg.Storage.Sinks {
+		for _, groupName := range sink.MetricGroups {
+			if !groupNames[groupName] {
+				problems = append(problems, fmt.Errorf("storage config validation failed: sink '%s' refers to unknown metric group '%s'", sink.Name, groupName))
+			}
+		}
 	}
 
-	return nil
+	return problems
 }
 
-func (c *GrafanaDataSource) Validate() error {
-	if c.Name == "" {
-		return fmt.Errorf("datasource name is required")
+// --- Individual validation functions ---
+
+func (c *StorageConfig) Validate() error {
+	timescale := &c.TimescaleDB
+	if !slices.Contains([]string{"off", "auto", "on"}, timescale.Mode) {
+		return fmt.Errorf("invalid timescaledb mode: '%s'", timescale.Mode)
 	}
-	if c.URL == "" {
-		return fmt.Errorf("datasource URL is required")
+	if timescale.ChunkInterval.Duration <= 0 {
+		return fmt.Errorf("timescaledb chunk-interval must be positive")
+	}
+	if timescale.CompressAfter.Duration < 0 {
+		return fmt.Errorf("timescaledb compress-after must not be negative")
+	}
+
+	rollups := &c.Rollups
+	if rollups.Enabled && timescale.Mode == "on" {
+		return fmt.Errorf("rollups can't be used with timescaledb, use continuous-aggregates instead")
+	}
+	if rollups.After.Duration < 0 || rollups.Interval.Duration <= 0 {
+		return fmt.Errorf("rollups after must not be negative and interval must be positive")
+	}
+
+	spool := &c.Spool
+	if spool.Enabled && spool.Dir == "" {
+		return fmt.Errorf("spool dir is required")
+	}
+	if spool.MaxSize < 0 || spool.ReplayInterval.Duration <= 0 {
+		return fmt.Errorf("spool max-size must not be negative and replay-interval must be positive")
+	}
+
+	if c.CollectionLog.Retention.Duration < 0 {
+		return fmt.Errorf("collection-log retention must not be negative")
+	}
+
+	switch c.TimeSource {
+	case "metrics-db", "collector", "target":
+	default:
+		return fmt.Errorf("time-source must be 'metrics-db', 'collector' or 'target', got '%s'", c.TimeSource)
+	}
+	if c.PurgeInactiveAfter.Duration < 0 {
+		return fmt.Errorf("purge-inactive-after must not be negative")
+	}
+
+	if !c.WriteMetricsDB && len(c.Sinks) == 0 {
+		return fmt.Errorf("at least one sink is required when write-metrics-db is false")
+	}
+	sinkNames := make(map[string]bool)
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		if err := sink.Validate(); err != nil {
+			return fmt.Errorf("sink at index %d ('%s') validation failed: %w", i, sink.Name, err)
+		}
+		if sinkNames[sink.Name] {
+			return fmt.Errorf("duplicate sink name found: '%s'", sink.Name)
+		}
+		sinkNames[sink.Name] = true
+	}
+	return nil
+}
+
+func (c *AlertsConfig) Validate() error {
+	url := c.Webhook.URL
+	if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("webhook url must be an http or https URL: '%s'", url)
+	}
+	if c.RepeatInterval.Duration <= 0 {
+		return fmt.Errorf("repeat-interval must be positive")
+	}
+	return nil
+}
+
+// Validate checks the report settings, the metric is required when the report is enabled
+func (c *AvailabilityReportConfig) Validate(metrics MetricsConfig) error {
+	if c.Target <= 0 || c.Target > 100 {
+		return fmt.Errorf("target must be a percentage above 0 and up to 100")
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone '%s': %w", c.Timezone, err)
+	}
+	if c.Interval.Duration <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	url := c.Webhook.URL
+	if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("webhook url must be an http or https URL: '%s'", url)
+	}
+	if email := &c.Email; email.Host != "" {
+		if email.Port <= 0 || email.Port > 65535 {
+			return fmt.Errorf("email port must be from 1 to 65535")
+		}
+		if email.From == "" || len(email.To) == 0 {
+			return fmt.Errorf("email from and to are required with email host")
+		}
+	}
+	if !c.Enabled {
+		return nil
+	}
+	for _, group := range metrics.MetricGroups {
+		for _, metric := range group.Metrics {
+			if metric.Name != c.Metric {
+				continue
+			}
+			if metric.Scope == "database" {
+				return fmt.Errorf("metric '%s' must have scope 'server'", c.Metric)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown metric '%s'", c.Metric)
+}
+
+// check validates discovery sources and the metrics they map
+func (c *DiscoveryConfig) check(metricNames map[string]bool) []error {
+	var problems []error
+	if len(c.Sources) > 0 && c.Interval.Duration <= 0 {
+		problems = append(problems, fmt.Errorf("interval must be positive"))
+	}
+	if len(c.Sources) > 0 && c.Timeout.Duration <= 0 {
+		problems = append(problems, fmt.Errorf("timeout must be positive"))
+	}
+	sourceNames := make(map[string]bool)
+	for i := range c.Sources {
+		source := &c.Sources[i]
+		if err := source.Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("source at index %d ('%s'): %w", i, source.Name, err))
+		}
+		if sourceNames[source.Name] {
+			problems = append(problems, fmt.Errorf("duplicate source name: '%s'", source.Name))
+		}
+		sourceNames[source.Name] = true
+		problems = append(problems, checkMetricOverrides("source '"+source.Name+"'", source.Metrics, metricNames)...)
+	}
+	return problems
+}
+
+// checkMetricOverrides validates metrics assigned to a server, a discovery source or a profile
+func checkMetricOverrides(owner string, overrides []ServerMetricOverride, metricNames map[string]bool) []error {
+	var problems []error
+	names := make(map[string]bool)
+	for _, metric := range overrides {
+		if !metricNames[metric.Name] {
+			problems = append(problems, fmt.Errorf("metric '%s' of %s is not defined in metrics configuration", metric.Name, owner))
+		}
+		if names[metric.Name] {
+			problems = append(problems, fmt.Errorf("duplicate metric '%s' for %s", metric.Name, owner))
+		}
+		names[metric.Name] = true
+	}
+	return problems
+}
+
+// checkMetricProfiles validates profile names and their metrics
+func checkMetricProfiles(profiles []MetricProfile, metricNames map[string]bool) []error {
+	var problems []error
+	profileNames := make(map[string]bool)
+	for i, profile := range profiles {
+		if profile.Name == "" {
+			problems = append(problems, fmt.Errorf("profile at index %d: name is required", i))
+		}
+		if profileNames[profile.Name] {
+			problems = append(problems, fmt.Errorf("duplicate profile name: '%s'", profile.Name))
+		}
+		profileNames[profile.Name] = true
+		problems = append(problems, checkMetricOverrides("profile '"+profile.Name+"'", profile.Metrics, metricNames)...)
+	}
+	return problems
+}
+
+func (c *APIConfig) Validate() error {
+	if c.QueryTimeout.Duration <= 0 {
+		return fmt.Errorf("query-timeout must be positive")
+	}
+	if c.Push.MaxAge.Duration <= 0 {
+		return fmt.Errorf("push.max-age must be positive")
+	}
+	if c.Push.MaxFuture.Duration < 0 {
+		return fmt.Errorf("push.max-future can't be negative")
+	}
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, token := range c.Push.Tokens {
+		if token.Name == "" || token.Token == "" {
+			return fmt.Errorf("push tokens require name and token")
+		}
+		if names[token.Name] {
+			return fmt.Errorf("duplicate push token name: '%s'", token.Name)
+		}
+		if tokens[token.Token] {
+			return fmt.Errorf("push token '%s' is used by another agent", token.Name)
+		}
+		names[token.Name], tokens[token.Token] = true, true
+	}
+	if err := c.Auth.Validate(); err != nil {
+		return err
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires both cert-file and key-file")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		return fmt.Errorf("tls client-ca-file requires cert-file and key-file")
+	}
+	if len(c.Auth.Clients) > 0 && c.TLS.ClientCAFile == "" {
+		return fmt.Errorf("auth clients require tls client-ca-file")
+	}
+	if c.Listen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address '%s': %w", c.Listen, err)
+	}
+	return nil
+}
+
+// Validate checks API clients, roles default to read
+func (c *APIAuthConfig) Validate() error {
+	validRoles := []string{"read", "operator"}
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i := range c.Tokens {
+		token := &c.Tokens[i]
+		if token.Name == "" || token.Token == "" {
+			return fmt.Errorf("auth tokens require name and token")
+		}
+		if names[token.Name] {
+			return fmt.Errorf("duplicate auth token name: '%s'", token.Name)
+		}
+		if tokens[token.Token] {
+			return fmt.Errorf("auth token '%s' is used by another client", token.Name)
+		}
+		names[token.Name], tokens[token.Token] = true, true
+		if token.Role == "" {
+			token.Role = "read"
+		}
+		if !slices.Contains(validRoles, token.Role) {
+			return fmt.Errorf("invalid role of auth token '%s': '%s', expected read or operator", token.Name, token.Role)
+		}
+	}
+	commonNames := make(map[string]bool)
+	for i := range c.Clients {
+		client := &c.Clients[i]
+		if client.CommonName == "" {
+			return fmt.Errorf("auth clients require common-name")
+		}
+		if commonNames[client.CommonName] {
+			return fmt.Errorf("duplicate auth client common-name: '%s'", client.CommonName)
+		}
+		commonNames[client.CommonName] = true
+		if client.Role == "" {
+			client.Role = "read"
+		}
+		if !slices.Contains(validRoles, client.Role) {
+			return fmt.Errorf("invalid role of auth client '%s': '%s', expected read or operator", client.CommonName,
+				client.Role)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether API clients must authenticate
+func (c *APIAuthConfig) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.Clients) > 0
+}
+
+// Validate checks that limits are not negative
+func (c *LimitsConfig) Validate() error {
+	if c.MaxConcurrentCollections < 0 || c.MaxConnectionsPerTarget < 0 || c.MaxInflightWrites < 0 || c.MaxBufferedMemory < 0 ||
+		c.MaxQueriesPerMinute < 0 {
+		return fmt.Errorf("limits must not be negative, 0 means unlimited")
+	}
+	return nil
+}
+
+// Validate checks the lease, it is renewed three times per lease
+func (c *LeaderElectionConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Lease.Duration < 3*time.Second {
+		return fmt.Errorf("lease must be at least 3s: %s", c.Lease.Duration)
+	}
+	return nil
+}
+
+// Validate checks that the shard is one of the shards
+func (c *ShardingConfig) Validate() error {
+	if c.Shards < 0 {
+		return fmt.Errorf("shards must not be negative, 0 disables sharding")
+	}
+	if c.Shards > 0 && (c.Shard < 0 || c.Shard >= c.Shards) {
+		return fmt.Errorf("shard must be from 0 to %d: %d", c.Shards-1, c.Shard)
+	}
+	return nil
+}
+
+// Validate checks that instances are forgotten after several heartbeats
+func (c *InstancesConfig) Validate() error {
+	if c.HeartbeatInterval.Duration < 0 || c.ForgetAfter.Duration < 0 {
+		return fmt.Errorf("heartbeat-interval and forget-after must not be negative")
+	}
+	if c.HeartbeatInterval.Duration > 0 && c.ForgetAfter.Duration < 3*c.HeartbeatInterval.Duration {
+		return fmt.Errorf("forget-after must be at least three heartbeat intervals: %s", c.ForgetAfter.Duration)
+	}
+	return nil
+}
+
+// Validate checks that a keytab comes with its principal
+func (c *KerberosConfig) Validate() error {
+	if c.Keytab == "" {
+		return nil
+	}
+	if c.Principal == "" {
+		return fmt.Errorf("principal is required with keytab")
+	}
+	if c.RefreshInterval.Duration <= 0 {
+		return fmt.Errorf("refresh-interval must be positive")
+	}
+	return nil
+}
+
+// Profile returns the metric profile with the name
+func (cfg *AppConfig) Profile(name string) (MetricProfile, bool) {
+	for _, profile := range cfg.MetricProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return MetricProfile{}, false
+}
+
+// DecodeServer decodes a server defined outside of configuration files, e.g. sent to the API,
+// with the keys of db-servers. The server is validated as monitored servers are.
+func DecodeServer(raw map[string]interface{}) (DbConnectionConfig, error) {
+	var server DbConnectionConfig
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:      &server,
+		TagName:     "mapstructure",
+		DecodeHook:  mapstructure.ComposeDecodeHookFunc(customDurationHook(), postgresVersionHook()),
+		ErrorUnused: true,
+	})
+	if err != nil {
+		return server, fmt.Errorf("failed to create decoder: %w", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return server, err
+	}
+	if err := server.Validate(); err != nil {
+		return server, err
+	}
+	if server.Driver == "sqlite" {
+		return server, fmt.Errorf("'sqlite' driver is supported for metrics database only")
+	}
+	return server, nil
+}
+
+func (c *DiscoverySourceConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch c.Type {
+	case "sql":
+		if c.SQL.Query == "" {
+			return fmt.Errorf("sql.query is required for source type 'sql'")
+		}
+	case "dns-srv":
+		if c.DNSSRV.Name == "" {
+			return fmt.Errorf("dns-srv.name is required for source type 'dns-srv'")
+		}
+	case "consul":
+		if c.Consul.Service == "" {
+			return fmt.Errorf("consul.service is required for source type 'consul'")
+		}
+		if c.Consul.Address == "" {
+			c.Consul.Address = "http://127.0.0.1:8500"
+		}
+	case "kubernetes":
+		if c.Kubernetes.LabelSelector == "" {
+			return fmt.Errorf("kubernetes.label-selector is required for source type 'kubernetes'")
+		}
+		if c.Kubernetes.Port == 0 {
+			c.Kubernetes.Port = 5432
+		}
+	default:
+		return fmt.Errorf("invalid source type: '%s'", c.Type)
+	}
+
+	if c.Server.DSN != "" {
+		return fmt.Errorf("server.dsn is not supported, servers are addressed by the source")
+	}
+	if c.Server.Host != "" || c.Server.Name != "" {
+		return fmt.Errorf("server.host and server.name come from the source and must not be set")
+	}
+	// Check the template as a server with a placeholder address, defaults are applied to the template
+	template := c.Server
+	template.Host, template.Name = "discovered", "discovered"
+	if template.Port == 0 {
+		template.Port = 5432
+	}
+	if err := template.Validate(); err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+	if template.Driver == "sqlite" {
+		return fmt.Errorf("server: 'sqlite' driver is supported for metrics database only")
+	}
+	template.Host, template.Name = "", ""
+	c.Server = template
+	return nil
+}
+
+func (c *SinkConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("sink name is required")
+	}
+	switch c.Type {
+	case "kafka":
+		if c.Kafka == nil {
+			return fmt.Errorf("kafka settings are required for sink type 'kafka'")
+		}
+		return c.Kafka.Validate()
+	case "otlp":
+		if c.OTLP == nil {
+			return fmt.Errorf("otlp settings are required for sink type 'otlp'")
+		}
+		return c.OTLP.Validate()
+	case "clickhouse":
+		if c.ClickHouse == nil {
+			return fmt.Errorf("clickhouse settings are required for sink type 'clickhouse'")
+		}
+		return c.ClickHouse.Validate()
+	default:
+		return fmt.Errorf("invalid sink type: '%s'", c.Type)
+	}
+}
+
+func (c *OTLPSinkConfig) Validate() error {
+	if !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("otlp endpoint must be an http or https URL: '%s'", c.Endpoint)
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = "elmon"
+	}
+	if c.Prefix == "" {
+		c.Prefix = "elmon"
+	}
+	return nil
+}
+
+func (c *ClickHouseSinkConfig) Validate() error {
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("clickhouse url must be an http or https URL: '%s'", c.URL)
+	}
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	if c.Database == "" {
+		c.Database = "default"
+	}
+	if c.Table == "" {
+		c.Table = "metric_value"
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 10000
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("clickhouse batch-size must be positive")
+	}
+	if c.FlushInterval.Duration == 0 {
+		c.FlushInterval.Duration = 5 * time.Second
+	}
+	if c.FlushInterval.Duration < 0 {
+		return fmt.Errorf("clickhouse flush-interval must be positive")
+	}
+	if c.MaxBuffer == 0 {
+		c.MaxBuffer = 10 * c.BatchSize
+	}
+	if c.MaxBuffer < c.BatchSize {
+		return fmt.Errorf("clickhouse max-buffer must not be less than batch-size")
+	}
+	if c.TTL.Duration < 0 {
+		return fmt.Errorf("clickhouse ttl must not be negative")
+	}
+	return nil
+}
+
+func (c *KafkaSinkConfig) Validate() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("kafka brokers are required")
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("kafka topic is required")
+	}
+	if c.ClientID == "" {
+		c.ClientID = "elmon"
+	}
+	if c.RequiredAcks == "" {
+		c.RequiredAcks = "all"
+	}
+	if !slices.Contains([]string{"none", "one", "all"}, c.RequiredAcks) {
+		return fmt.Errorf("invalid kafka required-acks: '%s'", c.RequiredAcks)
+	}
+	if c.BatchTimeout.Duration == 0 {
+		c.BatchTimeout.Duration = 50 * time.Millisecond
+	}
+	if c.BatchTimeout.Duration < 0 {
+		return fmt.Errorf("kafka batch-timeout must not be negative")
+	}
+	return nil
+}
+
+func (c *LogConfig) Validate() error {
+	if err := c.LogSinkConfig.Validate(); err != nil {
+		return err
+	}
+	if c.Dedup.Burst < 0 || c.Dedup.Interval.Duration < 0 {
+		return fmt.Errorf("dedup burst and interval must not be negative")
+	}
+	if c.Dedup.Burst > 0 && c.Dedup.Interval.Duration == 0 {
+		c.Dedup.Interval.Duration = 5 * time.Minute
+	}
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		// Sinks inherit level and format from top level settings
+		if sink.Level == "" {
+			sink.Level = c.Level
+		}
+		if sink.Format == "" {
+			sink.Format = c.Format
+		}
+		if err := sink.Validate(); err != nil {
+			return fmt.Errorf("sink at index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c *LogSinkConfig) Validate() error {
+	validLevels := []string{"debug", "info", "warn", "error"}
+	if !slices.Contains(validLevels, strings.ToLower(c.Level)) {
+		return fmt.Errorf("invalid log level: '%s'", c.Level)
+	}
+	validFormats := []string{"json", "text"}
+	if !slices.Contains(validFormats, strings.ToLower(c.Format)) {
+		return fmt.Errorf("invalid log format: '%s'", c.Format)
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return fmt.Errorf("max-size, max-backups and max-age must not be negative")
+	}
+	return nil
+}
+
+// sslModes are libpq sslmode values, also mapped to TLS settings of MySQL
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// IsUnixSocket reports whether host is the directory of a PostgreSQL unix socket instead of a TCP address
+func (c *DbConnectionConfig) IsUnixSocket() bool {
+	return strings.HasPrefix(c.Host, "/")
+}
+
+// validateHosts checks hosts of a server with several hosts and normalizes them to host:port.
+// The first host is stored as host and port of the server.
+func (c *DbConnectionConfig) validateHosts() error {
+	// Roles of hosts are detected with PostgreSQL functions
+	if c.Driver != "postgres" {
+		return fmt.Errorf("hosts require driver 'postgres'")
+	}
+	if c.DiscoverDatabases {
+		return fmt.Errorf("discover-databases is not supported together with hosts")
+	}
+	defaultPort := c.Port
+	if defaultPort == 0 {
+		defaultPort = 5432
+	}
+	seen := make(map[string]bool)
+	for i, address := range c.Hosts {
+		if strings.HasPrefix(address, "/") {
+			return fmt.Errorf("unix socket directory '%s' is not supported in hosts", address)
+		}
+		host, port := address, defaultPort
+		if strings.Contains(address, ":") {
+			var portText string
+			var err error
+			host, portText, err = net.SplitHostPort(address)
+			if err != nil {
+				return fmt.Errorf("invalid host '%s': %w", address, err)
+			}
+			if port, err = strconv.Atoi(portText); err != nil || port <= 0 || port > 65535 {
+				return fmt.Errorf("invalid port of host '%s'", address)
+			}
+		}
+		if host == "" {
+			return fmt.Errorf("invalid host '%s': host name is empty", address)
+		}
+		c.Hosts[i] = net.JoinHostPort(host, strconv.Itoa(port))
+		if seen[c.Hosts[i]] {
+			return fmt.Errorf("duplicate host: '%s'", c.Hosts[i])
+		}
+		seen[c.Hosts[i]] = true
+		if i == 0 {
+			if c.Host != "" && (c.Host != host || (c.Port != 0 && c.Port != port)) {
+				return fmt.Errorf("host and port must be left out or match the first of hosts")
+			}
+			c.Host, c.Port = host, port
+		}
+	}
+	if c.HostCheckInterval.Duration < 0 {
+		return fmt.Errorf("host-check-interval must not be negative")
+	}
+	if c.HostCheckInterval.Duration == 0 {
+		c.HostCheckInterval.Duration = 30 * time.Second
+	}
+	return nil
+}
+
+func (c *DbConnectionConfig) Validate() error {
+	if c.Driver == "" {
+		c.Driver = "postgres"
+	}
+	if c.ApplicationName == "" {
+		c.ApplicationName = "elmon"
+	}
+	validDrivers := []string{"postgres", "mysql", "sqlite", "pgbouncer"}
+	if !slices.Contains(validDrivers, c.Driver) {
+		return fmt.Errorf("invalid driver: '%s'", c.Driver)
+	}
+	if c.Auth.Type == "" {
+		c.Auth.Type = "password"
+	}
+	if c.Auth.Type != "password" && (c.DSN != "" || (c.Driver != "postgres" && c.Driver != "mysql")) {
+		return fmt.Errorf("auth type '%s' requires driver 'postgres' or 'mysql' without dsn", c.Auth.Type)
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone '%s': %w", c.Timezone, err)
+		}
+	}
+	if c.MaxQueriesPerMinute < 0 {
+		return fmt.Errorf("max-queries-per-minute must not be negative, 0 means the limits value")
+	}
+
+	// SQLite database is a local file, dbname is its path
+	if c.Driver == "sqlite" {
+		if c.DSN == "" && c.DbName == "" {
+			return fmt.Errorf("dbname (database file path) is required for driver 'sqlite'")
+		}
+		if c.Name == "" {
+			c.Name = c.DbName
+		}
+		return nil
+	}
+
+	// DSN is used verbatim, composed connection fields are informational only
+	if c.DSN != "" {
+		if c.Name == "" {
+			return fmt.Errorf("name is required when dsn is set")
+		}
+		if c.Port < 0 || c.Port > 65535 {
+			return fmt.Errorf("invalid port: %d", c.Port)
+		}
+		if c.DiscoverDatabases {
+			return fmt.Errorf("discover-databases is not supported together with dsn")
+		}
+		if len(c.Hosts) > 0 {
+			return fmt.Errorf("hosts are not supported together with dsn")
+		}
+		return nil
+	}
+
+	if len(c.Hosts) > 0 {
+		if err := c.validateHosts(); err != nil {
+			return err
+		}
+	}
+
+	// PgBouncer admin console is a virtual database, it lists pools of all databases itself
+	if c.Driver == "pgbouncer" {
+		if c.DbName == "" {
+			c.DbName = "pgbouncer"
+		}
+		if c.DiscoverDatabases {
+			return fmt.Errorf("discover-databases is not supported for driver 'pgbouncer'")
+		}
+	}
+
+	if c.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	// The port of a unix socket is the suffix of the socket file name, .s.PGSQL.<port>
+	if c.IsUnixSocket() {
+		if c.Driver != "postgres" && c.Driver != "pgbouncer" {
+			return fmt.Errorf("unix socket directory '%s' as host requires driver 'postgres' or 'pgbouncer'", c.Host)
+		}
+		if c.Port == 0 {
+			c.Port = 5432
+			if c.Driver == "pgbouncer" {
+				c.Port = 6432
+			}
+		}
+		if c.SslMode != "" && c.SslMode != "disable" {
+			return fmt.Errorf("ssl-mode '%s' is not supported over unix socket '%s'", c.SslMode, c.Host)
+		}
+		if c.Auth.Type == "aws-iam" || c.Auth.Type == "gcp-iam" {
+			return fmt.Errorf("auth type '%s' is not supported over unix socket '%s'", c.Auth.Type, c.Host)
+		}
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", c.Port)
+	}
+	if c.User == "" {
+		return fmt.Errorf("user is required")
+	}
+	if c.DbName == "" {
+		return fmt.Errorf("dbname is required")
+	}
+	if c.Name == "" {
+		c.Name = fmt.Sprintf("%s:%d_%s", c.Host, c.Port, c.DbName)
+	}
+	if c.SslMode == "" {
+		c.SslMode = "disable"
+	}
+	if !slices.Contains(sslModes, c.SslMode) {
+		return fmt.Errorf("invalid ssl-mode: '%s'", c.SslMode)
+	}
+	if (c.SslMode == "verify-ca" || c.SslMode == "verify-full") && c.SslRootCert == "" {
+		return fmt.Errorf("ssl-root-cert is required for ssl-mode '%s'", c.SslMode)
+	}
+	if (c.SslCert == "") != (c.SslKey == "") {
+		return fmt.Errorf("ssl-cert and ssl-key must be set together")
+	}
+	if err := c.Auth.Validate(c.Password, c.SslMode); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if (c.KrbSrvName != "" || c.KrbSpn != "") && c.Driver == "mysql" {
+		return fmt.Errorf("krb-srv-name and krb-spn are not supported for driver 'mysql'")
+	}
+
+	return nil
+}
+
+func (c *AuthConfig) Validate(password string, sslMode string) error {
+	validTypes := []string{"password", "aws-iam", "gcp-iam", "command"}
+	if !slices.Contains(validTypes, c.Type) {
+		return fmt.Errorf("invalid type: '%s'", c.Type)
+	}
+	if c.Type == "password" {
+		return nil
+	}
+	if password != "" {
+		return fmt.Errorf("password can't be set together with auth type '%s'", c.Type)
+	}
+	// Tokens are sent as passwords, they must not cross the network unencrypted
+	if !slices.Contains([]string{"require", "verify-ca", "verify-full"}, sslMode) {
+		return fmt.Errorf("auth type '%s' requires ssl-mode require, verify-ca or verify-full", c.Type)
+	}
+	if c.Type == "command" && c.Command == "" {
+		return fmt.Errorf("command is required for auth type 'command'")
+	}
+	if c.TokenLifetime.Duration < 0 || c.RefreshBefore.Duration < 0 {
+		return fmt.Errorf("token-lifetime and refresh-before must not be negative")
+	}
+	if c.TokenLifetime.Duration == 0 {
+		c.TokenLifetime.Duration = 15 * time.Minute
+	}
+	if c.RefreshBefore.Duration == 0 {
+		c.RefreshBefore.Duration = time.Minute
+	}
+	return nil
+}
+
+func (c *GrafanaConfig) Validate() error {
+	if c.Url == "" {
+		return fmt.Errorf("url is required")
+	}
+	if c.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive: %d", c.Timeout)
+	}
+	if c.DataSource == nil {
+		return fmt.Errorf("there is no grafana data source section")
+	}
+	if c.Dashboard == nil {
+		return fmt.Errorf("there is no grafana dashboard section")
+	}
+	if err:=c.DataSource.Validate();err!=nil{
+		return err
+	}
+
+	return nil
+}
+
+func (c *GrafanaDataSource) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("datasource name is required")
+	}
+	if c.URL == "" {
+		return fmt.Errorf("datasource URL is required")
 	}
 	if c.Database == "" {
 		return fmt.Errorf("datasource database is required")
@@ -348,8 +1922,8 @@ func (c *GrafanaDataSource) Validate() error {
 	if c.SSLMode == "" {
 		c.SSLMode = "disable"
 	}
-	if c.SSLMode != "disable" && c.SSLMode != "required" {
-		return fmt.Errorf("datasource ssl mode should be disabled or required")
+	if !slices.Contains(sslModes, c.SSLMode) {
+		return fmt.Errorf("invalid datasource ssl-mode: '%s'", c.SSLMode)
 	}
 	return nil
 }
@@ -368,8 +1942,29 @@ func (c *GrafanaDashboard) Validate() error {
 }
 
 func (c *MetricsConfig) Validate() error {
+	if problems := c.check(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// check returns every metrics configuration problem
+func (c *MetricsConfig) check() []error {
+	var problems []error
 	if c.Version != "1.0" {
-		return fmt.Errorf("unsupported metrics config version: '%s', expected '1.0'", c.Version)
+		problems = append(problems, fmt.Errorf("unsupported metrics config version: '%s', expected '1.0'", c.Version))
+	}
+	if !slices.Contains([]string{"off", "warn", "skip", "fail"}, c.Global.ExplainOnStart) {
+		problems = append(problems, fmt.Errorf("invalid explain-on-start: '%s', expected off, warn, skip or fail", c.Global.ExplainOnStart))
+	}
+	if !slices.Contains([]string{"skip", "queue", "run-late"}, c.Global.MissedTick) {
+		problems = append(problems, fmt.Errorf("invalid missed-tick: '%s', expected skip, queue or run-late", c.Global.MissedTick))
+	}
+	if c.Global.BackoffAfter < 0 {
+		problems = append(problems, fmt.Errorf("backoff-after-failures must not be negative"))
+	}
+	if c.Global.MaxBackoffInterval.Duration < 0 {
+		problems = append(problems, fmt.Errorf("max-backoff-interval must not be negative"))
 	}
 
 	groupNames := make(map[string]bool)
@@ -377,28 +1972,136 @@ func (c *MetricsConfig) Validate() error {
 
 	for _, group := range c.MetricGroups {
 		if group.Name == "" {
-			return fmt.Errorf("metric group name is required")
-		}
-		if groupNames[group.Name] {
-			return fmt.Errorf("duplicate metric group name: '%s'", group.Name)
+			problems = append(problems, fmt.Errorf("metric group name is required"))
+		} else if groupNames[group.Name] {
+			problems = append(problems, fmt.Errorf("duplicate metric group name: '%s'", group.Name))
 		}
 		groupNames[group.Name] = true
+		if group.AppliesTo != "" {
+			if _, err := selector.Parse(group.AppliesTo); err != nil {
+				problems = append(problems, fmt.Errorf("invalid applies-to of metric group '%s': %w", group.Name, err))
+			}
+		}
 
-		for _, metric := range group.Metrics {
+		for i := range group.Metrics {
+			metric := &group.Metrics[i] // Pointer so defaults set by Validate are kept
 			if metric.Name == "" {
-				return fmt.Errorf("metric name is required in group '%s'", group.Name)
+				problems = append(problems, fmt.Errorf("metric name is required in group '%s'", group.Name))
+				continue
 			}
 			if metricNames[metric.Name] {
-				return fmt.Errorf("duplicate metric name found globally: '%s'", metric.Name)
+				problems = append(problems, fmt.Errorf("duplicate metric name found globally: '%s'", metric.Name))
 			}
 			// Validate specific metric
 			if err := metric.Validate(); err != nil {
-				return fmt.Errorf("metric '%s' validation failed: %w", metric.Name, err)
+				problems = append(problems, fmt.Errorf("metric '%s' validation failed: %w", metric.Name, err))
 			}
 			metricNames[metric.Name] = true
 		}
 	}
-	return nil
+
+	problems = append(problems, c.checkBundles()...)
+
+	// Expressions may refer to any metric but themselves
+	for _, group := range c.MetricGroups {
+		for _, metric := range group.Metrics {
+			if metric.CollectionType != "expression" {
+				continue
+			}
+			parsed, err := expression.Parse(metric.Expression)
+			if err != nil {
+				continue // reported by Validate
+			}
+			for _, ref := range parsed.References() {
+				if ref.Metric == metric.Name {
+					problems = append(problems, fmt.Errorf("metric '%s' expression refers to itself", metric.Name))
+				} else if !metricNames[ref.Metric] {
+					problems = append(problems, fmt.Errorf("metric '%s' expression refers to unknown metric '%s'", metric.Name, ref.Metric))
+				}
+			}
+		}
+	}
+	problems = append(problems, c.checkForecasts()...)
+	return problems
+}
+
+// checkForecasts checks that every metric of collection-type 'forecast' reads another metric of the same scope
+func (c *MetricsConfig) checkForecasts() []error {
+	var problems []error
+	metrics := make(map[string]*Metric)
+	for _, group := range c.MetricGroups {
+		for i := range group.Metrics {
+			metrics[group.Metrics[i].Name] = &group.Metrics[i]
+		}
+	}
+
+	for _, group := range c.MetricGroups {
+		for _, metric := range group.Metrics {
+			if metric.CollectionType != "forecast" || metric.Forecast == nil {
+				continue
+			}
+			source, ok := metrics[metric.Forecast.Metric]
+			switch {
+			case metric.Forecast.Metric == metric.Name:
+				problems = append(problems, fmt.Errorf("metric '%s' forecasts itself", metric.Name))
+			case !ok:
+				problems = append(problems, fmt.Errorf("metric '%s' forecasts unknown metric '%s'", metric.Name,
+					metric.Forecast.Metric))
+			case source.Scope != metric.Scope:
+				problems = append(problems, fmt.Errorf("metric '%s' forecasts metric '%s' of another scope", metric.Name,
+					source.Name))
+			case source.StorageMode == "latest":
+				problems = append(problems, fmt.Errorf("metric '%s' forecasts metric '%s' of storage-mode 'latest', which keeps no history",
+					metric.Name, source.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// checkBundles checks that every metric of collection-type 'bundle' is split from exactly one metric
+// of the same scope
+func (c *MetricsConfig) checkBundles() []error {
+	var problems []error
+	metrics := make(map[string]*Metric)
+	for _, group := range c.MetricGroups {
+		for i := range group.Metrics {
+			metrics[group.Metrics[i].Name] = &group.Metrics[i]
+		}
+	}
+
+	splitFrom := make(map[string]string)
+	for _, group := range c.MetricGroups {
+		for _, metric := range group.Metrics {
+			for _, split := range metric.Split {
+				target, ok := metrics[split.Metric]
+				switch {
+				case !ok:
+					problems = append(problems, fmt.Errorf("metric '%s' splits key '%s' into unknown metric '%s'",
+						metric.Name, split.Key, split.Metric))
+					continue
+				case target.CollectionType != "bundle":
+					problems = append(problems, fmt.Errorf("metric '%s' splits key '%s' into metric '%s', which must have collection-type 'bundle'",
+						metric.Name, split.Key, split.Metric))
+				case target.Scope != metric.Scope:
+					problems = append(problems, fmt.Errorf("metric '%s' splits key '%s' into metric '%s' of another scope",
+						metric.Name, split.Key, split.Metric))
+				}
+				if other, ok := splitFrom[split.Metric]; ok {
+					problems = append(problems, fmt.Errorf("metric '%s' is split from both '%s' and '%s'", split.Metric, other, metric.Name))
+				}
+				splitFrom[split.Metric] = metric.Name
+			}
+		}
+	}
+	for _, group := range c.MetricGroups {
+		for _, metric := range group.Metrics {
+			if metric.CollectionType == "bundle" && splitFrom[metric.Name] == "" {
+				problems = append(problems, fmt.Errorf("metric '%s' of collection-type 'bundle' is not in the split of any metric", metric.Name))
+			}
+		}
+	}
+	return problems
 }
 
 func (m *Metric) Validate() error {
@@ -408,56 +2111,339 @@ func (m *Metric) Validate() error {
 		return fmt.Errorf("invalid value-type: '%s'", m.ValueType)
 	}
 
+	// Validate Scope
+	if m.Scope == "" {
+		m.Scope = "server"
+	}
+	if m.Scope != "server" && m.Scope != "database" {
+		return fmt.Errorf("invalid scope: '%s'", m.Scope)
+	}
+
+	// Validate Role
+	if m.Role == "" {
+		m.Role = "any"
+	}
+	if !slices.Contains([]string{"any", "primary", "replica"}, m.Role) {
+		return fmt.Errorf("invalid role: '%s'", m.Role)
+	}
+
+	// Validate HostPolicy
+	if m.HostPolicy == "" {
+		m.HostPolicy = "primary-only"
+	}
+	if !slices.Contains([]string{"primary-only", "prefer-replica", "any"}, m.HostPolicy) {
+		return fmt.Errorf("invalid host-policy: '%s', expected primary-only, prefer-replica or any", m.HostPolicy)
+	}
+
+	// Validate StorageMode
+	if m.StorageMode == "" {
+		m.StorageMode = "timeseries"
+	}
+	if m.StorageMode != "timeseries" && m.StorageMode != "latest" {
+		return fmt.Errorf("invalid storage-mode: '%s'", m.StorageMode)
+	}
+
+	// Validate Kind
+	if m.Kind == "" {
+		m.Kind = "gauge"
+	}
+	if m.Kind != "gauge" && m.Kind != "counter" {
+		return fmt.Errorf("invalid kind: '%s'", m.Kind)
+	}
+	if m.Kind == "counter" && (m.ValueType == "bool" || m.ValueType == "string") {
+		return fmt.Errorf("kind 'counter' requires a numeric or table value-type, got '%s'", m.ValueType)
+	}
+
+	for name := range m.Labels {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("label name must not be empty")
+		}
+	}
+
+	// Validate result limits
+	if m.MaxResultBytes < 0 || m.MaxRows < 0 {
+		return fmt.Errorf("max-result-bytes and max-rows must not be negative")
+	}
+	if m.ResultLimit == "" {
+		m.ResultLimit = "fail"
+	}
+	if m.ResultLimit != "fail" && m.ResultLimit != "truncate" {
+		return fmt.Errorf("invalid result-limit: '%s', expected fail or truncate", m.ResultLimit)
+	}
+
 	// Validate CollectionType
 	switch m.CollectionType {
 	case "sql":
-		if m.SQLFile == "" {
+		if m.SQLFile == "" && len(m.SQLFiles) == 0 && len(m.SQLVersions) == 0 {
 			return fmt.Errorf("sql-file is required for collection-type 'sql'")
 		}
+		for i := range m.SQLVersions {
+			if err := m.SQLVersions[i].Validate(); err != nil {
+				return err
+			}
+		}
 		// File existence check - optional, better to do when collector starts
 	case "go_func":
 		if m.GoFunction == "" {
 			return fmt.Errorf("go-function is required for collection-type 'go_func'")
 		}
+	case "command":
+		if m.Command == "" {
+			return fmt.Errorf("command is required for collection-type 'command'")
+		}
+	case "http":
+		if m.HTTP == nil {
+			return fmt.Errorf("http section is required for collection-type 'http'")
+		}
+		if err := m.HTTP.Validate(); err != nil {
+			return err
+		}
+	case "expression":
+		if m.Expression == "" {
+			return fmt.Errorf("expression is required for collection-type 'expression'")
+		}
+		if _, err := expression.Parse(m.Expression); err != nil {
+			return fmt.Errorf("invalid expression: %w", err)
+		}
+		if m.MaxAge.Duration < 0 {
+			return fmt.Errorf("max-age must not be negative")
+		}
+	case "forecast":
+		if m.Forecast == nil {
+			return fmt.Errorf("forecast section is required for collection-type 'forecast'")
+		}
+		if m.ValueType != "float" {
+			return fmt.Errorf("collection-type 'forecast' requires value-type float")
+		}
+		if err := m.Forecast.Validate(); err != nil {
+			return err
+		}
+	case "bundle":
+		// Values come from the split of another metric, see MetricsConfig.checkBundles
+	case "push":
+		// Values are sent by agents to POST /api/push
 	default:
 		return fmt.Errorf("unknown collection-type: '%s'", m.CollectionType)
 	}
+
+	// Extensions are looked up in the database the metric queries
+	if len(m.RequiresExtensions) > 0 && m.CollectionType != "sql" && m.CollectionType != "go_func" {
+		return fmt.Errorf("requires-extensions is supported for collection-type 'sql' and 'go_func' only")
+	}
+	for _, extension := range m.RequiresExtensions {
+		if strings.TrimSpace(extension) == "" {
+			return fmt.Errorf("extension name in requires-extensions must not be empty")
+		}
+	}
+
+	if len(m.Split) > 0 && slices.Contains([]string{"expression", "forecast", "bundle", "push"}, m.CollectionType) {
+		return fmt.Errorf("split is not supported for collection-type '%s'", m.CollectionType)
+	}
+	// Backfilled values are stored at past times, a latest value would be replaced by an older one
+	if m.BackfillSQLFile != "" {
+		switch {
+		case m.CollectionType != "sql":
+			return fmt.Errorf("backfill-sql-file requires collection-type 'sql'")
+		case m.StorageMode == "latest":
+			return fmt.Errorf("backfill-sql-file is not supported for storage-mode 'latest'")
+		case len(m.Split) > 0:
+			return fmt.Errorf("backfill-sql-file is not supported together with split")
+		}
+	}
+	keys := make(map[string]bool)
+	for _, split := range m.Split {
+		if split.Key == "" || split.Metric == "" {
+			return fmt.Errorf("split requires key and metric")
+		}
+		if keys[split.Key] {
+			return fmt.Errorf("duplicate split key: '%s'", split.Key)
+		}
+		keys[split.Key] = true
+	}
 	return nil
 }
 
-func validateServerMetricsMap(mappings []ServerMetricsMapping, serverNames map[string]bool, metricNames map[string]bool) error {
+func (c *HttpProbeConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("http url is required")
+	}
+	if c.Method == "" {
+		c.Method = "GET"
+	}
+	c.Method = strings.ToUpper(c.Method)
+	if c.ExpectedStatus == 0 {
+		c.ExpectedStatus = 200
+	}
+	if c.ExpectedStatus < 100 || c.ExpectedStatus > 599 {
+		return fmt.Errorf("invalid http expected-status: %d", c.ExpectedStatus)
+	}
+	if c.JSONPath != "" && !strings.HasPrefix(c.JSONPath, "$") {
+		return fmt.Errorf("http json-path must start with '$': '%s'", c.JSONPath)
+	}
+	return nil
+}
+
+func validateServerMetricsMap(mappings []ServerMetricsMapping, serverNames map[string]bool, metricNames map[string]bool,
+	profiles map[string]MetricProfile) []error {
+	var problems []error
 	mapServerNames := make(map[string]bool)
 	for _, mapping := range mappings {
 		if mapping.Name == "" {
-			return fmt.Errorf("server name is required in servers-metrics-map")
+			problems = append(problems, fmt.Errorf("server name is required in servers-metrics-map"))
+			continue
 		}
 		if !serverNames[mapping.Name] {
-			return fmt.Errorf("server '%s' from servers-metrics-map is not defined in db-servers", mapping.Name)
+			problems = append(problems, fmt.Errorf("server '%s' from servers-metrics-map is not defined in db-servers", mapping.Name))
 		}
 		if mapServerNames[mapping.Name] {
-			return fmt.Errorf("duplicate server name '%s' in servers-metrics-map", mapping.Name)
+			problems = append(problems, fmt.Errorf("duplicate server name '%s' in servers-metrics-map", mapping.Name))
 		}
 		mapServerNames[mapping.Name] = true
 
+		if mapping.DefaultInterval.Duration < 0 || mapping.DefaultMaxRetries < 0 || mapping.DefaultRetryDelay.Duration < 0 ||
+			mapping.DefaultQueryTimeout.Duration < 0 {
+			problems = append(problems, fmt.Errorf("server defaults of '%s' in servers-metrics-map must not be negative", mapping.Name))
+		}
+		if _, ok := profiles[mapping.Profile]; mapping.Profile != "" && !ok {
+			problems = append(problems, fmt.Errorf("profile '%s' for server '%s' is not defined in metric-profiles", mapping.Profile, mapping.Name))
+		}
+		for _, excluded := range mapping.Exclude {
+			if !metricNames[excluded] {
+				problems = append(problems, fmt.Errorf("excluded metric '%s' for server '%s' is not defined in metrics configuration", excluded, mapping.Name))
+			}
+			// Metrics are resolved at this point, an excluded metric is there only if listed for the server too
+			if slices.ContainsFunc(mapping.Metrics, func(metric ServerMetricOverride) bool { return metric.Name == excluded }) {
+				problems = append(problems, fmt.Errorf("metric '%s' for server '%s' is both excluded and listed", excluded, mapping.Name))
+			}
+		}
+
 		mapMetricNames := make(map[string]bool)
 		for _, metric := range mapping.Metrics {
 			if metric.Name == "" {
-				return fmt.Errorf("metric name is required for server '%s' in mapping", mapping.Name)
+				problems = append(problems, fmt.Errorf("metric name is required for server '%s' in mapping", mapping.Name))
+				continue
 			}
 			if !metricNames[metric.Name] {
-				return fmt.Errorf("metric '%s' for server '%s' is not defined in metrics configuration", metric.Name, mapping.Name)
+				problems = append(problems, fmt.Errorf("metric '%s' for server '%s' is not defined in metrics configuration", metric.Name, mapping.Name))
 			}
 			if mapMetricNames[metric.Name] {
-				return fmt.Errorf("duplicate metric '%s' for server '%s' in mapping", metric.Name, mapping.Name)
+				problems = append(problems, fmt.Errorf("duplicate metric '%s' for server '%s' in mapping", metric.Name, mapping.Name))
 			}
 			mapMetricNames[metric.Name] = true
 		}
 	}
-	return nil
+	return problems
+}
+
+func validateClusters(clusters []ClusterConfig, serverNames map[string]bool) []error {
+	var problems []error
+	clusterNames := make(map[string]bool)
+	clusterServers := make(map[string]string) // server name -> cluster name
+	for i := range clusters {
+		cluster := &clusters[i]
+		if cluster.Name == "" {
+			problems = append(problems, fmt.Errorf("cluster name is required"))
+			continue
+		}
+		if clusterNames[cluster.Name] {
+			problems = append(problems, fmt.Errorf("duplicate cluster name: '%s'", cluster.Name))
+		}
+		clusterNames[cluster.Name] = true
+
+		if len(cluster.Servers) == 0 {
+			problems = append(problems, fmt.Errorf("cluster '%s' has no servers", cluster.Name))
+		}
+		for _, server := range cluster.Servers {
+			if !serverNames[server] {
+				problems = append(problems, fmt.Errorf("server '%s' from cluster '%s' is not defined in db-servers", server, cluster.Name))
+			}
+			if other, ok := clusterServers[server]; ok {
+				problems = append(problems, fmt.Errorf("server '%s' belongs to both clusters '%s' and '%s'", server, other, cluster.Name))
+			}
+			clusterServers[server] = cluster.Name
+		}
+
+		if cluster.RoleCheckInterval.Duration == 0 {
+			cluster.RoleCheckInterval.Duration = 30 * time.Second
+		}
+	}
+	return problems
 }
 
 // --- Helper functions ---
 
+// isEnabled reports whether an optional enabled flag is set, a missing flag means enabled
+func isEnabled(flag *bool) bool {
+	return flag == nil || *flag
+}
+
+// IsEnabled reports whether metrics of the group are collected
+func (g *MetricGroup) IsEnabled() bool {
+	return isEnabled(g.Enabled)
+}
+
+// IsEnabled reports whether the metric is collected
+func (m *Metric) IsEnabled() bool {
+	return isEnabled(m.Enabled)
+}
+
+// IsEnabled reports whether the server is connected and monitored
+func (c *DbConnectionConfig) IsEnabled() bool {
+	return isEnabled(c.Enabled)
+}
+
+// IsEnabled reports whether the metric is collected on the server of the mapping
+func (m *ServerMetricOverride) IsEnabled() bool {
+	return isEnabled(m.Enabled)
+}
+
+// SQLFileFor returns the SQL file for the given driver and PostgreSQL server_version_num (0 when unknown):
+// the first matching sql-versions entry, then sql-files of the driver, falling back to sql-file
+func (m *Metric) SQLFileFor(driver string, versionNum int) string {
+	if versionNum > 0 {
+		for i := range m.SQLVersions {
+			if m.SQLVersions[i].Matches(versionNum) {
+				return m.SQLVersions[i].SQLFile
+			}
+		}
+	}
+	if file, ok := m.SQLFiles[driver]; ok && file != "" {
+		return file
+	}
+	return m.SQLFile
+}
+
+// MaxQueryTimeout returns the longest query timeout of metrics mapped to the server.
+// It is used as server-side statement timeout so monitoring sessions can't run away.
+func (cfg *AppConfig) MaxQueryTimeout(serverName string) time.Duration {
+	for _, mapping := range cfg.ServerMetricsMap {
+		if mapping.Name == serverName {
+			return cfg.MetricsQueryTimeout(&mapping)
+		}
+	}
+	return cfg.Metrics.Global.DefaultQueryTimeout.Duration
+}
+
+// MetricsQueryTimeout returns the longest query timeout of mapped metrics, at least the default one
+func (cfg *AppConfig) MetricsQueryTimeout(mapping *ServerMetricsMapping) time.Duration {
+	metrics := make(map[string]Metric)
+	for _, group := range cfg.Metrics.MetricGroups {
+		for _, metric := range group.Metrics {
+			metrics[metric.Name] = metric
+		}
+	}
+
+	maxTimeout := cfg.Metrics.Global.DefaultQueryTimeout.Duration
+	for _, override := range mapping.Metrics {
+		timeout := mapping.MetricSettings(override).QueryTimeout.Duration
+		if timeout == 0 {
+			timeout = metrics[override.Name].QueryTimeout.Duration
+		}
+		maxTimeout = max(maxTimeout, timeout)
+	}
+	return maxTimeout
+}
+
 // GetAllMetricNames returns a slice of all defined metric names
 func (c *MetricsConfig) GetAllMetricNames() map[string]bool {
 	names := make(map[string]bool)
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// redacted replaces secrets in snapshots, their changes are not detected
+const redacted = "<redacted>"
+
+// secretKeys are keys whose values are never stored, values under "headers" are redacted as well
+var secretKeys = []string{"password", "token", "dsn"}
+
+// maxSummaryKeys limits keys listed per kind of change in a diff summary
+const maxSummaryKeys = 10
+
+// Snapshot returns the effective configuration, defaults and ELMON_* overrides included, flattened to
+// dotted keys, e.g. "metrics-db.write-timeout" or "db-servers[pg1].host". List elements with a name are
+// keyed by it, others by position. Secrets are redacted, so the snapshot can be stored.
+func (cfg *AppConfig) Snapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	flattenSettings(snapshot, "", cfg.settings, false)
+	return snapshot
+}
+
+// flattenSettings adds leaf values of settings to snapshot
+func flattenSettings(snapshot map[string]interface{}, key string, value interface{}, secret bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			snapshot[key] = value
+		}
+		for name, child := range value {
+			childKey := name
+			if key != "" {
+				childKey = key + "." + name
+			}
+			flattenSettings(snapshot, childKey, child, secret || name == "headers" || slices.Contains(secretKeys, name))
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			snapshot[key] = value
+		}
+		for i, child := range value {
+			element := fmt.Sprintf("%s[%d]", key, i)
+			if item, ok := child.(map[string]interface{}); ok {
+				if name, ok := item["name"].(string); ok && name != "" {
+					element = fmt.Sprintf("%s[%s]", key, name)
+				}
+			}
+			flattenSettings(snapshot, element, child, secret)
+		}
+	default:
+		if secret && value != nil && value != "" {
+			value = redacted
+		}
+		snapshot[key] = value
+	}
+}
+
+// SnapshotHash returns the SHA-256 of the snapshot, equal snapshots have equal hashes
+func SnapshotHash(snapshot map[string]interface{}) string {
+	// Maps are marshalled with sorted keys
+	data, _ := json.Marshal(snapshot)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SnapshotDiff lists keys which differ between two snapshots
+type SnapshotDiff struct {
+	Added   []string               `json:"added,omitempty"`
+	Removed []string               `json:"removed,omitempty"`
+	Changed map[string]ValueChange `json:"changed,omitempty"`
+}
+
+// ValueChange is the previous and the new value of a changed key
+type ValueChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// DiffSnapshots compares snapshots. Values are compared as JSON, so a snapshot read back from JSON
+// equals the one it was written from.
+func DiffSnapshots(previous, current map[string]interface{}) SnapshotDiff {
+	diff := SnapshotDiff{Changed: make(map[string]ValueChange)}
+	for key, value := range current {
+		old, ok := previous[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+		oldJSON, _ := json.Marshal(old)
+		newJSON, _ := json.Marshal(value)
+		if string(oldJSON) != string(newJSON) {
+			diff.Changed[key] = ValueChange{Old: old, New: value}
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	return diff
+}
+
+// Empty reports whether the snapshots are equal
+func (diff SnapshotDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// Summary describes the diff in a line, e.g. "1 changed: storage.time-source; 2 added: ..."
+func (diff SnapshotDiff) Summary() string {
+	if diff.Empty() {
+		return "no changes"
+	}
+	changed := make([]string, 0, len(diff.Changed))
+	for key := range diff.Changed {
+		changed = append(changed, key)
+	}
+	slices.Sort(changed)
+
+	var parts []string
+	for _, kind := range []struct {
+		name string
+		keys []string
+	}{{"changed", changed}, {"added", diff.Added}, {"removed", diff.Removed}} {
+		if len(kind.keys) == 0 {
+			continue
+		}
+		keys := kind.keys
+		more := ""
+		if len(keys) > maxSummaryKeys {
+			keys, more = keys[:maxSummaryKeys], fmt.Sprintf(" and %d more", len(kind.keys)-maxSummaryKeys)
+		}
+		parts = append(parts, fmt.Sprintf("%d %s: %s%s", len(kind.keys), kind.name, strings.Join(keys, ", "), more))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables overriding configuration keys
+const envPrefix = "ELMON"
+
+// applyEnvOverrides overrides configuration values with ELMON_* environment variables.
+// Variable names are built from the key path in upper case with '-' and '.' replaced by '_'
+// and list elements addressed by index, e.g. ELMON_LOG_LEVEL or ELMON_DB_SERVERS_0_PASSWORD.
+// List elements and map entries can be overridden only if they exist in configuration files.
+func applyEnvOverrides(settings map[string]interface{}) error {
+	return applyStructEnv(settings, reflect.TypeOf(AppConfig{}), envPrefix)
+}
+
+// applyStructEnv walks configuration struct fields and sets values found in environment
+func applyStructEnv(settings map[string]interface{}, t reflect.Type, prefix string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		// Squashed structs share keys of the parent
+		if key == ",squash" {
+			if err := applyStructEnv(settings, field.Type, prefix); err != nil {
+				return err
+			}
+			continue
+		}
+		// Fields without tag are populated at runtime
+		if key == "" || key == "-" {
+			continue
+		}
+		envName := prefix + "_" + envKey(key)
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case fieldType == reflect.TypeOf(Duration{}) || fieldType.Kind() != reflect.Struct &&
+			fieldType.Kind() != reflect.Slice && fieldType.Kind() != reflect.Map:
+			if err := setEnvValue(settings, key, envName, fieldType); err != nil {
+				return err
+			}
+
+		case fieldType.Kind() == reflect.Struct:
+			child, ok := settings[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+			}
+			if err := applyStructEnv(child, fieldType, envName); err != nil {
+				return err
+			}
+			// Sections missing in files are created only when overridden
+			if len(child) > 0 {
+				settings[key] = child
+			}
+
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+			items, _ := settings[key].([]interface{})
+			for index, item := range items {
+				child, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if err := applyStructEnv(child, fieldType.Elem(), envName+"_"+strconv.Itoa(index)); err != nil {
+					return err
+				}
+			}
+
+		case fieldType.Kind() == reflect.Slice:
+			// Lists of scalars are given as comma separated values
+			if value, ok := os.LookupEnv(envName); ok {
+				var items []interface{}
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						items = append(items, item)
+					}
+				}
+				settings[key] = items
+			}
+
+		case fieldType.Kind() == reflect.Map:
+			entries, _ := settings[key].(map[string]interface{})
+			for entryKey := range entries {
+				if value, ok := os.LookupEnv(envName + "_" + envKey(entryKey)); ok {
+					entries[entryKey] = value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setEnvValue converts environment variable value to the field kind and stores it in settings
+func setEnvValue(settings map[string]interface{}, key string, envName string, fieldType reflect.Type) error {
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		return nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("environment variable %s: expected integer, got '%s'", envName, value)
+		}
+		settings[key] = n
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("environment variable %s: expected boolean, got '%s'", envName, value)
+		}
+		settings[key] = b
+	default:
+		// Strings and durations, durations are parsed by the decode hook
+		settings[key] = value
+	}
+	return nil
+}
+
+// envKey converts configuration key to environment variable name part
+func envKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the top level directive listing additional configuration files.
+// Values are file paths or glob patterns relative to the including file.
+const includeKey = "include"
+
+// readConfigTree reads configuration file with all files it includes and merges them.
+// Lists (db-servers, metric-groups, servers-metrics-map, ...) are concatenated,
+// maps are merged recursively and scalar values from included files override earlier ones.
+func readConfigTree(configPath string, visited map[string]bool) (map[string]interface{}, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config file path '%s': %w", configPath, err)
+	}
+	if visited[absPath] {
+		return nil, fmt.Errorf("config file '%s' is included recursively", configPath)
+	}
+	visited[absPath] = true
+	defer delete(visited, absPath)
+
+	rawContent, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", configPath, err)
+	}
+
+	// Expand environment variables of format ${VAR}
+	expandedContent := os.ExpandEnv(string(rawContent))
+
+	settings := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(expandedContent), &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %w", configPath, err)
+	}
+
+	patterns, err := includePatterns(settings[includeKey])
+	if err != nil {
+		return nil, fmt.Errorf("config file '%s': %w", configPath, err)
+	}
+	delete(settings, includeKey)
+
+	baseDir := filepath.Dir(configPath)
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s': invalid include pattern '%s': %w", configPath, pattern, err)
+		}
+		// Plain file names must exist, glob patterns may match nothing (empty conf.d)
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("config file '%s': included file '%s' not found", configPath, pattern)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			included, err := readConfigTree(file, visited)
+			if err != nil {
+				return nil, err
+			}
+			mergeSettings(settings, included)
+		}
+	}
+
+	return settings, nil
+}
+
+// includePatterns converts include directive value into a list of patterns
+func includePatterns(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		patterns := make([]string, 0, len(v))
+		for _, item := range v {
+			pattern, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("include must contain file paths, got %v", item)
+			}
+			patterns = append(patterns, pattern)
+		}
+		return patterns, nil
+	default:
+		return nil, fmt.Errorf("include must be a file path or a list of file paths")
+	}
+}
+
+// mergeSettings merges src into dst: lists are appended, maps merged recursively,
+// other values replaced
+func mergeSettings(dst map[string]interface{}, src map[string]interface{}) {
+	for key, srcValue := range src {
+		switch s := srcValue.(type) {
+		case map[string]interface{}:
+			if d, ok := dst[key].(map[string]interface{}); ok {
+				mergeSettings(d, s)
+				continue
+			}
+		case []interface{}:
+			if d, ok := dst[key].([]interface{}); ok {
+				dst[key] = append(d, s...)
+				continue
+			}
+		}
+		dst[key] = srcValue
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Schema returns JSON Schema describing configuration files.
+// Every section is optional, so the same schema applies to the main file and included files.
+func Schema() map[string]interface{} {
+	schema := structSchema(reflect.TypeOf(AppConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "elmon configuration"
+	properties := schema["properties"].(map[string]interface{})
+	properties[includeKey] = map[string]interface{}{
+		"description": "file paths or glob patterns of included configuration files",
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	return schema
+}
+
+// structSchema describes configuration struct, unknown keys are not allowed
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		// Squashed structs share keys of the parent
+		if key == ",squash" {
+			for name, property := range structSchema(field.Type)["properties"].(map[string]interface{}) {
+				properties[name] = property
+			}
+			continue
+		}
+		// Fields without tag are populated at runtime
+		if key == "" || key == "-" {
+			continue
+		}
+		properties[key] = typeSchema(field.Type)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// typeSchema describes a single configuration value
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(Duration{}) {
+		return map[string]interface{}{
+			"type":        "string",
+			"pattern":     `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+			"description": "duration, e.g. 500ms, 30s or 1h30m",
+		}
+	}
+
+	if t == reflect.TypeOf(PostgresVersion("")) {
+		return map[string]interface{}{
+			"type":        []string{"string", "integer"},
+			"description": "PostgreSQL version, e.g. 14, \"9.6\" or \"13.4\", versions with a dot must be quoted",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	default:
+		// interface{} values such as SQL template params accept anything
+		return map[string]interface{}{}
+	}
+}
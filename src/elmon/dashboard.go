@@ -0,0 +1,127 @@
+package main
+
+import (
+	"elmon/config"
+	"elmon/dashboard"
+	"encoding/json"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+)
+
+// generateDashboardCommand writes a Grafana dashboard with a panel for every enabled metric.
+// Only the metrics section is validated, so secrets of other sections are not required.
+func generateDashboardCommand(args []string) {
+	flags := flag.NewFlagSet("generate-dashboard", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	output := flags.String("output", "-", "dashboard file, - for stdout")
+	title := flags.String("title", "Elmon - metrics", "dashboard title")
+	uid := flags.String("uid", "elmon-metrics", "dashboard uid, an imported dashboard with the same uid is replaced")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	appConfig, err := config.Read(opts.configPath)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	if err := appConfig.Metrics.Validate(); err != nil {
+		stdlog.Fatalf("FATAL: invalid metrics configuration: %v", err)
+	}
+
+	dashboardOpts := dashboardOptions(appConfig)
+	dashboardOpts.Title, dashboardOpts.UID = *title, *uid
+	generated, err := json.MarshalIndent(dashboard.Generate(dashboardGroups(appConfig.Metrics), dashboardOpts), "", "  ")
+	if err != nil {
+		stdlog.Fatalf("FATAL: failed to encode dashboard: %v", err)
+	}
+	writeDashboard(os.Stdout, *output, generated)
+}
+
+// prepareDashboardCommand rewrites data source references and variables of an existing dashboard,
+// so dashboards exported from one Grafana can be imported for any metrics DB
+func prepareDashboardCommand(args []string) {
+	flags := flag.NewFlagSet("prepare-dashboard", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	input := flags.String("input", "", "dashboard file exported from Grafana (required)")
+	output := flags.String("output", "-", "prepared dashboard file, - for stdout")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "--input is required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	appConfig, err := config.Read(opts.configPath)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	raw, err := os.ReadFile(*input)
+	if err != nil {
+		stdlog.Fatalf("FATAL: failed to read dashboard: %v", err)
+	}
+	prepared, err := dashboard.Prepare(raw, dashboardOptions(appConfig))
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	writeDashboard(os.Stdout, *output, prepared)
+}
+
+// dashboardOptions takes the data source input of dashboards from the grafana section
+func dashboardOptions(appConfig *config.AppConfig) dashboard.Options {
+	var opts dashboard.Options
+	if appConfig.Grafana.Dashboard != nil {
+		opts.Input = appConfig.Grafana.Dashboard.Input
+	}
+	if appConfig.Grafana.DataSource != nil {
+		opts.DataSourceLabel = appConfig.Grafana.DataSource.Name
+	}
+	return opts
+}
+
+// writeDashboard writes a dashboard to the output file or to stdout for "-"
+func writeDashboard(stdout *os.File, output string, content []byte) {
+	content = append(content, '\n')
+	if output == "-" {
+		if _, err := stdout.Write(content); err != nil {
+			stdlog.Fatalf("FATAL: failed to write dashboard: %v", err)
+		}
+		return
+	}
+	if err := os.WriteFile(output, content, 0o644); err != nil {
+		stdlog.Fatalf("FATAL: failed to write dashboard file: %v", err)
+	}
+}
+
+// dashboardGroups converts enabled metric groups and metrics to dashboard definitions
+func dashboardGroups(metrics config.MetricsConfig) []dashboard.Group {
+	var groups []dashboard.Group
+	for _, group := range metrics.MetricGroups {
+		if !group.IsEnabled() {
+			continue
+		}
+		dashboardGroup := dashboard.Group{Name: group.Name, Description: group.Description}
+		for _, metric := range group.Metrics {
+			// Values of bundle metrics are stored as the metrics they are split into
+			if !metric.IsEnabled() || len(metric.Split) > 0 {
+				continue
+			}
+			dashboardGroup.Metrics = append(dashboardGroup.Metrics, dashboard.Metric{
+				Name:        metric.Name,
+				Description: metric.Description,
+				ValueType:   metric.ValueType,
+				Unit:        metric.Unit,
+				Kind:        metric.Kind,
+				Scope:       metric.Scope,
+				StorageMode: metric.StorageMode,
+			})
+		}
+		groups = append(groups, dashboardGroup)
+	}
+	return groups
+}
@@ -0,0 +1,321 @@
+// Package dashboard generates Grafana dashboards from metric definitions, so every configured
+// metric can be viewed without building panels by hand
+package dashboard
+
+import "strings"
+
+// Options defines the generated dashboard
+type Options struct {
+	Title           string // default: Elmon - metrics
+	UID             string // default: elmon-metrics
+	Input           string // data source input variable asked for on import, default: DS_ELMON_METRICS
+	DataSourceLabel string // label of the input, default: elmon_metrics
+}
+
+// Group is a metric group, shown as a dashboard row
+type Group struct {
+	Name        string
+	Description string
+	Metrics     []Metric
+}
+
+// Metric is a metric definition, shown as a panel
+type Metric struct {
+	Name        string
+	Description string
+	ValueType   string // int, int64, float, string, bool or table
+	Unit        string // e.g. percent, seconds, bytes or a Grafana unit id
+	Kind        string // gauge or counter
+	Scope       string // server or database
+	StorageMode string // timeseries or latest
+}
+
+// Dashboard is a Grafana dashboard in the import format
+type Dashboard struct {
+	Inputs        []Input                `json:"__inputs"`
+	Requires      []Requirement          `json:"__requires"`
+	Annotations   map[string]interface{} `json:"annotations"`
+	Description   string                 `json:"description"`
+	Editable      bool                   `json:"editable"`
+	GraphTooltip  int                    `json:"graphTooltip"`
+	ID            *int                   `json:"id"`
+	Links         []interface{}          `json:"links"`
+	Panels        []*Panel               `json:"panels"`
+	Refresh       string                 `json:"refresh"`
+	SchemaVersion int                    `json:"schemaVersion"`
+	Tags          []string               `json:"tags"`
+	Templating    Templating             `json:"templating"`
+	Time          TimeRange              `json:"time"`
+	Timezone      string                 `json:"timezone"`
+	Title         string                 `json:"title"`
+	UID           string                 `json:"uid"`
+	Version       int                    `json:"version"`
+}
+
+// Input is a value asked for when the dashboard is imported
+type Input struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	PluginID   string `json:"pluginId"`
+	PluginName string `json:"pluginName"`
+}
+
+// Requirement is a plugin the dashboard depends on
+type Requirement struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Templating holds dashboard variables
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard variable filled by a query
+type Variable struct {
+	Name        string                 `json:"name"`
+	Label       string                 `json:"label,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type"`
+	DataSource  *DataSource            `json:"datasource,omitempty"`
+	Definition  string                 `json:"definition,omitempty"`
+	Query       string                 `json:"query"`
+	Multi       bool                   `json:"multi"`
+	IncludeAll  bool                   `json:"includeAll"`
+	Refresh     int                    `json:"refresh"` // 1 on dashboard load, 2 on time range change
+	Current     map[string]interface{} `json:"current"`
+	Options     []interface{}          `json:"options"`
+}
+
+// TimeRange is the default time range of the dashboard
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DataSource references a data source, by the input variable in generated dashboards
+type DataSource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// Panel is a dashboard panel or a row
+type Panel struct {
+	ID              int                      `json:"id"`
+	Type            string                   `json:"type"`
+	Title           string                   `json:"title"`
+	Description     string                   `json:"description,omitempty"`
+	GridPos         GridPos                  `json:"gridPos"`
+	Collapsed       *bool                    `json:"collapsed,omitempty"`
+	Panels          []*Panel                 `json:"panels,omitempty"`
+	DataSource      *DataSource              `json:"datasource,omitempty"`
+	FieldConfig     map[string]interface{}   `json:"fieldConfig,omitempty"`
+	Options         map[string]interface{}   `json:"options,omitempty"`
+	Transformations []map[string]interface{} `json:"transformations,omitempty"`
+	Repeat          string                   `json:"repeat,omitempty"`
+	RepeatDirection string                   `json:"repeatDirection,omitempty"`
+	Targets         []Target                 `json:"targets,omitempty"`
+}
+
+// GridPos places a panel on the 24 column dashboard grid
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Target is a raw SQL query of a panel
+type Target struct {
+	RefID      string      `json:"refId"`
+	DataSource *DataSource `json:"datasource"`
+	EditorMode string      `json:"editorMode"`
+	Format     string      `json:"format"` // time_series or table
+	RawQuery   bool        `json:"rawQuery"`
+	RawSQL     string      `json:"rawSql"`
+}
+
+const (
+	dataSourceType = "grafana-postgresql-datasource"
+	gridWidth      = 24
+)
+
+// Generate builds a dashboard with a row per group and a panel per metric. Panels repeat
+// for every server selected in the $server variable.
+func Generate(groups []Group, opts Options) *Dashboard {
+	opts.setDefaults()
+	dataSource := opts.dataSource()
+
+	layout := &layout{}
+	panelTypes := make(map[string]bool)
+	for _, group := range groups {
+		if len(group.Metrics) == 0 {
+			continue
+		}
+		layout.addRow(group.Name, group.Description)
+		for _, metric := range group.Metrics {
+			panel := newPanel(metric, dataSource)
+			layout.add(panel)
+			panelTypes[panel.Type] = true
+		}
+	}
+
+	requires := []Requirement{
+		{Type: "grafana", ID: "grafana", Name: "Grafana", Version: ""},
+		{Type: "datasource", ID: dataSourceType, Name: "PostgreSQL", Version: ""},
+	}
+	for _, panelType := range []string{"stat", "state-timeline", "table", "timeseries"} {
+		if panelTypes[panelType] {
+			requires = append(requires, Requirement{Type: "panel", ID: panelType, Name: panelName(panelType)})
+		}
+	}
+
+	return &Dashboard{
+		Inputs:   []Input{opts.input()},
+		Requires: requires,
+		Annotations: map[string]interface{}{"list": []interface{}{map[string]interface{}{
+			"builtIn":    1,
+			"datasource": map[string]string{"type": "grafana", "uid": "-- Grafana --"},
+			"enable":     true,
+			"hide":       true,
+			"iconColor":  "rgba(0, 211, 255, 1)",
+			"name":       "Annotations & Alerts",
+			"type":       "dashboard",
+		}}},
+		Description:   "Generated by elmon from metric definitions",
+		Editable:      true,
+		GraphTooltip:  1,
+		Links:         []interface{}{},
+		Panels:        layout.panels,
+		Refresh:       "1m",
+		SchemaVersion: 42,
+		Tags:          []string{"elmon", "generated"},
+		Templating:    Templating{List: variables(dataSource)},
+		Time:          TimeRange{From: "now-24h", To: "now"},
+		Timezone:      "browser",
+		Title:         opts.Title,
+		UID:           opts.UID,
+		Version:       1,
+	}
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Title == "" {
+		opts.Title = "Elmon - metrics"
+	}
+	if opts.UID == "" {
+		opts.UID = "elmon-metrics"
+	}
+	if opts.Input == "" {
+		opts.Input = "DS_ELMON_METRICS"
+	}
+	if opts.DataSourceLabel == "" {
+		opts.DataSourceLabel = "elmon_metrics"
+	}
+}
+
+// dataSource references the metrics DB through the input variable, replaced by Grafana on import
+func (opts *Options) dataSource() *DataSource {
+	return &DataSource{Type: dataSourceType, UID: "${" + opts.Input + "}"}
+}
+
+func (opts *Options) input() Input {
+	return Input{
+		Name:       opts.Input,
+		Label:      opts.DataSourceLabel,
+		Type:       "datasource",
+		PluginID:   dataSourceType,
+		PluginName: "PostgreSQL",
+	}
+}
+
+// Variable queries read the server table, so the dashboard serves every monitored server
+const (
+	environmentQuery = "select distinct environment_name from server where is_active order by 1"
+	serverQuery      = "select server_id as __value, name as __text from server where is_active and environment_name in ($environment) order by name"
+)
+
+// variables returns $environment and $server variables, servers are filtered by the selected environments
+func variables(dataSource *DataSource) []Variable {
+	return []Variable{
+		{
+			Name:        "environment",
+			Description: "Environment of monitored servers",
+			Type:        "query",
+			DataSource:  dataSource,
+			Definition:  environmentQuery,
+			Query:       environmentQuery,
+			Multi:       true,
+			IncludeAll:  true,
+			Refresh:     1,
+			Current:     map[string]interface{}{},
+			Options:     []interface{}{},
+		},
+		{
+			Name:        "server",
+			Description: "Monitored server",
+			Type:        "query",
+			DataSource:  dataSource,
+			Definition:  serverQuery,
+			Query:       serverQuery,
+			Multi:       true,
+			IncludeAll:  true,
+			Refresh:     1,
+			Current:     map[string]interface{}{},
+			Options:     []interface{}{},
+		},
+	}
+}
+
+// panelName returns the display name of a panel plugin
+func panelName(panelType string) string {
+	switch panelType {
+	case "state-timeline":
+		return "State timeline"
+	case "timeseries":
+		return "Time series"
+	default:
+		return strings.ToUpper(panelType[:1]) + panelType[1:]
+	}
+}
+
+// layout places panels left to right, wrapping to the next line when a panel doesn't fit
+type layout struct {
+	panels []*Panel
+	x, y   int
+	height int // of the current line
+}
+
+func (l *layout) addRow(title, description string) {
+	l.newLine()
+	collapsed := false
+	l.panels = append(l.panels, &Panel{
+		ID:          len(l.panels) + 1,
+		Type:        "row",
+		Title:       title,
+		Description: description,
+		GridPos:     GridPos{H: 1, W: gridWidth, Y: l.y},
+		Collapsed:   &collapsed,
+		Panels:      []*Panel{},
+	})
+	l.y++
+}
+
+func (l *layout) add(panel *Panel) {
+	if l.x+panel.GridPos.W > gridWidth {
+		l.newLine()
+	}
+	panel.ID = len(l.panels) + 1
+	panel.GridPos.X, panel.GridPos.Y = l.x, l.y
+	l.panels = append(l.panels, panel)
+	l.x += panel.GridPos.W
+	l.height = max(l.height, panel.GridPos.H)
+}
+
+func (l *layout) newLine() {
+	l.y += l.height
+	l.x, l.height = 0, 0
+}
@@ -0,0 +1,230 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+)
+
+// units maps units of metric definitions to Grafana unit ids, other units are passed as written
+var units = map[string]string{
+	"percent":      "percent",
+	"percentage":   "percent",
+	"seconds":      "s",
+	"milliseconds": "ms",
+	"ms":           "ms",
+	"bytes":        "bytes",
+	"table":        "",
+}
+
+// newPanel builds the panel of a metric: numbers are drawn as time series (counters as their
+// per second rate), booleans as a state timeline, strings and tables as a table of the latest value.
+// Metrics with storage-mode latest are read from metric_value_latest.
+func newPanel(metric Metric, dataSource *DataSource) *Panel {
+	panel := &Panel{
+		Title:           metric.Name + " - $server",
+		Description:     metric.Description,
+		DataSource:      dataSource,
+		Repeat:          "server",
+		RepeatDirection: "v",
+	}
+	unit, ok := units[metric.Unit]
+	if !ok {
+		unit = metric.Unit
+	}
+	perDatabase := metric.Scope == "database"
+	latest := metric.StorageMode == "latest"
+
+	switch metric.ValueType {
+	case "int", "int64", "float":
+		key := "value"
+		if metric.Kind == "counter" {
+			key = "value_rate"
+			panel.Title = metric.Name + " per second - $server"
+			unit = rateUnit(unit)
+		}
+		if latest {
+			panel.Type = "stat"
+			panel.GridPos = GridPos{H: 4, W: 6}
+			panel.Options = statOptions(perDatabase)
+			panel.Targets = []Target{newTarget(dataSource, "table", latestQuery(metric.Name, perDatabase,
+				fmt.Sprintf("(mv.metric_value ->> '%s')::float8 as value", key)))}
+		} else {
+			panel.Type = "timeseries"
+			panel.GridPos = GridPos{H: 8, W: 12}
+			panel.Options = map[string]interface{}{
+				"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": perDatabase},
+				"tooltip": map[string]interface{}{"mode": "multi", "sort": "desc"},
+			}
+			panel.Targets = []Target{newTarget(dataSource, "time_series", seriesQuery(metric.Name, perDatabase,
+				fmt.Sprintf("(mv.metric_value ->> '%s')::float8 as value", key)))}
+		}
+		panel.FieldConfig = fieldConfig(unit, nil)
+
+	case "bool":
+		mappings := []interface{}{map[string]interface{}{
+			"type": "value",
+			"options": map[string]interface{}{
+				"0": map[string]interface{}{"text": "false", "color": "red", "index": 0},
+				"1": map[string]interface{}{"text": "true", "color": "green", "index": 1},
+			},
+		}}
+		column := "(mv.metric_value ->> 'value')::bool::int as value"
+		if latest {
+			panel.Type = "stat"
+			panel.GridPos = GridPos{H: 4, W: 6}
+			panel.Options = statOptions(perDatabase)
+			panel.Targets = []Target{newTarget(dataSource, "table", latestQuery(metric.Name, perDatabase, column))}
+		} else {
+			panel.Type = "state-timeline"
+			panel.GridPos = GridPos{H: 4, W: 12}
+			panel.Options = map[string]interface{}{"showValue": "never", "mergeValues": true}
+			panel.Targets = []Target{newTarget(dataSource, "time_series", seriesQuery(metric.Name, perDatabase, column))}
+		}
+		panel.FieldConfig = fieldConfig("", mappings)
+
+	case "string":
+		panel.Type = "table"
+		panel.GridPos = GridPos{H: 6, W: 12}
+		panel.Options = map[string]interface{}{"cellHeight": "sm", "showHeader": true}
+		panel.FieldConfig = fieldConfig(unit, nil)
+		panel.Targets = []Target{newTarget(dataSource, "table", lastValueQuery(metric.Name, perDatabase, latest,
+			"mv.metric_value ->> 'value' as value"))}
+
+	default:
+		// Tables are objects or arrays of objects, every object becomes a row split into columns
+		panel.Type = "table"
+		panel.GridPos = GridPos{H: 8, W: gridWidth}
+		panel.Options = map[string]interface{}{"cellHeight": "sm", "showHeader": true}
+		panel.FieldConfig = fieldConfig(unit, nil)
+		panel.Transformations = []map[string]interface{}{
+			{"id": "extractFields", "options": map[string]interface{}{"source": "item", "format": "json"}},
+			{"id": "organize", "options": map[string]interface{}{"excludeByName": map[string]bool{"item": true}}},
+		}
+		panel.Targets = []Target{newTarget(dataSource, "table", tableQuery(metric.Name, perDatabase, latest))}
+	}
+	return panel
+}
+
+// rateUnit returns the unit of the per second rate of a counter
+func rateUnit(unit string) string {
+	if unit == "bytes" {
+		return "Bps"
+	}
+	return "ops"
+}
+
+func newTarget(dataSource *DataSource, format string, query string) Target {
+	return Target{
+		RefID:      "A",
+		DataSource: dataSource,
+		EditorMode: "code",
+		Format:     format,
+		RawQuery:   true,
+		RawSQL:     query,
+	}
+}
+
+func fieldConfig(unit string, mappings []interface{}) map[string]interface{} {
+	if mappings == nil {
+		mappings = []interface{}{}
+	}
+	defaults := map[string]interface{}{
+		"color":    map[string]interface{}{"mode": "palette-classic"},
+		"mappings": mappings,
+	}
+	if unit != "" {
+		defaults["unit"] = unit
+	}
+	return map[string]interface{}{"defaults": defaults, "overrides": []interface{}{}}
+}
+
+// statOptions shows the last value, one per database for per-database metrics
+func statOptions(perDatabase bool) map[string]interface{} {
+	return map[string]interface{}{
+		"colorMode":   "none",
+		"graphMode":   "none",
+		"justifyMode": "auto",
+		"textMode":    "auto",
+		"reduceOptions": map[string]interface{}{
+			"calcs":  []string{"lastNotNull"},
+			"fields": "",
+			"values": perDatabase,
+		},
+	}
+}
+
+// seriesQuery selects values of the metric in the dashboard time range,
+// series of per-database metrics are named by the database
+func seriesQuery(metricName string, perDatabase bool, column string) string {
+	series := ""
+	if perDatabase {
+		series = ", mv.database_name as metric"
+	}
+	return fmt.Sprintf(`select mv."time" as time%s, %s
+from metric_value mv
+inner join metric m on m.metric_id = mv.metric_id
+where m.metric_name = %s
+  and m.is_active
+  and mv.server_id = $server
+  and $__timeFilter(mv."time")
+order by time`, series, column, quote(metricName))
+}
+
+// latestQuery selects the current value of a metric with storage-mode latest
+func latestQuery(metricName string, perDatabase bool, column string) string {
+	database := ""
+	if perDatabase {
+		database = "mv.database_name, "
+	}
+	return fmt.Sprintf(`select %s%s
+from metric_value_latest mv
+inner join metric m on m.metric_id = mv.metric_id
+where m.metric_name = %s
+  and m.is_active
+  and mv.server_id = $server
+order by mv.database_name`, database, column, quote(metricName))
+}
+
+// lastValueQuery selects the last value of every database in the dashboard time range
+func lastValueQuery(metricName string, perDatabase bool, latest bool, column string) string {
+	database := ""
+	if perDatabase {
+		database = "mv.database_name, "
+	}
+	if latest {
+		return fmt.Sprintf(`select mv."time" as time, %s%s
+from metric_value_latest mv
+inner join metric m on m.metric_id = mv.metric_id
+where m.metric_name = %s
+  and m.is_active
+  and mv.server_id = $server
+order by mv.database_name`, database, column, quote(metricName))
+	}
+	return fmt.Sprintf(`select distinct on (mv.database_name) mv."time" as time, %s%s
+from metric_value mv
+inner join metric m on m.metric_id = mv.metric_id
+where m.metric_name = %s
+  and m.is_active
+  and mv.server_id = $server
+  and $__timeFilter(mv."time")
+order by mv.database_name, mv."time" desc`, database, column, quote(metricName))
+}
+
+// tableQuery returns rows of the last value of every database, an object is a single row
+func tableQuery(metricName string, perDatabase bool, latest bool) string {
+	database := ""
+	if perDatabase {
+		database = "database_name, "
+	}
+	return fmt.Sprintf(`with last_value as (
+%s
+)
+select %sitem
+from last_value, jsonb_array_elements(case jsonb_typeof(value) when 'array' then value else jsonb_build_array(value) end) item`,
+		lastValueQuery(metricName, true, latest, "mv.metric_value as value"), database)
+}
+
+// quote returns a SQL string literal
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
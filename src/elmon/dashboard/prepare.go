@@ -0,0 +1,132 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// postgresTypes are plugin ids of the PostgreSQL data source, postgres before Grafana 10
+var postgresTypes = map[string]bool{dataSourceType: true, "postgres": true}
+
+// Prepare makes a dashboard exported from Grafana or built by hand importable for any metrics DB:
+// references to PostgreSQL data sources, including legacy references by name, are replaced with
+// the input variable, the input replaces other data source inputs, and $environment and $server
+// variables reading the server table are set. Existing variables of these names are replaced,
+// $server holds server_id as in generated dashboards. Title and UID of opts are not used.
+func Prepare(raw []byte, opts Options) ([]byte, error) {
+	opts.setDefaults()
+
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(raw, &dashboard); err != nil {
+		return nil, fmt.Errorf("failed to parse dashboard: %w", err)
+	}
+	if _, ok := dashboard["panels"]; !ok {
+		return nil, fmt.Errorf("dashboard has no panels, an API response must be unwrapped from 'dashboard' first")
+	}
+
+	dataSource, err := toJSONValue(opts.dataSource())
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range dashboard {
+		if key != "__inputs" && key != "__requires" {
+			rewriteDataSources(value, dataSource)
+		}
+	}
+
+	input, err := toJSONValue(opts.input())
+	if err != nil {
+		return nil, err
+	}
+	inputs := []interface{}{input}
+	existing, _ := dashboard["__inputs"].([]interface{})
+	for _, item := range existing {
+		if fields, ok := item.(map[string]interface{}); ok && fields["type"] == "datasource" && postgresTypes[fmt.Sprint(fields["pluginId"])] {
+			continue
+		}
+		inputs = append(inputs, item)
+	}
+	dashboard["__inputs"] = inputs
+	dashboard["id"] = nil
+
+	if err := setVariables(dashboard, opts.dataSource()); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// rewriteDataSources replaces every PostgreSQL data source reference below value
+func rewriteDataSources(value interface{}, dataSource interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			if key == "datasource" && isPostgresReference(child) {
+				typed[key] = dataSource
+				continue
+			}
+			rewriteDataSources(child, dataSource)
+		}
+	case []interface{}:
+		for _, child := range typed {
+			rewriteDataSources(child, dataSource)
+		}
+	}
+}
+
+// isPostgresReference reports whether a datasource field refers to a PostgreSQL data source.
+// Built-in data sources such as "-- Grafana --" and the default data source (null) are kept.
+func isPostgresReference(reference interface{}) bool {
+	switch typed := reference.(type) {
+	case string:
+		return typed != "" && !strings.HasPrefix(typed, "-- ")
+	case map[string]interface{}:
+		pluginType, _ := typed["type"].(string)
+		return postgresTypes[pluginType]
+	}
+	return false
+}
+
+// setVariables replaces or adds $environment and $server, environment goes first
+// because server options depend on it
+func setVariables(dashboard map[string]interface{}, dataSource *DataSource) error {
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	if templating == nil {
+		templating = make(map[string]interface{})
+		dashboard["templating"] = templating
+	}
+	list, _ := templating["list"].([]interface{})
+
+	var added []interface{}
+	for _, variable := range variables(dataSource) {
+		value, err := toJSONValue(variable)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, item := range list {
+			if fields, ok := item.(map[string]interface{}); ok && fields["name"] == variable.Name {
+				list[i] = value
+				replaced = true
+			}
+		}
+		if !replaced {
+			added = append(added, value)
+		}
+	}
+	templating["list"] = append(added, list...)
+	return nil
+}
+
+// toJSONValue converts a typed value to the generic form of decoded JSON
+func toJSONValue(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dashboard value: %w", err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode dashboard value: %w", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"elmon/collector"
+	"elmon/config"
+	"elmon/discovery"
+	"elmon/logger"
+	"elmon/scheduler"
+	"fmt"
+)
+
+// discoveredSource is a discovery source with servers monitored because of it
+type discoveredSource struct {
+	config  config.DiscoverySourceConfig
+	source  discovery.Source
+	servers map[string]discovery.Server // monitored server name -> server as discovered
+}
+
+// startDiscovery adds servers of discovery sources to the collector and schedules their refresh.
+// Returns nil scheduler when no sources are configured.
+func (app *application) startDiscovery(metricCollector *collector.Collector) (*scheduler.TaskScheduler, error) {
+	discoveryConfig := app.config.Discovery
+	if len(discoveryConfig.Sources) == 0 {
+		return nil, nil
+	}
+
+	var sources []*discoveredSource
+	for _, sourceConfig := range discoveryConfig.Sources {
+		source, err := app.newDiscoverySource(sourceConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create discovery source '%s': %w", sourceConfig.Name, err)
+		}
+		sources = append(sources, &discoveredSource{
+			config:  sourceConfig,
+			source:  source,
+			servers: make(map[string]discovery.Server),
+		})
+	}
+
+	log := app.log.WithComponent("discovery")
+	refresh := func(ctx context.Context, _ interface{}) error {
+		for _, source := range sources {
+			app.refreshDiscoveredServers(ctx, log.With("source", source.config.Name), metricCollector, source)
+		}
+		return nil
+	}
+	// Servers are monitored from the start, not after the first interval
+	refresh(context.Background(), nil)
+
+	discoveryScheduler := scheduler.NewTaskScheduler(discoveryConfig.Interval.Duration, 0, 0, refresh, nil, log)
+	if err := discoveryScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return discoveryScheduler, nil
+}
+
+// newDiscoverySource creates the source of the configured type
+func (app *application) newDiscoverySource(sourceConfig config.DiscoverySourceConfig) (discovery.Source, error) {
+	switch sourceConfig.Type {
+	case "sql":
+		return &discovery.SQLSource{DB: app.metricsDB.DB(), Query: sourceConfig.SQL.Query}, nil
+	case "dns-srv":
+		return &discovery.DNSSRVSource{Name: sourceConfig.DNSSRV.Name}, nil
+	case "consul":
+		consul := sourceConfig.Consul
+		return &discovery.ConsulSource{
+			Address:    consul.Address,
+			Service:    consul.Service,
+			Tag:        consul.Tag,
+			Datacenter: consul.Datacenter,
+			Token:      consul.Token,
+		}, nil
+	case "kubernetes":
+		kubernetes := sourceConfig.Kubernetes
+		return discovery.NewInClusterKubernetesSource(kubernetes.Namespace, kubernetes.LabelSelector, kubernetes.Port)
+	default:
+		return nil, fmt.Errorf("discovery source type '%s' is not supported", sourceConfig.Type)
+	}
+}
+
+// refreshDiscoveredServers adds servers which appeared in the source and removes servers which
+// disappeared or changed their address. When the source fails its servers are kept.
+func (app *application) refreshDiscoveredServers(ctx context.Context, log *logger.Logger,
+	metricCollector *collector.Collector, source *discoveredSource) {
+	ctx, cancel := context.WithTimeout(ctx, app.config.Discovery.Timeout.Duration)
+	defer cancel()
+
+	servers, err := source.source.Discover(ctx)
+	if err != nil {
+		log.Error(err, "Server discovery failed, keeping previously discovered servers")
+		return
+	}
+	discovered := make(map[string]discovery.Server)
+	for _, server := range servers {
+		server.Name = source.config.NamePrefix + server.Name
+		discovered[server.Name] = server
+	}
+
+	for name, server := range source.servers {
+		if current, ok := discovered[name]; ok && current == server {
+			continue
+		}
+		if err := app.removeServer(metricCollector, name); err != nil {
+			log.Error(err, "Failed to remove discovered server", "server_name", name)
+		}
+		delete(source.servers, name)
+	}
+
+	for name, server := range discovered {
+		if _, ok := source.servers[name]; ok {
+			continue
+		}
+		if !app.inShard(name) {
+			log.Debug("Discovered server belongs to another shard, skipping", "server_name", name)
+			continue
+		}
+		if app.isMonitored(name) {
+			log.Warn("Discovered server has the name of a monitored server, skipping", "server_name", name)
+			continue
+		}
+		_, err := app.addServer(metricCollector, discoveredServerConfig(source.config, server), config.ServerMetricsMapping{
+			Name:    name,
+			Params:  source.config.Params,
+			Metrics: source.config.Metrics,
+		})
+		if err != nil {
+			// Retried on the next refresh
+			log.Error(err, "Failed to add discovered server", "server_name", name, "host", server.Host, "port", server.Port)
+			continue
+		}
+		source.servers[name] = server
+	}
+}
+
+// discoveredServerConfig completes the server template of the source with the discovered address
+func discoveredServerConfig(sourceConfig config.DiscoverySourceConfig, server discovery.Server) config.DbConnectionConfig {
+	srvCfg := sourceConfig.Server
+	srvCfg.Name = server.Name
+	srvCfg.Host = server.Host
+	if server.Port != 0 {
+		srvCfg.Port = server.Port
+	}
+	if server.DbName != "" {
+		srvCfg.DbName = server.DbName
+	}
+	if server.Environment != "" {
+		srvCfg.Environment = server.Environment
+	}
+	return srvCfg
+}
@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ConsulSource lists registered instances of a Consul service, failing health checks included:
+// an unhealthy database is still monitored. An instance is named after its service ID, "dbname" and "environment" service meta are used when set.
+type ConsulSource struct {
+	Address    string // e.g. http://127.0.0.1:8500
+	Service    string
+	Tag        string
+	Datacenter string
+	Token      string
+	Client     *http.Client // nil - http.DefaultClient
+}
+
+// consulServiceEntry is an item of Consul GET /v1/health/service/<service> response
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+}
+
+func (s *ConsulSource) Discover(ctx context.Context) ([]Server, error) {
+	query := url.Values{}
+	if s.Tag != "" {
+		query.Set("tag", s.Tag)
+	}
+	if s.Datacenter != "" {
+		query.Set("dc", s.Datacenter)
+	}
+	endpoint := strings.TrimSuffix(s.Address, "/") + "/v1/health/service/" + url.PathEscape(s.Service) + "?" + query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Consul request: %w", err)
+	}
+	if s.Token != "" {
+		request.Header.Set("X-Consul-Token", s.Token)
+	}
+	var entries []consulServiceEntry
+	if err := getJSON(s.Client, request, &entries); err != nil {
+		return nil, fmt.Errorf("failed to list Consul service '%s': %w", s.Service, err)
+	}
+
+	servers := make([]Server, 0, len(entries))
+	for _, entry := range entries {
+		// Service address is empty when the service uses the node address
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		servers = append(servers, Server{
+			Name:        entry.Service.ID,
+			Host:        host,
+			Port:        entry.Service.Port,
+			DbName:      entry.Service.Meta["dbname"],
+			Environment: entry.Service.Meta["environment"],
+		})
+	}
+	return servers, nil
+}
+
+// getJSON executes the request and decodes JSON response with 200 status
+func getJSON(client *http.Client, request *http.Request, target interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 16<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
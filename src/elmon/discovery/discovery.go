@@ -0,0 +1,35 @@
+// Package discovery lists monitored servers from external inventories
+package discovery
+
+import (
+	"context"
+	"strconv"
+)
+
+// Server is a database server found by a source
+type Server struct {
+	Name        string
+	Host        string
+	Port        int    // 0 - port of the server template
+	DbName      string // empty - database of the server template
+	Environment string // empty - environment of the server template
+}
+
+// Source lists servers of an inventory. An error means the list is unknown, not empty.
+type Source interface {
+	Discover(ctx context.Context) ([]Server, error)
+}
+
+// uniqueNames appends port to names of servers sharing a name with another server
+func uniqueNames(servers []Server) []Server {
+	counts := make(map[string]int)
+	for _, server := range servers {
+		counts[server.Name]++
+	}
+	for i := range servers {
+		if counts[servers[i].Name] > 1 {
+			servers[i].Name += ":" + strconv.Itoa(servers[i].Port)
+		}
+	}
+	return servers
+}
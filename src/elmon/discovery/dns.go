@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNSSRVSource lists servers from SRV records, e.g. _postgresql._tcp.db.example.com.
+// A server is named after its target host, port is appended when hosts repeat.
+type DNSSRVSource struct {
+	Name     string
+	Resolver *net.Resolver // nil - default resolver
+}
+
+func (s *DNSSRVSource) Discover(ctx context.Context) ([]Server, error) {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV records of '%s': %w", s.Name, err)
+	}
+
+	servers := make([]Server, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		servers = append(servers, Server{Name: host, Host: host, Port: int(record.Port)})
+	}
+	return uniqueNames(servers), nil
+}
@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Service account files mounted into every pod
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountToken     = serviceAccountDir + "/token"
+	serviceAccountCA        = serviceAccountDir + "/ca.crt"
+	serviceAccountNamespace = serviceAccountDir + "/namespace"
+)
+
+// KubernetesSource lists running pods matching a label selector. It talks to the API server
+// with the service account of the collector pod, which needs permission to list pods.
+// A server is named after its pod and addressed by the pod IP.
+type KubernetesSource struct {
+	APIServer     string // e.g. https://10.0.0.1:443
+	Namespace     string
+	LabelSelector string
+	Port          int
+	TokenFile     string // re-read on every request, projected tokens are rotated
+	Client        *http.Client
+}
+
+// kubernetesPodList is Kubernetes GET /api/v1/namespaces/<namespace>/pods response
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// NewInClusterKubernetesSource creates a source using the API server and service account of the pod.
+// Empty namespace means the namespace of the pod.
+func NewInClusterKubernetesSource(namespace, labelSelector string, port int) (*KubernetesSource, error) {
+	host, apiPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || apiPort == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST is not set")
+	}
+	if namespace == "" {
+		content, err := os.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", serviceAccountCA)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &KubernetesSource{
+		APIServer:     "https://" + net.JoinHostPort(host, apiPort),
+		Namespace:     namespace,
+		LabelSelector: labelSelector,
+		Port:          port,
+		TokenFile:     serviceAccountToken,
+		Client:        &http.Client{Transport: transport},
+	}, nil
+}
+
+func (s *KubernetesSource) Discover(ctx context.Context) ([]Server, error) {
+	endpoint := strings.TrimSuffix(s.APIServer, "/") + "/api/v1/namespaces/" + url.PathEscape(s.Namespace) +
+		"/pods?" + url.Values{"labelSelector": {s.LabelSelector}}.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes request: %w", err)
+	}
+	if s.TokenFile != "" {
+		token, err := os.ReadFile(s.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	var pods kubernetesPodList
+	if err := getJSON(s.Client, request, &pods); err != nil {
+		return nil, fmt.Errorf("failed to list pods '%s' in namespace '%s': %w", s.LabelSelector, s.Namespace, err)
+	}
+
+	var servers []Server
+	for _, pod := range pods.Items {
+		// Readiness is not required, an unhealthy database is still monitored
+		if pod.Status.Phase != "Running" || pod.Status.PodIP == "" {
+			continue
+		}
+		servers = append(servers, Server{Name: pod.Metadata.Name, Host: pod.Status.PodIP, Port: s.Port})
+	}
+	return servers, nil
+}
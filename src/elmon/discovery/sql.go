@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SQLSource lists servers with a query, typically on the metrics database. Columns are matched by name:
+// name and host are required, port, dbname and environment are optional.
+type SQLSource struct {
+	DB    *sql.DB
+	Query string
+}
+
+func (s *SQLSource) Discover(ctx context.Context) ([]Server, error) {
+	rows, err := s.DB.QueryContext(ctx, s.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query servers: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query columns: %w", err)
+	}
+	index := make(map[string]int)
+	for i, column := range columns {
+		index[strings.ToLower(column)] = i
+	}
+	for _, required := range []string{"name", "host"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("query must return '%s' column", required)
+		}
+	}
+
+	var servers []Server
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	column := func(name string) string {
+		if i, ok := index[name]; ok {
+			return strings.TrimSpace(values[i].String)
+		}
+		return ""
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to scan servers: %w", err)
+		}
+		server := Server{
+			Name:        column("name"),
+			Host:        column("host"),
+			DbName:      column("dbname"),
+			Environment: column("environment"),
+		}
+		if server.Name == "" || server.Host == "" {
+			return nil, fmt.Errorf("query returned a server without name or host")
+		}
+		if port := column("port"); port != "" {
+			server.Port, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port of server '%s': %w", server.Name, err)
+			}
+		}
+		servers = append(servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read servers: %w", err)
+	}
+	return servers, nil
+}
@@ -7,5 +7,5 @@ if [ "$ELMON_DEBUG" = "1" ]; then
     echo "Files in /app:" && ls -la /app/
     tail -f /dev/null
 else
-    exec ./elmon
+    exec ./elmon run --bootstrap
 fi
\ No newline at end of file
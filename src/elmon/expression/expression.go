@@ -0,0 +1,368 @@
+// Package expression evaluates arithmetic over recently collected metric values,
+// e.g. "blks_hit / (blks_hit + blks_read) * 100"
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrNoValue is returned by Evaluate when a referenced value is unknown or the result is undefined,
+// e.g. the referenced metric was not collected yet or a ratio has zero denominator
+var ErrNoValue = errors.New("no value")
+
+// Reference is a metric value used by an expression. "connection_count.active" refers to
+// key "active" of metric connection_count, a plain metric name refers to its "value" key.
+type Reference struct {
+	Metric string
+	Path   []string
+}
+
+func (r Reference) String() string {
+	return strings.Join(append([]string{r.Metric}, r.Path...), ".")
+}
+
+// LookupFunc returns current number of the referenced value, ErrNoValue when it is unknown
+type LookupFunc func(ref Reference) (float64, error)
+
+// Expression is a parsed expression
+type Expression struct {
+	text       string
+	root       node
+	references []Reference
+}
+
+// functions callable from expressions with their minimal argument count
+var functions = map[string]int{"abs": 1, "min": 1, "max": 1}
+
+// Parse parses expression text. Supported are numbers, metric references, + - * / operators,
+// parentheses and functions abs(x), min(x, ...) and max(x, ...).
+func Parse(text string) (*Expression, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().position)
+	}
+	return &Expression{text: text, root: root, references: p.references}, nil
+}
+
+// References returns metric values used by the expression in order of appearance
+func (e *Expression) References() []Reference {
+	return e.references
+}
+
+func (e *Expression) String() string {
+	return e.text
+}
+
+// Evaluate computes the expression, lookup supplies referenced values.
+// Division by zero and non-finite results return ErrNoValue.
+func (e *Expression) Evaluate(lookup LookupFunc) (float64, error) {
+	result, err := e.root.evaluate(lookup)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, fmt.Errorf("result is not a finite number: %w", ErrNoValue)
+	}
+	return result, nil
+}
+
+// --- Evaluation ---
+
+type node interface {
+	evaluate(lookup LookupFunc) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) evaluate(LookupFunc) (float64, error) {
+	return float64(n), nil
+}
+
+type referenceNode Reference
+
+func (n referenceNode) evaluate(lookup LookupFunc) (float64, error) {
+	return lookup(Reference(n))
+}
+
+type negateNode struct {
+	operand node
+}
+
+func (n negateNode) evaluate(lookup LookupFunc) (float64, error) {
+	value, err := n.operand.evaluate(lookup)
+	return -value, err
+}
+
+type binaryNode struct {
+	operator    byte
+	left, right node
+}
+
+func (n binaryNode) evaluate(lookup LookupFunc) (float64, error) {
+	left, err := n.left.evaluate(lookup)
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.evaluate(lookup)
+	if err != nil {
+		return 0, err
+	}
+	switch n.operator {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero: %w", ErrNoValue)
+		}
+		return left / right, nil
+	}
+}
+
+type callNode struct {
+	function  string
+	arguments []node
+}
+
+func (n callNode) evaluate(lookup LookupFunc) (float64, error) {
+	values := make([]float64, len(n.arguments))
+	for i, argument := range n.arguments {
+		value, err := argument.evaluate(lookup)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+	switch n.function {
+	case "abs":
+		return math.Abs(values[0]), nil
+	case "min":
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Min(result, value)
+		}
+		return result, nil
+	default: // max
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Max(result, value)
+		}
+		return result, nil
+	}
+}
+
+// --- Parsing ---
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+// tokenize splits text into numbers, names (metric references with dots) and single character operators
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// Exponent, e.g. 1e6
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), position: start + 1})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, text: string(runes[start:i]), position: start + 1})
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, token{kind: tokenOperator, text: string(r), position: i + 1})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i+1)
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, text: "end of expression", position: len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens     []token
+	current    int
+	references []Reference
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.current]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.current]
+	if t.kind != tokenEnd {
+		p.current++
+	}
+	return t
+}
+
+func (p *parser) isOperator(operators string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && strings.Contains(operators, t.text)
+}
+
+// parseSum: product (('+' | '-') product)*
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+-") {
+		operator := p.next().text[0]
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct: unary (('*' | '/') unary)*
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*/") {
+		operator := p.next().text[0]
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary: '-' unary | primary
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary: number | reference | function '(' arguments ')' | '(' sum ')'
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", t.text, t.position)
+		}
+		return numberNode(value), nil
+	case tokenName:
+		if p.isOperator("(") {
+			return p.parseCall(t)
+		}
+		parts := strings.Split(t.text, ".")
+		if slices.Contains(parts, "") {
+			return nil, fmt.Errorf("invalid metric reference '%s' at position %d", t.text, t.position)
+		}
+		ref := Reference{Metric: parts[0], Path: parts[1:]}
+		if len(ref.Path) == 0 {
+			ref.Path = []string{"value"}
+		}
+		p.references = append(p.references, ref)
+		return referenceNode(ref), nil
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOperator(")") {
+				return nil, fmt.Errorf("expected ')' at position %d", p.peek().position)
+			}
+			p.next()
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.position)
+}
+
+// parseCall parses arguments of function name
+func (p *parser) parseCall(name token) (node, error) {
+	minArguments, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s' at position %d", name.text, name.position)
+	}
+	p.next() // (
+	call := callNode{function: name.text}
+	if !p.isOperator(")") {
+		for {
+			argument, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			call.arguments = append(call.arguments, argument)
+			if !p.isOperator(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if !p.isOperator(")") {
+		return nil, fmt.Errorf("expected ')' at position %d", p.peek().position)
+	}
+	p.next()
+	if len(call.arguments) < minArguments || (name.text == "abs" && len(call.arguments) > 1) {
+		return nil, fmt.Errorf("wrong number of arguments of '%s' at position %d", name.text, name.position)
+	}
+	return call, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"elmon/collector"
+	"elmon/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// checkExtensions reports whether the extensions required by the metric of a PostgreSQL task are installed
+// in the database it queries. Servers with create-extensions create missing ones first. A task missing
+// extensions is not scheduled and the reason is stored in collection_status, instead of failing every run.
+func (app *application) checkExtensions(task *collector.MetricTask, required []string) bool {
+	installed, err := app.targetExtensions(task)
+	if err != nil {
+		task.Logger.Warn("Failed to read installed extensions, the metric is collected anyway", "error", err)
+		return true
+	}
+	var missing []string
+	for _, name := range required {
+		if !installed[name] && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 && app.serverConfigs[task.ServerName].CreateExtensions {
+		missing = app.createExtensions(task, installed, missing)
+	}
+	if len(missing) == 0 {
+		return true
+	}
+
+	task.Logger.Warn("Metric requires extensions not installed in the database, skipping", "extensions", missing)
+	if app.metricsDB != nil && task.ServerID != 0 {
+		reason := fmt.Sprintf("extensions not installed: %s", strings.Join(missing, ", "))
+		_ = app.metricsDB.MarkCollectionUnavailable(context.Background(), task.Logger, task.ServerID, task.MetricID,
+			task.DatabaseName, reason)
+	}
+	return false
+}
+
+// targetExtensions returns extensions installed in the database of the task, they are read once per
+// server and database
+func (app *application) targetExtensions(task *collector.MetricTask) (map[string]bool, error) {
+	database := task.DatabaseName
+	if database == "" {
+		database = app.serverConfigs[task.ServerName].DbName
+	}
+	if installed, ok := app.extensions[task.ServerName][database]; ok {
+		return installed, nil
+	}
+
+	installed, err := sql.GetExtensions(task.TargetDB, task.Driver, app.config.Metrics.Global.DefaultQueryTimeout.Duration)
+	if err != nil {
+		return nil, err
+	}
+	if app.extensions[task.ServerName] == nil {
+		app.extensions[task.ServerName] = make(map[string]map[string]bool)
+	}
+	app.extensions[task.ServerName][database] = installed
+	app.log.Debug("Installed extensions read", "server_name", task.ServerName, "database_name", database,
+		"extension_count", len(installed))
+	return installed, nil
+}
+
+// createExtensions creates missing extensions in the database of the task, returns those still missing.
+// Creation usually needs a superuser or the CREATE privilege on the database.
+func (app *application) createExtensions(task *collector.MetricTask, installed map[string]bool,
+	missing []string) []string {
+	var failed []string
+	for _, name := range missing {
+		err := sql.CreateExtension(task.TargetDB, name, app.config.Metrics.Global.DefaultQueryTimeout.Duration)
+		if err != nil {
+			task.Logger.Warn("Failed to create extension required by the metric", "extension", name, "error", err)
+			failed = append(failed, name)
+			continue
+		}
+		installed[name] = true
+		task.Logger.Info("Extension required by the metric created", "extension", name)
+	}
+	return failed
+}
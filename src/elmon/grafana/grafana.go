@@ -0,0 +1,169 @@
+// Package grafana provisions the metrics DB data source and dashboards through the Grafana HTTP API
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dataSourceType is the plugin id of the PostgreSQL data source since Grafana 10
+const dataSourceType = "grafana-postgresql-datasource"
+
+// errNotFound is returned for 404 responses
+var errNotFound = errors.New("not found")
+
+// DataSource is a PostgreSQL data source reading the metrics DB
+type DataSource struct {
+	Name     string
+	URL      string // host:port
+	Database string
+	User     string
+	Password string
+	SSLMode  string
+}
+
+// Dashboard is a dashboard JSON imported with its data source input bound to the data source
+type Dashboard struct {
+	Title     string // replaces the title of the JSON, empty keeps it
+	JSON      []byte
+	Input     string // data source input variable, e.g. DS_ELMON_METRICS
+	Overwrite bool   // replace a dashboard with the same uid
+}
+
+// Client calls the Grafana HTTP API with a service account token
+type Client struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewClient(baseURL string, token string, timeout time.Duration) *Client {
+	return &Client{
+		url:    strings.TrimRight(baseURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// EnsureDataSource creates the data source or updates the one of the same name, returns its uid
+func (c *Client) EnsureDataSource(ctx context.Context, dataSource DataSource) (string, error) {
+	body := map[string]interface{}{
+		"name":     dataSource.Name,
+		"type":     dataSourceType,
+		"access":   "proxy",
+		"url":      dataSource.URL,
+		"user":     dataSource.User,
+		"database": dataSource.Database,
+		"jsonData": map[string]interface{}{
+			"database": dataSource.Database,
+			"sslmode":  dataSource.SSLMode,
+		},
+		"secureJsonData": map[string]interface{}{
+			"password": dataSource.Password,
+		},
+	}
+
+	var existing struct {
+		UID string `json:"uid"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/datasources/name/"+url.PathEscape(dataSource.Name), nil, &existing)
+	switch {
+	case errors.Is(err, errNotFound):
+		var created struct {
+			DataSource struct {
+				UID string `json:"uid"`
+			} `json:"datasource"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/api/datasources", body, &created); err != nil {
+			return "", fmt.Errorf("failed to create data source '%s': %w", dataSource.Name, err)
+		}
+		return created.DataSource.UID, nil
+	case err != nil:
+		return "", fmt.Errorf("failed to look up data source '%s': %w", dataSource.Name, err)
+	}
+
+	body["uid"] = existing.UID
+	if err := c.do(ctx, http.MethodPut, "/api/datasources/uid/"+url.PathEscape(existing.UID), body, nil); err != nil {
+		return "", fmt.Errorf("failed to update data source '%s': %w", dataSource.Name, err)
+	}
+	return existing.UID, nil
+}
+
+// ImportDashboard imports the dashboard with its input bound to the data source of dataSourceUID
+func (c *Client) ImportDashboard(ctx context.Context, dashboard Dashboard, dataSourceUID string) error {
+	var model map[string]interface{}
+	if err := json.Unmarshal(dashboard.JSON, &model); err != nil {
+		return fmt.Errorf("failed to parse dashboard: %w", err)
+	}
+	if dashboard.Title != "" {
+		model["title"] = dashboard.Title
+	}
+	model["id"] = nil
+
+	body := map[string]interface{}{
+		"dashboard": model,
+		"overwrite": dashboard.Overwrite,
+		"inputs": []map[string]interface{}{{
+			"name":     dashboard.Input,
+			"type":     "datasource",
+			"pluginId": dataSourceType,
+			"value":    dataSourceUID,
+		}},
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/dashboards/import", body, nil); err != nil {
+		return fmt.Errorf("failed to import dashboard '%v': %w", model["title"], err)
+	}
+	return nil
+}
+
+// do sends a JSON request and decodes a JSON response into result when it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.token)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("grafana returned %s: %s", response.Status, strings.TrimSpace(string(content)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("invalid grafana response: %w", err)
+	}
+	return nil
+}
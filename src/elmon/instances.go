@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"elmon/api"
+	"elmon/collector"
+	"elmon/logger"
+	"elmon/scheduler"
+	"elmon/sql"
+	"os"
+	"slices"
+	"time"
+)
+
+// instanceRegistry keeps the row of this instance in elmon_instance up to date
+type instanceRegistry struct {
+	app       *application
+	collector *collector.Collector
+	instance  sql.Instance // fields not changing between heartbeats
+	scheduler *scheduler.TaskScheduler
+	log       *logger.Logger
+}
+
+// startInstanceRegistry registers the instance and renews its heartbeat every instances.heartbeat-interval.
+// The leader deletes rows of instances gone for longer than forget-after. Returns nil when the registry
+// is disabled.
+func (app *application) startInstanceRegistry(metricCollector *collector.Collector) (*instanceRegistry, error) {
+	instances := app.config.Instances
+	if instances.HeartbeatInterval.Duration == 0 {
+		return nil, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	registry := &instanceRegistry{
+		app:       app,
+		collector: metricCollector,
+		instance: sql.Instance{
+			Instance:          app.configInstance(),
+			Hostname:          hostname,
+			PID:               os.Getpid(),
+			AppVersion:        version,
+			Shards:            app.config.Sharding.Shards,
+			StartedAt:         time.Now(),
+			HeartbeatInterval: instances.HeartbeatInterval.Duration,
+		},
+		log: app.log.WithComponent("instance-registry"),
+	}
+	if sharding := app.config.Sharding; sharding.Shards > 0 {
+		registry.instance.Shard = &sharding.Shard
+	}
+
+	// A metrics DB outage at start doesn't stop collection, the next heartbeat registers the instance
+	if err := registry.heartbeat(context.Background()); err != nil {
+		registry.log.Error(err, "Failed to register the instance")
+	} else {
+		registry.log.Info("Instance registered", "instance", registry.instance.Instance)
+	}
+	registry.scheduler = scheduler.NewTaskScheduler(instances.HeartbeatInterval.Duration, 0, 0,
+		func(ctx context.Context, _ interface{}) error {
+			err := registry.heartbeat(ctx)
+			if err != nil {
+				registry.log.Error(err, "Instance heartbeat failed")
+			}
+			return err
+		}, nil, registry.log)
+	if err := registry.scheduler.Start(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// heartbeat saves the current state of the instance, the leader forgets instances gone for long
+func (registry *instanceRegistry) heartbeat(ctx context.Context) error {
+	app := registry.app
+	ctx, cancel := context.WithTimeout(ctx, app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+
+	instance := registry.instance
+	instance.Leader = app.isLeader()
+	instance.Collecting = app.elector == nil || app.elector.IsLeader()
+	instance.HeartbeatAt = time.Now()
+	for _, state := range registry.collector.State() {
+		if !slices.Contains(instance.Servers, state.ServerName) {
+			instance.Servers = append(instance.Servers, state.ServerName)
+		}
+	}
+	slices.Sort(instance.Servers)
+
+	db := app.metricsDB.DB()
+	if err := sql.SaveInstance(ctx, db, instance); err != nil {
+		return err
+	}
+	if !instance.Leader {
+		return nil
+	}
+	deleted, err := sql.DeleteInstances(ctx, db, instance.HeartbeatAt.Add(-app.config.Instances.ForgetAfter.Duration))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		registry.log.Info("Instances without heartbeats deleted", "instances", deleted)
+	}
+	return nil
+}
+
+// Stop stops heartbeats and marks the instance stopped
+func (registry *instanceRegistry) Stop() {
+	registry.scheduler.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), registry.app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+	if err := sql.MarkInstanceStopped(ctx, registry.app.metricsDB.DB(), registry.instance.Instance, time.Now()); err != nil {
+		registry.log.Error(err, "Failed to mark the instance stopped")
+	}
+}
+
+// instanceReader reads instances sharing the metrics DB for the API
+type instanceReader struct {
+	storage sql.Storage
+}
+
+// Instances returns registered instances, an instance is alive until it stops or misses three heartbeats
+func (reader instanceReader) Instances(ctx context.Context) ([]api.Instance, error) {
+	stored, err := sql.ReadInstances(ctx, reader.storage.DB())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var instances []api.Instance
+	for _, instance := range stored {
+		instances = append(instances, api.Instance{
+			Instance:          instance.Instance,
+			Hostname:          instance.Hostname,
+			PID:               instance.PID,
+			Version:           instance.AppVersion,
+			Shard:             instance.Shard,
+			Shards:            instance.Shards,
+			Leader:            instance.Leader,
+			Collecting:        instance.Collecting,
+			Servers:           instance.Servers,
+			StartedAt:         instance.StartedAt,
+			HeartbeatAt:       instance.HeartbeatAt,
+			HeartbeatInterval: instance.HeartbeatInterval.String(),
+			StoppedAt:         instance.StoppedAt,
+			Alive:             instance.StoppedAt == nil && now.Sub(instance.HeartbeatAt) < 3*instance.HeartbeatInterval,
+		})
+	}
+	return instances, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"elmon/scheduler"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// kinitTimeout limits a single kinit run
+const kinitTimeout = time.Minute
+
+// startKerberos obtains a ticket from the keytab and renews it every refresh interval, so GSSAPI
+// connections opened later find a valid ticket in the credential cache. Returns nil scheduler
+// when no keytab is configured.
+func (app *application) startKerberos() (*scheduler.TaskScheduler, error) {
+	kerberos := app.config.Kerberos
+	if kerberos.Keytab == "" {
+		return nil, nil
+	}
+	// The cache is shared with the GSSAPI provider of the PostgreSQL driver through the environment
+	if kerberos.CredentialCache != "" {
+		if err := os.Setenv("KRB5CCNAME", kerberos.CredentialCache); err != nil {
+			return nil, fmt.Errorf("failed to set KRB5CCNAME: %w", err)
+		}
+	}
+
+	log := app.log.WithComponent("kerberos")
+	kinit := func(ctx context.Context, _ interface{}) error {
+		kinitCtx, cancel := context.WithTimeout(ctx, kinitTimeout)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(kinitCtx, kerberos.Kinit, "-k", "-t", kerberos.Keytab, kerberos.Principal)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				err = fmt.Errorf("%w: %s", err, message)
+			}
+			return fmt.Errorf("kinit failed for principal '%s': %w", kerberos.Principal, err)
+		}
+		log.Debug("Kerberos ticket renewed", "principal", kerberos.Principal)
+		return nil
+	}
+
+	// The first ticket is required before any connection is opened
+	if err := kinit(context.Background(), nil); err != nil {
+		return nil, err
+	}
+	log.Info("Kerberos ticket obtained", "principal", kerberos.Principal, "refresh_interval", kerberos.RefreshInterval.Duration)
+
+	refreshScheduler := scheduler.NewTaskScheduler(kerberos.RefreshInterval.Duration, 0, 0, kinit, nil, log)
+	if err := refreshScheduler.Start(); err != nil {
+		return nil, err
+	}
+	return refreshScheduler, nil
+}
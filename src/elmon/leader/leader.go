@@ -0,0 +1,148 @@
+// Package leader elects one of elmon instances sharing the metrics DB with a lease stored in it,
+// so a standby instance takes over collection when the leader disappears
+package leader
+
+import (
+	"context"
+	"elmon/logger"
+	"elmon/scheduler"
+	"sync"
+	"time"
+)
+
+// LeaseStore keeps leases, implemented by the metrics DB storage
+type LeaseStore interface {
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// Elector renews the lease three times per TTL. An instance that fails to renew the lease for
+// two thirds of TTL steps down, before others can take the lease over. A watchdog checks the age of
+// the lease apart from renewals, so a renewal hanging on an unreachable metrics DB doesn't keep the
+// instance leading.
+type Elector struct {
+	Name      string // lease name
+	Holder    string // instance id
+	TTL       time.Duration
+	Store     LeaseStore
+	OnElected func() // called when the instance becomes the leader
+	OnDemoted func() // called when the instance loses the lease
+	Logger    *logger.Logger
+
+	mutex     sync.Mutex
+	leader    bool
+	renewedAt time.Time // last successful renewal, start of the current lease
+	scheduler *scheduler.TaskScheduler
+	watchdog  *scheduler.TaskScheduler
+}
+
+// Start tries to acquire the lease at once and then keeps trying or renewing it
+func (elector *Elector) Start() error {
+	if err := elector.tick(context.Background(), nil); err != nil {
+		elector.Logger.Warn("Leader lease check failed, will retry", "error", err.Error())
+	}
+	elector.scheduler = scheduler.NewTaskScheduler(elector.TTL/3, 0, 0, elector.tick, nil, elector.Logger)
+	if err := elector.scheduler.Start(); err != nil {
+		return err
+	}
+	elector.watchdog = scheduler.NewTaskScheduler(elector.TTL/10, 0, 0, elector.checkLease, nil, elector.Logger)
+	return elector.watchdog.Start()
+}
+
+// Stop stops renewing and releases the lease, so a standby instance takes over without waiting for expiry
+func (elector *Elector) Stop() {
+	if elector.scheduler != nil {
+		elector.scheduler.Stop()
+	}
+	if elector.watchdog != nil {
+		elector.watchdog.Stop()
+	}
+	elector.mutex.Lock()
+	wasLeader := elector.leader
+	elector.leader = false
+	elector.mutex.Unlock()
+	if !wasLeader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), elector.TTL/3)
+	defer cancel()
+	if err := elector.Store.ReleaseLease(ctx, elector.Name, elector.Holder); err != nil {
+		elector.Logger.Error(err, "Failed to release leader lease")
+		return
+	}
+	elector.Logger.Info("Leader lease released", "holder", elector.Holder)
+}
+
+// IsLeader reports whether the instance holds the lease
+func (elector *Elector) IsLeader() bool {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	return elector.leader
+}
+
+// tick acquires or renews the lease and calls OnElected or OnDemoted when leadership changes
+func (elector *Elector) tick(ctx context.Context, _ interface{}) error {
+	attemptedAt := time.Now()
+	// A renewal outlasting its interval can't keep the lease anyway
+	ctx, cancel := context.WithTimeout(ctx, elector.TTL/3)
+	acquired, err := elector.Store.AcquireLease(ctx, elector.Name, elector.Holder, elector.TTL)
+	cancel()
+
+	elector.mutex.Lock()
+	wasLeader := elector.leader
+	switch {
+	case err != nil:
+		// The lease may still be ours, but step down before others can take it over
+		if wasLeader && elector.expiring() {
+			elector.leader = false
+		}
+	case acquired:
+		elector.leader = true
+		elector.renewedAt = attemptedAt
+	default:
+		elector.leader = false
+	}
+	isLeader := elector.leader
+	elector.mutex.Unlock()
+
+	switch {
+	case isLeader && !wasLeader:
+		elector.Logger.Info("Became the leader", "holder", elector.Holder)
+		if elector.OnElected != nil {
+			elector.OnElected()
+		}
+	case !isLeader && wasLeader:
+		elector.demoted()
+	}
+	return err
+}
+
+// checkLease steps down once the lease was not renewed for two thirds of TTL, also while a renewal
+// is still waiting for the metrics DB
+func (elector *Elector) checkLease(_ context.Context, _ interface{}) error {
+	elector.mutex.Lock()
+	stepDown := elector.leader && elector.expiring()
+	if stepDown {
+		elector.leader = false
+	}
+	elector.mutex.Unlock()
+
+	if stepDown {
+		elector.demoted()
+	}
+	return nil
+}
+
+// expiring reports whether others can take the lease over soon, the mutex must be held
+func (elector *Elector) expiring() bool {
+	return time.Since(elector.renewedAt) >= elector.TTL-elector.TTL/3
+}
+
+// demoted reports the lost lease and calls OnDemoted
+func (elector *Elector) demoted() {
+	elector.Logger.Warn("Lost the leader lease, standing by", "holder", elector.Holder)
+	if elector.OnDemoted != nil {
+		elector.OnDemoted()
+	}
+}
@@ -0,0 +1,179 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupConfig limits repeated warnings and errors.
+// Each distinct record (level, message, error and logger attributes) is written
+// Burst times per Interval, the rest is counted and reported in a summary record.
+type DedupConfig struct {
+	Burst    int           // records written per interval, 0 disables deduplication
+	Interval time.Duration // summary interval
+}
+
+// dedupEntry counts occurrences of one record key in the current interval
+type dedupEntry struct {
+	windowStart time.Time
+	count       int
+	suppressed  int
+	last        slog.Record  // last suppressed record, used for the summary
+	handler     slog.Handler // handler the summary is written to
+}
+
+// dedupState is shared by a logger and all its child loggers
+type dedupState struct {
+	config  DedupConfig
+	mutex   sync.Mutex
+	entries map[string]*dedupEntry
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// dedupHandler drops warnings and errors repeated more than Burst times per Interval
+type dedupHandler struct {
+	next   slog.Handler
+	state  *dedupState
+	prefix string // attributes added with WithAttrs/WithGroup, part of the record key
+}
+
+// newDedupState creates shared state and starts flushing summaries every interval
+func newDedupState(config DedupConfig) *dedupState {
+	state := &dedupState{
+		config:  config,
+		entries: make(map[string]*dedupEntry),
+		stop:    make(chan struct{}),
+	}
+	go state.flushLoop()
+	return state
+}
+
+// flushLoop writes summaries of intervals that ended without new occurrences
+func (state *dedupState) flushLoop() {
+	ticker := time.NewTicker(state.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-state.stop:
+			return
+		case now := <-ticker.C:
+			state.flush(now, false)
+		}
+	}
+}
+
+// close stops the flush loop and reports all pending summaries
+func (state *dedupState) close() {
+	state.stopped.Do(func() {
+		close(state.stop)
+		state.flush(time.Now(), true)
+	})
+}
+
+// flush reports and forgets entries whose interval has ended, or all entries with force
+func (state *dedupState) flush(now time.Time, force bool) {
+	state.mutex.Lock()
+	var summaries []*dedupEntry
+	for key, entry := range state.entries {
+		if !force && now.Sub(entry.windowStart) < state.config.Interval {
+			continue
+		}
+		if entry.suppressed > 0 {
+			summaries = append(summaries, entry)
+		}
+		delete(state.entries, key)
+	}
+	state.mutex.Unlock()
+
+	for _, entry := range summaries {
+		record := entry.last.Clone()
+		record.Time = now
+		record.AddAttrs(slog.Int("suppressed_count", entry.suppressed))
+		_ = entry.handler.Handle(context.Background(), record)
+	}
+}
+
+// Enabled delegates to the wrapped handler
+func (h *dedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle writes the record unless the same record was already written Burst times in the interval
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	// Debug and info records are never deduplicated
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	key := h.recordKey(r)
+	state := h.state
+
+	state.mutex.Lock()
+	entry, ok := state.entries[key]
+	suppressedBefore := 0
+	if !ok || r.Time.Sub(entry.windowStart) >= state.config.Interval {
+		// New interval, report how many records the previous one dropped
+		if ok {
+			suppressedBefore = entry.suppressed
+		}
+		entry = &dedupEntry{windowStart: r.Time, handler: h.next}
+		state.entries[key] = entry
+	}
+	entry.count++
+	if entry.count > state.config.Burst {
+		entry.suppressed++
+		entry.last = r.Clone()
+		state.mutex.Unlock()
+		return nil
+	}
+	state.mutex.Unlock()
+
+	if suppressedBefore > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed_count", suppressedBefore))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns handler whose records are keyed by the attributes as well
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var prefix strings.Builder
+	prefix.WriteString(h.prefix)
+	for _, attr := range attrs {
+		// Task id differs on every scheduled run of the same task
+		if attr.Key == "task_id" {
+			continue
+		}
+		prefix.WriteString(attr.String())
+		prefix.WriteByte(' ')
+	}
+	return &dedupHandler{next: h.next.WithAttrs(attrs), state: h.state, prefix: prefix.String()}
+}
+
+// WithGroup returns handler whose records are keyed by the group as well
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{next: h.next.WithGroup(name), state: h.state, prefix: h.prefix + name + "."}
+}
+
+// recordKey identifies identical records: level, message, error and logger attributes except task id.
+// Other record attributes such as attempt numbers are ignored.
+func (h *dedupHandler) recordKey(r slog.Record) string {
+	var key strings.Builder
+	key.WriteString(h.prefix)
+	key.WriteString(r.Level.String())
+	key.WriteByte(' ')
+	key.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "error" {
+			key.WriteByte(' ')
+			key.WriteString(attr.Value.String())
+			return false
+		}
+		return true
+	})
+	return key.String()
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// fanoutHandler passes every record to all handlers whose level allows it
+type fanoutHandler struct {
+	handlers []slog.Handler
+}
+
+// Enabled reports whether at least one handler accepts the level
+func (h *fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle writes the record to every enabled handler
+func (h *fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h.handlers {
+		if !handler.Enabled(ctx, r.Level) {
+			continue
+		}
+		// Records must not be shared between handlers
+		if err := handler.Handle(ctx, r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs returns fanout of handlers with the attributes added
+func (h *fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return &fanoutHandler{handlers: handlers}
+}
+
+// WithGroup returns fanout of handlers with the group opened
+func (h *fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return &fanoutHandler{handlers: handlers}
+}
@@ -2,6 +2,8 @@ package logger
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"os"
 	"runtime"
@@ -13,51 +15,151 @@ type Config struct {
 	Level    string // debug, info, warn, error
 	Format   string // json, text
 	FileName string // File name or empty string for console output
+	Stderr   bool   // Console output goes to stderr instead of stdout
+
+	// File rotation, used only with FileName
+	MaxSize    int  // megabytes, 0 disables rotation
+	MaxBackups int  // rotated files to keep, 0 keeps all
+	MaxAge     int  // days to keep rotated files, 0 keeps forever
+	Compress   bool // gzip rotated files
 }
 
 // Logger provides a wrapper around slog.Logger.
 type Logger struct {
 	*slog.Logger
+	files []*RotatingFile // log files of all sinks, empty for console output
+	dedup *dedupState     // nil when deduplication is disabled
 }
 
 // New creates a new logger instance with specified level, format (JSON/text), and output file.
 // If logFileName is empty, output goes to os.Stdout.
 // Note: defer logFile.Close() is omitted for production-like long-lived loggers,
-// file closure should be handled at application shutdown.
+// file closure should be handled at application shutdown with Close.
 func New(level slog.Level, isJSON bool, logFileName string) (*Logger, error) {
+	handler, file, err := newHandler(level, isJSON, Config{FileName: logFileName})
+	if err != nil {
+		return nil, err
+	}
+	return newLogger([]slog.Handler{handler}, []*RotatingFile{file}), nil
+}
+
+// NewByConfig creates a new logger instance based on the provided configuration.
+func NewByConfig(config Config) (*Logger, error) {
+	return NewWithSinks([]Config{config})
+}
+
+// NewWithSinks creates a logger writing every record to all sinks
+// whose level allows it, e.g. text at info level to console and JSON at debug level to a file.
+func NewWithSinks(sinks []Config) (*Logger, error) {
+	var handlers []slog.Handler
+	var files []*RotatingFile
+	for _, sink := range sinks {
+		handler, file, err := newHandler(parseLevel(sink.Level), sink.Format == "json", sink)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		handlers = append(handlers, handler)
+		files = append(files, file)
+	}
+	return newLogger(handlers, files), nil
+}
+
+// newLogger combines sink handlers into a single logger
+func newLogger(handlers []slog.Handler, files []*RotatingFile) *Logger {
+	logger := &Logger{}
+	for _, file := range files {
+		if file != nil {
+			logger.files = append(logger.files, file)
+		}
+	}
+	if len(handlers) == 1 {
+		logger.Logger = slog.New(handlers[0])
+	} else {
+		logger.Logger = slog.New(&fanoutHandler{handlers: handlers})
+	}
+	return logger
+}
+
+// newHandler creates handler writing to console or to a rotating file from config.
+// The returned file is nil for console output.
+func newHandler(level slog.Level, isJSON bool, config Config) (slog.Handler, *RotatingFile, error) {
 	opts := &slog.HandlerOptions{
 		Level: level,
 		// AddSource: true, // Uncomment to include file and line number in logs
 	}
 
-	writer := os.Stdout
+	var writer io.Writer = os.Stdout
+	if config.Stderr {
+		writer = os.Stderr
+	}
+	var file *RotatingFile
 
-	if logFileName != "" {
-		logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if config.FileName != "" {
+		var err error
+		file, err = OpenRotatingFile(config.FileName, config.MaxSize, config.MaxBackups, config.MaxAge, config.Compress)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		writer = logFile
+		writer = file
 	}
 
-	var handler slog.Handler
 	if isJSON {
-		handler = slog.NewJSONHandler(writer, opts)
-	} else {
-		handler = slog.NewTextHandler(writer, opts)
+		return slog.NewJSONHandler(writer, opts), file, nil
 	}
+	return slog.NewTextHandler(writer, opts), file, nil
+}
 
-	return &Logger{Logger: slog.New(handler)}, nil
+// With returns a child logger adding the given key-value pairs to every record,
+// e.g. log.With("server_name", name, "metric_name", metric).
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{Logger: l.Logger.With(args...), files: l.files, dedup: l.dedup}
 }
 
-// NewByConfig creates a new logger instance based on the provided configuration.
-func NewByConfig(config Config) (*Logger, error) {
-	logFileName := config.FileName
-	level := parseLevel(config.Level)
-	isJson := config.Format == "json"
+// WithDedup returns a logger writing each distinct warning or error at most
+// config.Burst times per config.Interval and summarizing the rest.
+// Close of the returned logger stops the summary goroutine.
+func (l *Logger) WithDedup(config DedupConfig) *Logger {
+	if config.Burst <= 0 || config.Interval <= 0 {
+		return l
+	}
+	state := newDedupState(config)
+	handler := &dedupHandler{next: l.Handler(), state: state}
+	return &Logger{Logger: slog.New(handler), files: l.files, dedup: state}
+}
 
-	logger, err := New(level, isJson, logFileName)
-	return logger, err
+// WithComponent returns a child logger marking records with the application component
+func (l *Logger) WithComponent(component string) *Logger {
+	return l.With("component", component)
+}
+
+// Reopen reopens log files, e.g. on SIGHUP after logrotate moved them.
+// Does nothing for console output.
+func (l *Logger) Reopen() error {
+	var errs []error
+	for _, file := range l.files {
+		if err := file.Reopen(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes deduplication summaries and closes log files.
+func (l *Logger) Close() error {
+	if l.dedup != nil {
+		l.dedup.close()
+	}
+
+	var errs []error
+	for _, file := range l.files {
+		if err := file.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // Debug logs a debug-level message with additional key-value pairs.
@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used in names of rotated files: app-2006-01-02T15-04-05.000.log
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.Writer appending to a log file and rotating it by size.
+// Rotated files are kept according to maxBackups and maxAge and optionally gzip compressed.
+type RotatingFile struct {
+	path       string
+	maxSize    int64         // bytes, 0 disables size based rotation
+	maxBackups int           // 0 keeps all backups
+	maxAge     time.Duration // 0 keeps backups forever
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	cleanupMu sync.Mutex
+}
+
+// OpenRotatingFile opens log file for appending. maxSize is in megabytes, maxAge in days.
+func OpenRotatingFile(path string, maxSize int, maxBackups int, maxAge int, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAge) * 24 * time.Hour,
+		compress:   compress,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes log record, rotating the file first if the record does not fit into maxSize
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, fmt.Errorf("log file '%s' is closed", f.path)
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file by path. It is used after external tools
+// such as logrotate have moved the file away.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the file, subsequent writes fail
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file for appending and remembers its current size
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames current file to a timestamped backup and starts a new one
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if err := os.Rename(f.path, f.backupName(time.Now())); err != nil {
+		return fmt.Errorf("failed to rotate log file '%s': %w", f.path, err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	// Compression and removal of old files must not block logging
+	go f.cleanup()
+	return nil
+}
+
+// backupName returns rotated file name for the given time
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// cleanup compresses new backups and removes those exceeding maxBackups or maxAge
+func (f *RotatingFile) cleanup() {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	dir := filepath.Dir(f.path)
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name[len(prefix):], ".gz"), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), time: t})
+	}
+	// Newest first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+
+	for i, b := range backups {
+		expired := f.maxAge > 0 && time.Since(b.time) > f.maxAge
+		if (f.maxBackups > 0 && i >= f.maxBackups) || expired {
+			os.Remove(b.path)
+			continue
+		}
+		if f.compress && !strings.HasSuffix(b.path, ".gz") {
+			compressFile(b.path)
+		}
+	}
+}
+
+// compressFile replaces file with its gzip compressed copy
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
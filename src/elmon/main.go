@@ -1,220 +1,406 @@
 package main
 
 import (
+	"context"
 	"elmon/collector"
 	"elmon/config"
-	"elmon/logger"
-	"elmon/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
 	stdlog "log"
-	"log/slog"
 	"os"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+const usage = `Usage: elmon <command> [options]
+
+Commands:
+  run                 Start metric collection (default when no command is given)
+  bootstrap           Migrate metrics database, register metadata, provision Grafana and exit
+  validate-config     Validate configuration and SQL files, then exit
+  collect-once        Collect a single metric from a single server, print it and exit
+  backfill            Recompute values of past windows of a metric and store them
+  sla-report          Compute, store and print monthly availability of servers
+  generate-dashboard  Write a Grafana dashboard with a panel for every metric
+  prepare-dashboard   Rewrite data sources and variables of an exported Grafana dashboard
+  status              Print the state of scheduled tasks of a running collector
+  config schema       Print JSON Schema of configuration files
+  version             Print version and exit
+
+Run 'elmon <command> -h' for command options.
+`
+
 func main() {
-	// 1. Load configuration
-	appConfig, err := config.Load("config.yaml")
-	if err != nil {
-		stdlog.Fatalf("FATAL: Failed to load configuration: %v", err)
+	command := "run"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runCommand(args)
+	case "bootstrap":
+		bootstrapCommand(args)
+	case "validate-config":
+		validateConfigCommand(args)
+	case "collect-once":
+		collectOnceCommand(args)
+	case "backfill":
+		backfillCommand(args)
+	case "sla-report":
+		slaReportCommand(args)
+	case "generate-dashboard":
+		generateDashboardCommand(args)
+	case "prepare-dashboard":
+		prepareDashboardCommand(args)
+	case "status":
+		statusCommand(args)
+	case "config":
+		configCommand(args)
+	case "version":
+		fmt.Println("elmon", version)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s", command, usage)
+		os.Exit(2)
 	}
+}
 
-	// 2. Initialize logger
-	log, err := logger.NewByConfig(logger.Config{
-		Level:    appConfig.Log.Level,
-		Format:   appConfig.Log.Format,
-		FileName: appConfig.Log.File,
+// runCommand starts all schedulers and blocks until the service is stopped
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	bootstrap := flags.Bool("bootstrap", false,
+		"migrate the metrics database before starting, as elmon bootstrap does, e.g. without an init container")
+	flags.Bool("skip-bootstrap", false, "deprecated, run skips the migrations unless --bootstrap is given")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	runService(func(ready func(), stop <-chan struct{}) {
+		runCollector(opts, *bootstrap, ready, stop)
 	})
+}
+
+// runCollector starts all schedulers and blocks until stop is closed, a nil stop blocks forever.
+// ready is called once the collector is running.
+func runCollector(opts *commonOptions, bootstrap bool, ready func(), stop <-chan struct{}) {
+	// 1. Load configuration and initialize logger
+	app, err := newApplication(opts)
 	if err != nil {
-		stdlog.Fatalf("FATAL: Failed to initialize logger: %v", err)
-	}
-	slog.SetDefault(log.Logger)
-	log.Info("Logger started")
-
-	// 3. Connect to metrics database
-	metricsDBParams := sql.ConnectionParams{
-		Host:                  appConfig.MetricsDB.Host,
-		Port:                  appConfig.MetricsDB.Port,
-		User:                  appConfig.MetricsDB.User,
-		Password:              appConfig.MetricsDB.Password,
-		DbName:                appConfig.MetricsDB.DbName,
-		SslMode:               appConfig.MetricsDB.SslMode,
-		MaxOpenConnections:    appConfig.MetricsDB.MaxOpenConnections,
-		MaxIdleConnections:    appConfig.MetricsDB.MaxIdleConnections,
-		ConnectionMaxLifetime: appConfig.MetricsDB.ConnectionMaxLifetime,
-		ConnectionMaxIdleTime: appConfig.MetricsDB.ConnectionMaxIdleTime,
-	}
-
-	db, err := sql.Connect(log, metricsDBParams)
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	log := app.log
+	defer app.close()
+	app.reopenLogOnSignal()
+
+	// Kerberos ticket is needed before the first GSSAPI connection
+	kerberosScheduler, err := app.startKerberos()
 	if err != nil {
+		log.Error(err, "Failed to obtain Kerberos ticket")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if kerberosScheduler != nil {
+		defer kerberosScheduler.Stop()
+	}
+
+	// 2. Connect to metrics database, execute migrations with --bootstrap and save metrics configuration
+	if err := app.connectMetricsDB(); err != nil {
 		log.Error(err, "error connecting to metrics database server")
 		stdlog.Fatalf("Fatal error connecting to metrics SQL server: %v", err)
 	}
-	defer db.Close()
-	log.Info("Metrics database server connected")
+	initMetricsDB := app.attachMetricsDB
+	if bootstrap {
+		initMetricsDB = app.initMetricsDB
+	}
+	if err := initMetricsDB(); err != nil {
+		log.Error(err, "error initializing metrics database")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	// The audit trail of configuration doesn't stop collection
+	if err := app.recordConfigVersion(); err != nil {
+		log.Error(err, "Failed to save configuration version")
+	}
+
+	// 3. Connect to all monitored database servers
+	if err := app.connectServers(); err != nil {
+		log.Error(err, "Error establishing connections to database servers")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+
+	// 4. Save server information to metrics database and deactivate removed servers
+	if err := app.saveServers(); err != nil {
+		log.Error(err, "error saving servers to metrics DB")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if err := app.deactivateRemovedServers(); err != nil {
+		log.Error(err, "error deactivating removed servers")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
 
-	// 4. Execute database migrations
-	sqlBytes, err := os.ReadFile("sql/script/init.sql")
+	// 5. Start metrics DB health checks, open sinks and the spool before tasks are built,
+	// so they can spool values when metrics DB is unavailable
+	healthScheduler, err := app.startHealthCheck()
 	if err != nil {
-		log.Error(err, "error opening initial SQL script file")
+		log.Error(err, "Failed to start metrics DB health checks")
 		stdlog.Fatalf("Fatal error: %v", err)
 	}
-	if _, err = db.Exec(string(sqlBytes)); err != nil {
-		log.Error(err, "failed to execute initial SQL script")
+	if healthScheduler != nil {
+		defer healthScheduler.Stop()
+	}
+	if err := app.openSinks(); err != nil {
+		log.Error(err, "Failed to open sinks")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	spoolScheduler, err := app.startSpool()
+	if err != nil {
+		log.Error(err, "Failed to start spool")
 		stdlog.Fatalf("Fatal error: %v", err)
 	}
-	log.Info("Initial SQL script executed successfully")
+	if spoolScheduler != nil {
+		defer spoolScheduler.Stop()
+	}
 
-	// 5. Save metrics configuration to database
-	metricsForDB := &sql.MetricConfigForDB{}
-	metricMap := make(map[string]*sql.MetricInfo) // Map for quick metric lookup by name
-	for _, group := range appConfig.Metrics.MetricGroups {
-		g := &sql.MetricGroupInfo{Name: group.Name, Description: group.Description}
-		for _, metric := range group.Metrics {
-			m := &sql.MetricInfo{Name: metric.Name, Description: metric.Description}
-			g.Metrics = append(g.Metrics, m)
-			metricMap[m.Name] = m // Populate the map
-		}
-		metricsForDB.MetricGroups = append(metricsForDB.MetricGroups, g)
+	// 6. Build tasks and start the collector
+	log.Info("Assembling metric tasks for the collector...")
+	metricTasks, err := app.explainTasks(app.buildMetricTasks(func(serverName, _ string) bool {
+		return app.inShard(serverName)
+	}))
+	if err != nil {
+		log.Error(err, "Metric SQL check failed")
+		stdlog.Fatalf("Fatal error: %v", err)
 	}
-	err = sql.InsertMetricsToDB(log, metricsForDB, db)
+
+	log.Info("Initializing and starting the collector", "task_count", len(metricTasks))
+	metricCollector := collector.NewCollector(metricTasks, log.WithComponent("collector"))
+	app.addRoleChecks(metricCollector)
+	if err := app.addHostChecks(metricCollector); err != nil {
+		log.Error(err, "Failed to schedule host checks")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	// With leader election the collector stands by until this instance holds the leader lease.
+	// The elector is stopped after the collector, so the lease is released once collection stopped.
+	elector, err := app.startLeaderElection(metricCollector)
 	if err != nil {
-		log.Error(err, "Error inserting metrics into database")
-		stdlog.Fatalf("Fatal error: %v", err)
-	}
-
-	// 6. Connect to all monitored database servers
-	var allServerParams []sql.ConnectionParams
-	serverInfoMap := make(map[string]*sql.ServerInfo) // Map to link server name with server info
-	for _, srvCfg := range appConfig.DBServers {
-		params := sql.ConnectionParams{
-			Name:                  srvCfg.Name,
-			Host:                  srvCfg.Host,
-			Port:                  srvCfg.Port,
-			User:                  srvCfg.User,
-			Password:              srvCfg.Password,
-			DbName:                srvCfg.DbName,
-			SslMode:               srvCfg.SslMode,
-			MaxOpenConnections:    srvCfg.MaxOpenConnections,
-			MaxIdleConnections:    srvCfg.MaxIdleConnections,
-			ConnectionMaxLifetime: srvCfg.ConnectionMaxLifetime,
-			ConnectionMaxIdleTime: srvCfg.ConnectionMaxIdleTime,
-		}
-		allServerParams = append(allServerParams, params)
-
-		info := &sql.ServerInfo{
-			Name:        srvCfg.Name,
-			Environment: srvCfg.Environment,
-			Host:        srvCfg.Host,
-			Port:        srvCfg.Port,
-			SslMode:     srvCfg.SslMode,
-		}
-		serverInfoMap[info.Name] = info
+		log.Error(err, "Failed to start leader election")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if elector != nil {
+		defer elector.Stop()
 	}
+	if err := metricCollector.Start(); err != nil {
+		log.Error(err, "Failed to start the collector")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	defer metricCollector.Stop()
 
-	// connections is now map[string]*sql.DB where key is unique server name
-	connections, err := sql.ConnectAll(log, allServerParams)
+	// 7. Add servers of discovery sources and keep them in sync
+	discoveryScheduler, err := app.startDiscovery(metricCollector)
 	if err != nil {
-		log.Error(err, "Error establishing connections to database servers")
+		log.Error(err, "Failed to start server discovery")
 		stdlog.Fatalf("Fatal error: %v", err)
 	}
-	// Don't forget to close all connections on exit
-	defer func() {
-		for _, conn := range connections {
-			conn.Close()
-		}
-	}()
-	log.Info("Connection to all database servers established")
+	if discoveryScheduler != nil {
+		defer discoveryScheduler.Stop()
+	}
 
-	// 7. Save server information to metrics database
-	var serversToSave []*sql.ServerInfo
-	for _, info := range serverInfoMap {
-		serversToSave = append(serversToSave, info)
+	// 8. Restore servers added through the API and serve the API
+	apiServer, err := app.startAPI(metricCollector)
+	if err != nil {
+		log.Error(err, "Failed to start the API")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if apiServer != nil {
+		defer apiServer.Stop()
 	}
-	err = sql.SaveAllServersToMetricsDb(log, serversToSave, db)
+
+	// 9. Start background rollups of old metric values
+	rollupScheduler, err := app.startRollups()
 	if err != nil {
-		log.Error(err, "error saving servers to metrics DB")
+		log.Error(err, "Failed to start rollups")
 		stdlog.Fatalf("Fatal error: %v", err)
 	}
-	log.Info("Servers loaded to metrics DB")
+	if rollupScheduler != nil {
+		defer rollupScheduler.Stop()
+	}
 
-	log.Info("Assembling metric tasks for the collector...")
-	var metricTasks []*collector.MetricTask
+	// 10. Delete collection log older than its retention
+	cleanupScheduler, err := app.startCollectionLogCleanup()
+	if err != nil {
+		log.Error(err, "Failed to start collection log cleanup")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if cleanupScheduler != nil {
+		defer cleanupScheduler.Stop()
+	}
 
-	// Create lookup maps for faster access by name
-	metricsConfigMap := make(map[string]config.Metric)
-	for _, group := range appConfig.Metrics.MetricGroups {
-		for _, metric := range group.Metrics {
-			metricsConfigMap[metric.Name] = metric
-		}
+	// 11. Delete servers and metrics inactive for longer than purge-inactive-after
+	purgeScheduler, err := app.startInactivePurge()
+	if err != nil {
+		log.Error(err, "Failed to start inactive purge")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if purgeScheduler != nil {
+		defer purgeScheduler.Stop()
 	}
 
-	// Create metric tasks based on server-metric mappings
-	for _, mapping := range appConfig.ServerMetricsMap {
-		serverInfo, ok := serverInfoMap[mapping.Name]
-		if !ok {
-			log.Warn("Server from mapping not found in server list, skipping", "server", mapping.Name)
-			continue
+	// 12. Compute monthly availability of servers and send summaries of completed months
+	reportScheduler, err := app.startAvailabilityReports()
+	if err != nil {
+		log.Error(err, "Failed to start availability reports")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if reportScheduler != nil {
+		defer reportScheduler.Stop()
+	}
+
+	// 13. Register the instance in elmon_instance and renew its heartbeat
+	registry, err := app.startInstanceRegistry(metricCollector)
+	if err != nil {
+		log.Error(err, "Failed to start the instance registry")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if registry != nil {
+		defer registry.Stop()
+	}
+
+	log.Info("Application is running. Press Ctrl+C to exit.")
+	// Tell systemd or the Windows service control manager that elmon is ready
+	if err := sdNotify("READY=1"); err != nil {
+		log.Error(err, "Failed to notify systemd about readiness")
+	}
+	ready()
+	startWatchdog(log, func() { metricCollector.State() })
+
+	// Deferred stops run once the service is asked to stop, e.g. by SIGTERM or the service control manager
+	<-stop
+	log.Info("Stopping the application...")
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Error(err, "Failed to notify systemd about stopping")
+	}
+}
+
+// configCommand runs configuration helper subcommands
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "schema" {
+		fmt.Fprintf(os.Stderr, "usage: elmon config schema\n")
+		os.Exit(2)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(config.Schema()); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+}
+
+// collectOnceCommand executes a single metric against a single server one time
+// and prints collected values as JSON to stdout
+func collectOnceCommand(args []string) {
+	flags := flag.NewFlagSet("collect-once", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	serverName := flags.String("server", "", "server name from db-servers (required)")
+	metricName := flags.String("metric", "", "metric name from metrics (required)")
+	noStore := flags.Bool("no-store", false, "do not connect to metrics database and do not store collected values")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	if *serverName == "" || *metricName == "" {
+		fmt.Fprintln(os.Stderr, "both --server and --metric are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Keep stdout for metric values only, logs go to stderr
+	opts.logStderr = true
+
+	app, err := newApplication(opts)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	log := app.log
+	defer app.close()
+	kerberosScheduler, err := app.startKerberos()
+	if err != nil {
+		log.Error(err, "Failed to obtain Kerberos ticket")
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if kerberosScheduler != nil {
+		defer kerberosScheduler.Stop()
+	}
+
+	if !*noStore {
+		if err := app.connectMetricsDB(); err != nil {
+			log.Error(err, "error connecting to metrics database server")
+			stdlog.Fatalf("Fatal error: %v", err)
 		}
+		if err := app.initMetricsDB(); err != nil {
+			log.Error(err, "error initializing metrics database")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+	}
+	if err := app.connectServers(*serverName); err != nil {
+		log.Error(err, "Error establishing connection to database server", "server_name", *serverName)
+		stdlog.Fatalf("Fatal error: %v", err)
+	}
+	if !*noStore {
+		if err := app.saveServers(); err != nil {
+			log.Error(err, "error saving servers to metrics DB")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+		if err := app.openSinks(); err != nil {
+			log.Error(err, "Failed to open sinks")
+			stdlog.Fatalf("Fatal error: %v", err)
+		}
+	}
+
+	tasks := app.buildMetricTasks(func(server, metric string) bool {
+		return server == *serverName && metric == *metricName
+	})
+	if len(tasks) == 0 {
+		stdlog.Fatalf("Fatal error: metric '%s' is not mapped to server '%s' in servers-metrics-map", *metricName, *serverName)
+	}
 
-		targetDBConn, ok := connections[serverInfo.Name]
-		if !ok {
-			log.Warn("Active connection for server not found, skipping", "server", mapping.Name)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	failed := false
+	for _, task := range tasks {
+		collected := false
+		task.DryRun = *noStore
+		task.OnValue = func(task *collector.MetricTask, value json.RawMessage) {
+			collected = true
+			encoder.Encode(collectedValue{
+				Server:   task.ServerName,
+				Metric:   task.MetricName,
+				Database: task.DatabaseName,
+				Value:    value,
+			})
+		}
+		if err := collector.ProcessMetric(context.Background(), task); err != nil {
+			task.Logger.Error(err, "Metric collection failed")
+			failed = true
 			continue
 		}
-
-		for _, metricOverride := range mapping.Metrics {
-			metricInfo, ok := metricMap[metricOverride.Name]
-			if !ok {
-				log.Warn("Metric from mapping not found in metric list, skipping", "metric", metricOverride.Name)
-				continue
-			}
-
-			baseMetricConfig := metricsConfigMap[metricOverride.Name]
-
-			// Create task combining base and overridden parameters
-			task := &collector.MetricTask{
-				ServerName:     serverInfo.Name,
-				MetricName:     metricInfo.Name,
-				ServerID:       *serverInfo.ID,
-				MetricID:       metricInfo.DbMetricID,
-				CollectionType: baseMetricConfig.CollectionType,
-				SQLFile:        baseMetricConfig.SQLFile,
-				GoFunction:     baseMetricConfig.GoFunction,
-				Interval:       metricOverride.Interval.Duration, // Apply overrides
-				MaxRetries:     metricOverride.MaxRetries,
-				RetryDelay:     metricOverride.RetryDelay.Duration,
-				QueryTimeout:   metricOverride.QueryTimeout.Duration,
-				Logger:         log,
-				TargetDB:       targetDBConn,
-				MetricsDB:      db,
-			}
-
-			// Use global/base values if overrides are not provided
-			if task.Interval == 0 {
-				task.Interval = baseMetricConfig.Interval.Duration
-			}
-			if task.MaxRetries == 0 {
-				task.MaxRetries = baseMetricConfig.MaxRetries
-			}
-			if task.RetryDelay == 0 {
-				task.RetryDelay = baseMetricConfig.RetryDelay.Duration
-			}
-			if task.QueryTimeout == 0 {
-				task.QueryTimeout = baseMetricConfig.QueryTimeout.Duration
-			}
-
-			metricTasks = append(metricTasks, task)
+		if !collected {
+			task.Logger.Warn("Metric returned no value")
 		}
 	}
-
-	log.Info("Initializing and starting the collector", "task_count", len(metricTasks))
-	collector := collector.NewCollector(metricTasks, log)
-	if err := collector.Start(); err != nil {
-		log.Error(err, "Failed to start the collector")
-		stdlog.Fatalf("Fatal error: %v", err)
+	if failed {
+		os.Exit(1)
 	}
-	defer collector.Stop()
+}
 
-	log.Info("Application is running. Press Ctrl+C to exit.")
-	// TODO: Add OS signal handling for graceful shutdown
-	select {} // Infinite blocking
-}
\ No newline at end of file
+// collectedValue is printed by collect-once for every collected value
+type collectedValue struct {
+	Server   string          `json:"server"`
+	Metric   string          `json:"metric"`
+	Database string          `json:"database,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
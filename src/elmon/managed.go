@@ -0,0 +1,253 @@
+package main
+
+import (
+	"elmon/api"
+	"elmon/collector"
+	"elmon/config"
+	"elmon/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// managedServers adds and removes servers through the API and keeps their definitions in metrics DB,
+// so they are monitored again after restart
+type managedServers struct {
+	app       *application
+	collector *collector.Collector
+
+	mutex sync.Mutex
+	names map[string]bool // servers with a stored definition
+}
+
+// AddServer starts monitoring the server with metrics of the profile and stores its definition
+func (managed *managedServers) AddServer(request api.AddServerRequest) (*api.AddedServer, error) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	added, err := managed.add(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored as sent, so ${VAR} references in credentials are not saved expanded
+	definition, err := json.Marshal(request)
+	if err == nil {
+		err = managed.app.metricsDB.SaveManagedServer(sql.ManagedServer{
+			Name:       added.Name,
+			Profile:    request.Profile,
+			Definition: definition,
+		})
+	}
+	if err != nil {
+		if removeErr := managed.app.removeServer(managed.collector, added.Name); removeErr != nil {
+			managed.app.log.Error(removeErr, "Failed to remove server which could not be saved", "server_name", added.Name)
+		}
+		return nil, err
+	}
+	managed.names[added.Name] = true
+	return added, nil
+}
+
+// RemoveServer stops monitoring a server added through the API and deletes its definition
+func (managed *managedServers) RemoveServer(name string) error {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	if !managed.names[name] {
+		if managed.app.isMonitored(name) {
+			return fmt.Errorf("%w: server '%s' is not added through the API", api.ErrConflict, name)
+		}
+		return fmt.Errorf("%w: server '%s' is not monitored", api.ErrNotFound, name)
+	}
+	// A server which failed to be restored on startup is not monitored, only its definition is deleted
+	if managed.app.isMonitored(name) {
+		if err := managed.app.removeServer(managed.collector, name); err != nil {
+			return err
+		}
+	}
+	if err := managed.app.metricsDB.DeleteManagedServer(name); err != nil {
+		return err
+	}
+	delete(managed.names, name)
+	return nil
+}
+
+// PauseServer stops collections of any monitored server, e.g. during its maintenance
+func (managed *managedServers) PauseServer(name string) (*api.PausedServer, error) {
+	if !managed.app.isMonitored(name) {
+		return nil, fmt.Errorf("%w: server '%s' is not monitored", api.ErrNotFound, name)
+	}
+	count := managed.collector.PauseServer(name)
+	return &api.PausedServer{Name: name, Paused: true, TaskCount: count}, nil
+}
+
+// ResumeServer starts collections of a server paused with PauseServer again
+func (managed *managedServers) ResumeServer(name string) (*api.PausedServer, error) {
+	if !managed.app.isMonitored(name) {
+		return nil, fmt.Errorf("%w: server '%s' is not monitored", api.ErrNotFound, name)
+	}
+	count := managed.collector.ResumeServer(name)
+	return &api.PausedServer{Name: name, Paused: false, TaskCount: count}, nil
+}
+
+// add resolves the profile and the server definition and adds the server
+func (managed *managedServers) add(request api.AddServerRequest) (*api.AddedServer, error) {
+	profile, ok := managed.app.config.Profile(request.Profile)
+	if !ok {
+		return nil, fmt.Errorf("%w: metric profile '%s' is not defined", api.ErrInvalid, request.Profile)
+	}
+	srvCfg, err := config.DecodeServer(request.Server)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid server: %w", api.ErrInvalid, err)
+	}
+	// The command would run on the collector host, only configuration files may define it
+	if srvCfg.Auth.Type == "command" {
+		return nil, fmt.Errorf("%w: auth type 'command' can't be used by servers added through the API", api.ErrInvalid)
+	}
+	if !managed.app.inShard(srvCfg.Name) {
+		return nil, fmt.Errorf("%w: server '%s' belongs to shard %d, add it through an instance of that shard",
+			api.ErrConflict, srvCfg.Name, serverShard(srvCfg.Name, managed.app.config.Sharding.Shards))
+	}
+	// Credentials may refer to environment variables of the collector listed in api.env-vars
+	for _, value := range []*string{&srvCfg.User, &srvCfg.Password, &srvCfg.DSN} {
+		if *value, err = managed.expandEnv(*value); err != nil {
+			return nil, err
+		}
+	}
+
+	params := maps.Clone(profile.Params)
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	maps.Copy(params, request.Params)
+
+	taskCount, err := managed.app.addServer(managed.collector, srvCfg, config.ServerMetricsMapping{
+		Name:    srvCfg.Name,
+		Params:  params,
+		Metrics: profile.Metrics,
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := managed.app.serverInfo(srvCfg.Name)
+	return &api.AddedServer{
+		Name:      info.Name,
+		Host:      info.Host,
+		Port:      info.Port,
+		Profile:   profile.Name,
+		TaskCount: taskCount,
+	}, nil
+}
+
+// expandEnv replaces ${VAR} references with values of variables listed in api.env-vars. Other variables
+// are refused, as a caller could send them to a host of its own.
+func (managed *managedServers) expandEnv(value string) (string, error) {
+	var refused []string
+	expanded := os.Expand(value, func(name string) string {
+		if !slices.Contains(managed.app.config.API.EnvVars, name) {
+			refused = append(refused, name)
+			return ""
+		}
+		return os.Getenv(name)
+	})
+	if len(refused) > 0 {
+		return "", fmt.Errorf("%w: environment variables %s are not listed in api.env-vars", api.ErrInvalid,
+			strings.Join(refused, ", "))
+	}
+	return expanded, nil
+}
+
+// restore adds servers stored in metrics DB, a server that fails is logged and can be deleted through the API
+func (managed *managedServers) restore() error {
+	servers, err := managed.app.metricsDB.LoadManagedServers()
+	if err != nil {
+		return err
+	}
+
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+	for _, server := range servers {
+		// Monitored and managed by the instance of its shard
+		if !managed.app.inShard(server.Name) {
+			continue
+		}
+		managed.names[server.Name] = true
+		var request api.AddServerRequest
+		if err := json.Unmarshal(server.Definition, &request); err != nil {
+			managed.app.log.Error(err, "Invalid definition of server added through the API", "server_name", server.Name)
+			continue
+		}
+		if _, err := managed.add(request); err != nil {
+			managed.app.log.Error(err, "Failed to restore server added through the API", "server_name", server.Name)
+		}
+	}
+	return nil
+}
+
+// startAPI restores servers added through the API and serves the API.
+// Returns nil server when the API is disabled.
+func (app *application) startAPI(metricCollector *collector.Collector) (*api.Server, error) {
+	if app.config.API.Listen == "" && app.config.API.ControlSocket == "" {
+		return nil, nil
+	}
+
+	managed := &managedServers{app: app, collector: metricCollector, names: make(map[string]bool)}
+	if err := managed.restore(); err != nil {
+		return nil, err
+	}
+
+	server := api.NewServer(app.config.API.Listen, app.log.WithComponent("api"))
+	if tlsConfig := app.config.API.TLS; tlsConfig.CertFile != "" {
+		if err := server.SetTLS(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if app.config.API.Auth.Enabled() {
+		server.RequireAuth(apiAuth(app.config.API.Auth))
+	}
+	server.HandleServers(managed)
+	server.HandleServerPause(managed)
+	server.HandleTasks(taskInspector{metricCollector})
+	server.HandleHealth(healthChecker{app.dbHealth})
+	server.HandleValues(valueReader{app.metricsDB}, app.config.API.QueryTimeout.Duration)
+	server.HandleInstances(instanceReader{app.metricsDB}, app.config.API.QueryTimeout.Duration)
+	if app.config.API.UI {
+		server.HandleUI()
+	}
+	if len(app.config.API.Push.Tokens) > 0 {
+		tokens := make(map[string]string)
+		for _, token := range app.config.API.Push.Tokens {
+			tokens[token.Token] = token.Name
+		}
+		server.HandlePush(newPushReceiver(app), tokens)
+	}
+	if app.config.API.Listen != "" {
+		if err := server.Start(); err != nil {
+			return nil, err
+		}
+	}
+	if app.config.API.ControlSocket != "" {
+		if err := server.StartSocket(app.config.API.ControlSocket); err != nil {
+			server.Stop()
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// apiAuth converts API clients of the configuration to their API form
+func apiAuth(authConfig config.APIAuthConfig) *api.Auth {
+	auth := &api.Auth{Tokens: make(map[string]api.Identity), Clients: make(map[string]api.Identity)}
+	for _, token := range authConfig.Tokens {
+		auth.Tokens[token.Token] = api.Identity{Name: token.Name, Role: token.Role}
+	}
+	for _, client := range authConfig.Clients {
+		auth.Clients[client.CommonName] = api.Identity{Name: client.CommonName, Role: client.Role}
+	}
+	return auth
+}
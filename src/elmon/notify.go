@@ -0,0 +1,62 @@
+package main
+
+import (
+	"elmon/logger"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state such as READY=1 to systemd. It does nothing when elmon is not started
+// by a Type=notify unit, that is when NOTIFY_SOCKET is not set.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ is an abstract socket, net handles it
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns the WatchdogSec of the unit, 0 when the watchdog is disabled
+// or meant for another process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startWatchdog pings the systemd watchdog twice per WatchdogSec while alive returns.
+// alive blocks when the collector is stuck, pings stop then and systemd restarts elmon.
+func startWatchdog(log *logger.Logger, alive func()) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	log.Info("Feeding systemd watchdog", "watchdog_interval", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			alive()
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Error(err, "Failed to ping systemd watchdog")
+			}
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// commonOptions holds file layout options shared by all commands
+type commonOptions struct {
+	configPath string // configuration file, ELMON_CONFIG
+	sqlDir     string // directory with init scripts, ELMON_SQL_DIR
+	baseDir    string // working directory for relative paths, ELMON_BASE_DIR
+	logStderr  bool   // console logs go to stderr, set by commands printing their results to stdout
+}
+
+// addCommonFlags registers layout flags, defaults are taken from environment variables
+func addCommonFlags(flags *flag.FlagSet) *commonOptions {
+	opts := &commonOptions{}
+	flags.StringVar(&opts.configPath, "config", envOrDefault("ELMON_CONFIG", "config.yaml"),
+		"configuration file path (env ELMON_CONFIG)")
+	flags.StringVar(&opts.sqlDir, "sql-dir", envOrDefault("ELMON_SQL_DIR", filepath.Join("sql", "script")),
+		"directory containing metrics database init scripts (env ELMON_SQL_DIR)")
+	flags.StringVar(&opts.baseDir, "base-dir", os.Getenv("ELMON_BASE_DIR"),
+		"working directory, relative config, SQL and certificate paths are resolved against it (env ELMON_BASE_DIR)")
+	return opts
+}
+
+// apply changes working directory to base dir so that every relative path,
+// including sql-file entries of metrics, is resolved against it
+func (opts *commonOptions) apply() error {
+	if opts.baseDir == "" {
+		return nil
+	}
+	if err := os.Chdir(opts.baseDir); err != nil {
+		return fmt.Errorf("failed to change working directory to '%s': %w", opts.baseDir, err)
+	}
+	return nil
+}
+
+// envOrDefault returns environment variable value or fallback if it is not set
+func envOrDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"elmon/api"
+	"elmon/collector"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pushKey identifies the task storing pushed values of a metric of a server and database
+type pushKey struct {
+	server   string
+	metric   string
+	database string
+}
+
+// pushTask stores pushed values, counter state is kept between pushes
+type pushTask struct {
+	mutex sync.Mutex // values of a task are stored one at a time
+	task  *collector.MetricTask
+}
+
+// pushReceiver stores values sent by agents to POST /api/push
+type pushReceiver struct {
+	app   *application
+	mutex sync.Mutex
+	tasks map[pushKey]*pushTask
+
+	partitionsFrom time.Time // metric_value partitions are created for pushed times from here on
+}
+
+func newPushReceiver(app *application) *pushReceiver {
+	return &pushReceiver{app: app, tasks: make(map[pushKey]*pushTask)}
+}
+
+// Push stores values of a monitored server, including servers with enabled: false which are only
+// registered. Values are checked first, a failed write stops the request and reports how many values
+// were stored before it.
+func (receiver *pushReceiver) Push(ctx context.Context, agent string, request api.PushRequest) (api.PushResult, error) {
+	var result api.PushResult
+	info := receiver.app.serverInfo(request.Server)
+	if info == nil {
+		return result, fmt.Errorf("%w: server '%s' is not monitored", api.ErrNotFound, request.Server)
+	}
+	if info.ID == nil {
+		return result, fmt.Errorf("%w: server '%s' is not saved to metrics DB yet", api.ErrConflict, request.Server)
+	}
+
+	pushConfig := receiver.app.config.API.Push
+	now := time.Now()
+	var oldest time.Time
+	tasks := make([]*pushTask, len(request.Values))
+	for i, value := range request.Values {
+		if !value.Time.IsZero() {
+			if value.Time.Before(now.Add(-pushConfig.MaxAge.Duration)) ||
+				value.Time.After(now.Add(pushConfig.MaxFuture.Duration)) {
+				return result, fmt.Errorf("%w: time of value %d is outside of the accepted window from %s ago to %s ahead",
+					api.ErrInvalid, i, pushConfig.MaxAge.Duration, pushConfig.MaxFuture.Duration)
+			}
+			if oldest.IsZero() || value.Time.Before(oldest) {
+				oldest = value.Time
+			}
+		}
+		task, err := receiver.task(request.Server, *info.ID, value)
+		if err != nil {
+			return result, fmt.Errorf("value %d: %w", i, err)
+		}
+		if err := task.task.CheckValue(value.Value); err != nil {
+			return result, fmt.Errorf("%w: value %d of metric '%s': %w", api.ErrInvalid, i, value.Metric, err)
+		}
+		tasks[i] = task
+	}
+
+	if !oldest.IsZero() {
+		if err := receiver.createPartitions(ctx, oldest, now); err != nil {
+			return result, err
+		}
+	}
+
+	for i, value := range request.Values {
+		task := tasks[i]
+		task.mutex.Lock()
+		err := task.task.StoreValue(ctx, value.Value, value.Time)
+		task.mutex.Unlock()
+		if err != nil {
+			return result, fmt.Errorf("failed to store value %d of metric '%s', %d of %d values stored: %w",
+				i, value.Metric, result.Stored, len(request.Values), err)
+		}
+		result.Stored++
+	}
+	receiver.app.log.Debug("Pushed values stored", "agent", agent, "server_name", request.Server,
+		"value_count", result.Stored)
+
+	// Rollups have passed the times of values pushed late
+	rollups := receiver.app.config.Storage.Rollups
+	if !oldest.IsZero() && oldest.Before(now.Add(-rollups.After.Duration)) {
+		if err := receiver.app.rewindRollups(oldest); err != nil {
+			receiver.app.log.Error(err, "Failed to rewind rollups, pushed values are not aggregated", "agent", agent)
+		}
+	}
+	return result, nil
+}
+
+// createPartitions creates metric_value partitions of pushed past times, partitions are created in advance
+// for the current and coming months only
+func (receiver *pushReceiver) createPartitions(ctx context.Context, from time.Time, to time.Time) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if !receiver.partitionsFrom.IsZero() && !from.Before(receiver.partitionsFrom) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, receiver.app.config.MetricsDB.WriteTimeout.Duration)
+	defer cancel()
+	if err := receiver.app.metricsDB.CreateMetricPartitions(ctx, from, to); err != nil {
+		return err
+	}
+	receiver.partitionsFrom = from
+	return nil
+}
+
+// task returns the task storing the value, created on the first push of the metric
+func (receiver *pushReceiver) task(serverName string, serverID int, value api.PushedValue) (*pushTask, error) {
+	app := receiver.app
+	metricInfo, ok := app.metricInfos[value.Metric]
+	if !ok {
+		return nil, fmt.Errorf("%w: metric '%s' is not configured", api.ErrNotFound, value.Metric)
+	}
+	metricConfig := app.metricConfigs[value.Metric]
+	switch {
+	case app.disabledMetrics[value.Metric]:
+		return nil, fmt.Errorf("%w: metric '%s' is disabled", api.ErrInvalid, value.Metric)
+	case metricConfig.CollectionType == "bundle" || metricConfig.CollectionType == "expression" ||
+		metricConfig.CollectionType == "forecast":
+		return nil, fmt.Errorf("%w: values of metric '%s' of collection-type %s can't be pushed", api.ErrInvalid,
+			value.Metric, metricConfig.CollectionType)
+	case metricConfig.Scope == "database" && value.Database == "":
+		return nil, fmt.Errorf("%w: metric '%s' requires database", api.ErrInvalid, value.Metric)
+	case metricConfig.Scope == "server" && value.Database != "":
+		return nil, fmt.Errorf("%w: metric '%s' is collected per server, database is not allowed", api.ErrInvalid,
+			value.Metric)
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	key := pushKey{server: serverName, metric: value.Metric, database: value.Database}
+	if task, ok := receiver.tasks[key]; ok && task.task.ServerID == serverID {
+		return task, nil
+	}
+
+	taskLog := app.log.WithComponent("push").With("server_name", serverName, "metric_name", value.Metric)
+	if value.Database != "" {
+		taskLog = taskLog.With("database_name", value.Database)
+	}
+	task := &collector.MetricTask{
+		ServerName:     serverName,
+		ServerID:       serverID,
+		MetricName:     value.Metric,
+		MetricID:       metricInfo.DbMetricID,
+		DatabaseName:   value.Database,
+		CollectionType: metricConfig.CollectionType,
+		ValueType:      metricConfig.ValueType,
+		StorageMode:    metricConfig.StorageMode,
+		Labels:         metricConfig.Labels,
+		Priority:       metricConfig.Priority,
+		QueryTimeout:   metricConfig.QueryTimeout.Duration,
+		Logger:         taskLog,
+		MetricsDB:      app.metricsDB,
+		Spool:          app.spool,
+		Sinks:          app.sinksFor(value.Metric),
+		SkipMetricsDB:  !app.config.Storage.WriteMetricsDB,
+		Limits:         app.limits,
+		DBHealth:       app.dbHealth,
+		TimeSource:     "target", // the time sent by the agent
+	}
+	if task.QueryTimeout == 0 {
+		task.QueryTimeout = app.config.Metrics.Global.DefaultQueryTimeout.Duration
+	}
+	if metricConfig.Kind == "counter" {
+		task.Counter = collector.NewCounterState()
+	}
+	if app.cachedMetrics[value.Metric] {
+		task.Cache = app.valueCache
+	}
+	if len(metricConfig.Split) > 0 {
+		task.Parts = app.bundleParts(task, metricConfig.Split)
+	}
+	receiver.tasks[key] = &pushTask{task: task}
+	return receiver.tasks[key], nil
+}
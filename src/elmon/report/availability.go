@@ -0,0 +1,117 @@
+// Package report computes reports over stored metric values, e.g. the monthly availability of servers,
+// and sends their summaries
+package report
+
+import (
+	"elmon/sql"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Month returns the start of the month containing t and the start of the next month in the location
+func Month(t time.Time, location *time.Location) (time.Time, time.Time) {
+	t = t.In(location)
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// sampleState reads a value of the up/down metric. A success key, stored by http probes, decides first,
+// then the value key: true or a number above 0 is up. A number is an uptime, restarts are found by it
+// falling. ok is false for values telling neither, they are not counted.
+func sampleState(value json.RawMessage) (up bool, uptime float64, ok bool) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(value, &object); err != nil {
+		return false, 0, false
+	}
+	raw, found := object["success"]
+	if !found {
+		raw = object["value"]
+	}
+	var state interface{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return false, 0, false
+	}
+	switch state := state.(type) {
+	case bool:
+		return state, 0, true
+	case float64:
+		return state > 0, state, true
+	}
+	return false, 0, false
+}
+
+// Availability computes the availability of a server in the period from its values of the up/down metric
+// in time order. A value covers the time up to the next one, at most maxGap. Time covered by no value is
+// unmonitored and doesn't count against availability. Values before start cover the start of the period.
+func Availability(serverName string, values []sql.ServerValue, start time.Time, end time.Time,
+	maxGap time.Duration, now time.Time) sql.AvailabilityReport {
+	report := sql.AvailabilityReport{
+		ServerName:  serverName,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Complete:    !now.Before(end),
+		ComputedAt:  now,
+	}
+	// The running month is reported up to now
+	until := end
+	if now.Before(until) {
+		until = now
+	}
+
+	var up, down time.Duration
+	inOutage := false // an outage reaching into the period was counted
+	previousUptime := 0.0
+	for i, value := range values {
+		isUp, uptime, ok := sampleState(value.Value)
+		if !ok {
+			continue
+		}
+		coveredUntil := value.Time.Add(maxGap)
+		if i+1 < len(values) && values[i+1].Time.Before(coveredUntil) {
+			coveredUntil = values[i+1].Time
+		}
+		covered := overlap(value.Time, coveredUntil, start, until)
+
+		if isUp {
+			up += covered
+			inOutage = false
+			// Uptime falling below the last one seen up means the server restarted in between
+			if !value.Time.Before(start) && value.Time.Before(until) && uptime < previousUptime {
+				report.Restarts++
+			}
+			previousUptime = uptime
+			continue
+		}
+		down += covered
+		if covered > 0 && !inOutage {
+			report.Outages++
+			inOutage = true
+		}
+	}
+
+	report.UpSeconds = int64(up.Seconds())
+	report.DownSeconds = int64(down.Seconds())
+	if length := until.Sub(start); length > up+down {
+		report.UnmonitoredSeconds = int64((length - up - down).Seconds())
+	}
+	if up+down > 0 {
+		percent := math.Round(float64(up)/float64(up+down)*1e6) / 1e4
+		report.Percent = &percent
+	}
+	return report
+}
+
+// overlap returns the length of the intersection of two time ranges
+func overlap(from time.Time, to time.Time, start time.Time, end time.Time) time.Duration {
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	if !from.Before(to) {
+		return 0
+	}
+	return to.Sub(from)
+}
@@ -0,0 +1,263 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"elmon/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Summary is the availability of all servers in a month, posted to the webhook as JSON
+type Summary struct {
+	Report      string          `json:"report"` // availability
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	Target      float64         `json:"target_percent"`
+	Complete    bool            `json:"complete"`
+	Servers     []ServerSummary `json:"servers"`
+}
+
+// ServerSummary is the availability of a server in the month of a Summary
+type ServerSummary struct {
+	Server             string   `json:"server"`
+	Percent            *float64 `json:"availability_percent"` // null when the server was not monitored
+	DownSeconds        int64    `json:"down_seconds"`
+	UnmonitoredSeconds int64    `json:"unmonitored_seconds"`
+	Outages            int      `json:"outages"`
+	Restarts           int      `json:"restarts"`
+	MeetsTarget        bool     `json:"meets_target"`
+}
+
+// NewSummary summarizes availability reports of a month against the target percentage
+func NewSummary(reports []sql.AvailabilityReport, start time.Time, end time.Time, target float64) Summary {
+	summary := Summary{Report: "availability", PeriodStart: start, PeriodEnd: end, Target: target, Complete: true,
+		Servers: []ServerSummary{}}
+	for _, report := range reports {
+		summary.Complete = summary.Complete && report.Complete
+		summary.Servers = append(summary.Servers, ServerSummary{
+			Server:             report.ServerName,
+			Percent:            report.Percent,
+			DownSeconds:        report.DownSeconds,
+			UnmonitoredSeconds: report.UnmonitoredSeconds,
+			Outages:            report.Outages,
+			Restarts:           report.Restarts,
+			MeetsTarget:        report.Percent != nil && *report.Percent >= target,
+		})
+	}
+	return summary
+}
+
+// Monitored reports whether any server of the summary was monitored in the month
+func (summary Summary) Monitored() bool {
+	for _, server := range summary.Servers {
+		if server.Percent != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Title names the report and its month, e.g. "Availability 2026-09"
+func (summary Summary) Title() string {
+	title := "Availability " + summary.PeriodStart.Format("2006-01")
+	if !summary.Complete {
+		title += " (month to date)"
+	}
+	return title
+}
+
+// WriteText writes the summary as a plain text table, servers below the target are marked
+func (summary Summary) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%s, %s to %s, target %s%%\n\n", summary.Title(),
+		summary.PeriodStart.Format(time.RFC3339), summary.PeriodEnd.Format(time.RFC3339),
+		strconv.FormatFloat(summary.Target, 'f', -1, 64))
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVER\tAVAILABILITY\tDOWNTIME\tUNMONITORED\tOUTAGES\tRESTARTS\t")
+	for _, server := range summary.Servers {
+		availability := "-"
+		if server.Percent != nil {
+			availability = strconv.FormatFloat(*server.Percent, 'f', -1, 64) + "%"
+			if !server.MeetsTarget {
+				availability += " !"
+			}
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%d\t\n", server.Server, availability,
+			time.Duration(server.DownSeconds)*time.Second, time.Duration(server.UnmonitoredSeconds)*time.Second,
+			server.Outages, server.Restarts)
+	}
+	return writer.Flush()
+}
+
+// Email defines the SMTP server mailing summaries
+type Email struct {
+	Host     string
+	Port     int
+	Username string // empty sends without authentication
+	Password string
+	From     string
+	To       []string
+}
+
+// Sender delivers summaries to a webhook and by email, either may be left out
+type Sender struct {
+	WebhookURL string
+	Headers    map[string]string
+	Email      *Email
+
+	client *http.Client
+}
+
+// Enabled reports whether summaries are delivered anywhere
+func (sender *Sender) Enabled() bool {
+	return sender.WebhookURL != "" || sender.Email != nil
+}
+
+// Delivery lists the channels a summary reached
+type Delivery struct {
+	Webhook bool
+	Email   bool
+}
+
+// Send posts the summary to the webhook and mails it, channels already in delivered are skipped.
+// Both channels are tried when one fails, the returned delivery includes the channels reached so far.
+func (sender *Sender) Send(ctx context.Context, summary Summary, delivered Delivery) (Delivery, error) {
+	var errs []error
+	if sender.WebhookURL != "" && !delivered.Webhook {
+		if err := sender.post(ctx, summary); err != nil {
+			errs = append(errs, err)
+		} else {
+			delivered.Webhook = true
+		}
+	}
+	if sender.Email != nil && !delivered.Email {
+		if err := sender.mail(ctx, summary); err != nil {
+			errs = append(errs, err)
+		} else {
+			delivered.Email = true
+		}
+	}
+	if len(errs) > 0 {
+		return delivered, fmt.Errorf("failed to send %s summary: %w", strings.ToLower(summary.Title()),
+			errors.Join(errs...))
+	}
+	return delivered, nil
+}
+
+// post sends the summary as JSON document
+func (sender *Sender) post(ctx context.Context, summary Summary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to serialize summary: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create summary request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range sender.Headers {
+		request.Header.Set(name, value)
+	}
+
+	if sender.client == nil {
+		sender.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send summary to webhook: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("summary webhook returned status %d: %s", response.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// mail sends the summary as a plain text email. The SMTP session ends with ctx or after mailTimeout,
+// whichever comes first, so an unresponsive server doesn't block the caller.
+func (sender *Sender) mail(ctx context.Context, summary Summary) error {
+	email := sender.Email
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", email.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&message, "Subject: elmon: %s\r\n", summary.Title())
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	var text bytes.Buffer
+	if err := summary.WriteText(&text); err != nil {
+		return err
+	}
+	message.WriteString(strings.ReplaceAll(text.String(), "\n", "\r\n"))
+
+	if err := sender.sendMail(ctx, message.Bytes()); err != nil {
+		return fmt.Errorf("failed to mail summary: %w", err)
+	}
+	return nil
+}
+
+// mailTimeout limits an SMTP session
+const mailTimeout = 30 * time.Second
+
+// sendMail does what smtp.SendMail does over a connection with a deadline
+func (sender *Sender) sendMail(ctx context.Context, message []byte) error {
+	email := sender.Email
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+	dialer := &net.Dialer{Timeout: mailTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(email.Host, strconv.Itoa(email.Port)))
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Cancellation of ctx interrupts a session waiting for the server
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, email.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: email.Host}); err != nil {
+			return err
+		}
+	}
+	if email.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", email.Username, email.Password, email.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(email.From); err != nil {
+		return err
+	}
+	for _, to := range email.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -3,7 +3,9 @@ package scheduler
 import (
 	"context"
 	"elmon/logger"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,6 +14,37 @@ import (
 // TaskFunc now accepts interface{}, making the scheduler universal
 type TaskFunc func(ctx context.Context, taskPayload interface{}) error
 
+// ErrPanic wraps panics recovered from task functions
+var ErrPanic = errors.New("task panicked")
+
+// Protect calls fn and turns its panic into an error wrapping ErrPanic, so a faulty task fails alone
+// instead of crashing the process. The panic is logged with its stack.
+func Protect(log *logger.Logger, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+			log.Error(err, "Task: Recovered from panic", "stack", string(debug.Stack()))
+		}
+	}()
+	return fn()
+}
+
+// taskIDKey is the context key of the current task cycle id
+type taskIDKey struct{}
+
+// TaskIDFromContext returns id of the task cycle the context belongs to
+func TaskIDFromContext(ctx context.Context) (uint64, bool) {
+	taskID, ok := ctx.Value(taskIDKey{}).(uint64)
+	return taskID, ok
+}
+
+// Missed tick policies decide what happens to a tick that comes while the previous run is still active
+const (
+	MissedTickSkip    = "skip"     // the tick is dropped and reported to OnSkip
+	MissedTickQueue   = "queue"    // every missed tick runs later, one after another
+	MissedTickRunLate = "run-late" // one run starts as soon as the active one ends, other ticks are dropped
+)
+
 type TaskScheduler struct {
 	Interval   time.Duration
 	MaxRetries int
@@ -20,14 +53,37 @@ type TaskScheduler struct {
 	Payload    interface{} // Task payload
 	Logger     *logger.Logger
 
+	// Runs of the task don't overlap unless AllowOverlap is set, MissedTick is one of the
+	// MissedTick* policies (skip when empty). OnSkip is called for every dropped tick.
+	AllowOverlap bool
+	MissedTick   string
+	OnSkip       func()
+
+	// After BackoffAfter failed runs in a row the interval doubles with every further failure, up to
+	// MaxBackoffInterval, until a run succeeds. 0 disables the backoff.
+	BackoffAfter       int
+	MaxBackoffInterval time.Duration
+
 	// Fields for atomic ID generation and tracking
 	taskIDCounter     uint64 // Atomically incremented counter for unique task IDs
 	currentTaskID     uint64 // ID of the currently running task, protected by mutex
 
 	ticker            *time.Ticker
+	startedAt         time.Time // ticks happen at startedAt + n*Interval
 	stopChan          chan struct{} // Used to signal the main runLoop to stop
 	isRunning         bool
 	isDisabled        bool
+	isPaused          bool // no runs start until Resume
+	activeRuns        int // runs started and not finished yet
+	pendingRuns       int // missed ticks waiting for the active run to end
+	skippedRuns       uint64 // ticks dropped while a run was active
+	lastRunAt         time.Time // start of the last finished run
+	lastDuration      time.Duration
+	lastError         error // of the last finished run, nil on success
+	failedRuns        int // finished runs failed in a row
+	panics            uint64 // runs ended by a panic of the task
+	backoffInterval   time.Duration // stretched interval while the task keeps failing, 0 otherwise
+	backoffTicks      int // ticks left to skip before the next run of the stretched interval
 	mutex             sync.Mutex // Protected state fields
 	currentTaskCancel context.CancelFunc // Used to abort the currently running task
 }
@@ -70,6 +126,7 @@ func (taskScheduler *TaskScheduler) Start() error {
 	}
 
 	taskScheduler.ticker = time.NewTicker(taskScheduler.Interval)
+	taskScheduler.startedAt = time.Now()
 
 	go taskScheduler.runLoop()
 
@@ -105,6 +162,7 @@ func (taskScheduler *TaskScheduler) Stop() {
 	}
 
 	// Signal the runLoop to exit
+	taskScheduler.pendingRuns = 0
 	close(taskScheduler.stopChan)
 	taskScheduler.isRunning = false
 	taskScheduler.stopChan = make(chan struct{}) // Re-initialize for potential future Start
@@ -126,6 +184,24 @@ func (taskScheduler *TaskScheduler) EnableExecution() {
 	taskScheduler.Logger.Info("TaskScheduler: Execution re-enabled.")
 }
 
+// Pause stops starting runs until Resume, runs in progress are not aborted. Missed ticks queued
+// before the pause are dropped.
+func (taskScheduler *TaskScheduler) Pause() {
+	taskScheduler.mutex.Lock()
+	defer taskScheduler.mutex.Unlock()
+	taskScheduler.isPaused = true
+	taskScheduler.pendingRuns = 0
+	taskScheduler.Logger.Info("TaskScheduler: Execution paused.")
+}
+
+// Resume starts runs again from the next tick
+func (taskScheduler *TaskScheduler) Resume() {
+	taskScheduler.mutex.Lock()
+	defer taskScheduler.mutex.Unlock()
+	taskScheduler.isPaused = false
+	taskScheduler.Logger.Info("TaskScheduler: Execution resumed.")
+}
+
 // AbortCurrentExecution attempts to cancel the currently running task
 func (taskScheduler *TaskScheduler) AbortCurrentExecution() {
 	taskScheduler.mutex.Lock()
@@ -140,6 +216,106 @@ func (taskScheduler *TaskScheduler) AbortCurrentExecution() {
 	}
 }
 
+// NextRun returns the time of the next scheduled execution, zero if the scheduler is not running
+func (taskScheduler *TaskScheduler) NextRun() time.Time {
+	taskScheduler.mutex.Lock()
+	defer taskScheduler.mutex.Unlock()
+	return taskScheduler.nextRun()
+}
+
+// State is a snapshot of the scheduler for inspection
+type State struct {
+	Running       bool // started and not stopped
+	Paused        bool // no runs start until Resume
+	Interval      time.Duration
+	ActiveRuns    int
+	PendingRuns   int
+	CurrentTaskID uint64 // id of the run started last while it runs, 0 when idle
+	SkippedRuns   uint64
+	LastRun       time.Time // start of the last finished run, zero before the first one ends
+	LastDuration  time.Duration
+	LastError     error
+	FailedRuns    int       // finished runs failed in a row
+	Panics        uint64    // runs ended by a panic since the start
+	Backoff       bool      // the interval is stretched because of failures
+	NextRun       time.Time // zero when not running
+}
+
+// State returns the current state of the scheduler
+func (taskScheduler *TaskScheduler) State() State {
+	taskScheduler.mutex.Lock()
+	defer taskScheduler.mutex.Unlock()
+
+	return State{
+		Running:       taskScheduler.isRunning,
+		Paused:        taskScheduler.isPaused,
+		Interval:      taskScheduler.Interval,
+		ActiveRuns:    taskScheduler.activeRuns,
+		PendingRuns:   taskScheduler.pendingRuns,
+		CurrentTaskID: taskScheduler.currentTaskID,
+		SkippedRuns:   taskScheduler.skippedRuns,
+		LastRun:       taskScheduler.lastRunAt,
+		LastDuration:  taskScheduler.lastDuration,
+		LastError:     taskScheduler.lastError,
+		FailedRuns:    taskScheduler.failedRuns,
+		Panics:        taskScheduler.panics,
+		Backoff:       taskScheduler.backoffInterval > 0,
+		NextRun:       taskScheduler.nextRun(),
+	}
+}
+
+// nextRun computes the next tick, caller holds the mutex
+func (taskScheduler *TaskScheduler) nextRun() time.Time {
+	if !taskScheduler.isRunning || taskScheduler.Interval <= 0 {
+		return time.Time{}
+	}
+	ticks := time.Since(taskScheduler.startedAt)/taskScheduler.Interval + 1
+	ticks += time.Duration(taskScheduler.backoffTicks)
+	return taskScheduler.startedAt.Add(ticks * taskScheduler.Interval)
+}
+
+// finishRun records the result of a run and stretches or restores the interval, caller holds the mutex
+func (taskScheduler *TaskScheduler) finishRun(startedAt time.Time, runErr error) {
+	taskScheduler.lastRunAt = startedAt
+	taskScheduler.lastDuration = time.Since(startedAt)
+	taskScheduler.lastError = runErr
+	if taskScheduler.BackoffAfter <= 0 {
+		return
+	}
+
+	if runErr == nil {
+		if taskScheduler.backoffInterval > 0 {
+			taskScheduler.Logger.Info("TaskScheduler: Task succeeded, interval restored.",
+				"interval", taskScheduler.Interval, "failed_runs", taskScheduler.failedRuns)
+		}
+		taskScheduler.failedRuns = 0
+		taskScheduler.backoffInterval = 0
+		taskScheduler.backoffTicks = 0
+		return
+	}
+	// Runs aborted by Stop or AbortCurrentExecution say nothing about the task
+	if errors.Is(runErr, context.Canceled) {
+		return
+	}
+	taskScheduler.failedRuns++
+	if taskScheduler.failedRuns < taskScheduler.BackoffAfter {
+		return
+	}
+
+	interval := taskScheduler.Interval
+	for i := taskScheduler.BackoffAfter; i <= taskScheduler.failedRuns && interval < taskScheduler.MaxBackoffInterval; i++ {
+		interval *= 2
+	}
+	interval = min(interval, max(taskScheduler.MaxBackoffInterval, taskScheduler.Interval))
+	if interval == taskScheduler.Interval {
+		return
+	}
+	taskScheduler.backoffInterval = interval
+	taskScheduler.backoffTicks = int(interval/taskScheduler.Interval) - 1
+	taskScheduler.Logger.Warn("TaskScheduler: Task keeps failing, interval stretched.",
+		"failed_runs", taskScheduler.failedRuns, "interval", taskScheduler.Interval, "effective_interval", interval)
+}
+
 // --- Execution Logic ---
 
 // runLoop is the main goroutine that manages the periodic scheduling
@@ -153,64 +329,145 @@ func (taskScheduler *TaskScheduler) runLoop() {
 		case <-taskScheduler.ticker.C:
 			taskScheduler.mutex.Lock()
 			isDisabled := taskScheduler.isDisabled
+			isPaused := taskScheduler.isPaused
+			backoff := !isPaused && !isDisabled && taskScheduler.backoffTicks > 0
+			if backoff {
+				taskScheduler.backoffTicks--
+			}
 			// Reset disable flag immediately after checking to ensure it only affects one run
-			taskScheduler.isDisabled = false
+			if !isPaused {
+				taskScheduler.isDisabled = false
+			}
 			taskScheduler.mutex.Unlock()
 
+			if isPaused {
+				taskScheduler.Logger.Debug("TaskScheduler: Execution skipped, the scheduler is paused.")
+				continue
+			}
 			if isDisabled {
 				taskScheduler.Logger.Info("TaskScheduler: Execution skipped due to DisableNextExecution flag.")
 				continue
 			}
+			if backoff {
+				taskScheduler.Logger.Debug("TaskScheduler: Execution skipped, the interval is stretched after failures.")
+				continue
+			}
 
-			// Generate a unique ID for this task cycle
-			newTaskID := atomic.AddUint64(&taskScheduler.taskIDCounter, 1)
-
-			taskCtx, taskCancel := context.WithCancel(context.Background())
-
-			// Store the cancel function AND the task ID in the struct
 			taskScheduler.mutex.Lock()
-			taskScheduler.currentTaskCancel = taskCancel
-			taskScheduler.currentTaskID = newTaskID
+			skipped := false
+			if taskScheduler.activeRuns > 0 && !taskScheduler.AllowOverlap {
+				switch taskScheduler.MissedTick {
+				case MissedTickQueue:
+					taskScheduler.pendingRuns++
+				case MissedTickRunLate:
+					skipped = taskScheduler.pendingRuns > 0
+					taskScheduler.pendingRuns = 1
+				default:
+					skipped = true
+				}
+				if skipped {
+					taskScheduler.skippedRuns++
+				}
+			} else {
+				taskScheduler.startExecution()
+			}
+			pendingRuns := taskScheduler.pendingRuns
 			taskScheduler.mutex.Unlock()
 
-			go taskScheduler.executeTaskWithRetries(taskCtx, taskCancel, newTaskID) // Pass ID to task
+			if skipped {
+				taskScheduler.Logger.Warn("TaskScheduler: Execution skipped, the previous run is still active.",
+					"task_id", taskScheduler.CurrentTaskID())
+				if taskScheduler.OnSkip != nil {
+					taskScheduler.OnSkip()
+				}
+			} else if pendingRuns > 0 {
+				taskScheduler.Logger.Debug("TaskScheduler: Execution delayed until the previous run ends.",
+					"pending_runs", pendingRuns)
+			}
 		}
 	}
 }
 
+// startExecution starts a task cycle in its own goroutine, caller holds the mutex
+func (taskScheduler *TaskScheduler) startExecution() {
+	// Generate a unique ID for this task cycle
+	newTaskID := atomic.AddUint64(&taskScheduler.taskIDCounter, 1)
+
+	taskCtx, taskCancel := context.WithCancel(context.Background())
+
+	// Store the cancel function AND the task ID in the struct
+	taskScheduler.currentTaskCancel = taskCancel
+	taskScheduler.currentTaskID = newTaskID
+	taskScheduler.activeRuns++
+
+	go taskScheduler.executeTaskWithRetries(taskCtx, taskCancel, newTaskID) // Pass ID to task
+}
+
+// CurrentTaskID returns id of the task cycle started last while it runs, 0 when idle
+func (taskScheduler *TaskScheduler) CurrentTaskID() uint64 {
+	taskScheduler.mutex.Lock()
+	defer taskScheduler.mutex.Unlock()
+	return taskScheduler.currentTaskID
+}
+
 // executeTaskWithRetries runs the task function with retry logic
 func (taskScheduler *TaskScheduler) executeTaskWithRetries(ctx context.Context, cancelFunc context.CancelFunc, taskID uint64) {
+	startedAt := time.Now()
+	var runErr error // result of the last attempt, reported by State
+
 	// Ensure the cancel function is cleared when this execution finishes, regardless of how it exits
 	defer func() {
 		cancelFunc() // Always call cancel to release context resources
 		taskScheduler.mutex.Lock()
+		taskScheduler.finishRun(startedAt, runErr)
 		// Only clear the reference if it is still pointing to *this* task's cancel function
 		if taskScheduler.currentTaskID == taskID {
 			taskScheduler.currentTaskCancel = nil
 			taskScheduler.currentTaskID = 0 // Clear the ID as well
 		}
+		taskScheduler.activeRuns--
+		// A missed tick runs right away unless the scheduler was stopped meanwhile
+		if taskScheduler.pendingRuns > 0 && taskScheduler.isRunning && !taskScheduler.isPaused {
+			taskScheduler.pendingRuns--
+			taskScheduler.startExecution()
+		}
 		taskScheduler.mutex.Unlock()
 	}()
 
-	taskScheduler.Logger.Debug("Task: Execution cycle started.")
+	// Every record of this cycle carries the task id, task functions read it from context
+	log := taskScheduler.Logger.With("task_id", taskID)
+	ctx = context.WithValue(ctx, taskIDKey{}, taskID)
+
+	log.Debug("Task: Execution cycle started.")
 
 	for attempt := 0; attempt <= taskScheduler.MaxRetries; attempt++ {
 		// Check for context cancellation (e.g., from AbortCurrentExecution or Stop)
 		if ctx.Err() != nil {
-			taskScheduler.Logger.Warn("Task: Aborted due to context cancellation",
+			log.Warn("Task: Aborted due to context cancellation",
 				"attempt", attempt+1,
 				"error", ctx.Err())
+			runErr = ctx.Err()
 			return
 		}
 
-		err := taskScheduler.Task(ctx, taskScheduler.Payload)
+		err := Protect(log, func() error {
+			return taskScheduler.Task(ctx, taskScheduler.Payload)
+		})
+		runErr = err
+		// A panic is not retried, the task is likely to panic again
+		if errors.Is(err, ErrPanic) {
+			taskScheduler.mutex.Lock()
+			taskScheduler.panics++
+			taskScheduler.mutex.Unlock()
+			return
+		}
 
 		if err == nil {
-			taskScheduler.Logger.Info("Task: Completed successfully.")
+			log.Info("Task: Completed successfully.")
 			return
 		}
 
-		taskScheduler.Logger.Error(err, "Task: Failed and requires retry",
+		log.Error(err, "Task: Failed and requires retry",
 			"attempt", attempt+1,
 			"max_attempts", taskScheduler.MaxRetries+1,
 			"error", err)
@@ -221,13 +478,14 @@ func (taskScheduler *TaskScheduler) executeTaskWithRetries(ctx context.Context,
 			case <-time.After(taskScheduler.RetryDelay):
 				// Wait finished, proceed to next retry
 			case <-ctx.Done():
-				taskScheduler.Logger.Warn("Task: Aborted during retry delay wait",
+				log.Warn("Task: Aborted during retry delay wait",
 					"error", ctx.Err())
+				runErr = ctx.Err()
 				return
 			}
 		}
 	}
 
-	taskScheduler.Logger.Error(fmt.Errorf("task: Failed permanently after all attempts"), "Scheduler task failed",
+	log.Error(fmt.Errorf("task: Failed permanently after all attempts"), "Scheduler task failed",
 		"max_attempts", taskScheduler.MaxRetries+1)
 }
\ No newline at end of file
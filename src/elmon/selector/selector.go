@@ -0,0 +1,254 @@
+// Package selector matches server tags against expressions such as
+// "role==primary && tier==prod" or "!(environment==test || tier==dev)"
+package selector
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Selector is a parsed selector
+type Selector struct {
+	text string
+	root node
+}
+
+// Parse parses selector text. Supported are tag comparisons key==value and key!=value, a bare key
+// matching servers which have the tag, operators && || ! and parentheses. Values containing spaces
+// or operator characters are written in quotes: region=="eu west".
+func Parse(text string) (*Selector, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().position)
+	}
+	return &Selector{text: text, root: root}, nil
+}
+
+func (s *Selector) String() string {
+	return s.text
+}
+
+// Matches reports whether tags satisfy the selector, tag names are expected in lower case
+func (s *Selector) Matches(tags map[string]string) bool {
+	return s.root.matches(tags)
+}
+
+// --- Evaluation ---
+
+type node interface {
+	matches(tags map[string]string) bool
+}
+
+// compareNode compares a tag, a missing tag equals no value
+type compareNode struct {
+	key, value string
+	equal      bool
+}
+
+func (n compareNode) matches(tags map[string]string) bool {
+	return (tags[n.key] == n.value) == n.equal
+}
+
+// hasNode matches servers with a non-empty tag
+type hasNode string
+
+func (n hasNode) matches(tags map[string]string) bool {
+	return tags[string(n)] != ""
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) matches(tags map[string]string) bool {
+	return !n.operand.matches(tags)
+}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicalNode) matches(tags map[string]string) bool {
+	if n.and {
+		return n.left.matches(tags) && n.right.matches(tags)
+	}
+	return n.left.matches(tags) || n.right.matches(tags)
+}
+
+// --- Parsing ---
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenOperator
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+// operators of selectors, two character operators first
+var operators = []string{"==", "!=", "&&", "||", "!", "(", ")"}
+
+// tokenize splits text into words (tag keys and values, quoted or not) and operators
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated quote at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start+1 : i]), position: start})
+			i++
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i]), position: start})
+		default:
+			operator := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, position: i})
+			i += len([]rune(operator))
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, position: len(runes)}), nil
+}
+
+// isWordRune reports whether r may appear in unquoted keys and values, e.g. app.kubernetes.io/name
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-./:", r)
+}
+
+type parser struct {
+	tokens   []token
+	position int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.position]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.position]
+	if t.kind != tokenEnd {
+		p.position++
+	}
+	return t
+}
+
+func (p *parser) isOperator(text string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == text
+}
+
+// parseOr parses terms joined with ||, && binds tighter
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	if p.isOperator("(") {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator(")") {
+			return nil, fmt.Errorf("expected ')' at position %d", p.peek().position)
+		}
+		p.next()
+		return inner, nil
+	}
+	return p.parseTerm()
+}
+
+// parseTerm parses key==value, key!=value or a bare key. Tag names are case-insensitive, values are not.
+func (p *parser) parseTerm() (node, error) {
+	key := p.next()
+	if key.kind != tokenWord {
+		if key.kind == tokenEnd {
+			return nil, fmt.Errorf("unexpected end of selector")
+		}
+		return nil, fmt.Errorf("expected tag name at position %d, got '%s'", key.position, key.text)
+	}
+	key.text = strings.ToLower(key.text)
+	if !p.isOperator("==") && !p.isOperator("!=") {
+		return hasNode(key.text), nil
+	}
+	operator := p.next()
+	value := p.next()
+	if value.kind != tokenWord {
+		return nil, fmt.Errorf("expected value of tag '%s' at position %d", key.text, value.position)
+	}
+	return compareNode{key: key.text, value: value.text, equal: operator.text == "=="}, nil
+}
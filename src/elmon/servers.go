@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"elmon/api"
+	"elmon/collector"
+	"elmon/config"
+	"elmon/sql"
+	"fmt"
+	"slices"
+)
+
+// addServer starts monitoring a server while the collector is running: the server is connected,
+// saved to metrics DB and metrics of the mapping are scheduled. Names must be unique.
+// Returns the number of scheduled tasks.
+func (app *application) addServer(metricCollector *collector.Collector, srvCfg config.DbConnectionConfig,
+	mapping config.ServerMetricsMapping) (int, error) {
+	app.serversMutex.Lock()
+	defer app.serversMutex.Unlock()
+
+	if _, exists := app.serverInfos[srvCfg.Name]; exists {
+		return 0, fmt.Errorf("%w: server '%s' is already monitored", api.ErrConflict, srvCfg.Name)
+	}
+	if !srvCfg.IsEnabled() {
+		return 0, fmt.Errorf("%w: server '%s' is disabled", api.ErrInvalid, srvCfg.Name)
+	}
+	mapping.Metrics = slices.Concat(mapping.Metrics, app.config.SelectedMetrics(&mapping, srvCfg.SelectorTags()))
+	app.registerServer(srvCfg, app.config.MetricsQueryTimeout(&mapping))
+
+	if err := app.connectServers(srvCfg.Name); err != nil {
+		app.closeServer(srvCfg.Name)
+		return 0, fmt.Errorf("%w: %w", api.ErrUnreachable, err)
+	}
+	info := app.serverInfos[srvCfg.Name]
+	if err := app.metricsDB.SaveServers(app.log, []*sql.ServerInfo{info}); err != nil {
+		app.closeServer(srvCfg.Name)
+		return 0, fmt.Errorf("failed to save server '%s' to metrics DB: %w", srvCfg.Name, err)
+	}
+
+	mapping.Name = srvCfg.Name
+	tasks, err := app.explainTasks(app.buildServerTasks(mapping, nil))
+	if err != nil {
+		app.closeServer(srvCfg.Name)
+		return 0, fmt.Errorf("%w: server '%s': %w", api.ErrInvalid, srvCfg.Name, err)
+	}
+	if err := metricCollector.AddTasks(tasks); err != nil {
+		app.closeServer(srvCfg.Name)
+		return 0, fmt.Errorf("failed to schedule metrics of server '%s': %w", srvCfg.Name, err)
+	}
+	if pool, ok := app.hostPools[srvCfg.Name]; ok {
+		if err := metricCollector.AddHostCheck(pool); err != nil {
+			metricCollector.RemoveServer(srvCfg.Name)
+			app.closeServer(srvCfg.Name)
+			return 0, fmt.Errorf("failed to schedule host checks of server '%s': %w", srvCfg.Name, err)
+		}
+	}
+	app.log.Info("Server added", "server_name", srvCfg.Name, "host", info.Host, "port", info.Port, "task_count", len(tasks))
+	return len(tasks), nil
+}
+
+// removeServer stops monitoring a server added with addServer and closes its connections.
+// Collected values and the server record are kept in metrics DB.
+func (app *application) removeServer(metricCollector *collector.Collector, serverName string) error {
+	app.serversMutex.Lock()
+	defer app.serversMutex.Unlock()
+
+	if _, exists := app.serverInfos[serverName]; !exists {
+		return fmt.Errorf("%w: server '%s' is not monitored", api.ErrNotFound, serverName)
+	}
+	removed := metricCollector.RemoveServer(serverName)
+	app.closeServer(serverName)
+	// Saving the server again activates it
+	_, err := app.metricsDB.DeactivateServers(context.Background(), func(name string) bool { return name != serverName })
+	if err != nil {
+		app.log.Warn("Failed to deactivate removed server", "server_name", serverName, "error", err)
+	}
+	app.log.Info("Server removed", "server_name", serverName, "task_count", removed)
+	return nil
+}
+
+// serverInfo returns information of a monitored server, nil when it is not monitored
+func (app *application) serverInfo(serverName string) *sql.ServerInfo {
+	app.serversMutex.Lock()
+	defer app.serversMutex.Unlock()
+	return app.serverInfos[serverName]
+}
+
+// isMonitored reports whether a server with the name is monitored
+func (app *application) isMonitored(serverName string) bool {
+	app.serversMutex.Lock()
+	defer app.serversMutex.Unlock()
+	_, exists := app.serverInfos[serverName]
+	return exists
+}
+
+// closeServer closes connections of a server and removes it from lookup maps
+func (app *application) closeServer(serverName string) {
+	for _, conn := range app.databaseConnections[serverName] {
+		if conn != app.connections[serverName] {
+			conn.Close()
+		}
+	}
+	delete(app.databaseConnections, serverName)
+	if pool, ok := app.hostPools[serverName]; ok {
+		// The connection of the server is one of the pool
+		pool.Close()
+		delete(app.hostPools, serverName)
+	} else if conn, ok := app.connections[serverName]; ok {
+		conn.Close()
+	}
+	delete(app.connections, serverName)
+	delete(app.serverParams, serverName)
+	delete(app.serverInfos, serverName)
+	delete(app.serverConfigs, serverName)
+	delete(app.serverBudgets, serverName)
+	delete(app.serverVersions, serverName)
+	delete(app.extensions, serverName)
+}
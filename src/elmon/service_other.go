@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runService runs the collector in the foreground until SIGINT or SIGTERM, e.g. from systemd, and stops
+// it gracefully. systemd is told about its state by sdNotify, ready has nothing to report.
+func runService(run func(ready func(), stop <-chan struct{})) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	// A second signal kills the process when the graceful stop hangs
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	run(func() {}, ctx.Done())
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	stdlog "log"
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// runService runs the collector as a Windows service when started by the service control manager,
+// and in the foreground until Ctrl+C otherwise
+func runService(run func(ready func(), stop <-chan struct{})) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		stdlog.Fatalf("FATAL: failed to detect Windows service: %v", err)
+	}
+	if !isService {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		run(func() {}, ctx.Done())
+		return
+	}
+	// The name is ignored for services running in their own process
+	if err := svc.Run("elmon", &windowsService{run: run}); err != nil {
+		stdlog.Fatalf("FATAL: Windows service failed: %v", err)
+	}
+}
+
+// windowsService reports the collector state to the service control manager and stops it on request
+type windowsService struct {
+	run func(ready func(), stop <-chan struct{})
+}
+
+func (service *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var readyOnce sync.Once
+	go func() {
+		defer close(done)
+		service.run(func() {
+			readyOnce.Do(func() { status <- svc.Status{State: svc.Running, Accepts: accepted} })
+		}, stop)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"hash/fnv"
+)
+
+// serverShard returns the shard of a server with rendezvous hashing: the shard with the highest
+// score for the server name. When the number of shards changes, only servers of added or removed
+// shards move to another shard.
+func serverShard(serverName string, shards int) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(serverName))
+	nameHash := hash.Sum64()
+
+	shard := 0
+	var highest uint64
+	for i := 0; i < shards; i++ {
+		if score := mix64(nameHash + uint64(i)*0x9e3779b97f4a7c15); i == 0 || score > highest {
+			shard, highest = i, score
+		}
+	}
+	return shard
+}
+
+// mix64 is the splitmix64 finalizer, it makes scores of neighbouring shards independent
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// inShard reports whether the server is collected by this instance, always true without sharding
+func (app *application) inShard(serverName string) bool {
+	sharding := app.config.Sharding
+	return sharding.Shards == 0 || serverShard(serverName, sharding.Shards) == sharding.Shard
+}
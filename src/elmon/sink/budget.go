@@ -0,0 +1,41 @@
+package sink
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// MemoryBudget limits memory of values buffered by sinks, shared by all sinks using it.
+// A nil budget is unlimited.
+type MemoryBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// NewMemoryBudget returns a budget of maxBytes, nil for 0
+func NewMemoryBudget(maxBytes int64) *MemoryBudget {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &MemoryBudget{max: maxBytes}
+}
+
+// reserve takes size bytes of the budget, fails when they don't fit
+func (b *MemoryBudget) reserve(size int) error {
+	if b == nil {
+		return nil
+	}
+	if used := b.used.Add(int64(size)); used > b.max {
+		b.used.Add(-int64(size))
+		return fmt.Errorf("buffered values exceed memory limit of %d bytes", b.max)
+	}
+	return nil
+}
+
+// release returns size bytes to the budget
+func (b *MemoryBudget) release(size int) {
+	if b == nil {
+		return
+	}
+	b.used.Add(-int64(size))
+}
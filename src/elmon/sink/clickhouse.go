@@ -0,0 +1,282 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"elmon/logger"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ClickHouseConfig defines ClickHouse HTTP interface connection and batching
+type ClickHouseConfig struct {
+	URL           string // HTTP interface, e.g. http://clickhouse:8123
+	Database      string
+	Table         string
+	Username      string
+	Password      string
+	BatchSize     int           // rows sent in one insert
+	FlushInterval time.Duration // maximum time a row waits for its batch
+	MaxBuffer     int           // rows kept while ClickHouse is unavailable, oldest are dropped above it
+	AsyncInsert   bool          // let the server buffer inserts as well (async_insert setting)
+	TTL           time.Duration // table TTL of new tables, 0 keeps values forever
+	Budget        *MemoryBudget // memory of buffered rows shared with other sinks, nil is unlimited
+}
+
+// ClickHouseSink buffers values and inserts them in batches with JSONEachRow format.
+// Write never waits for the server, failed batches are kept and retried with the next flush.
+type ClickHouseSink struct {
+	name   string
+	config ClickHouseConfig
+	client *http.Client
+	log    *logger.Logger
+
+	mutex   sync.Mutex
+	rows    [][]byte
+	flushCh chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// clickHouseRow is a metric_value row, columns are named like the PostgreSQL table
+type clickHouseRow struct {
+	Time                 string            `json:"time"`
+	ServerID             int               `json:"server_id"`
+	MetricID             int               `json:"metric_id"`
+	ServerName           string            `json:"server_name"`
+	MetricName           string            `json:"metric_name"`
+	DatabaseName         string            `json:"database_name"`
+	MetricValue          string            `json:"metric_value"` // JSON document as text, read with JSONExtract functions
+	CollectionDurationMs float64           `json:"collection_duration_ms"`
+	TargetTime           *string           `json:"target_time"`
+	Labels               map[string]string `json:"labels"`
+}
+
+// clickHouseTimeFormat is accepted by DateTime64 columns with the default input format
+const clickHouseTimeFormat = "2006-01-02 15:04:05.000000"
+
+// NewClickHouseSink creates the table if it doesn't exist and starts background flushing
+func NewClickHouseSink(name string, config ClickHouseConfig, log *logger.Logger) (*ClickHouseSink, error) {
+	s := &ClickHouseSink{
+		name:    name,
+		config:  config,
+		client:  &http.Client{Timeout: time.Minute},
+		log:     log,
+		flushCh: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.execute(ctx, s.createTableSQL(), nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to create clickhouse table: %w", err)
+	}
+	// Tables created before labels were supported
+	addLabelsSQL := fmt.Sprintf("alter table %s add column if not exists labels Map(LowCardinality(String), String)",
+		s.config.Table)
+	if err := s.execute(ctx, addLabelsSQL, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to add labels column to clickhouse table: %w", err)
+	}
+
+	go s.flushLoop()
+	return s, nil
+}
+
+// createTableSQL returns schema of the table, ordered for server/metric time range queries
+func (s *ClickHouseSink) createTableSQL() string {
+	ttl := ""
+	if s.config.TTL > 0 {
+		ttl = fmt.Sprintf("\n\t\tttl toDateTime(time) + interval %d second", int64(s.config.TTL.Seconds()))
+	}
+	return fmt.Sprintf(`
+		create table if not exists %s (
+			time DateTime64(6, 'UTC'),
+			server_id UInt32,
+			metric_id UInt32,
+			server_name LowCardinality(String),
+			metric_name LowCardinality(String),
+			database_name LowCardinality(String),
+			metric_value String,
+			collection_duration_ms Float64,
+			target_time Nullable(DateTime64(6, 'UTC')),
+			labels Map(LowCardinality(String), String)
+		)
+		engine = MergeTree
+		partition by toYYYYMM(time)
+		order by (server_id, metric_id, database_name, time)%s`, s.config.Table, ttl)
+}
+
+// Name returns sink name from configuration
+func (s *ClickHouseSink) Name() string {
+	return s.name
+}
+
+// Write adds value to the current batch
+func (s *ClickHouseSink) Write(ctx context.Context, value Value) error {
+	row := clickHouseRow{
+		Time:                 value.Time.UTC().Format(clickHouseTimeFormat),
+		ServerID:             value.ServerID,
+		MetricID:             value.MetricID,
+		ServerName:           value.ServerName,
+		MetricName:           value.MetricName,
+		DatabaseName:         value.DatabaseName,
+		MetricValue:          string(value.Value),
+		CollectionDurationMs: float64(value.Duration.Microseconds()) / 1000,
+		Labels:               value.Labels,
+	}
+	if row.Labels == nil {
+		row.Labels = map[string]string{} // Map columns are not nullable
+	}
+	if !value.TargetTime.IsZero() {
+		targetTime := value.TargetTime.UTC().Format(clickHouseTimeFormat)
+		row.TargetTime = &targetTime
+	}
+	line, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metric value: %w", err)
+	}
+
+	// Rows of a batch being sent can't be dropped, so new values are rejected instead
+	if err := s.config.Budget.reserve(len(line)); err != nil {
+		return fmt.Errorf("clickhouse sink '%s' dropped the value: %w", s.name, err)
+	}
+
+	s.mutex.Lock()
+	s.rows = append(s.rows, line)
+	full := len(s.rows) >= s.config.BatchSize
+	s.mutex.Unlock()
+
+	if full {
+		select {
+		case s.flushCh <- struct{}{}:
+		default: // flush is already requested
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered rows and stops background flushing
+func (s *ClickHouseSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// flushLoop sends batches when they are full or every flush interval
+func (s *ClickHouseSink) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		case <-s.flushCh:
+			s.flush()
+		}
+	}
+}
+
+// flush inserts buffered rows batch by batch, stops at the first failure keeping the rest
+func (s *ClickHouseSink) flush() {
+	for {
+		s.mutex.Lock()
+		count := min(len(s.rows), s.config.BatchSize)
+		batch := s.rows[:count]
+		s.mutex.Unlock()
+		if count == 0 {
+			return
+		}
+
+		body := bytes.Join(batch, []byte{'\n'})
+		query := fmt.Sprintf("insert into %s format JSONEachRow", s.config.Table)
+		settings := url.Values{}
+		if s.config.AsyncInsert {
+			settings.Set("async_insert", "1")
+			settings.Set("wait_for_async_insert", "1")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := s.execute(ctx, query, settings, body)
+		cancel()
+
+		s.mutex.Lock()
+		if err != nil {
+			dropped := s.trim()
+			s.mutex.Unlock()
+			s.log.Error(err, "Failed to insert batch into clickhouse, it will be retried",
+				"sink", s.name, "buffered_rows", len(s.rows), "dropped_rows", dropped)
+			return
+		}
+		// Rows appended during the insert stay after the sent batch
+		s.config.Budget.release(rowsSize(s.rows[:count]))
+		s.rows = s.rows[count:]
+		s.mutex.Unlock()
+	}
+}
+
+// trim drops the oldest rows above MaxBuffer, caller holds the mutex
+func (s *ClickHouseSink) trim() int {
+	if s.config.MaxBuffer <= 0 || len(s.rows) <= s.config.MaxBuffer {
+		return 0
+	}
+	dropped := len(s.rows) - s.config.MaxBuffer
+	s.config.Budget.release(rowsSize(s.rows[:dropped]))
+	s.rows = s.rows[dropped:]
+	return dropped
+}
+
+// rowsSize returns the number of buffered bytes of rows
+func rowsSize(rows [][]byte) int {
+	size := 0
+	for _, row := range rows {
+		size += len(row)
+	}
+	return size
+}
+
+// execute runs query with the HTTP interface, body is the data of insert queries
+func (s *ClickHouseSink) execute(ctx context.Context, query string, settings url.Values, body []byte) error {
+	params := url.Values{}
+	for name, values := range settings {
+		params[name] = values
+	}
+	params.Set("database", s.config.Database)
+
+	var reader io.Reader = bytes.NewReader(body)
+	if body == nil {
+		reader = bytes.NewReader([]byte(query))
+	} else {
+		params.Set("query", query)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL+"/?"+params.Encode(), reader)
+	if err != nil {
+		return fmt.Errorf("failed to create clickhouse request: %w", err)
+	}
+	if s.config.Username != "" {
+		request.Header.Set("X-ClickHouse-User", s.config.Username)
+		request.Header.Set("X-ClickHouse-Key", s.config.Password)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send clickhouse request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("clickhouse returned status %d: %s", response.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// KafkaConfig defines Kafka producer settings
+type KafkaConfig struct {
+	Brokers      []string
+	Topic        string
+	ClientID     string
+	RequiredAcks string        // none, one or all
+	BatchTimeout time.Duration // how long a message waits for other messages of the batch
+	TLS          bool
+	Username     string // SASL/PLAIN credentials, empty disables authentication
+	Password     string
+}
+
+// KafkaSink publishes values as JSON messages keyed by server, metric and database,
+// so values of one series go to the same partition in order
+type KafkaSink struct {
+	name   string
+	writer *kafka.Writer
+}
+
+// NewKafkaSink creates producer. Brokers are contacted on the first write.
+func NewKafkaSink(name string, config KafkaConfig) (*KafkaSink, error) {
+	var acks kafka.RequiredAcks
+	switch config.RequiredAcks {
+	case "none":
+		acks = kafka.RequireNone
+	case "one":
+		acks = kafka.RequireOne
+	case "all", "":
+		acks = kafka.RequireAll
+	default:
+		return nil, fmt.Errorf("invalid kafka required-acks: '%s'", config.RequiredAcks)
+	}
+
+	transport := &kafka.Transport{ClientID: config.ClientID}
+	if config.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if config.Username != "" {
+		transport.SASL = plain.Mechanism{Username: config.Username, Password: config.Password}
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Topic:        config.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		BatchTimeout: config.BatchTimeout,
+		Transport:    transport,
+	}
+	return &KafkaSink{name: name, writer: writer}, nil
+}
+
+// Name returns sink name from configuration
+func (s *KafkaSink) Name() string {
+	return s.name
+}
+
+// Write publishes value and waits for acknowledgement
+func (s *KafkaSink) Write(ctx context.Context, value Value) error {
+	payload, err := value.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to serialize metric value: %w", err)
+	}
+
+	err = s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(value.Key()),
+		Value: payload,
+		Time:  value.Time,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish metric value to kafka topic '%s': %w", s.writer.Topic, err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes connections
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
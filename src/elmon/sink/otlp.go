@@ -0,0 +1,248 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// OTLPConfig defines OTLP/HTTP metrics exporter settings
+type OTLPConfig struct {
+	Endpoint    string            // full URL, e.g. http://otel-collector:4318/v1/metrics
+	Headers     map[string]string // e.g. authentication headers
+	ServiceName string            // service.name resource attribute
+	Prefix      string            // metric name prefix
+}
+
+// OTLPSink converts values into OTLP gauges and pushes them with OTLP/HTTP JSON encoding.
+// Every number of the value JSON becomes a data point, booleans are sent as 0 and 1,
+// strings become attributes of the numbers next to them and below them.
+type OTLPSink struct {
+	name   string
+	config OTLPConfig
+	client *http.Client
+}
+
+// NewOTLPSink creates exporter, the endpoint is contacted on the first write
+func NewOTLPSink(name string, config OTLPConfig) *OTLPSink {
+	return &OTLPSink{name: name, config: config, client: &http.Client{}}
+}
+
+// Name returns sink name from configuration
+func (s *OTLPSink) Name() string {
+	return s.name
+}
+
+// Write converts value into an export request and sends it
+func (s *OTLPSink) Write(ctx context.Context, value Value) error {
+	request, err := s.exportRequest(value)
+	if err != nil {
+		return err
+	}
+	if request == nil {
+		return nil // value has no numbers
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to serialize OTLP request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	for name, headerValue := range s.config.Headers {
+		httpRequest.Header.Set(name, headerValue)
+	}
+
+	response, err := s.client.Do(httpRequest)
+	if err != nil {
+		return fmt.Errorf("failed to send OTLP request to '%s': %w", s.config.Endpoint, err)
+	}
+	defer response.Body.Close()
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint '%s' returned status %d: %s", s.config.Endpoint, response.StatusCode, responseBody)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (s *OTLPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// OTLP/HTTP JSON request, see opentelemetry-proto metrics_service.proto
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string          `json:"timeUnixNano"` // fixed64 is a string in protobuf JSON
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// exportRequest builds request with one gauge per number path, nil when there are no numbers
+func (s *OTLPSink) exportRequest(value Value) (*otlpExportRequest, error) {
+	var document interface{}
+	if err := json.Unmarshal(value.Value, &document); err != nil {
+		return nil, fmt.Errorf("metric value is not valid JSON: %w", err)
+	}
+
+	// Strings of the value override labels with the same name
+	attributes := maps.Clone(value.Labels)
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+	attributes["server_name"] = value.ServerName
+	if value.DatabaseName != "" {
+		attributes["database_name"] = value.DatabaseName
+	}
+
+	timeUnixNano := strconv.FormatInt(value.Time.UnixNano(), 10)
+	gauges := make(map[string]*otlpGauge)
+	var names []string
+	addPoint := func(path string, number float64, pointAttributes map[string]string) {
+		name := s.config.Prefix + "." + value.MetricName
+		// {"value": ...} is the usual single number metric
+		if path != "value" {
+			name += "." + path
+		}
+		gauge, ok := gauges[name]
+		if !ok {
+			gauge = &otlpGauge{}
+			gauges[name] = gauge
+			names = append(names, name)
+		}
+		gauge.DataPoints = append(gauge.DataPoints, otlpDataPoint{
+			Attributes:   toOTLPAttributes(pointAttributes),
+			TimeUnixNano: timeUnixNano,
+			AsDouble:     number,
+		})
+	}
+	collectPoints(document, "value", attributes, addPoint)
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var metrics []otlpMetric
+	for _, name := range names {
+		metrics = append(metrics, otlpMetric{Name: name, Gauge: *gauges[name]})
+	}
+	return &otlpExportRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpAttributeValue{StringValue: s.config.ServiceName}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "elmon"},
+			Metrics: metrics,
+		}},
+	}}}, nil
+}
+
+// collectPoints walks JSON document. Object keys extend the path, array elements keep it,
+// so rows of a table value become points of one gauge told apart by their string columns.
+func collectPoints(node interface{}, path string, attributes map[string]string,
+	addPoint func(path string, number float64, attributes map[string]string)) {
+	switch typed := node.(type) {
+	case float64:
+		addPoint(path, typed, attributes)
+	case bool:
+		if typed {
+			addPoint(path, 1, attributes)
+		} else {
+			addPoint(path, 0, attributes)
+		}
+	case []interface{}:
+		for _, element := range typed {
+			collectPoints(element, path, attributes, addPoint)
+		}
+	case map[string]interface{}:
+		// Strings describe the numbers of the same object
+		nested := make(map[string]string, len(attributes))
+		for name, attribute := range attributes {
+			nested[name] = attribute
+		}
+		for key, field := range typed {
+			if text, ok := field.(string); ok {
+				nested[key] = text
+			}
+		}
+
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := key
+			if path != "value" {
+				childPath = path + "." + key
+			}
+			collectPoints(typed[key], childPath, nested, addPoint)
+		}
+	}
+}
+
+// toOTLPAttributes converts attributes sorted by key
+func toOTLPAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, otlpAttribute{Key: key, Value: otlpAttributeValue{StringValue: attributes[key]}})
+	}
+	return result
+}
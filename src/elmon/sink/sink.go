@@ -0,0 +1,71 @@
+// Package sink streams collected metric values to outputs other than the metrics database
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Sink receives every collected metric value
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, value Value) error
+	Close() error
+}
+
+// Value is a collected metric value together with its origin
+type Value struct {
+	Time         time.Time
+	ServerName   string
+	MetricName   string
+	DatabaseName string // empty for server level metrics
+	ServerID     int
+	MetricID     int
+	Value        json.RawMessage
+	Labels       map[string]string // configured and returned labels, nil when there are none
+	Duration     time.Duration
+	TargetTime   time.Time // zero when unknown
+}
+
+// message is the JSON document sent to message based sinks
+type message struct {
+	Time                 time.Time         `json:"time"`
+	ServerName           string            `json:"server_name"`
+	MetricName           string            `json:"metric_name"`
+	DatabaseName         string            `json:"database_name,omitempty"`
+	ServerID             int               `json:"server_id"`
+	MetricID             int               `json:"metric_id"`
+	Value                json.RawMessage   `json:"value"`
+	Labels               map[string]string `json:"labels,omitempty"`
+	CollectionDurationMs float64           `json:"collection_duration_ms"`
+	TargetTime           *time.Time        `json:"target_time,omitempty"`
+}
+
+// Marshal returns JSON document of the value, fields are named like metric_value columns
+func (value Value) Marshal() ([]byte, error) {
+	doc := message{
+		Time:                 value.Time,
+		ServerName:           value.ServerName,
+		MetricName:           value.MetricName,
+		DatabaseName:         value.DatabaseName,
+		ServerID:             value.ServerID,
+		MetricID:             value.MetricID,
+		Value:                value.Value,
+		Labels:               value.Labels,
+		CollectionDurationMs: float64(value.Duration.Microseconds()) / 1000,
+	}
+	if !value.TargetTime.IsZero() {
+		doc.TargetTime = &value.TargetTime
+	}
+	return json.Marshal(doc)
+}
+
+// Key identifies the series of the value, values of one series keep their order
+func (value Value) Key() string {
+	key := value.ServerName + "/" + value.MetricName
+	if value.DatabaseName != "" {
+		key += "/" + value.DatabaseName
+	}
+	return key
+}
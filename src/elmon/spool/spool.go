@@ -0,0 +1,204 @@
+// Package spool keeps metric values that could not be written into the metrics database
+// in an append-only file until they are replayed
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrFull is returned by Append when the spool file reached its size limit
+var ErrFull = errors.New("spool is full")
+
+// fileName is the spool file inside the spool directory
+const fileName = "metric_value.jsonl"
+
+// Record is a metric value waiting to be written into metric_value table
+type Record struct {
+	Time         time.Time         `json:"time"` // collection time, stored instead of the insert time
+	ServerID     int               `json:"server_id"`
+	MetricID     int               `json:"metric_id"`
+	DatabaseName string            `json:"database_name,omitempty"`
+	Value        json.RawMessage   `json:"value"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Duration     time.Duration     `json:"duration"`
+	TargetTime   time.Time         `json:"target_time"` // zero when unknown
+}
+
+// Stats describes current spool depth
+type Stats struct {
+	Records int   // records waiting for replay
+	Bytes   int64 // spool file size
+	Dropped int64 // records rejected because the spool was full since start
+}
+
+// Spool is an append-only file of records, one JSON document per line
+type Spool struct {
+	path     string
+	maxBytes int64 // 0 means unlimited
+
+	mutex   sync.Mutex
+	records int
+	size    int64
+	dropped int64
+}
+
+// Open creates the spool directory if needed and counts records left by a previous run
+func Open(dir string, maxSizeMB int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	spool := &Spool{
+		path:     filepath.Join(dir, fileName),
+		maxBytes: int64(maxSizeMB) * 1024 * 1024,
+	}
+
+	content, err := os.ReadFile(spool.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read spool file: %w", err)
+	}
+	// A line cut by a crash would corrupt the next appended record
+	if complete := bytes.LastIndexByte(content, '\n') + 1; complete < len(content) {
+		content = content[:complete]
+		if err := os.Truncate(spool.path, int64(complete)); err != nil {
+			return nil, fmt.Errorf("failed to truncate spool file: %w", err)
+		}
+	}
+	spool.size = int64(len(content))
+	spool.records = bytes.Count(content, []byte{'\n'})
+
+	return spool, nil
+}
+
+// Append writes record to the end of the spool file
+func (spool *Spool) Append(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to serialize spool record: %w", err)
+	}
+	line = append(line, '\n')
+
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if spool.maxBytes > 0 && spool.size+int64(len(line)) > spool.maxBytes {
+		spool.dropped++
+		return ErrFull
+	}
+
+	file, err := os.OpenFile(spool.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+
+	spool.size += int64(len(line))
+	spool.records++
+	return nil
+}
+
+// Replay passes records to write in the order they were spooled and removes written ones.
+// Replay stops at the first write error, the failed record and the rest are kept.
+// Lines that can't be parsed (e.g. cut by a crash) are skipped.
+func (spool *Spool) Replay(ctx context.Context, write func(Record) error) (int, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if spool.records == 0 {
+		return 0, nil
+	}
+
+	file, err := os.Open(spool.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open spool file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	replayed := 0
+	var writeErr error
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Partial last line is dropped
+			offset += int64(len(line))
+			break
+		}
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read spool file: %w", err)
+		}
+
+		var record Record
+		if json.Unmarshal(line, &record) == nil {
+			if writeErr = ctx.Err(); writeErr != nil {
+				break
+			}
+			if writeErr = write(record); writeErr != nil {
+				break
+			}
+			replayed++
+		}
+		offset += int64(len(line))
+	}
+
+	if err := spool.truncate(file, offset); err != nil {
+		return replayed, err
+	}
+	return replayed, writeErr
+}
+
+// truncate removes the first offset bytes of the spool file
+func (spool *Spool) truncate(file *os.File, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek spool file: %w", err)
+	}
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read spool file: %w", err)
+	}
+
+	if len(rest) == 0 {
+		if err := os.Remove(spool.path); err != nil {
+			return fmt.Errorf("failed to remove spool file: %w", err)
+		}
+	} else {
+		// Rewrite through a temporary file, so a crash never leaves a partial spool
+		tmpPath := spool.path + ".tmp"
+		if err := os.WriteFile(tmpPath, rest, 0o644); err != nil {
+			return fmt.Errorf("failed to write spool file: %w", err)
+		}
+		if err := os.Rename(tmpPath, spool.path); err != nil {
+			return fmt.Errorf("failed to replace spool file: %w", err)
+		}
+	}
+
+	spool.size = int64(len(rest))
+	spool.records = bytes.Count(rest, []byte{'\n'})
+	return nil
+}
+
+// Stats returns current spool depth
+func (spool *Spool) Stats() Stats {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	return Stats{Records: spool.records, Bytes: spool.size, Dropped: spool.dropped}
+}
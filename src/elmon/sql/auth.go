@@ -0,0 +1,265 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Authentication types, token types replace the password with a short-lived token
+const (
+	AuthPassword = "password" // static password, ~/.pgpass is used on PostgreSQL when it is empty
+	AuthAWSIAM   = "aws-iam"  // AWS RDS IAM authentication token
+	AuthGCPIAM   = "gcp-iam"  // GCP Cloud SQL IAM login, OAuth2 token of the metadata server
+	AuthCommand  = "command"  // token printed by an external command
+)
+
+// AuthParams defines how the connection password is obtained
+type AuthParams struct {
+	Type          string
+	Region        string        // aws-iam: region of the instance, default: AWS_REGION
+	Command       string        // command: executable printing the token
+	CommandArgs   []string      // command: arguments of the executable
+	TokenLifetime time.Duration // command: validity of a printed token
+	RefreshBefore time.Duration // a new token is requested this long before expiry
+}
+
+// IsToken reports whether connections authenticate with generated tokens
+func (auth AuthParams) IsToken() bool {
+	return auth.Type != "" && auth.Type != AuthPassword
+}
+
+// awsTokenLifetime is the validity of RDS IAM tokens
+const awsTokenLifetime = 15 * time.Minute
+
+// gcpMetadataTokenURL returns OAuth2 tokens of the service account attached to the instance or pod
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// commandTokenTimeout limits token commands, so a hanging command doesn't block new connections forever
+const commandTokenTimeout = 30 * time.Second
+
+// openTokenDB opens a connection pool getting a new token for connections opened after the
+// previous one is about to expire. Connections already open are not affected by token expiry.
+func openTokenDB(params ConnectionParams) (*sql.DB, error) {
+	connector := &tokenConnector{params: params}
+	switch params.Auth.Type {
+	case AuthAWSIAM:
+		connector.source = awsIAMToken
+	case AuthGCPIAM:
+		connector.source = gcpIAMToken
+	case AuthCommand:
+		connector.source = commandToken
+	default:
+		return nil, fmt.Errorf("unsupported auth type: '%s'", params.Auth.Type)
+	}
+	switch sqlDriverName(params) {
+	case DriverPostgres:
+		connector.driver = &pq.Driver{}
+	case DriverMySQL:
+		connector.driver = &mysql.MySQLDriver{}
+	default:
+		return nil, fmt.Errorf("auth type '%s' is not supported by driver '%s'", params.Auth.Type, params.Driver)
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// tokenSource returns a token for params and the time it expires
+type tokenSource func(ctx context.Context, params ConnectionParams) (string, time.Time, error)
+
+// tokenConnector connects with the current token, the driver connector is rebuilt with every new token
+type tokenConnector struct {
+	params ConnectionParams
+	source tokenSource
+	driver driver.Driver
+
+	mutex     sync.Mutex
+	connector driver.Connector
+	expires   time.Time
+}
+
+func (connector *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	current, err := connector.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return current.Connect(ctx)
+}
+
+func (connector *tokenConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+// current returns the driver connector, requesting a new token when the current one expires soon
+func (connector *tokenConnector) current(ctx context.Context) (driver.Connector, error) {
+	connector.mutex.Lock()
+	defer connector.mutex.Unlock()
+	if connector.connector != nil && time.Until(connector.expires) > connector.params.Auth.RefreshBefore {
+		return connector.connector, nil
+	}
+
+	token, expires, err := connector.source(ctx, connector.params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s auth token: %w", connector.params.Auth.Type, err)
+	}
+	params := connector.params
+	params.Password = token
+	dsn, err := BuildDSN(params)
+	if err != nil {
+		return nil, err
+	}
+	current, err := newConnector(params, dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.connector, connector.expires = current, expires
+	return current, nil
+}
+
+// newConnector returns a driver connector for a composed connection string
+func newConnector(params ConnectionParams, dsn string) (driver.Connector, error) {
+	if sqlDriverName(params) == DriverMySQL {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewConnector(cfg)
+	}
+	switch params.SslMode {
+	case "allow":
+		return newAllowConnector(params)
+	case "prefer":
+		return newPreferConnector(params)
+	default:
+		return pq.NewConnector(dsn)
+	}
+}
+
+// awsIAMToken generates an RDS IAM authentication token, a presigned connect request signed with
+// AWS Signature Version 4. Credentials are read from the standard AWS environment variables.
+func awsIAMToken(_ context.Context, params ConnectionParams) (string, time.Time, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return "", time.Time{}, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+	region := params.Auth.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return "", time.Time{}, fmt.Errorf("region is not set")
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/rds-db/aws4_request"
+	host := net.JoinHostPort(params.Host, strconv.Itoa(params.Port))
+
+	query := url.Values{}
+	query.Set("Action", "connect")
+	query.Set("DBUser", params.User)
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(awsTokenLifetime.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if sessionToken := os.Getenv("AWS_SESSION_TOKEN"); sessionToken != "" {
+		query.Set("X-Amz-Security-Token", sessionToken)
+	}
+	// Signature V4 escapes spaces as %20
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	emptyPayload := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET", "/", canonicalQuery, "host:" + host + "\n", "host", hex.EncodeToString(emptyPayload[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "rds-db", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return host + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature, now.Add(awsTokenLifetime), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// gcpIAMToken requests an OAuth2 access token of the attached service account from the GCE or
+// GKE metadata server, Cloud SQL accepts it as the password of IAM database users
+func gcpIAMToken(ctx context.Context, _ ConnectionParams) (string, time.Time, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("metadata server request failed: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("metadata server returned %s", response.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // in seconds
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid metadata server response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("metadata server returned no access token")
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+// commandToken runs the configured command, its trimmed stdout is the token
+func commandToken(ctx context.Context, params ConnectionParams) (string, time.Time, error) {
+	cmdCtx, cancel := context.WithTimeout(ctx, commandTokenTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(cmdCtx, params.Auth.Command, params.Auth.CommandArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return "", time.Time{}, fmt.Errorf("command '%s' failed: %w", params.Auth.Command, err)
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("command '%s' printed no token", params.Auth.Command)
+	}
+	return token, time.Now().Add(params.Auth.TokenLifetime), nil
+}
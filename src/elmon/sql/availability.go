@@ -0,0 +1,187 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ServerValue is a value of a metric of a server, read for reports computed over all servers
+type ServerValue struct {
+	ServerName string
+	Time       time.Time
+	Value      json.RawMessage
+}
+
+// AvailabilityReport is a row of availability_report, the availability of a server in a month
+type AvailabilityReport struct {
+	ServerName         string
+	PeriodStart        time.Time
+	PeriodEnd          time.Time
+	Percent            *float64 // nil when the server was not monitored in the period
+	UpSeconds          int64
+	DownSeconds        int64
+	UnmonitoredSeconds int64
+	Outages            int
+	Restarts           int
+	Complete           bool // the period has ended
+	ComputedAt         time.Time
+	NotifiedAt         *time.Time // summary of the period sent to every channel
+	WebhookNotifiedAt  *time.Time // summary of the period posted to the webhook
+	EmailNotifiedAt    *time.Time // summary of the period mailed
+}
+
+// ReadServerMetricValues returns server level values of a metric stored from from up to to, of every active server,
+// ordered by server name and time
+func ReadServerMetricValues(ctx context.Context, db *sql.DB, metricName string, from time.Time,
+	to time.Time) ([]ServerValue, error) {
+	metricID, err := lookupID(ctx, db, `select metric_id from metric where metric_name = $1;`, "metric", metricName)
+	if err != nil {
+		return nil, err
+	}
+	const selectSQL = `
+		select s.name, mv.time, mv.metric_value
+		from metric_value mv
+		inner join server s on s.server_id = mv.server_id
+		where mv.metric_id = $1 and mv.database_name = '' and mv.time >= $2 and mv.time < $3 and s.is_active
+		order by s.name, mv.time;`
+
+	rows, err := db.QueryContext(ctx, selectSQL, metricID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metric values: %w", err)
+	}
+	defer rows.Close()
+
+	var values []ServerValue
+	for rows.Next() {
+		var value ServerValue
+		var metricValue string
+		if err := rows.Scan(&value.ServerName, &value.Time, &metricValue); err != nil {
+			return nil, fmt.Errorf("failed to scan metric values: %w", err)
+		}
+		value.Value = json.RawMessage(metricValue)
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metric values: %w", err)
+	}
+	return values, nil
+}
+
+// SaveAvailabilityReports inserts or replaces availability of servers, the time the summary was sent is kept
+func SaveAvailabilityReports(ctx context.Context, db *sql.DB, reports []AvailabilityReport) error {
+	const upsertSQL = `
+		insert into availability_report (server_id, period_start, period_end, availability_percent, up_seconds,
+			down_seconds, unmonitored_seconds, outages, restarts, complete, computed_at)
+		select server_id, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		from server
+		where name = $1
+		on conflict (server_id, period_start) do update set
+			period_end = excluded.period_end,
+			availability_percent = excluded.availability_percent,
+			up_seconds = excluded.up_seconds,
+			down_seconds = excluded.down_seconds,
+			unmonitored_seconds = excluded.unmonitored_seconds,
+			outages = excluded.outages,
+			restarts = excluded.restarts,
+			complete = excluded.complete,
+			computed_at = excluded.computed_at;`
+
+	return RunInTx(ctx, db, func(tx *sql.Tx) error {
+		for _, report := range reports {
+			var percent sql.NullFloat64
+			if report.Percent != nil {
+				percent = sql.NullFloat64{Float64: *report.Percent, Valid: true}
+			}
+			_, err := tx.ExecContext(ctx, upsertSQL, report.ServerName, report.PeriodStart.UTC(), report.PeriodEnd.UTC(),
+				percent, report.UpSeconds, report.DownSeconds, report.UnmonitoredSeconds, report.Outages, report.Restarts,
+				report.Complete, report.ComputedAt.UTC())
+			if err != nil {
+				return fmt.Errorf("failed to save availability of server '%s': %w", report.ServerName, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ReadAvailabilityReports returns availability of active servers in the period starting at periodStart,
+// ordered by server name
+func ReadAvailabilityReports(ctx context.Context, db *sql.DB, periodStart time.Time) ([]AvailabilityReport, error) {
+	const selectSQL = `
+		select s.name, ar.period_start, ar.period_end, ar.availability_percent, ar.up_seconds, ar.down_seconds,
+			ar.unmonitored_seconds, ar.outages, ar.restarts, ar.complete, ar.computed_at, ar.notified_at,
+			ar.webhook_notified_at, ar.email_notified_at
+		from availability_report ar
+		inner join server s on s.server_id = ar.server_id
+		where ar.period_start = $1 and s.is_active
+		order by s.name;`
+
+	rows, err := db.QueryContext(ctx, selectSQL, periodStart.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query availability reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []AvailabilityReport
+	for rows.Next() {
+		var report AvailabilityReport
+		var percent sql.NullFloat64
+		var notifiedAt, webhookNotifiedAt, emailNotifiedAt sql.NullTime
+		err := rows.Scan(&report.ServerName, &report.PeriodStart, &report.PeriodEnd, &percent, &report.UpSeconds,
+			&report.DownSeconds, &report.UnmonitoredSeconds, &report.Outages, &report.Restarts, &report.Complete,
+			&report.ComputedAt, &notifiedAt, &webhookNotifiedAt, &emailNotifiedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan availability reports: %w", err)
+		}
+		if percent.Valid {
+			report.Percent = &percent.Float64
+		}
+		if notifiedAt.Valid {
+			report.NotifiedAt = &notifiedAt.Time
+		}
+		if webhookNotifiedAt.Valid {
+			report.WebhookNotifiedAt = &webhookNotifiedAt.Time
+		}
+		if emailNotifiedAt.Valid {
+			report.EmailNotifiedAt = &emailNotifiedAt.Time
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read availability reports: %w", err)
+	}
+	return reports, nil
+}
+
+// MarkAvailabilityNotified records that the summary of the period starting at periodStart was sent
+func MarkAvailabilityNotified(ctx context.Context, db *sql.DB, periodStart time.Time, notifiedAt time.Time) error {
+	_, err := db.ExecContext(ctx, `update availability_report set notified_at = $1 where period_start = $2;`,
+		notifiedAt.UTC(), periodStart.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to mark availability report notified: %w", err)
+	}
+	return nil
+}
+
+// MarkAvailabilityDelivered records the channels, "webhook" or "email", the summary of the period starting
+// at periodStart reached, so a failed channel is retried without sending it again to the others
+func MarkAvailabilityDelivered(ctx context.Context, db *sql.DB, periodStart time.Time, channel string,
+	deliveredAt time.Time) error {
+	var column string
+	switch channel {
+	case "webhook":
+		column = "webhook_notified_at"
+	case "email":
+		column = "email_notified_at"
+	default:
+		return fmt.Errorf("unknown summary channel '%s'", channel)
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`update availability_report set %s = $1 where period_start = $2;`, column),
+		deliveredAt.UTC(), periodStart.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to mark availability summary delivered to %s: %w", channel, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ConfigVersion is the configuration an instance started with
+type ConfigVersion struct {
+	Time       time.Time
+	Instance   string          // instance id, versions of an instance are compared with each other
+	AppVersion string          // elmon build version
+	Hash       string          // SHA-256 of Settings
+	Summary    string          // changes since the previous version of the instance in a line
+	Changes    json.RawMessage // changed keys with their old and new values, null for the first version
+	Settings   json.RawMessage // flattened configuration with secrets redacted
+}
+
+// SaveConfigVersion adds a configuration version
+func SaveConfigVersion(ctx context.Context, db *sql.DB, version ConfigVersion) error {
+	const insertSQL = `
+		insert into config_version (time, instance, app_version, config_hash, summary, changes, settings)
+		values ($1, $2, $3, $4, $5, $6, $7);`
+
+	var changes sql.NullString
+	if version.Changes != nil {
+		changes = sql.NullString{String: string(version.Changes), Valid: true}
+	}
+	_, err := db.ExecContext(ctx, insertSQL, version.Time, version.Instance, version.AppVersion, version.Hash,
+		version.Summary, changes, string(version.Settings))
+	if err != nil {
+		return fmt.Errorf("failed to save configuration version: %w", err)
+	}
+	return nil
+}
+
+// LastConfigVersion returns the latest configuration version of the instance, nil when there is none
+func LastConfigVersion(ctx context.Context, db *sql.DB, instance string) (*ConfigVersion, error) {
+	const selectSQL = `
+		select time, app_version, config_hash, summary, settings
+		from config_version
+		where instance = $1
+		order by config_version_id desc
+		limit 1;`
+
+	version := ConfigVersion{Instance: instance}
+	var settings string
+	err := db.QueryRowContext(ctx, selectSQL, instance).Scan(&version.Time, &version.AppVersion, &version.Hash,
+		&version.Summary, &settings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last configuration version: %w", err)
+	}
+	version.Settings = json.RawMessage(settings)
+	return &version, nil
+}
@@ -5,31 +5,17 @@ import (
 	"elmon/logger"
 	"fmt"
 	"time"
-
-	_ "github.com/lib/pq"
 )
 
 // Connect now accepts local ConnectionParams type and doesn't depend on config
 func Connect(log *logger.Logger, params ConnectionParams) (*sql.DB, error) {
 
-	if params.SslMode == "" {
-		params.SslMode = "disable"
-	}
-
-	connectionString := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		params.Host, params.Port, params.User, params.Password, params.DbName, params.SslMode)
-
-	connection, err := sql.Open("postgres", connectionString)
+	connection, err := Open(params)
 	if err != nil {
 		log.Error(err, "error while opening database connection")
 		return nil, err
 	}
 
-	connection.SetMaxOpenConns(params.MaxOpenConnections)
-	connection.SetMaxIdleConns(params.MaxIdleConnections)
-	connection.SetConnMaxLifetime(time.Duration(params.ConnectionMaxLifetime) * time.Second)
-	connection.SetConnMaxIdleTime(time.Duration(params.ConnectionMaxIdleTime) * time.Second)
-
 	// Test connection
 	if err := connection.Ping(); err != nil {
 		log.Error(err, "error pinging database")
@@ -40,6 +26,25 @@ func Connect(log *logger.Logger, params ConnectionParams) (*sql.DB, error) {
 	return connection, nil
 }
 
+// Open creates the connection pool of params without connecting, so the server may be down
+func Open(params ConnectionParams) (*sql.DB, error) {
+	connectionString, err := BuildDSN(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build connection string: %w", err)
+	}
+
+	connection, err := openDB(params, connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	connection.SetMaxOpenConns(params.MaxOpenConnections)
+	connection.SetMaxIdleConns(params.MaxIdleConnections)
+	connection.SetConnMaxLifetime(time.Duration(params.ConnectionMaxLifetime) * time.Second)
+	connection.SetConnMaxIdleTime(time.Duration(params.ConnectionMaxIdleTime) * time.Second)
+	return connection, nil
+}
+
 // ConnectAll now accepts slice of local ConnectionParams
 func ConnectAll(log *logger.Logger, serverParams []ConnectionParams) (map[string]*sql.DB, error) {
 	connections := make(map[string]*sql.DB)
@@ -54,8 +59,15 @@ func ConnectAll(log *logger.Logger, serverParams []ConnectionParams) (map[string
 			return nil, fmt.Errorf("failed to connect to server %s: %w", serverName, err)
 		}
 		connections[serverName] = conn
-		log.Info("Successfully connected", "server", serverName)
+		log.Info("Successfully connected", "server_name", serverName)
 	}
 
 	return connections, nil
-}
\ No newline at end of file
+}
+
+// ResetIdleConnections closes idle connections of the pool and allows maxIdle of them again,
+// so connections broken by a database restart are not reused
+func ResetIdleConnections(db *sql.DB, maxIdle int) {
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(maxIdle)
+}
@@ -0,0 +1,91 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SQL queries listing user databases per driver
+const (
+	SQLDiscoverPostgresDatabases = `
+		select datname
+		from pg_database
+		where not datistemplate
+		  and datallowconn
+		order by datname
+	`
+	SQLDiscoverMySQLDatabases = `
+		select schema_name
+		from information_schema.schemata
+		where schema_name not in ('mysql', 'information_schema', 'performance_schema', 'sys')
+		order by schema_name
+	`
+)
+
+// DiscoverDatabases returns names of all non-template databases on the server
+// except the ones listed in exclude
+func DiscoverDatabases(db *sql.DB, driver string, exclude []string, timeout time.Duration) ([]string, error) {
+	query := SQLDiscoverPostgresDatabases
+	if driver == DriverMySQL {
+		query = SQLDiscoverMySQLDatabases
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database list: %w", err)
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan database name: %w", err)
+		}
+		if slices.Contains(exclude, name) {
+			continue
+		}
+		databases = append(databases, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during database list iteration: %w", err)
+	}
+
+	return databases, nil
+}
+
+// ConnectDatabases opens a connection to every listed database of the server.
+// The server's own connection is reused for its configured database.
+func ConnectDatabases(log *logger.Logger, params ConnectionParams, serverConnection *sql.DB, databases []string) (map[string]*sql.DB, error) {
+	connections := make(map[string]*sql.DB)
+	for _, database := range databases {
+		if database == params.DbName {
+			connections[database] = serverConnection
+			continue
+		}
+
+		dbParams := params
+		dbParams.DbName = database
+		conn, err := Connect(log, dbParams)
+		if err != nil {
+			// In case of error, close connections opened here
+			for name, c := range connections {
+				if name != params.DbName {
+					c.Close()
+				}
+			}
+			return nil, fmt.Errorf("failed to connect to database %s on server %s: %w", database, params.Name, err)
+		}
+		connections[database] = conn
+		log.Info("Successfully connected", "server_name", params.Name, "database", database)
+	}
+
+	return connections, nil
+}
@@ -0,0 +1,290 @@
+package sql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Supported database drivers for monitored servers, SQLite is used for metrics database only.
+// PgBouncer admin console is reached through the PostgreSQL driver.
+const (
+	DriverPostgres  = "postgres"
+	DriverMySQL     = "mysql"
+	DriverSQLite    = "sqlite"
+	DriverPgBouncer = "pgbouncer"
+)
+
+// BuildDSN composes a driver specific connection string from connection parameters
+func BuildDSN(params ConnectionParams) (string, error) {
+	if params.DSN != "" {
+		return params.DSN, nil
+	}
+
+	switch params.Driver {
+	case "", DriverPostgres:
+		return buildPostgresDSN(params), nil
+	case DriverMySQL:
+		return buildMySQLDSN(params)
+	case DriverSQLite:
+		return buildSQLiteDSN(params), nil
+	case DriverPgBouncer:
+		return buildPgBouncerDSN(params), nil
+	default:
+		return "", fmt.Errorf("unsupported database driver: '%s'", params.Driver)
+	}
+}
+
+// DriverName returns the database/sql driver name for the connection parameters
+func DriverName(params ConnectionParams) string {
+	if params.Driver == "" {
+		return DriverPostgres
+	}
+	return params.Driver
+}
+
+// sqlDriverName returns the name the driver is registered with in database/sql
+func sqlDriverName(params ConnectionParams) string {
+	if params.Driver == DriverPgBouncer {
+		return DriverPostgres
+	}
+	return DriverName(params)
+}
+
+// ParseDSNAddress extracts host, port and database name from a verbatim DSN.
+// Values that cannot be determined are returned empty, port falls back to the driver default.
+func ParseDSNAddress(driver string, dsn string) (host string, port int, dbName string) {
+	if driver == DriverMySQL {
+		port = 3306
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", port, ""
+		}
+		host = cfg.Addr
+		if h, p, err := net.SplitHostPort(cfg.Addr); err == nil {
+			host = h
+			if n, err := strconv.Atoi(p); err == nil {
+				port = n
+			}
+		}
+		return host, port, cfg.DBName
+	}
+
+	host, port = "localhost", 5432
+	if driver == DriverPgBouncer {
+		port = 6432
+	}
+	// URL form is converted to key=value form by lib/pq
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		converted, err := pq.ParseURL(dsn)
+		if err != nil {
+			return "", port, ""
+		}
+		dsn = converted
+	}
+	for _, field := range strings.Fields(dsn) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, "'")
+		switch key {
+		case "host":
+			host = value
+		case "port":
+			if n, err := strconv.Atoi(value); err == nil {
+				port = n
+			}
+		case "dbname":
+			dbName = value
+		}
+	}
+	return host, port, dbName
+}
+
+// buildPostgresDSN composes a lib/pq key=value connection string
+func buildPostgresDSN(params ConnectionParams) string {
+	sslMode := params.SslMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	// A host starting with / is a unix socket directory, lib/pq connects to <host>/.s.PGSQL.<port> then
+	dsn := fmt.Sprintf("host=%s user=%s dbname=%s sslmode=%s",
+		quoteDSNValue(params.Host), quoteDSNValue(params.User), quoteDSNValue(params.DbName), sslMode)
+	// Without a port key lib/pq uses 5432
+	if params.Port > 0 {
+		dsn += fmt.Sprintf(" port=%d", params.Port)
+	}
+
+	// Without a password key lib/pq looks the password up in ~/.pgpass or PGPASSFILE
+	if params.Password != "" {
+		dsn += " password=" + quoteDSNValue(params.Password)
+	}
+
+	if params.SslRootCert != "" {
+		dsn += " sslrootcert=" + quoteDSNValue(params.SslRootCert)
+	}
+	if params.SslCert != "" {
+		dsn += " sslcert=" + quoteDSNValue(params.SslCert)
+	}
+	if params.SslKey != "" {
+		dsn += " sslkey=" + quoteDSNValue(params.SslKey)
+	}
+	// Used when the server asks for GSSAPI authentication
+	if params.KrbSrvName != "" {
+		dsn += " krbsrvname=" + quoteDSNValue(params.KrbSrvName)
+	}
+	if params.KrbSpn != "" {
+		dsn += " krbspn=" + quoteDSNValue(params.KrbSpn)
+	}
+	// Unknown keys are sent by lib/pq as session runtime parameters
+	if params.ApplicationName != "" {
+		dsn += " application_name=" + quoteDSNValue(params.ApplicationName)
+	}
+	if params.StatementTimeout > 0 {
+		dsn += fmt.Sprintf(" statement_timeout=%d", params.StatementTimeout.Milliseconds())
+	}
+
+	return dsn
+}
+
+// buildPgBouncerDSN composes a lib/pq connection string for the PgBouncer admin console.
+// PgBouncer rejects unknown startup parameters, so statement_timeout is not sent.
+func buildPgBouncerDSN(params ConnectionParams) string {
+	params.StatementTimeout = 0
+	if params.DbName == "" {
+		params.DbName = "pgbouncer"
+	}
+	return buildPostgresDSN(params)
+}
+
+// quoteDSNValue quotes key=value connection string value if it contains spaces or quotes
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// buildMySQLDSN composes a go-sql-driver/mysql connection string.
+// PostgreSQL style ssl modes are mapped to the closest MySQL TLS setting.
+func buildMySQLDSN(params ConnectionParams) (string, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = params.User
+	cfg.Passwd = params.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(params.Host, strconv.Itoa(params.Port))
+	cfg.DBName = params.DbName
+	// IAM tokens are sent as cleartext passwords, ssl-mode is validated to encrypt them
+	cfg.AllowCleartextPasswords = params.Auth.IsToken()
+	if params.ApplicationName != "" {
+		cfg.ConnectionAttributes = "program_name:" + params.ApplicationName
+	}
+	if params.StatementTimeout > 0 {
+		// Session variable limiting SELECT execution time, in milliseconds
+		cfg.Params = map[string]string{
+			"max_execution_time": strconv.FormatInt(params.StatementTimeout.Milliseconds(), 10),
+		}
+	}
+
+	switch params.SslMode {
+	case "", "disable":
+		cfg.TLSConfig = "false"
+	case "allow", "prefer":
+		cfg.TLSConfig = "preferred"
+	case "require":
+		cfg.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		cfg.TLSConfig = "true"
+	}
+
+	// Certificates require a custom TLS config registered in the driver, modes without TLS don't use them
+	if (params.SslRootCert != "" || params.SslCert != "") && cfg.TLSConfig != "false" {
+		tlsConfig, err := buildTLSConfig(params)
+		if err != nil {
+			return "", err
+		}
+		name := "elmon-" + params.Name + "-" + params.DbName
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return "", fmt.Errorf("failed to register TLS config: %w", err)
+		}
+		// preferred falls back to a plain connection, a custom config does only when allowed explicitly
+		cfg.AllowFallbackToPlaintext = cfg.TLSConfig == "preferred"
+		cfg.TLSConfig = name
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// buildTLSConfig loads CA and client certificates for drivers configured through tls.Config.
+// Server certificates are verified in verify-ca and verify-full modes only, verify-ca skips the host name.
+func buildTLSConfig(params ConnectionParams) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         params.Host,
+		InsecureSkipVerify: params.SslMode != "verify-full",
+	}
+
+	if params.SslRootCert != "" {
+		caCert, err := os.ReadFile(params.SslRootCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssl root certificate '%s': %w", params.SslRootCert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in ssl root certificate '%s'", params.SslRootCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if params.SslCert != "" {
+		certificate, err := tls.LoadX509KeyPair(params.SslCert, params.SslKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssl client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if params.SslMode == "verify-ca" {
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, roots)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyCertificateChain checks that the server certificate is signed by a trusted CA, nil roots
+// mean system ones. Host name is not checked, the way PostgreSQL verify-ca mode works.
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server sent no certificate")
+	}
+	certificates := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		certificate, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %w", err)
+		}
+		certificates[i] = certificate
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := certificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	if err != nil {
+		return fmt.Errorf("failed to verify server certificate: %w", err)
+	}
+	return nil
+}
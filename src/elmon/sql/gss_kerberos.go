@@ -0,0 +1,14 @@
+//go:build kerberos
+
+package sql
+
+import (
+	"github.com/lib/pq"
+	"github.com/lib/pq/auth/kerberos"
+)
+
+// GSSAPI authentication needs the Kerberos provider of lib/pq, which is a separate module.
+// Build with: go mod tidy && go build -tags kerberos
+func init() {
+	pq.RegisterGSSProvider(func() (pq.GSS, error) { return kerberos.NewGSS() })
+}
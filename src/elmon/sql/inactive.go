@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// queryExecer reads and writes on the connection pool or inside a transaction
+type queryExecer interface {
+	Execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DeactivateServers marks active servers not kept by keep inactive, saving a server activates it again.
+// Returns names of the deactivated servers.
+func DeactivateServers(ctx context.Context, db *sql.DB, keep func(name string) bool) ([]string, error) {
+	return deactivate(ctx, db, "server", "name", keep)
+}
+
+// deactivate marks active rows of the table whose name is not kept inactive
+func deactivate(ctx context.Context, db queryExecer, table string, nameColumn string,
+	keep func(name string) bool) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("select %s from %s where is_active;", nameColumn, table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active %s rows: %w", table, err)
+	}
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read active %s rows: %w", table, err)
+		}
+		if !keep(name) {
+			missing = append(missing, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read active %s rows: %w", table, err)
+	}
+
+	// Rows are read completely first, a transaction runs a single statement at a time
+	updateSQL := fmt.Sprintf("update %s set is_active = false, deactivated_at = $1 where %s = $2;", table, nameColumn)
+	now := time.Now().UTC()
+	for _, name := range missing {
+		if _, err := db.ExecContext(ctx, updateSQL, now, name); err != nil {
+			return nil, fmt.Errorf("failed to deactivate %s '%s': %w", table, name, err)
+		}
+	}
+	return missing, nil
+}
+
+// purgeInactive deletes servers and metrics deactivated before the time together with their collection
+// status and latest values. serverTables are other tables with rows of servers, e.g. role history.
+// Collected values are left to retention.
+func purgeInactive(ctx context.Context, db *sql.DB, before time.Time, serverTables ...string) (int64, int64, error) {
+	const inactiveServers = `select server_id from server where not is_active and deactivated_at < $1`
+	const inactiveMetrics = `select metric_id from metric where not is_active and deactivated_at < $1`
+	statements := []string{
+		`delete from collection_status where server_id in (` + inactiveServers + `) or metric_id in (` +
+			inactiveMetrics + `);`,
+		`delete from metric_value_latest where server_id in (` + inactiveServers + `) or metric_id in (` +
+			inactiveMetrics + `);`,
+	}
+	for _, table := range serverTables {
+		statements = append(statements, `delete from `+table+` where server_id in (`+inactiveServers+`);`)
+	}
+
+	var servers, metrics int64
+	err := RunInTx(ctx, db, func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement, before); err != nil {
+				return fmt.Errorf("failed to delete rows of inactive servers and metrics: %w", err)
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `delete from server where not is_active and deactivated_at < $1;`, before)
+		if err != nil {
+			return fmt.Errorf("failed to purge inactive servers: %w", err)
+		}
+		if servers, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		result, err = tx.ExecContext(ctx, `delete from metric where not is_active and deactivated_at < $1;`, before)
+		if err != nil {
+			return fmt.Errorf("failed to purge inactive metrics: %w", err)
+		}
+		metrics, err = result.RowsAffected()
+		return err
+	})
+	return servers, metrics, err
+}
@@ -0,0 +1,136 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Instance is a row of elmon_instance, an elmon instance sharing the metrics DB
+type Instance struct {
+	Instance          string // instance id
+	Hostname          string
+	PID               int
+	AppVersion        string
+	Shard             *int // nil without sharding
+	Shards            int
+	Leader            bool     // runs jobs shared by all instances
+	Collecting        bool     // false while standing by for the leader lease
+	Servers           []string // names of servers collected by the instance
+	StartedAt         time.Time
+	HeartbeatAt       time.Time
+	HeartbeatInterval time.Duration
+	StoppedAt         *time.Time // set when the instance stopped cleanly
+}
+
+// SaveInstance inserts or replaces the row of the instance, a restarted instance replaces its previous row
+func SaveInstance(ctx context.Context, db *sql.DB, instance Instance) error {
+	const upsertSQL = `
+		insert into elmon_instance (instance, hostname, pid, app_version, shard, shards, leader, collecting, servers,
+			started_at, heartbeat_at, heartbeat_interval_seconds, stopped_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, null)
+		on conflict (instance) do update set
+			hostname = excluded.hostname,
+			pid = excluded.pid,
+			app_version = excluded.app_version,
+			shard = excluded.shard,
+			shards = excluded.shards,
+			leader = excluded.leader,
+			collecting = excluded.collecting,
+			servers = excluded.servers,
+			started_at = excluded.started_at,
+			heartbeat_at = excluded.heartbeat_at,
+			heartbeat_interval_seconds = excluded.heartbeat_interval_seconds,
+			stopped_at = null;`
+
+	servers := instance.Servers
+	if servers == nil {
+		servers = []string{}
+	}
+	serversJSON, err := json.Marshal(servers)
+	if err != nil {
+		return fmt.Errorf("failed to serialize servers of instance: %w", err)
+	}
+	var shard sql.NullInt64
+	if instance.Shard != nil {
+		shard = sql.NullInt64{Int64: int64(*instance.Shard), Valid: true}
+	}
+	_, err = db.ExecContext(ctx, upsertSQL, instance.Instance, instance.Hostname, instance.PID, instance.AppVersion,
+		shard, instance.Shards, instance.Leader, instance.Collecting, string(serversJSON), instance.StartedAt.UTC(),
+		instance.HeartbeatAt.UTC(), int64(instance.HeartbeatInterval.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to save instance '%s': %w", instance.Instance, err)
+	}
+	return nil
+}
+
+// MarkInstanceStopped records that the instance stopped cleanly
+func MarkInstanceStopped(ctx context.Context, db *sql.DB, instance string, stoppedAt time.Time) error {
+	_, err := db.ExecContext(ctx, `update elmon_instance set stopped_at = $1, leader = false, collecting = false
+		where instance = $2;`, stoppedAt.UTC(), instance)
+	if err != nil {
+		return fmt.Errorf("failed to mark instance '%s' stopped: %w", instance, err)
+	}
+	return nil
+}
+
+// DeleteInstances removes instances whose last heartbeat is older than before, returns the number removed
+func DeleteInstances(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, `delete from elmon_instance where heartbeat_at < $1;`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete instances: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete instances: %w", err)
+	}
+	return deleted, nil
+}
+
+// ReadInstances returns registered instances ordered by shard and instance id
+func ReadInstances(ctx context.Context, db *sql.DB) ([]Instance, error) {
+	const selectSQL = `
+		select instance, hostname, pid, app_version, shard, shards, leader, collecting, servers, started_at,
+			heartbeat_at, heartbeat_interval_seconds, stopped_at
+		from elmon_instance
+		order by coalesce(shard, 0), instance;`
+
+	rows, err := db.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query instances: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []Instance
+	for rows.Next() {
+		var instance Instance
+		var shard sql.NullInt64
+		var servers string
+		var heartbeatSeconds int64
+		var stoppedAt sql.NullTime
+		err := rows.Scan(&instance.Instance, &instance.Hostname, &instance.PID, &instance.AppVersion, &shard,
+			&instance.Shards, &instance.Leader, &instance.Collecting, &servers, &instance.StartedAt,
+			&instance.HeartbeatAt, &heartbeatSeconds, &stoppedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan instances: %w", err)
+		}
+		if shard.Valid {
+			value := int(shard.Int64)
+			instance.Shard = &value
+		}
+		if err := json.Unmarshal([]byte(servers), &instance.Servers); err != nil {
+			return nil, fmt.Errorf("invalid servers of instance '%s': %w", instance.Instance, err)
+		}
+		instance.HeartbeatInterval = time.Duration(heartbeatSeconds) * time.Second
+		if stoppedAt.Valid {
+			instance.StoppedAt = &stoppedAt.Time
+		}
+		instances = append(instances, instance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read instances: %w", err)
+	}
+	return instances, nil
+}
@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AcquireLease takes the lease of the name for the holder, or extends it when the holder has it already.
+// Another holder's lease is taken over only after it expires. Expiry is checked with the metrics DB
+// clock, so clocks of the instances competing for the lease don't have to agree.
+func AcquireLease(ctx context.Context, db *sql.DB, name string, holder string, ttl time.Duration) (bool, error) {
+	const upsertSQL = `
+		insert into lease (name, holder, acquired_at, expires_at)
+		values ($1, $2, now(), now() + make_interval(secs => $3))
+		on conflict (name) do update set
+			holder = excluded.holder,
+			acquired_at = case when lease.holder = excluded.holder then lease.acquired_at else excluded.acquired_at end,
+			expires_at = excluded.expires_at
+		where lease.holder = excluded.holder or lease.expires_at < now()
+		returning holder;
+	`
+	return acquireLease(ctx, db, upsertSQL, name, holder, ttl)
+}
+
+// ReleaseLease gives up the lease of the name if the holder has it, so others don't wait for its expiry
+func ReleaseLease(ctx context.Context, db *sql.DB, name string, holder string) error {
+	if _, err := db.ExecContext(ctx, `delete from lease where name = $1 and holder = $2;`, name, holder); err != nil {
+		return fmt.Errorf("failed to release lease '%s': %w", name, err)
+	}
+	return nil
+}
+
+// acquireLease runs a driver specific upsert returning a row only when the lease was acquired
+func acquireLease(ctx context.Context, db *sql.DB, upsertSQL string, name string, holder string, ttl time.Duration) (bool, error) {
+	var acquiredBy string
+	err := db.QueryRowContext(ctx, upsertSQL, name, holder, ttl.Seconds()).Scan(&acquiredBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease '%s': %w", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,59 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// ManagedServer is a server added through the API, its definition is replayed on startup
+type ManagedServer struct {
+	Name       string
+	Profile    string
+	Definition json.RawMessage // API request adding the server
+}
+
+// SaveManagedServer inserts or replaces the definition of a server added through the API
+func SaveManagedServer(db *sql.DB, server ManagedServer) error {
+	const upsertSQL = `
+		insert into managed_server (name, profile, definition)
+		values ($1, $2, $3)
+		on conflict (name) do update set profile = excluded.profile, definition = excluded.definition;`
+
+	if _, err := db.Exec(upsertSQL, server.Name, server.Profile, string(server.Definition)); err != nil {
+		return fmt.Errorf("failed to save managed server '%s': %w", server.Name, err)
+	}
+	return nil
+}
+
+// DeleteManagedServer deletes the definition of a server added through the API
+func DeleteManagedServer(db *sql.DB, name string) error {
+	if _, err := db.Exec(`delete from managed_server where name = $1;`, name); err != nil {
+		return fmt.Errorf("failed to delete managed server '%s': %w", name, err)
+	}
+	return nil
+}
+
+// LoadManagedServers returns definitions of all servers added through the API in order of addition
+func LoadManagedServers(db *sql.DB) ([]ManagedServer, error) {
+	rows, err := db.Query(`select name, profile, definition from managed_server order by created_at, name;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query managed servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []ManagedServer
+	for rows.Next() {
+		var server ManagedServer
+		var definition string
+		if err := rows.Scan(&server.Name, &server.Profile, &definition); err != nil {
+			return nil, fmt.Errorf("failed to scan managed servers: %w", err)
+		}
+		server.Definition = json.RawMessage(definition)
+		servers = append(servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read managed servers: %w", err)
+	}
+	return servers, nil
+}
@@ -1,9 +1,11 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
 	"elmon/logger"
 	"fmt"
+	"time"
 )
 
 // SQL constants for inserting metric configuration into the database
@@ -20,11 +22,18 @@ const (
 	// SQL to insert a metric name linked to its group.
 	// It uses ON CONFLICT to prevent duplicates and returns the metric_id.
 	SQLInsertMetric = `
-		insert into metric (metric_group_id, metric_name, description)
-		values ($1, $2, $3)
+		insert into metric (metric_group_id, metric_name, description, unit, value_type, collection_type,
+			interval_seconds)
+		values ($1, $2, $3, $4, $5, $6, $7)
 		on conflict (metric_name) do update
 		set metric_group_id = excluded.metric_group_id,
-		    description = excluded.description
+		    description = excluded.description,
+		    unit = excluded.unit,
+		    value_type = excluded.value_type,
+		    collection_type = excluded.collection_type,
+		    interval_seconds = excluded.interval_seconds,
+		    is_active = true,
+		    deactivated_at = null
         returning metric_id
 	`
 )
@@ -47,6 +56,7 @@ func InsertMetricsToDB(log *logger.Logger, config *MetricConfigForDB, db *sql.DB
 		}
 	}()
 
+	names := make(map[string]bool)
 	for _, group := range config.MetricGroups {
 		var groupID int
 		err = transaction.QueryRow(SQLInsertMetricGroup, group.Name, group.Description).Scan(&groupID)
@@ -56,19 +66,43 @@ func InsertMetricsToDB(log *logger.Logger, config *MetricConfigForDB, db *sql.DB
 
 		for _, metric := range group.Metrics {
 			var metricID int
-			err = transaction.QueryRow(SQLInsertMetric, groupID, metric.Name, metric.Description).Scan(&metricID)
+			err = transaction.QueryRow(SQLInsertMetric, groupID, metric.Name, metric.Description,
+				nullString(metric.Unit), metric.ValueType, metric.CollectionType,
+				nullSeconds(metric.Interval)).Scan(&metricID)
 			if err != nil {
 				return fmt.Errorf("failed to insert/get metric ID for '%s': %w", metric.Name, err)
 			}
 			// Save ID back to structure for future use
 			metric.DbMetricID = metricID
+			names[metric.Name] = true
 		}
 	}
 
+	// Metrics removed from the configuration keep their values, they are marked inactive until purged
+	var deactivated []string
+	deactivated, err = deactivate(context.Background(), transaction, "metric", "metric_name",
+		func(name string) bool { return names[name] })
+	if err != nil {
+		return err
+	}
+	if len(deactivated) > 0 {
+		log.Info("Metrics removed from configuration deactivated", "metrics", deactivated)
+	}
+
 	if err = transaction.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	log.Info("Successfully inserted/updated metric configuration in the database.")
 	return nil
+}
+
+// nullString stores an empty string as null
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// nullSeconds stores a zero duration as null
+func nullSeconds(value time.Duration) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: value.Seconds(), Valid: value != 0}
 }
\ No newline at end of file
@@ -8,18 +8,65 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// ExecOptions selects the transaction a metric script runs in, without options it runs on its own
+type ExecOptions struct {
+	ReadOnly bool // run inside a read-only transaction which is always rolled back
+	// ServerTimeouts sets statement_timeout and lock_timeout of the transaction from the timeout, so the
+	// server stops the script even when the cancel request of the client is lost. PostgreSQL only.
+	ServerTimeouts bool
+}
+
 // ExecuteMetricValueGetScript executes an SQL script with a specified timeout
 // The function strictly checks that the query returns exactly one row
-// containing exactly one column of type JSONB or JSON
-func ExecuteMetricValueGetScript(db *sql.DB, script string, timeout time.Duration) (json.RawMessage, error) {
+// containing exactly one column of type JSONB or JSON.
+// The value is checked against limits before it is returned.
+func ExecuteMetricValueGetScript(db *sql.DB, script string, timeout time.Duration, options ExecOptions,
+	limits ResultLimits) (json.RawMessage, error) {
+	return executeMetricValue(db, script, nil, timeout, options, limits)
+}
+
+// executeMetricValue runs script, or statement prepared from it when not nil, and reads the single JSON value
+func executeMetricValue(db *sql.DB, script string, statement *sql.Stmt, timeout time.Duration, options ExecOptions,
+	limits ResultLimits) (json.RawMessage, error) {
 	// 1. Create a context with the timeout
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel() // Important: release context resources upon completion
 
 	// 2. Execute the query with context to get the Rows object
-	rows, err := db.QueryContext(ctx, script)
+	var queryer interface {
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	} = db
+	var transaction *sql.Tx
+	if options.ReadOnly || options.ServerTimeouts {
+		var err error
+		transaction, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: options.ReadOnly})
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer transaction.Rollback() // Read-only transactions are never committed
+		if options.ServerTimeouts {
+			if err := setLocalTimeouts(ctx, transaction, timeout); err != nil {
+				return nil, err
+			}
+		}
+		queryer = transaction
+		if statement != nil {
+			// The statement is reused if it is already prepared on the connection of the transaction
+			statement = transaction.StmtContext(ctx, statement)
+		}
+	}
+
+	var rows *sql.Rows
+	var err error
+	if statement != nil {
+		rows, err = statement.QueryContext(ctx)
+	} else {
+		rows, err = queryer.QueryContext(ctx, script)
+	}
 	if err != nil {
 		// Handle timeout error
 		if ctx.Err() == context.DeadlineExceeded {
@@ -72,12 +119,49 @@ func ExecuteMetricValueGetScript(db *sql.DB, script string, timeout time.Duratio
 		return nil, fmt.Errorf("error after iteration: %w", err)
 	}
 
-	// 7. Return the result
-	return json.RawMessage(jsonbResult), nil
+	// 7. Return the result within limits
+	value, err := limits.apply(json.RawMessage(jsonbResult))
+	if err != nil || transaction == nil || options.ReadOnly {
+		return value, err
+	}
+	// Changes of the script, if any, are kept as they are without a transaction
+	rows.Close()
+	if err := transaction.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return value, nil
 }
 
-// InsertMetricValue inserts metric record into metric_value table
-func InsertMetricValue(log *logger.Logger, db *sql.DB, metricId int, serverId int, value json.RawMessage) error {
+// setLocalTimeouts limits the transaction on the server side: statements are cancelled a tenth of timeout
+// before the client gives up, so the server reports the timeout itself, and a lock wait fails after half of
+// timeout, which tells a blocked script from a slow one. The settings end with the transaction.
+func setLocalTimeouts(ctx context.Context, transaction *sql.Tx, timeout time.Duration) error {
+	statementTimeout := max(timeout*9/10, time.Millisecond)
+	lockTimeout := max(timeout/2, time.Millisecond)
+	// set_config with is_local works like SET LOCAL, which takes no parameters
+	const setSQL = `select set_config('statement_timeout', $1, true), set_config('lock_timeout', $2, true);`
+	_, err := transaction.ExecContext(ctx, setSQL, fmt.Sprintf("%dms", statementTimeout.Milliseconds()),
+		fmt.Sprintf("%dms", lockTimeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("failed to set server timeouts: %w", err)
+	}
+	return nil
+}
+
+// InsertMetricValue inserts metric record into metric_value table.
+// databaseName is empty for server level metrics, zero targetTime and empty labels are stored as NULL.
+func InsertMetricValue(ctx context.Context, log *logger.Logger, db Execer, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	return InsertMetricValueAt(ctx, log, db, time.Time{}, metricId, serverId, databaseName, value, labels, duration,
+		targetTime)
+}
+
+// InsertMetricValueAt inserts metric record collected at valueTime, used to replay spooled values.
+// Zero valueTime is replaced with the metrics DB current time.
+func InsertMetricValueAt(ctx context.Context, log *logger.Logger, db Execer, valueTime time.Time, metricId int,
+	serverId int, databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
 	// Check for initialized connection
 	if db == nil {
 		err := fmt.Errorf("database connection (DB) is nil. Cannot insert metric: serverId=%d, metricId=%d", serverId, metricId)
@@ -87,12 +171,23 @@ func InsertMetricValue(log *logger.Logger, db *sql.DB, metricId int, serverId in
 
 	// SQL query for insertion
 	const insertSQL = `
-		INSERT INTO metric_value (time, server_id, metric_id, metric_value)
-		VALUES (NOW(), $1, $2, $3);
+		INSERT INTO metric_value (time, server_id, metric_id, database_name, metric_value, collection_duration_ms, target_time, labels)
+		VALUES (coalesce($7, NOW()), $1, $2, $3, $4, $5, $6, $8);
 	`
 
+	durationMs := float64(duration.Microseconds()) / 1000
+	target := sql.NullTime{Time: targetTime, Valid: !targetTime.IsZero()}
+
+	at := sql.NullTime{Time: valueTime, Valid: !valueTime.IsZero()}
+
+	labelsJSON, err := labelsValue(labels)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert metric: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+
 	// Execute query
-	_, err := db.Exec(insertSQL, serverId, metricId, value)
+	_, err = db.ExecContext(ctx, insertSQL, serverId, metricId, databaseName, value, durationMs, target, at, labelsJSON)
 
 	if err != nil {
 		log.Error(err, fmt.Sprintf("failed to insert metric: serverId=%d, metricId=%d", serverId, metricId))
@@ -100,4 +195,181 @@ func InsertMetricValue(log *logger.Logger, db *sql.DB, metricId int, serverId in
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// UpsertLatestMetricValue stores metric value in metric_value_latest table replacing the previous one,
+// used by metrics with storage-mode 'latest'. Zero targetTime is stored as NULL.
+func UpsertLatestMetricValue(ctx context.Context, log *logger.Logger, db Execer, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	if db == nil {
+		err := fmt.Errorf("database connection (DB) is nil. Cannot upsert metric: serverId=%d, metricId=%d", serverId, metricId)
+		log.Error(err, "Failed to upsert metric")
+		return err
+	}
+
+	const upsertSQL = `
+		insert into metric_value_latest (server_id, metric_id, database_name, time, metric_value, collection_duration_ms, target_time, labels)
+		values ($1, $2, $3, now(), $4, $5, $6, $7)
+		on conflict (server_id, metric_id, database_name) do update set
+			time = excluded.time,
+			metric_value = excluded.metric_value,
+			collection_duration_ms = excluded.collection_duration_ms,
+			target_time = excluded.target_time,
+			labels = excluded.labels;
+	`
+
+	durationMs := float64(duration.Microseconds()) / 1000
+	target := sql.NullTime{Time: targetTime, Valid: !targetTime.IsZero()}
+
+	labelsJSON, err := labelsValue(labels)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to upsert metric: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, value, durationMs, target, labelsJSON)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to upsert metric: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+
+	return nil
+}
+
+// labelsValue converts labels into JSON object, empty labels are stored as NULL
+func labelsValue(labels map[string]string) (sql.NullString, error) {
+	if len(labels) == 0 {
+		return sql.NullString{}, nil
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to serialize labels: %w", err)
+	}
+	return sql.NullString{String: string(labelsJSON), Valid: true}, nil
+}
+
+// GetServerTimezone returns the timezone of the target server, empty for PgBouncer which has no clock.
+// PostgreSQL reports the TimeZone setting, MySQL the session time zone or the system one behind SYSTEM.
+func GetServerTimezone(db *sql.DB, driver string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var timezone string
+	var err error
+	switch driver {
+	case DriverPgBouncer:
+		return "", nil
+	case DriverMySQL:
+		err = db.QueryRowContext(ctx,
+			"select if(@@session.time_zone = 'SYSTEM', @@system_time_zone, @@session.time_zone)").Scan(&timezone)
+	default:
+		err = db.QueryRowContext(ctx, "show timezone").Scan(&timezone)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get server timezone: %w", err)
+	}
+	return timezone, nil
+}
+
+// GetServerVersion returns server_version_num of a PostgreSQL server, e.g. 160002 for 16.2.
+// Other drivers return 0, their version is not known.
+func GetServerVersion(db *sql.DB, driver string, timeout time.Duration) (int, error) {
+	if driver == DriverPgBouncer || driver == DriverMySQL {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var version int
+	if err := db.QueryRowContext(ctx, "select current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get server version: %w", err)
+	}
+	return version, nil
+}
+
+// GetExtensions returns names of extensions installed in the database of a PostgreSQL connection.
+// Other drivers have no extensions and return nil.
+func GetExtensions(db *sql.DB, driver string, timeout time.Duration) (map[string]bool, error) {
+	if driver == DriverPgBouncer || driver == DriverMySQL {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "select extname from pg_extension")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed extensions: %w", err)
+	}
+	defer rows.Close()
+	extensions := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan installed extensions: %w", err)
+		}
+		extensions[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read installed extensions: %w", err)
+	}
+	return extensions, nil
+}
+
+// CreateExtension creates an extension in the database of a PostgreSQL connection if it doesn't exist
+func CreateExtension(db *sql.DB, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "create extension if not exists "+pq.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create extension '%s': %w", name, err)
+	}
+	return nil
+}
+
+// GetMySQLUptime returns seconds since a MySQL or MariaDB server started
+func GetMySQLUptime(db *sql.DB, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var name string
+	var uptime int64
+	if err := db.QueryRowContext(ctx, "show global status like 'Uptime'").Scan(&name, &uptime); err != nil {
+		return 0, fmt.Errorf("failed to get server uptime: %w", err)
+	}
+	return uptime, nil
+}
+
+// GetServerTime returns current time reported by the target server.
+// Compared with the metrics DB time it reveals clock skew between servers.
+func GetServerTime(db *sql.DB, driver string, timeout time.Duration) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// PgBouncer admin console has no clock
+	if driver == DriverPgBouncer {
+		return time.Time{}, nil
+	}
+
+	if driver == DriverMySQL {
+		// DATETIME is returned as text unless parseTime is set in DSN, then it is converted to RFC 3339
+		var value string
+		if err := db.QueryRowContext(ctx, "select utc_timestamp(6)").Scan(&value); err != nil {
+			return time.Time{}, fmt.Errorf("failed to get server time: %w", err)
+		}
+		serverTime, err := time.ParseInLocation("2006-01-02 15:04:05.999999", value, time.UTC)
+		if err != nil {
+			serverTime, err = time.Parse(time.RFC3339Nano, value)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse server time '%s': %w", value, err)
+		}
+		return serverTime, nil
+	}
+
+	var serverTime time.Time
+	if err := db.QueryRowContext(ctx, "select now()").Scan(&serverTime); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get server time: %w", err)
+	}
+	return serverTime, nil
+}
@@ -0,0 +1,72 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExecuteShowCommand runs a SHOW command on PgBouncer admin console and returns its rows as
+// JSON array of objects keyed by column names. The console speaks the simple query protocol
+// only, so the command is sent without parameters and outside of a transaction. Commands other
+// than SHOW are rejected, because the console also accepts PAUSE, KILL or SHUTDOWN.
+// The result is checked against limits like results of metric scripts.
+func ExecuteShowCommand(db *sql.DB, command string, timeout time.Duration, limits ResultLimits) (json.RawMessage, error) {
+	command = strings.TrimSpace(sqlLineComment.ReplaceAllString(sqlBlockComment.ReplaceAllString(command, " "), " "))
+	command = strings.TrimSpace(strings.TrimSuffix(command, ";"))
+	if fields := strings.Fields(command); len(fields) < 2 || !strings.EqualFold(fields[0], "show") ||
+		strings.Contains(command, ";") {
+		return nil, fmt.Errorf("expected a single SHOW command for PgBouncer, got '%s'", command)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, command)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("query timed out after %s: %w", timeout, ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to execute command: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// Numbers arrive decoded by the driver, text columns as bytes
+			if text, ok := values[i].([]byte); ok {
+				row[column] = string(text)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during iteration: %w", err)
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize command result: %w", err)
+	}
+	return limits.apply(value)
+}
@@ -0,0 +1,82 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PreparedScript runs a metric script through a statement prepared on the first run and reused later,
+// so frequently collected SQL is not parsed on every run. database/sql prepares the statement again on
+// every new pool connection, so recycled connections are handled transparently.
+type PreparedScript struct {
+	mutex     sync.Mutex
+	script    string    // script of statement
+	statement *sql.Stmt // nil until prepared, after an error and when the script can't be prepared
+	plain     bool      // the script can't be prepared, it runs as a plain query until it changes
+}
+
+// Execute runs script like ExecuteMetricValueGetScript. The statement is prepared again when script
+// changes (e.g. reloaded SQL) and after a failed run, e.g. when PostgreSQL rejects a cached plan after
+// a schema change. Scripts which can't be prepared, such as several statements, run as plain queries.
+func (p *PreparedScript) Execute(db *sql.DB, script string, timeout time.Duration, options ExecOptions,
+	limits ResultLimits) (json.RawMessage, error) {
+	statement, prepareErr := p.prepare(db, script, timeout)
+	if statement == nil {
+		value, err := ExecuteMetricValueGetScript(db, script, timeout, options, limits)
+		if prepareErr != nil && err == nil {
+			// The server is fine, so the script itself can't be prepared
+			p.setPlain(script)
+		}
+		return value, err
+	}
+	value, err := executeMetricValue(db, script, statement, timeout, options, limits)
+	if err != nil && !errors.Is(err, ErrResultTooLarge) {
+		p.invalidate(statement)
+	}
+	return value, err
+}
+
+// prepare returns the statement of script, nil when it runs as a plain query or preparing failed
+func (p *PreparedScript) prepare(db *sql.DB, script string, timeout time.Duration) (*sql.Stmt, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if script != p.script {
+		if p.statement != nil {
+			p.statement.Close()
+		}
+		p.script, p.statement, p.plain = script, nil, false
+	}
+	if p.statement == nil && !p.plain {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		statement, err := db.PrepareContext(ctx, script)
+		if err != nil {
+			return nil, err
+		}
+		p.statement = statement
+	}
+	return p.statement, nil
+}
+
+func (p *PreparedScript) setPlain(script string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.script == script {
+		p.plain = true
+	}
+}
+
+// invalidate closes a statement that failed, the next run prepares it again
+func (p *PreparedScript) invalidate(statement *sql.Stmt) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.statement == statement {
+		p.statement.Close()
+		p.statement = nil
+	}
+}
@@ -0,0 +1,260 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownName is returned when a server or a metric is not registered in metrics DB
+var ErrUnknownName = errors.New("not registered in metrics DB")
+
+// StoredValue is a metric value read from metric_value or metric_value_latest
+type StoredValue struct {
+	Time         time.Time
+	MetricName   string
+	DatabaseName string
+	Value        json.RawMessage
+	Labels       json.RawMessage // nil without labels
+}
+
+// ValueQuery selects values of a metric of a server stored from From up to To
+type ValueQuery struct {
+	ServerName   string
+	MetricName   string
+	DatabaseName string // empty for all databases
+	From         time.Time
+	To           time.Time
+	Limit        int  // the first Limit values in the order are returned
+	Descending   bool // latest values first
+}
+
+// StoredMetric is a metric registered in metrics DB
+type StoredMetric struct {
+	Name           string
+	Group          string
+	Description    string
+	Unit           string
+	ValueType      string
+	CollectionType string
+	Interval       time.Duration // zero when unknown
+	Active         bool
+}
+
+// StoredServer is a server registered in metrics DB
+type StoredServer struct {
+	Name        string
+	Environment string
+	Host        string
+	Port        int
+	Driver      string
+	Timezone    string
+	Active      bool
+}
+
+// ReadMetricValues returns values of the query in time order, latest first when descending. Times are compared in UTC, the way
+// SQLite keeps them.
+func ReadMetricValues(ctx context.Context, db *sql.DB, query ValueQuery) ([]StoredValue, error) {
+	serverID, err := lookupID(ctx, db, `select server_id from server where name = $1;`, "server", query.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	metricID, err := lookupID(ctx, db, `select metric_id from metric where metric_name = $1;`, "metric",
+		query.MetricName)
+	if err != nil {
+		return nil, err
+	}
+
+	selectSQL := `
+		select time, database_name, metric_value, labels
+		from metric_value
+		where server_id = $1 and metric_id = $2 and time >= $3 and time < $4`
+	args := []interface{}{serverID, metricID, query.From.UTC(), query.To.UTC()}
+	if query.DatabaseName != "" {
+		selectSQL += ` and database_name = $5`
+		args = append(args, query.DatabaseName)
+	}
+	order := "asc"
+	if query.Descending {
+		order = "desc"
+	}
+	selectSQL += fmt.Sprintf(` order by time %s, database_name limit %d;`, order, query.Limit)
+
+	rows, err := db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metric values: %w", err)
+	}
+	defer rows.Close()
+
+	var values []StoredValue
+	for rows.Next() {
+		value := StoredValue{MetricName: query.MetricName}
+		var metricValue string
+		var labels sql.NullString
+		if err := rows.Scan(&value.Time, &value.DatabaseName, &metricValue, &labels); err != nil {
+			return nil, fmt.Errorf("failed to scan metric values: %w", err)
+		}
+		value.Value = json.RawMessage(metricValue)
+		if labels.Valid {
+			value.Labels = json.RawMessage(labels.String)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metric values: %w", err)
+	}
+	return values, nil
+}
+
+// DeleteMetricValues deletes values of a metric of a server stored after after and up to until, of a single
+// database unless databaseName is empty. Returns the number of deleted values.
+func DeleteMetricValues(ctx context.Context, db *sql.DB, serverName string, metricName string, databaseName string,
+	after time.Time, until time.Time) (int64, error) {
+	serverID, err := lookupID(ctx, db, `select server_id from server where name = $1;`, "server", serverName)
+	if err != nil {
+		return 0, err
+	}
+	metricID, err := lookupID(ctx, db, `select metric_id from metric where metric_name = $1;`, "metric", metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	deleteSQL := `delete from metric_value where server_id = $1 and metric_id = $2 and time > $3 and time <= $4`
+	args := []interface{}{serverID, metricID, after.UTC(), until.UTC()}
+	if databaseName != "" {
+		deleteSQL += ` and database_name = $5`
+		args = append(args, databaseName)
+	}
+	result, err := db.ExecContext(ctx, deleteSQL+";", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete metric values: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ReadLatestValues returns values of metric_value_latest of the server, of a single metric unless
+// metricName is empty
+func ReadLatestValues(ctx context.Context, db *sql.DB, serverName string, metricName string) ([]StoredValue, error) {
+	serverID, err := lookupID(ctx, db, `select server_id from server where name = $1;`, "server", serverName)
+	if err != nil {
+		return nil, err
+	}
+	selectSQL := `
+		select mvl.time, m.metric_name, mvl.database_name, mvl.metric_value, mvl.labels
+		from metric_value_latest mvl
+		inner join metric m on m.metric_id = mvl.metric_id
+		where mvl.server_id = $1`
+	args := []interface{}{serverID}
+	if metricName != "" {
+		if _, err := lookupID(ctx, db, `select metric_id from metric where metric_name = $1;`, "metric",
+			metricName); err != nil {
+			return nil, err
+		}
+		selectSQL += ` and m.metric_name = $2`
+		args = append(args, metricName)
+	}
+	selectSQL += ` order by m.metric_name, mvl.database_name;`
+
+	rows, err := db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest values: %w", err)
+	}
+	defer rows.Close()
+
+	var values []StoredValue
+	for rows.Next() {
+		var value StoredValue
+		var metricValue string
+		var labels sql.NullString
+		if err := rows.Scan(&value.Time, &value.MetricName, &value.DatabaseName, &metricValue, &labels); err != nil {
+			return nil, fmt.Errorf("failed to scan latest values: %w", err)
+		}
+		value.Value = json.RawMessage(metricValue)
+		if labels.Valid {
+			value.Labels = json.RawMessage(labels.String)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read latest values: %w", err)
+	}
+	return values, nil
+}
+
+// ReadMetrics returns registered metrics in order of their names, inactive ones included
+func ReadMetrics(ctx context.Context, db *sql.DB) ([]StoredMetric, error) {
+	const selectSQL = `
+		select m.metric_name, mg.metric_group_name, coalesce(m.description, ''), coalesce(m.unit, ''),
+			coalesce(m.value_type, ''), coalesce(m.collection_type, ''), m.interval_seconds, m.is_active
+		from metric m
+		inner join metric_group mg on mg.metric_group_id = m.metric_group_id
+		order by m.metric_name;`
+
+	rows, err := db.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var metrics []StoredMetric
+	for rows.Next() {
+		var metric StoredMetric
+		var interval sql.NullFloat64
+		err := rows.Scan(&metric.Name, &metric.Group, &metric.Description, &metric.Unit, &metric.ValueType,
+			&metric.CollectionType, &interval, &metric.Active)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan metrics: %w", err)
+		}
+		metric.Interval = time.Duration(interval.Float64 * float64(time.Second))
+		metrics = append(metrics, metric)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// ReadServers returns registered servers in order of their names, inactive ones included
+func ReadServers(ctx context.Context, db *sql.DB) ([]StoredServer, error) {
+	const selectSQL = `
+		select name, environment_name, host, port, driver, coalesce(timezone, ''), is_active
+		from server
+		order by name;`
+
+	rows, err := db.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []StoredServer
+	for rows.Next() {
+		var server StoredServer
+		err := rows.Scan(&server.Name, &server.Environment, &server.Host, &server.Port, &server.Driver,
+			&server.Timezone, &server.Active)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan servers: %w", err)
+		}
+		servers = append(servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read servers: %w", err)
+	}
+	return servers, nil
+}
+
+// lookupID returns the id selected by name, ErrUnknownName when there is none
+func lookupID(ctx context.Context, db *sql.DB, selectSQL string, kind string, name string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, selectSQL, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s '%s' is %w", kind, name, ErrUnknownName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up %s '%s': %w", kind, name, err)
+	}
+	return id, nil
+}
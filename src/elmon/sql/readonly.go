@@ -0,0 +1,67 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// writeKeywords are statements rejected by CheckReadOnlySQL
+// Function-like words (replace, lock) are left to the read-only transaction guard.
+var writeKeywords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true,
+	"truncate": true, "create": true, "alter": true, "drop": true, "rename": true,
+	"grant": true, "revoke": true, "copy": true, "vacuum": true, "reindex": true,
+	"call": true, "do": true,
+}
+
+var (
+	sqlLineComment  = regexp.MustCompile(`--[^\n]*`)
+	sqlBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlDollarQuoted = regexp.MustCompile(`(?s)\$([A-Za-z_]*)\$.*?\$([A-Za-z_]*)\$`)
+	sqlStringLit    = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlQuotedIdent  = regexp.MustCompile(`"(?:[^"]|"")*"|` + "`[^`]*`")
+	sqlWord         = regexp.MustCompile(`[A-Za-z_]+`)
+)
+
+// CheckReadOnlySQL statically rejects scripts containing data modification or DDL statements.
+// Comments, string literals and quoted identifiers are ignored, so 'insert' as a JSON key is allowed.
+func CheckReadOnlySQL(script string) error {
+	stripped := sqlBlockComment.ReplaceAllString(script, " ")
+	stripped = sqlLineComment.ReplaceAllString(stripped, " ")
+	stripped = sqlDollarQuoted.ReplaceAllString(stripped, " ")
+	stripped = sqlStringLit.ReplaceAllString(stripped, " ")
+	stripped = sqlQuotedIdent.ReplaceAllString(stripped, " ")
+
+	for _, word := range sqlWord.FindAllString(stripped, -1) {
+		if writeKeywords[strings.ToLower(word)] {
+			return fmt.Errorf("script contains forbidden statement '%s'", strings.ToUpper(word))
+		}
+	}
+	return nil
+}
+
+// ExplainScript checks that the script parses and the monitoring user has access to
+// every referenced object by running EXPLAIN inside a read-only transaction
+func ExplainScript(db *sql.DB, script string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	transaction, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin read-only transaction: %w", err)
+	}
+	defer transaction.Rollback() // Nothing is ever committed
+
+	rows, err := transaction.QueryContext(ctx, "explain "+strings.TrimSpace(script))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("explain timed out after %s: %w", timeout, ctx.Err())
+		}
+		return fmt.Errorf("explain failed: %w", err)
+	}
+	return rows.Close()
+}
@@ -0,0 +1,74 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrResultTooLarge is returned for a metric result over its ResultLimits
+var ErrResultTooLarge = errors.New("metric result is too large")
+
+// ResultLimits caps the JSON value returned by a metric query, zero limits are unlimited
+type ResultLimits struct {
+	MaxBytes int // size of the value
+	MaxRows  int // elements of a top-level array, e.g. rows of a table metric
+
+	// Truncate keeps the rows which fit instead of failing, values other than arrays can't be
+	// truncated and fail anyway. OnTruncate is called with the rows kept and the size of the value
+	// before truncation.
+	Truncate   bool
+	OnTruncate func(keptRows int, size int)
+}
+
+// apply checks value against the limits and truncates it to whole rows when allowed
+func (limits ResultLimits) apply(value json.RawMessage) (json.RawMessage, error) {
+	overBytes := limits.MaxBytes > 0 && len(value) > limits.MaxBytes
+	if !overBytes && limits.MaxRows <= 0 {
+		return value, nil
+	}
+
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		if overBytes {
+			return nil, fmt.Errorf("%w: %d bytes, max-result-bytes is %d", ErrResultTooLarge, len(value), limits.MaxBytes)
+		}
+		return value, nil
+	}
+
+	// Rows are decoded one by one and kept until the first one over a limit
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("invalid metric result: %w", err)
+	}
+	kept := []byte{'['}
+	rows := 0
+	for decoder.More() {
+		var row json.RawMessage
+		if err := decoder.Decode(&row); err != nil {
+			return nil, fmt.Errorf("invalid metric result: %w", err)
+		}
+		var limitErr error
+		if limits.MaxRows > 0 && rows == limits.MaxRows {
+			limitErr = fmt.Errorf("%w: more than %d rows, max-rows is %d", ErrResultTooLarge, rows, limits.MaxRows)
+		} else if limits.MaxBytes > 0 && len(kept)+len(row)+2 > limits.MaxBytes { // with a comma and the closing bracket
+			limitErr = fmt.Errorf("%w: %d bytes, max-result-bytes is %d", ErrResultTooLarge, len(value), limits.MaxBytes)
+		}
+		if limitErr != nil {
+			if !limits.Truncate {
+				return nil, limitErr
+			}
+			if limits.OnTruncate != nil {
+				limits.OnTruncate(rows, len(value))
+			}
+			return append(kept, ']'), nil
+		}
+		if rows > 0 {
+			kept = append(kept, ',')
+		}
+		kept = append(kept, row...)
+		rows++
+	}
+	return value, nil
+}
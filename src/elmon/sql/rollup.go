@@ -0,0 +1,140 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"fmt"
+	"time"
+)
+
+// rollups are named like TimescaleDB continuous aggregates, so dashboards
+// query the same relations regardless of the storage used
+var rollups = []struct {
+	table  string
+	bucket string // date_trunc field
+}{
+	{table: "metric_value_hourly", bucket: "hour"},
+	{table: "metric_value_daily", bucket: "day"},
+}
+
+// InitRollups creates rollup tables and their watermark table for plain PostgreSQL storage
+func InitRollups(db *sql.DB) error {
+	for _, rollup := range rollups {
+		createSQL := fmt.Sprintf(`
+			create table if not exists %[1]s (
+				bucket timestamptz not null,
+				server_id integer not null,
+				metric_id integer not null,
+				database_name varchar(255) not null default '',
+				min_value double precision not null,
+				max_value double precision not null,
+				avg_value double precision not null,
+				value_count bigint not null,
+
+				constraint pk_%[1]s primary key (server_id, metric_id, database_name, bucket)
+			)`, rollup.table)
+		if _, err := db.Exec(createSQL); err != nil {
+			return fmt.Errorf("failed to create rollup table %s: %w", rollup.table, err)
+		}
+	}
+
+	const watermarkSQL = `
+		create table if not exists rollup_watermark (
+			rollup_name varchar(100) not null,
+			processed_until timestamptz not null,
+
+			constraint pk_rollup_watermark primary key (rollup_name)
+		)`
+	if _, err := db.Exec(watermarkSQL); err != nil {
+		return fmt.Errorf("failed to create rollup_watermark table: %w", err)
+	}
+	return nil
+}
+
+// RunRollups aggregates numeric 'value' keys of metric_value rows older than 'after'
+// into hourly and daily rollup tables. Every rollup continues from its watermark,
+// buckets are aggregated only when they are complete.
+func RunRollups(ctx context.Context, log *logger.Logger, db *sql.DB, after time.Duration) error {
+	for _, rollup := range rollups {
+		if err := runRollup(ctx, db, rollup.table, rollup.bucket, after); err != nil {
+			log.Error(err, "Rollup failed", "rollup", rollup.table)
+			return err
+		}
+	}
+	return nil
+}
+
+// runRollup aggregates a single rollup table in one transaction together with its watermark
+func runRollup(ctx context.Context, db *sql.DB, table string, bucket string, after time.Duration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin rollup transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Upper bound is the start of the bucket containing now() - after.
+	// The watermark is locked, so a concurrent RewindRollups waits for the new one and is not overwritten.
+	var from, until time.Time
+	err = tx.QueryRowContext(ctx, `
+		select
+			coalesce((select processed_until from rollup_watermark where rollup_name = $1 for update),
+				(select date_trunc($2, min(time)) from metric_value),
+				date_trunc($2, now() - make_interval(secs => $3))),
+			date_trunc($2, now() - make_interval(secs => $3))`,
+		table, bucket, after.Seconds()).Scan(&from, &until)
+	if err != nil {
+		return fmt.Errorf("failed to read rollup watermark: %w", err)
+	}
+	if !from.Before(until) {
+		return nil
+	}
+
+	aggregateSQL := fmt.Sprintf(`
+		insert into %s (bucket, server_id, metric_id, database_name, min_value, max_value, avg_value, value_count)
+		select date_trunc($1, time), server_id, metric_id, database_name, min(value), max(value), avg(value), count(*)
+		from (
+			select time, server_id, metric_id, database_name, (metric_value->>'value')::double precision as value
+			from metric_value
+			where time >= $2 and time < $3 and jsonb_typeof(metric_value->'value') = 'number'
+		) as numeric_values
+		group by 1, server_id, metric_id, database_name
+		on conflict (server_id, metric_id, database_name, bucket) do update set
+			min_value = excluded.min_value,
+			max_value = excluded.max_value,
+			avg_value = excluded.avg_value,
+			value_count = excluded.value_count`, table)
+	if _, err := tx.ExecContext(ctx, aggregateSQL, bucket, from, until); err != nil {
+		return fmt.Errorf("failed to aggregate %s: %w", table, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into rollup_watermark (rollup_name, processed_until) values ($1, $2)
+		on conflict (rollup_name) do update set processed_until = excluded.processed_until`, table, until)
+	if err != nil {
+		return fmt.Errorf("failed to update rollup watermark: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// RewindRollups moves watermarks back to the bucket containing 'from', so values written with older times,
+// e.g. by backfill or spool replay, are aggregated again. Nothing is done when rollups were never run.
+func RewindRollups(ctx context.Context, db *sql.DB, from time.Time) error {
+	var initialized bool
+	if err := db.QueryRowContext(ctx, "select to_regclass('rollup_watermark') is not null").Scan(&initialized); err != nil {
+		return fmt.Errorf("failed to check rollup_watermark table: %w", err)
+	}
+	if !initialized {
+		return nil
+	}
+	for _, rollup := range rollups {
+		_, err := db.ExecContext(ctx, `
+			update rollup_watermark set processed_until = least(processed_until, date_trunc($2, $3::timestamptz))
+			where rollup_name = $1`, rollup.table, rollup.bucket, from)
+		if err != nil {
+			return fmt.Errorf("failed to rewind watermark of %s: %w", rollup.table, err)
+		}
+	}
+	return nil
+}
@@ -5,10 +5,12 @@ create table if not exists server (
 	name varchar(255) not null,
 	host varchar(255) not null,
 	port smallint not null,
-	timezone varchar(20),
+	timezone varchar(64),
 	ssl_mode varchar(20) null,
+	driver varchar(20) not null constraint df_server_driver default ('postgres'),
 	description text null,
 	is_active boolean not null,
+	deactivated_at timestamptz null,
 	created_at timestamptz not null constraint df_server_created_at default (current_timestamp),
 	modified_at timestamptz null,
 	
@@ -21,6 +23,22 @@ create table if not exists server (
 	
 );
 
+-- Add driver column to server tables created before MySQL support
+alter table server add column if not exists driver varchar(20) not null default 'postgres';
+
+-- Servers removed from the configuration are kept inactive until purged
+alter table server add column if not exists deactivated_at timestamptz null;
+
+-- Widen timezone of server tables created before timezones were read from servers, IANA names are longer than 20
+do $$
+begin
+	if exists (select from information_schema.columns
+		where table_schema = current_schema() and table_name = 'server' and column_name = 'timezone'
+			and character_maximum_length < 64) then
+		alter table server alter column timezone type varchar(64);
+	end if;
+end $$;
+
 -- Table to store encrypted database credentials for servers
 create table if not exists credential (
 	credential_id serial not null,
@@ -52,6 +70,12 @@ create table if not exists metric (
 	metric_group_id smallint not null,
 	metric_name varchar(255) not null,
 	description text null,
+	unit varchar(64) null, -- e.g. seconds or bytes
+	value_type varchar(16) null, -- int, int64, float, string, bool or table
+	collection_type varchar(32) null, -- sql, go_func, command, http, expression or bundle
+	interval_seconds numeric(12, 3) null, -- collection interval, servers-metrics-map may override it
+	is_active boolean not null default true,
+	deactivated_at timestamptz null,
 
 	constraint pk_metric primary key (metric_id),
 
@@ -60,16 +84,215 @@ create table if not exists metric (
 	constraint uq_metric_metric_name unique (metric_name)
 );
 
+-- Metrics removed from the configuration are kept inactive until purged
+alter table metric add column if not exists is_active boolean not null default true;
+alter table metric add column if not exists deactivated_at timestamptz null;
+
+-- Configuration of metrics for dashboards and other consumers
+alter table metric add column if not exists unit varchar(64) null;
+alter table metric add column if not exists value_type varchar(16) null;
+alter table metric add column if not exists collection_type varchar(32) null;
+alter table metric add column if not exists interval_seconds numeric(12, 3) null;
+
 -- Main table for storing collected metric values (partitioned by time)
 create table if not exists metric_value (
 	time timestamptz not null,
 	server_id integer not null, -- no foreign key for insert optimization reasons
 	metric_id integer not null, -- no foreign key for insert optimization reasons
+	database_name varchar(255) not null constraint df_metric_value_database_name default (''), -- empty for server level metrics
 	metric_value jsonb not null,
+	collection_duration_ms numeric(12, 3) null, -- time spent collecting the value on the target
+	target_time timestamptz null, -- current time reported by the target server, null for non-SQL metrics
+	labels jsonb null, -- configured labels and labels returned with the value, e.g. {"tablespace": "pg_default"}
 
-	constraint pk_metric_value primary key (server_id, metric_id, time)
+	constraint pk_metric_value primary key (server_id, metric_id, database_name, time)
 ) partition by range (time);
 
+-- Add database_name column to metric_value tables created before database discovery
+alter table metric_value add column if not exists database_name varchar(255) not null default '';
+
+-- The primary key of those tables lacks database_name, values of several databases at the same time conflict
+do $$
+begin
+	if exists (
+		select 1
+		from pg_constraint c
+		where c.conrelid = 'metric_value'::regclass
+			and c.conname = 'pk_metric_value'
+			and not exists (
+				select 1
+				from pg_attribute a
+				where a.attrelid = c.conrelid and a.attname = 'database_name' and a.attnum = any (c.conkey)
+			)
+	) then
+		alter table metric_value drop constraint pk_metric_value;
+		alter table metric_value add constraint pk_metric_value primary key (server_id, metric_id, database_name, time);
+	end if;
+end;
+$$;
+
+-- Add collection duration and target server time to metric_value tables created before they were recorded
+alter table metric_value add column if not exists collection_duration_ms numeric(12, 3) null;
+alter table metric_value add column if not exists target_time timestamptz null;
+
+-- Add labels to metric_value tables created before labels were supported
+alter table metric_value add column if not exists labels jsonb null;
+
+-- Latest value of metrics with storage-mode 'latest' (current state such as is_primary), one row per server/metric/database
+create table if not exists metric_value_latest (
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null constraint df_metric_value_latest_database_name default (''), -- empty for server level metrics
+	time timestamptz not null,
+	metric_value jsonb not null,
+	collection_duration_ms numeric(12, 3) null,
+	target_time timestamptz null,
+	labels jsonb null,
+
+	constraint pk_metric_value_latest primary key (server_id, metric_id, database_name)
+);
+
+alter table metric_value_latest add column if not exists labels jsonb null;
+
+-- History of server roles detected within clusters (initial detection and failovers)
+create table if not exists server_role_history (
+	time timestamptz not null,
+	server_id integer not null,
+	cluster_name varchar(255) not null,
+	role varchar(20) not null,
+	previous_role varchar(20) null,
+
+	constraint pk_server_role_history primary key (server_id, time),
+
+	constraint fk_server_role_history_server_id foreign key (server_id) references server (server_id),
+
+	constraint chk_server_role_history_role check (role in ('primary', 'replica'))
+);
+
+-- Current collection state of every server/metric/database task ("monitoring of the monitoring")
+create table if not exists collection_status (
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null constraint df_collection_status_database_name default (''), -- empty for server level metrics
+	last_run_time timestamptz not null,
+	last_success_time timestamptz null,
+	last_error text null,
+	last_error_time timestamptz null,
+	consecutive_failures integer not null constraint df_collection_status_consecutive_failures default (0),
+	next_run_time timestamptz null,
+	skipped_runs integer not null constraint df_collection_status_skipped_runs default (0), -- runs skipped while the previous one was active
+	last_skip_time timestamptz null,
+	unavailable_reason text null, -- why the task is not scheduled, e.g. a required extension is not installed
+
+	constraint pk_collection_status primary key (server_id, metric_id, database_name),
+
+	constraint fk_collection_status_server_id foreign key (server_id) references server (server_id),
+
+	constraint fk_collection_status_metric_id foreign key (metric_id) references metric (metric_id)
+);
+
+-- Add skipped run counters to collection_status tables created before overlapping runs were skipped
+alter table collection_status add column if not exists skipped_runs integer not null default 0;
+alter table collection_status add column if not exists last_skip_time timestamptz null;
+alter table collection_status add column if not exists unavailable_reason text null;
+
+-- Every collection attempt with its outcome, written when storage.collection-log is enabled
+create table if not exists collection_log (
+	time timestamptz not null, -- start of the attempt
+	server_id integer not null, -- no foreign key for insert optimization reasons
+	metric_id integer not null, -- no foreign key for insert optimization reasons
+	database_name varchar(255) not null constraint df_collection_log_database_name default (''), -- empty for server level metrics
+	task_id bigint null, -- scheduler run id, the same as task_id of log records
+	status varchar(20) not null,
+	error text null,
+	duration_ms numeric(12, 3) not null,
+
+	constraint chk_collection_log_status check (status in ('success', 'empty', 'error', 'timeout'))
+);
+
+create index if not exists ix_collection_log_server_id_metric_id_time on collection_log (server_id, metric_id, time);
+create index if not exists ix_collection_log_time on collection_log (time);
+
+-- Servers added through the API, their definitions are replayed when the collector starts
+create table if not exists managed_server (
+	name varchar(255) not null,
+	profile varchar(255) not null,
+	definition jsonb not null,
+	created_at timestamptz not null constraint df_managed_server_created_at default (current_timestamp),
+
+	constraint pk_managed_server primary key (name)
+);
+
+-- Leases held by one of elmon instances sharing the metrics DB, e.g. the leader lease
+create table if not exists lease (
+	name varchar(255) not null,
+	holder varchar(255) not null, -- instance id
+	acquired_at timestamptz not null, -- since when the holder has the lease
+	expires_at timestamptz not null, -- others can take the lease over after this time
+
+	constraint pk_lease primary key (name)
+);
+
+-- Configuration every instance started with, to correlate changes of metric behavior with configuration edits
+create table if not exists config_version (
+	config_version_id bigserial not null,
+	time timestamptz not null,
+	instance varchar(255) not null, -- instance id, versions of an instance are compared with each other
+	app_version varchar(64) not null, -- elmon build version
+	config_hash char(64) not null, -- SHA-256 of settings
+	summary text not null, -- changes since the previous version of the instance
+	changes jsonb null, -- changed keys with old and new values, null for the first version of the instance
+	settings jsonb not null, -- flattened configuration with secrets redacted
+
+	constraint pk_config_version primary key (config_version_id)
+);
+
+create index if not exists ix_config_version_instance on config_version (instance, config_version_id);
+
+-- Monthly availability of servers computed from the up/down metric of reports.availability
+create table if not exists availability_report (
+	server_id integer not null,
+	period_start timestamptz not null, -- first day of the month in the report timezone
+	period_end timestamptz not null,
+	availability_percent numeric(7, 4) null, -- null when the server was not monitored in the month
+	up_seconds bigint not null,
+	down_seconds bigint not null,
+	unmonitored_seconds bigint not null, -- no value covered this time, e.g. while the collector was stopped
+	outages integer not null,
+	restarts integer not null,
+	complete boolean not null, -- false while the month is running
+	computed_at timestamptz not null,
+	notified_at timestamptz null, -- summary of the complete month sent to every channel
+	webhook_notified_at timestamptz null, -- summary posted to the webhook
+	email_notified_at timestamptz null, -- summary mailed
+
+	constraint pk_availability_report primary key (server_id, period_start),
+
+	constraint fk_availability_report_server_id foreign key (server_id) references server (server_id)
+);
+
+alter table availability_report add column if not exists webhook_notified_at timestamptz null;
+alter table availability_report add column if not exists email_notified_at timestamptz null;
+
+-- Running elmon instances sharing the metrics DB, every instance renews its row with heartbeats
+create table if not exists elmon_instance (
+	instance varchar(255) not null, -- instance id, the same as in config_version
+	hostname varchar(255) not null,
+	pid integer not null,
+	app_version varchar(64) not null, -- elmon build version
+	shard integer null, -- null without sharding
+	shards integer not null,
+	leader boolean not null, -- runs jobs shared by all instances, e.g. rollups
+	collecting boolean not null, -- false while standing by for the leader lease
+	servers jsonb not null, -- names of servers collected by the instance
+	started_at timestamptz not null,
+	heartbeat_at timestamptz not null,
+	heartbeat_interval_seconds integer not null, -- an instance missing three heartbeats is considered gone
+	stopped_at timestamptz null, -- set when the instance stopped cleanly
+
+	constraint pk_elmon_instance primary key (instance)
+);
+
 -- Function to automatically update the modified_at timestamp column
 create or replace function update_modified_at()
 returns trigger as $$
@@ -137,6 +360,11 @@ declare
 	start_date date;
 	end_date date;
 begin
+	-- metric_value is a hypertable when TimescaleDB storage is enabled, chunks are managed by TimescaleDB
+	if not exists (select 1 from pg_partitioned_table where partrelid = 'metric_value'::regclass) then
+		return;
+	end if;
+
 	-- Create parttiions
 	for i in 0..month_forward loop
 		partition_date := date_trunc('month', current_date + (i || ' months')::interval);
@@ -156,6 +384,35 @@ begin
 end;
 $$ language plpgsql;
 
+-- Function to create metric_value partitions of the months from from_time to to_time, both included,
+-- e.g. past months filled by elmon backfill
+create or replace function create_metric_partitions_between(from_time timestamptz, to_time timestamptz)
+returns void as $$
+declare
+	partition_date date;
+	partition_name text;
+begin
+	-- metric_value is a hypertable when TimescaleDB storage is enabled, chunks are managed by TimescaleDB
+	if not exists (select 1 from pg_partitioned_table where partrelid = 'metric_value'::regclass) then
+		return;
+	end if;
+
+	partition_date := date_trunc('month', from_time);
+	while partition_date <= date_trunc('month', to_time) loop
+		partition_name := 'metric_value_' || to_char(partition_date, 'YYYY_MM');
+		if not exists (select 1 from pg_tables where tablename = partition_name) then
+			execute format(
+				'create table %I partition of metric_value for values from (%L) to (%L)',
+				partition_name,
+				partition_date,
+				partition_date + interval '1 month'
+			);
+		end if;
+		partition_date := partition_date + interval '1 month';
+	end loop;
+end;
+$$ language plpgsql;
+
 -- Function to drop old partitions based on retention policy
 create or replace function drop_old_metric_partitions(retention_months integer default 6)
 returns void as $$
@@ -0,0 +1,208 @@
+-- Schema of SQLite metrics database, mirrors init.sql without partitioning and server side functions.
+-- Times are written by the collector in UTC, JSON values are stored as text.
+
+-- Table to store details of monitored database servers
+create table if not exists server (
+	server_id integer primary key autoincrement,
+	environment_name varchar(100) not null,
+	name varchar(255) not null,
+	host varchar(255) not null,
+	port smallint not null,
+	timezone varchar(64),
+	ssl_mode varchar(20) null,
+	driver varchar(20) not null default ('postgres'),
+	description text null,
+	is_active boolean not null,
+	deactivated_at timestamp null,
+	created_at timestamp not null default (current_timestamp),
+	modified_at timestamp null,
+
+	constraint uq_server_name unique(name),
+
+	constraint chk_server_port check (port between 1 and 65535),
+	constraint chk_server_ssl_mode check (ssl_mode in ('disable', 'allow', 'prefer', 'require', 'verify-ca', 'verify-full'))
+);
+
+-- Trigger to update modified_at column of the server table
+create trigger if not exists trigger_server_modified_at
+	after update on server
+	for each row when new.modified_at is old.modified_at
+begin
+	update server set modified_at = current_timestamp where server_id = new.server_id;
+end;
+
+-- Dictionary table for logical groups of metrics
+create table if not exists metric_group (
+	metric_group_id integer primary key autoincrement,
+	metric_group_name varchar(255) not null constraint uq_metric_group_metric_group_name unique,
+	description text null
+);
+
+-- Table defining individual metrics
+create table if not exists metric (
+	metric_id integer primary key autoincrement,
+	metric_group_id integer not null,
+	metric_name varchar(255) not null,
+	description text null,
+	unit varchar(64) null, -- e.g. seconds or bytes
+	value_type varchar(16) null, -- int, int64, float, string, bool or table
+	collection_type varchar(32) null, -- sql, go_func, command, http, expression or bundle
+	interval_seconds numeric(12, 3) null, -- collection interval, servers-metrics-map may override it
+	is_active boolean not null default true,
+	deactivated_at timestamp null,
+
+	constraint fk_metric_metric_group_id foreign key (metric_group_id) references metric_group (metric_group_id),
+
+	constraint uq_metric_metric_name unique (metric_name)
+);
+
+-- Main table for storing collected metric values
+create table if not exists metric_value (
+	time timestamp not null,
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null default (''), -- empty for server level metrics
+	metric_value text not null, -- JSON document
+	collection_duration_ms numeric(12, 3) null, -- time spent collecting the value on the target
+	target_time timestamp null, -- current time reported by the target server, null for non-SQL metrics
+	labels text null, -- JSON object of configured and returned labels
+
+	constraint pk_metric_value primary key (server_id, metric_id, database_name, time)
+);
+
+-- Latest value of metrics with storage-mode 'latest', one row per server/metric/database
+create table if not exists metric_value_latest (
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null default (''), -- empty for server level metrics
+	time timestamp not null,
+	metric_value text not null,
+	collection_duration_ms numeric(12, 3) null,
+	target_time timestamp null,
+	labels text null,
+
+	constraint pk_metric_value_latest primary key (server_id, metric_id, database_name)
+);
+
+-- History of server roles detected within clusters (initial detection and failovers)
+create table if not exists server_role_history (
+	time timestamp not null,
+	server_id integer not null,
+	cluster_name varchar(255) not null,
+	role varchar(20) not null,
+	previous_role varchar(20) null,
+
+	constraint pk_server_role_history primary key (server_id, time),
+
+	constraint fk_server_role_history_server_id foreign key (server_id) references server (server_id),
+
+	constraint chk_server_role_history_role check (role in ('primary', 'replica'))
+);
+
+-- Current collection state of every server/metric/database task
+create table if not exists collection_status (
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null default (''), -- empty for server level metrics
+	last_run_time timestamp not null,
+	last_success_time timestamp null,
+	last_error text null,
+	last_error_time timestamp null,
+	consecutive_failures integer not null default (0),
+	next_run_time timestamp null,
+	skipped_runs integer not null default (0), -- runs skipped while the previous one was active
+	last_skip_time timestamp null,
+	unavailable_reason text null, -- why the task is not scheduled, e.g. a required extension is not installed
+
+	constraint pk_collection_status primary key (server_id, metric_id, database_name),
+
+	constraint fk_collection_status_server_id foreign key (server_id) references server (server_id),
+
+	constraint fk_collection_status_metric_id foreign key (metric_id) references metric (metric_id)
+);
+
+-- Every collection attempt with its outcome, written when storage.collection-log is enabled
+create table if not exists collection_log (
+	time timestamp not null, -- start of the attempt
+	server_id integer not null,
+	metric_id integer not null,
+	database_name varchar(255) not null default (''), -- empty for server level metrics
+	task_id integer null, -- scheduler run id, the same as task_id of log records
+	status varchar(20) not null,
+	error text null,
+	duration_ms numeric(12, 3) not null,
+
+	constraint chk_collection_log_status check (status in ('success', 'empty', 'error', 'timeout'))
+);
+
+create index if not exists ix_collection_log_server_id_metric_id_time on collection_log (server_id, metric_id, time);
+create index if not exists ix_collection_log_time on collection_log (time);
+
+-- Leases held by one of elmon instances sharing the metrics DB, e.g. the leader lease
+create table if not exists lease (
+	name varchar(255) not null primary key,
+	holder varchar(255) not null, -- instance id
+	acquired_at timestamp not null, -- since when the holder has the lease
+	expires_at real not null -- julian day, others can take the lease over after this time
+);
+
+-- Configuration every instance started with, to correlate changes of metric behavior with configuration edits
+create table if not exists config_version (
+	config_version_id integer primary key autoincrement,
+	time timestamp not null,
+	instance varchar(255) not null, -- instance id, versions of an instance are compared with each other
+	app_version varchar(64) not null, -- elmon build version
+	config_hash char(64) not null, -- SHA-256 of settings
+	summary text not null, -- changes since the previous version of the instance
+	changes text null, -- changed keys with old and new values, null for the first version of the instance
+	settings text not null -- flattened configuration with secrets redacted
+);
+
+create index if not exists ix_config_version_instance on config_version (instance, config_version_id);
+
+-- Monthly availability of servers computed from the up/down metric of reports.availability
+create table if not exists availability_report (
+	server_id integer not null,
+	period_start timestamp not null, -- first day of the month in the report timezone
+	period_end timestamp not null,
+	availability_percent real null, -- null when the server was not monitored in the month
+	up_seconds integer not null,
+	down_seconds integer not null,
+	unmonitored_seconds integer not null, -- no value covered this time, e.g. while the collector was stopped
+	outages integer not null,
+	restarts integer not null,
+	complete boolean not null, -- false while the month is running
+	computed_at timestamp not null,
+	notified_at timestamp null, -- summary of the complete month sent to every channel
+	webhook_notified_at timestamp null, -- summary posted to the webhook
+	email_notified_at timestamp null, -- summary mailed
+
+	constraint pk_availability_report primary key (server_id, period_start),
+
+	constraint fk_availability_report_server_id foreign key (server_id) references server (server_id)
+);
+
+-- Running elmon instances sharing the metrics DB, every instance renews its row with heartbeats
+create table if not exists elmon_instance (
+	instance varchar(255) not null primary key, -- instance id, the same as in config_version
+	hostname varchar(255) not null,
+	pid integer not null,
+	app_version varchar(64) not null, -- elmon build version
+	shard integer null, -- null without sharding
+	shards integer not null,
+	leader boolean not null, -- runs jobs shared by all instances, e.g. rollups
+	collecting boolean not null, -- false while standing by for the leader lease
+	servers text not null, -- JSON array of names of servers collected by the instance
+	started_at timestamp not null,
+	heartbeat_at timestamp not null,
+	heartbeat_interval_seconds integer not null, -- an instance missing three heartbeats is considered gone
+	stopped_at timestamp null -- set when the instance stopped cleanly
+);
+
+-- Servers added through the API, their definitions are replayed when the collector starts
+create table if not exists managed_server (
+	name varchar(255) not null primary key,
+	profile varchar(255) not null,
+	definition text not null,
+	created_at timestamp not null default (current_timestamp)
+);
@@ -0,0 +1,6 @@
+-- Client connections and their limit, forecast by connection_usage_forecast
+select json_build_object(
+	'value', (select count(*) from pg_stat_activity where backend_type = 'client backend'),
+	'max_connections', current_setting('max_connections')::int
+		- current_setting('superuser_reserved_connections')::int
+);
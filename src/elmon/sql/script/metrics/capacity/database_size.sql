@@ -0,0 +1,4 @@
+-- Size of the current database in bytes, forecast by database_size_forecast
+select json_build_object(
+	'value', pg_database_size(current_database())
+);
@@ -0,0 +1,18 @@
+-- elmon get pg_stat_database counters of the current database, a bundle split into separate metrics
+select jsonb_build_object(
+    'xact_commit', xact_commit,
+    'xact_rollback', xact_rollback,
+    'blks_read', blks_read,
+    'blks_hit', blks_hit,
+    'tup_returned', tup_returned,
+    'tup_fetched', tup_fetched,
+    'tup_inserted', tup_inserted,
+    'tup_updated', tup_updated,
+    'tup_deleted', tup_deleted,
+    'conflicts', conflicts,
+    'temp_files', temp_files,
+    'temp_bytes', temp_bytes,
+    'deadlocks', deadlocks
+) as value
+from pg_stat_database
+where datname = current_database();
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- A sleeping session with an open InnoDB transaction is idle in transaction, as in pg_stat_activity
+select json_object(
+    'active', coalesce(sum(p.command not in ('Sleep', 'Daemon', 'Binlog Dump')), 0),
+    'idle', coalesce(sum(p.command = 'Sleep' and t.trx_id is null), 0),
+    'idle_in_transaction', coalesce(sum(p.command = 'Sleep' and t.trx_id is not null), 0),
+    'background', coalesce(sum(p.command in ('Daemon', 'Binlog Dump')), 0)
+) as connection_stats
+from information_schema.processlist p
+left join information_schema.innodb_trx t on t.trx_mysql_thread_id = p.id;
@@ -0,0 +1,6 @@
+-- elmon get total transactions
+select json_object(
+    'value', sum(variable_value)
+) as value
+from performance_schema.global_status
+where variable_name in ('Com_commit', 'Com_rollback');
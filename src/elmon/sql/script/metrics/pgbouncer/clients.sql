@@ -0,0 +1,2 @@
+-- Client connections with their state and the pool they use
+show clients;
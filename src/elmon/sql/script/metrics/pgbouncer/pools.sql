@@ -0,0 +1,2 @@
+-- Connection pools: client and server connections per database and user
+show pools;
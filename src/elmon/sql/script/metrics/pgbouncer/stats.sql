@@ -0,0 +1,2 @@
+-- Cumulative request, traffic and wait time counters per database
+show stats;
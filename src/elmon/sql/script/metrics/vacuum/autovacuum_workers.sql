@@ -0,0 +1,25 @@
+-- Running autovacuum workers and transaction ID wraparound headroom.
+-- value: number of running workers, workers: what they are doing
+select json_build_object(
+	'value', (select count(*) from pg_stat_activity where backend_type = 'autovacuum worker'),
+	'max_workers', current_setting('autovacuum_max_workers')::int,
+	-- anti-wraparound vacuum is forced when the age reaches freeze_max_age
+	'max_xid_age', (select max(age(datfrozenxid)) from pg_database),
+	'freeze_max_age', current_setting('autovacuum_freeze_max_age')::bigint,
+	'workers', coalesce((
+		select json_agg(json_build_object(
+			'pid', a.pid,
+			'database', a.datname,
+			-- the query is like "autovacuum: VACUUM ANALYZE public.orders"
+			'query', a.query,
+			'wraparound', a.query like '%to prevent wraparound%',
+			'phase', coalesce(p.phase, ''),
+			'heap_blocks_total', p.heap_blks_total,
+			'heap_blocks_scanned', p.heap_blks_scanned,
+			'duration_seconds', extract(epoch from now() - a.xact_start)::bigint
+		) order by a.xact_start)
+		from pg_stat_activity a
+		left join pg_stat_progress_vacuum p on p.pid = a.pid
+		where a.backend_type = 'autovacuum worker'
+	), '[]')
+);
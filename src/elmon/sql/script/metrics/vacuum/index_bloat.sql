@@ -0,0 +1,55 @@
+-- Estimated B-tree index bloat from planner statistics of the indexed columns.
+-- Expression and partial indexes are skipped, their size can't be estimated this way.
+-- value: estimated bloat of all indexes in bytes, indexes: 20 indexes with the most bloat
+with index_width as (
+	select
+		  i.indexrelid
+		, n.nspname as schema_name
+		, t.relname as table_name
+		, c.relname as index_name
+		, c.reltuples
+		, c.relpages
+		, current_setting('block_size')::numeric as block_size
+		, coalesce(substring(array_to_string(c.reloptions, ' ') from 'fillfactor=([0-9]+)')::numeric, 90) as fillfactor
+		-- index tuple header and average width of the indexed columns
+		, 8 + sum(coalesce(s.avg_width, 0)) as tuple_width
+	from pg_index i
+	join pg_class c on c.oid = i.indexrelid
+	join pg_class t on t.oid = i.indrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	join pg_am am on am.oid = c.relam and am.amname = 'btree'
+	join pg_attribute a on a.attrelid = i.indrelid and a.attnum = any (i.indkey)
+	left join pg_stats s on s.schemaname = n.nspname and s.tablename = t.relname and s.attname = a.attname
+	where n.nspname not in ('pg_catalog', 'information_schema')
+		and i.indexprs is null
+		and i.indpred is null
+		and c.reltuples > 0
+	group by i.indexrelid, n.nspname, t.relname, c.relname, c.reltuples, c.relpages, c.reloptions
+),
+index_bloat as (
+	select
+		  schema_name
+		, table_name
+		, index_name
+		, relpages * block_size as size_bytes
+		-- every tuple also takes a 4 byte line pointer, a page keeps 24 + 16 bytes for its header
+		-- and B-tree special space, the metapage is not counted
+		, greatest(relpages - 1 - ceil(reltuples * (tuple_width + 4) / ((block_size - 40) * fillfactor / 100)), 0)
+			* block_size as bloat_bytes
+	from index_width
+)
+select json_build_object(
+	'value', coalesce(sum(bloat_bytes), 0)::bigint,
+	'indexes', coalesce(json_agg(json_build_object(
+		'schema', schema_name,
+		'table', table_name,
+		'index', index_name,
+		'size_bytes', size_bytes::bigint,
+		'bloat_bytes', bloat_bytes::bigint,
+		'bloat_ratio', round(bloat_bytes / nullif(size_bytes, 0), 4)
+	) order by bloat_bytes desc) filter (where rank <= 20), '[]')
+)
+from (
+	select *, row_number() over (order by bloat_bytes desc) as rank
+	from index_bloat
+) ranked;
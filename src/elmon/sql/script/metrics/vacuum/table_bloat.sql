@@ -0,0 +1,46 @@
+-- Estimated table bloat from planner statistics (run ANALYZE for accurate numbers).
+-- value: estimated bloat of all tables in bytes, tables: 20 tables with the most bloat
+with table_width as (
+	select
+		  c.oid
+		, n.nspname as schema_name
+		, c.relname as table_name
+		, c.reltuples
+		, c.relpages
+		, current_setting('block_size')::numeric as block_size
+		, coalesce(substring(array_to_string(c.reloptions, ' ') from 'fillfactor=([0-9]+)')::numeric, 100) as fillfactor
+		-- tuple header, null bitmap and average width of the columns
+		, 24 + ceil(count(*) / 8.0) + sum((1 - coalesce(s.null_frac, 0)) * coalesce(s.avg_width, 0)) as tuple_width
+	from pg_class c
+	join pg_namespace n on n.oid = c.relnamespace
+	join pg_attribute a on a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped
+	left join pg_stats s on s.schemaname = n.nspname and s.tablename = c.relname and s.attname = a.attname
+	where c.relkind in ('r', 'm')
+		and n.nspname not in ('pg_catalog', 'information_schema')
+		and c.reltuples > 0
+	group by c.oid, n.nspname, c.relname, c.reltuples, c.relpages, c.reloptions
+),
+table_bloat as (
+	select
+		  schema_name
+		, table_name
+		, relpages * block_size as size_bytes
+		-- every tuple also takes a 4 byte line pointer, a page keeps 24 bytes for its header
+		, greatest(relpages - ceil(reltuples * (tuple_width + 4) / ((block_size - 24) * fillfactor / 100)), 0)
+			* block_size as bloat_bytes
+	from table_width
+)
+select json_build_object(
+	'value', coalesce(sum(bloat_bytes), 0)::bigint,
+	'tables', coalesce(json_agg(json_build_object(
+		'schema', schema_name,
+		'table', table_name,
+		'size_bytes', size_bytes::bigint,
+		'bloat_bytes', bloat_bytes::bigint,
+		'bloat_ratio', round(bloat_bytes / nullif(size_bytes, 0), 4)
+	) order by bloat_bytes desc) filter (where rank <= 20), '[]')
+)
+from (
+	select *, row_number() over (order by bloat_bytes desc) as rank
+	from table_bloat
+) ranked;
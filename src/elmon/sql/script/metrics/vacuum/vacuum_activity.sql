@@ -0,0 +1,50 @@
+-- Last (auto)vacuum and (auto)analyze of tables with dead tuples.
+-- value: number of tables over the autovacuum threshold, which autovacuum should process next
+-- (per-table autovacuum settings are not taken into account),
+-- tables: 20 tables with the most dead tuples
+with table_stats as (
+	select
+		  s.schemaname as schema_name
+		, s.relname as table_name
+		, s.n_live_tup
+		, s.n_dead_tup
+		, s.n_mod_since_analyze
+		, s.last_vacuum
+		, s.last_autovacuum
+		, s.last_analyze
+		, s.last_autoanalyze
+		, s.vacuum_count
+		, s.autovacuum_count
+		, s.analyze_count
+		, s.autoanalyze_count
+		, s.n_dead_tup > current_setting('autovacuum_vacuum_threshold')::numeric
+			+ current_setting('autovacuum_vacuum_scale_factor')::numeric * c.reltuples as vacuum_pending
+	from pg_stat_user_tables s
+	join pg_class c on c.oid = s.relid
+)
+select json_build_object(
+	'value', count(*) filter (where vacuum_pending),
+	'tables', coalesce(json_agg(json_build_object(
+		'schema', schema_name,
+		'table', table_name,
+		'live_tuples', n_live_tup,
+		'dead_tuples', n_dead_tup,
+		'dead_ratio', round(n_dead_tup::numeric / nullif(n_live_tup + n_dead_tup, 0), 4),
+		'modified_since_analyze', n_mod_since_analyze,
+		'vacuum_pending', vacuum_pending,
+		'last_vacuum', last_vacuum,
+		'last_autovacuum', last_autovacuum,
+		'last_analyze', last_analyze,
+		'last_autoanalyze', last_autoanalyze,
+		'vacuum_age_seconds', extract(epoch from now() - greatest(last_vacuum, last_autovacuum))::bigint,
+		'analyze_age_seconds', extract(epoch from now() - greatest(last_analyze, last_autoanalyze))::bigint,
+		'vacuum_count', vacuum_count,
+		'autovacuum_count', autovacuum_count,
+		'analyze_count', analyze_count,
+		'autoanalyze_count', autoanalyze_count
+	) order by n_dead_tup desc) filter (where rank <= 20 and n_dead_tup > 0), '[]')
+)
+from (
+	select *, row_number() over (order by n_dead_tup desc) as rank
+	from table_stats
+) ranked;
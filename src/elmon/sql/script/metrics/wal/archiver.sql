@@ -0,0 +1,14 @@
+-- WAL archiver counters, use with kind: counter to get archived and failed segments per run.
+-- value: segments failed to archive since the statistics reset,
+-- failing: the last archive_command attempt failed
+select json_build_object(
+	'value', failed_count,
+	'archived_count', archived_count,
+	'failing', coalesce(last_failed_time > coalesce(last_archived_time, '-infinity'), false),
+	'last_archived_wal', coalesce(last_archived_wal, ''),
+	'last_archived_time', last_archived_time,
+	'last_failed_wal', coalesce(last_failed_wal, ''),
+	'last_failed_time', last_failed_time,
+	'archive_age_seconds', extract(epoch from now() - last_archived_time)::bigint
+)
+from pg_stat_archiver;
@@ -0,0 +1,7 @@
+-- Current WAL position in bytes, use with kind: counter to get WAL generation rate.
+-- Standbys report the replayed position.
+select json_build_object(
+	'value', pg_wal_lsn_diff(
+		case when pg_is_in_recovery() then pg_last_wal_replay_lsn() else pg_current_wal_lsn() end,
+		'0/0')::bigint
+);
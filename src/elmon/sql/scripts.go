@@ -0,0 +1,38 @@
+package sql
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// bundledScripts are init scripts and the metric SQL library shipped with elmon,
+// so the binary works without the sql/script directory next to it
+//
+//go:embed script
+var bundledScripts embed.FS
+
+// bundledDir is the directory of bundled scripts as configurations refer to it
+const bundledDir = "sql/script/"
+
+// ReadScript reads a SQL file. Files on disk take precedence, so edited and custom scripts are used
+// as they are. A bundled script (sql/script/...) missing on disk is read from the binary.
+func ReadScript(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return content, err
+	}
+	name := strings.TrimPrefix(path.Clean(filepath.ToSlash(file)), "./")
+	if !strings.HasPrefix(name, bundledDir) {
+		return nil, err
+	}
+	content, bundledErr := bundledScripts.ReadFile(strings.TrimPrefix(name, "sql/"))
+	if bundledErr != nil {
+		return nil, err
+	}
+	return content, nil
+}
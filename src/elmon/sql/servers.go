@@ -10,17 +10,19 @@ import (
 // SaveServerToMetricsDb now accepts local ServerInfo type
 func SaveServerToMetricsDb(log *logger.Logger, server *ServerInfo, metricsDb *sql.DB) error {
 	query := `
-		INSERT INTO server (environment_name, name, host, port, timezone, ssl_mode, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, true)
+		INSERT INTO server (environment_name, name, host, port, timezone, ssl_mode, driver, is_active)
+		VALUES ($1, $2, $3, $4, coalesce(nullif($5, ''), 'UTC'), $6, $7, true)
 		ON CONFLICT (name) DO UPDATE SET
 			host = excluded.host, port = excluded.port, environment_name = excluded.environment_name,
-			timezone = excluded.timezone, ssl_mode = excluded.ssl_mode
+			timezone = case when $5 = '' then server.timezone else excluded.timezone end, ssl_mode = excluded.ssl_mode, driver = excluded.driver,
+			is_active = true, deactivated_at = null
 		RETURNING server_id;`
 
+	// Servers not connected in this run have no timezone, the stored one is kept for them
 	var serverID int
 	err := metricsDb.QueryRow(query,
 		server.Environment, server.Name, server.Host, server.Port,
-		"UTC", server.SslMode,
+		server.Timezone, server.SslMode, server.Driver,
 	).Scan(&serverID)
 
 	if err != nil {
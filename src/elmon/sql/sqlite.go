@@ -0,0 +1,358 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// buildSQLiteDSN uses dbname as the database file path. Writers from many tasks wait
+// for each other instead of failing with "database is locked".
+func buildSQLiteDSN(params ConnectionParams) string {
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", "busy_timeout(10000)")
+	pragmas.Add("_pragma", "journal_mode(WAL)")
+	pragmas.Add("_pragma", "foreign_keys(1)")
+	return "file:" + params.DbName + "?" + pragmas.Encode()
+}
+
+// SQLiteStorage keeps metrics in a local SQLite file, schema is created by init.sqlite.sql.
+// Times are generated by the collector since SQLite has no server clock.
+type SQLiteStorage struct {
+	db           *sql.DB
+	writeTimeout time.Duration
+}
+
+func (s *SQLiteStorage) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLiteStorage) InitScript() string {
+	return "init.sqlite.sql"
+}
+
+// sqliteAddedColumns are columns added to tables of init.sqlite.sql after the tables were released
+var sqliteAddedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"server", "deactivated_at", "timestamp null"},
+	{"metric", "is_active", "boolean not null default true"},
+	{"metric", "deactivated_at", "timestamp null"},
+	{"metric", "unit", "varchar(64) null"},
+	{"metric", "value_type", "varchar(16) null"},
+	{"metric", "collection_type", "varchar(32) null"},
+	{"metric", "interval_seconds", "numeric(12, 3) null"},
+	{"collection_status", "unavailable_reason", "text null"},
+	{"availability_report", "webhook_notified_at", "timestamp null"},
+	{"availability_report", "email_notified_at", "timestamp null"},
+}
+
+// UpgradeSchema adds missing sqliteAddedColumns, SQLite has no "add column if not exists"
+func (s *SQLiteStorage) UpgradeSchema(ctx context.Context) error {
+	for _, added := range sqliteAddedColumns {
+		var exists bool
+		err := s.db.QueryRowContext(ctx, `select count(*) > 0 from pragma_table_info($1) where name = $2;`,
+			added.table, added.column).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to read columns of table '%s': %w", added.table, err)
+		}
+		if exists {
+			continue
+		}
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf("alter table %s add column %s %s;", added.table, added.column,
+			added.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column '%s' to table '%s': %w", added.column, added.table, err)
+		}
+	}
+	return nil
+}
+
+// CreateMetricPartitions has nothing to do, metric_value of SQLite is not partitioned
+func (s *SQLiteStorage) CreateMetricPartitions(ctx context.Context, from time.Time, to time.Time) error {
+	return nil
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Now returns the collector clock, SQLite is a file of the collector host and has no clock of its own
+func (s *SQLiteStorage) Now(ctx context.Context) (time.Time, error) {
+	return time.Now(), nil
+}
+
+// SaveMetrics uses the PostgreSQL statements, SQLite supports the same upsert syntax
+func (s *SQLiteStorage) SaveMetrics(log *logger.Logger, config *MetricConfigForDB) error {
+	return InsertMetricsToDB(log, config, s.db)
+}
+
+func (s *SQLiteStorage) SaveServers(log *logger.Logger, servers []*ServerInfo) error {
+	return SaveAllServersToMetricsDb(log, servers, s.db)
+}
+
+func (s *SQLiteStorage) DeactivateServers(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return DeactivateServers(ctx, s.db, keep)
+}
+
+// Times are compared in UTC, the way they are written
+func (s *SQLiteStorage) PurgeInactive(ctx context.Context, before time.Time) (int64, int64, error) {
+	return purgeInactive(ctx, s.db, before.UTC(), "server_role_history", "availability_report")
+}
+
+func (s *SQLiteStorage) InsertMetricValue(ctx context.Context, log *logger.Logger, valueTime time.Time, metricId int,
+	serverId int, databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return insertSQLiteMetricValue(ctx, log, s.db, valueTime, metricId, serverId, databaseName, value, labels, duration,
+		targetTime)
+}
+
+// insertSQLiteMetricValue inserts the value on the connection pool or inside a transaction
+func insertSQLiteMetricValue(ctx context.Context, log *logger.Logger, db Execer, valueTime time.Time, metricId int,
+	serverId int, databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	const insertSQL = `
+		insert into metric_value (time, server_id, metric_id, database_name, metric_value, collection_duration_ms, target_time, labels)
+		values ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+
+	if valueTime.IsZero() {
+		valueTime = time.Now()
+	}
+	labelsJSON, err := labelsValue(labels)
+	if err == nil {
+		_, err = db.ExecContext(ctx, insertSQL, valueTime.UTC(), serverId, metricId, databaseName, string(value),
+			durationMs(duration), nullTime(targetTime), labelsJSON)
+	}
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert metric: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) UpsertLatestMetricValue(ctx context.Context, log *logger.Logger, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return upsertSQLiteLatestValue(ctx, log, s.db, metricId, serverId, databaseName, value, labels, duration, targetTime)
+}
+
+func (s *SQLiteStorage) WriteMetricValues(ctx context.Context, log *logger.Logger, rows []MetricValueRow) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return writeMetricValues(ctx, log, s.db, rows, insertSQLiteMetricValue, upsertSQLiteLatestValue)
+}
+
+// upsertSQLiteLatestValue replaces the latest value on the connection pool or inside a transaction
+func upsertSQLiteLatestValue(ctx context.Context, log *logger.Logger, db Execer, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	const upsertSQL = `
+		insert into metric_value_latest (server_id, metric_id, database_name, time, metric_value, collection_duration_ms, target_time, labels)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)
+		on conflict (server_id, metric_id, database_name) do update set
+			time = excluded.time,
+			metric_value = excluded.metric_value,
+			collection_duration_ms = excluded.collection_duration_ms,
+			target_time = excluded.target_time,
+			labels = excluded.labels;
+	`
+
+	labelsJSON, err := labelsValue(labels)
+	if err == nil {
+		_, err = db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, time.Now().UTC(), string(value),
+			durationMs(duration), nullTime(targetTime), labelsJSON)
+	}
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to upsert metric: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) UpsertCollectionStatus(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, runErr error, nextRun time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time,
+			last_success_time, last_error, last_error_time, consecutive_failures, next_run_time)
+		values ($1, $2, $3, $7,
+			case when $4 then null else $7 end,
+			nullif($5, ''),
+			case when $4 then $7 end,
+			case when $4 then 1 else 0 end,
+			$6)
+		on conflict (server_id, metric_id, database_name) do update set
+			last_run_time = excluded.last_run_time,
+			last_success_time = coalesce(excluded.last_success_time, s.last_success_time),
+			last_error = coalesce(excluded.last_error, s.last_error),
+			last_error_time = coalesce(excluded.last_error_time, s.last_error_time),
+			consecutive_failures = case when $4 then s.consecutive_failures + 1 else 0 end,
+			next_run_time = excluded.next_run_time,
+			unavailable_reason = null;
+	`
+
+	failed := runErr != nil
+	errorMessage := ""
+	if failed {
+		errorMessage = runErr.Error()
+	}
+
+	_, err := s.db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, failed, errorMessage, nullTime(nextRun),
+		time.Now().UTC())
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to update collection status: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) RecordSkippedRun(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, nextRun time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time,
+			next_run_time, skipped_runs, last_skip_time)
+		values ($1, $2, $3, $5, $4, 1, $5)
+		on conflict (server_id, metric_id, database_name) do update set
+			skipped_runs = s.skipped_runs + 1,
+			last_skip_time = excluded.last_skip_time,
+			next_run_time = excluded.next_run_time;
+	`
+
+	_, err := s.db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, nullTime(nextRun), time.Now().UTC())
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to record skipped run: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) MarkCollectionUnavailable(ctx context.Context, log *logger.Logger, serverId int,
+	metricId int, databaseName string, reason string) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time, unavailable_reason)
+		values ($1, $2, $3, $5, $4)
+		on conflict (server_id, metric_id, database_name) do update set
+			next_run_time = null,
+			unavailable_reason = excluded.unavailable_reason;
+	`
+
+	_, err := s.db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, reason, time.Now().UTC())
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to mark collection unavailable: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) InsertCollectionLog(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, taskID uint64, startTime time.Time, duration time.Duration, status string,
+	runErr error) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	const insertSQL = `
+		insert into collection_log (time, server_id, metric_id, database_name, task_id, status, error, duration_ms)
+		values ($1, $2, $3, $4, $5, $6, nullif($7, ''), $8);
+	`
+
+	errorMessage := ""
+	if runErr != nil {
+		errorMessage = runErr.Error()
+	}
+	_, err := s.db.ExecContext(ctx, insertSQL, startTime.UTC(), serverId, metricId, databaseName, nullTaskID(taskID), status,
+		errorMessage, durationMs(duration))
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert collection log: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteCollectionLog(ctx context.Context, before time.Time) (int64, error) {
+	return DeleteCollectionLog(ctx, s.db, before.UTC())
+}
+
+// Lease expiry is kept as a julian day number, so it is compared as a number with the SQLite clock
+func (s *SQLiteStorage) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	const upsertSQL = `
+		insert into lease (name, holder, acquired_at, expires_at)
+		values ($1, $2, current_timestamp, julianday('now') + $3 / 86400.0)
+		on conflict (name) do update set
+			holder = excluded.holder,
+			acquired_at = case when lease.holder = excluded.holder then lease.acquired_at else excluded.acquired_at end,
+			expires_at = excluded.expires_at
+		where lease.holder = excluded.holder or lease.expires_at < julianday('now')
+		returning holder;
+	`
+	return acquireLease(ctx, s.db, upsertSQL, name, holder, ttl)
+}
+
+func (s *SQLiteStorage) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return ReleaseLease(ctx, s.db, name, holder)
+}
+
+func (s *SQLiteStorage) InsertServerRoleChange(ctx context.Context, log *logger.Logger, serverId int,
+	clusterName string, role string, previousRole string) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	const insertSQL = `
+		insert into server_role_history (time, server_id, cluster_name, role, previous_role)
+		values ($5, $1, $2, $3, nullif($4, ''));
+	`
+
+	if _, err := s.db.ExecContext(ctx, insertSQL, serverId, clusterName, role, previousRole, time.Now().UTC()); err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert server role change: serverId=%d, role=%s", serverId, role))
+		return err
+	}
+	return nil
+}
+
+// Managed servers use the PostgreSQL statements, definition is stored as text
+func (s *SQLiteStorage) SaveManagedServer(server ManagedServer) error {
+	return SaveManagedServer(s.db, server)
+}
+
+func (s *SQLiteStorage) DeleteManagedServer(name string) error {
+	return DeleteManagedServer(s.db, name)
+}
+
+func (s *SQLiteStorage) LoadManagedServers() ([]ManagedServer, error) {
+	return LoadManagedServers(s.db)
+}
+
+// Configuration versions use the PostgreSQL statements, changes and settings are stored as text
+func (s *SQLiteStorage) SaveConfigVersion(ctx context.Context, version ConfigVersion) error {
+	version.Time = version.Time.UTC()
+	return SaveConfigVersion(ctx, s.db, version)
+}
+
+func (s *SQLiteStorage) LastConfigVersion(ctx context.Context, instance string) (*ConfigVersion, error) {
+	return LastConfigVersion(ctx, s.db, instance)
+}
+
+// durationMs converts collection duration to milliseconds stored in collection_duration_ms
+func durationMs(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// nullTime stores zero time as NULL, in UTC otherwise
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value.UTC(), Valid: !value.IsZero()}
+}
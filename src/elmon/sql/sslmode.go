@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// openDB opens a connection pool. Token authentication and the allow and prefer ssl modes of libpq,
+// which lib/pq doesn't support, need custom connectors for composed connection strings.
+func openDB(params ConnectionParams, dsn string) (*sql.DB, error) {
+	if params.DSN != "" {
+		return sql.Open(sqlDriverName(params), dsn)
+	}
+	if params.Auth.IsToken() {
+		return openTokenDB(params)
+	}
+	if sqlDriverName(params) != DriverPostgres || (params.SslMode != "allow" && params.SslMode != "prefer") {
+		return sql.Open(sqlDriverName(params), dsn)
+	}
+	connector, err := newConnector(params, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// newAllowConnector tries a plain connection first and SSL when the server rejects it
+func newAllowConnector(params ConnectionParams) (driver.Connector, error) {
+	return newSSLFallbackConnector(params, "disable", "require", func(err error) bool {
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr)
+	})
+}
+
+// newPreferConnector tries an SSL connection first and a plain one when the server has no SSL
+func newPreferConnector(params ConnectionParams) (driver.Connector, error) {
+	return newSSLFallbackConnector(params, "require", "disable", func(err error) bool {
+		return errors.Is(err, pq.ErrSSLNotSupported)
+	})
+}
+
+// newSSLFallbackConnector connects with the first ssl mode and retries with the second one
+// when fallback accepts the error
+func newSSLFallbackConnector(params ConnectionParams, first string, second string, fallback func(error) bool) (driver.Connector, error) {
+	connectors := make([]driver.Connector, 0, 2)
+	for _, mode := range []string{first, second} {
+		params.SslMode = mode
+		dsn, err := BuildDSN(params)
+		if err != nil {
+			return nil, err
+		}
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		connectors = append(connectors, connector)
+	}
+	return &sslFallbackConnector{first: connectors[0], second: connectors[1], fallback: fallback}, nil
+}
+
+// sslFallbackConnector connects with the second connector when the first one fails with an error
+// accepted by fallback
+type sslFallbackConnector struct {
+	first    driver.Connector
+	second   driver.Connector
+	fallback func(error) bool
+}
+
+func (connector *sslFallbackConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := connector.first.Connect(ctx)
+	if err == nil || ctx.Err() != nil || !connector.fallback(err) {
+		return conn, err
+	}
+	return connector.second.Connect(ctx)
+}
+
+func (connector *sslFallbackConnector) Driver() driver.Driver {
+	return connector.first.Driver()
+}
@@ -0,0 +1,134 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"fmt"
+	"time"
+)
+
+// UpsertCollectionStatus records the result of a collection attempt in collection_status table.
+// A nil runErr marks a successful run and resets the consecutive failure counter.
+func UpsertCollectionStatus(ctx context.Context, log *logger.Logger, db *sql.DB, serverId int, metricId int,
+	databaseName string, runErr error, nextRun time.Time) error {
+	if db == nil {
+		err := fmt.Errorf("database connection (DB) is nil. Cannot update collection status: serverId=%d, metricId=%d", serverId, metricId)
+		log.Error(err, "Failed to update collection status")
+		return err
+	}
+
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time,
+			last_success_time, last_error, last_error_time, consecutive_failures, next_run_time)
+		values ($1, $2, $3, now(),
+			case when $4 then null else now() end,
+			nullif($5, ''),
+			case when $4 then now() end,
+			case when $4 then 1 else 0 end,
+			$6)
+		on conflict (server_id, metric_id, database_name) do update set
+			last_run_time = excluded.last_run_time,
+			last_success_time = coalesce(excluded.last_success_time, s.last_success_time),
+			last_error = coalesce(excluded.last_error, s.last_error),
+			last_error_time = coalesce(excluded.last_error_time, s.last_error_time),
+			consecutive_failures = case when $4 then s.consecutive_failures + 1 else 0 end,
+			next_run_time = excluded.next_run_time,
+			unavailable_reason = null;
+	`
+
+	failed := runErr != nil
+	errorMessage := ""
+	if failed {
+		errorMessage = runErr.Error()
+	}
+
+	// Zero next run means the task is not scheduled (e.g. the scheduler is stopped)
+	next := sql.NullTime{Time: nextRun, Valid: !nextRun.IsZero()}
+
+	if _, err := db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, failed, errorMessage, next); err != nil {
+		log.Error(err, fmt.Sprintf("failed to update collection status: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+
+	return nil
+}
+
+// RecordSkippedRun counts a run skipped because the previous run of the task was still active.
+// A task without a status row yet gets one, its first run is still in progress.
+func RecordSkippedRun(ctx context.Context, log *logger.Logger, db *sql.DB, serverId int, metricId int,
+	databaseName string, nextRun time.Time) error {
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time,
+			next_run_time, skipped_runs, last_skip_time)
+		values ($1, $2, $3, now(), $4, 1, now())
+		on conflict (server_id, metric_id, database_name) do update set
+			skipped_runs = s.skipped_runs + 1,
+			last_skip_time = excluded.last_skip_time,
+			next_run_time = excluded.next_run_time;
+	`
+
+	next := sql.NullTime{Time: nextRun, Valid: !nextRun.IsZero()}
+	if _, err := db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, next); err != nil {
+		log.Error(err, fmt.Sprintf("failed to record skipped run: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+// MarkCollectionUnavailable records why a task is not scheduled, e.g. a required extension is missing.
+// The reason is cleared by the next collection attempt. A task without a status row yet gets one.
+func MarkCollectionUnavailable(ctx context.Context, log *logger.Logger, db *sql.DB, serverId int, metricId int,
+	databaseName string, reason string) error {
+	const upsertSQL = `
+		insert into collection_status as s (server_id, metric_id, database_name, last_run_time, unavailable_reason)
+		values ($1, $2, $3, now(), $4)
+		on conflict (server_id, metric_id, database_name) do update set
+			next_run_time = null,
+			unavailable_reason = excluded.unavailable_reason;
+	`
+
+	if _, err := db.ExecContext(ctx, upsertSQL, serverId, metricId, databaseName, reason); err != nil {
+		log.Error(err, fmt.Sprintf("failed to mark collection unavailable: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+// InsertCollectionLog stores a collection attempt in collection_log table, status is success, empty, error
+// or timeout. A zero taskID is stored as NULL.
+func InsertCollectionLog(ctx context.Context, log *logger.Logger, db *sql.DB, serverId int, metricId int,
+	databaseName string, taskID uint64, startTime time.Time, duration time.Duration, status string,
+	runErr error) error {
+	const insertSQL = `
+		insert into collection_log (time, server_id, metric_id, database_name, task_id, status, error, duration_ms)
+		values ($1, $2, $3, $4, $5, $6, nullif($7, ''), $8);
+	`
+
+	errorMessage := ""
+	if runErr != nil {
+		errorMessage = runErr.Error()
+	}
+	_, err := db.ExecContext(ctx, insertSQL, startTime, serverId, metricId, databaseName, nullTaskID(taskID), status,
+		errorMessage,
+		durationMs(duration))
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert collection log: serverId=%d, metricId=%d", serverId, metricId))
+		return err
+	}
+	return nil
+}
+
+// DeleteCollectionLog deletes collection_log rows older than before, returns the number of deleted rows
+func DeleteCollectionLog(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, `delete from collection_log where time < $1;`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old collection log: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// nullTaskID stores runs outside of a scheduler as NULL
+func nullTaskID(taskID uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(taskID), Valid: taskID != 0}
+}
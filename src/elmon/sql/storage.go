@@ -0,0 +1,285 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Storage is the metrics database: metric configuration, servers, collected values
+// and collection status. PostgreSQL is the default, SQLite suits small installations.
+type Storage interface {
+	// DB returns the underlying connection
+	DB() *sql.DB
+	// InitScript returns file name of the schema bootstrap script in the SQL directory
+	InitScript() string
+	// UpgradeSchema adds columns the init script can't add to tables created by an earlier version
+	UpgradeSchema(ctx context.Context) error
+	// CreateMetricPartitions creates missing metric_value partitions of the months from from to to,
+	// e.g. before values of past windows are stored
+	CreateMetricPartitions(ctx context.Context, from time.Time, to time.Time) error
+	Ping(ctx context.Context) error
+	// Now returns the current time of the metrics DB clock
+	Now(ctx context.Context) (time.Time, error)
+
+	SaveMetrics(log *logger.Logger, config *MetricConfigForDB) error
+	SaveServers(log *logger.Logger, servers []*ServerInfo) error
+	// DeactivateServers marks servers not kept by keep inactive, returns names of deactivated servers
+	DeactivateServers(ctx context.Context, keep func(name string) bool) ([]string, error)
+	// PurgeInactive deletes servers and metrics deactivated before the time, returns their numbers
+	PurgeInactive(ctx context.Context, before time.Time) (servers int64, metrics int64, err error)
+
+	// InsertMetricValue stores value collected at valueTime, zero valueTime means now
+	InsertMetricValue(ctx context.Context, log *logger.Logger, valueTime time.Time, metricId int, serverId int,
+		databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+		targetTime time.Time) error
+	UpsertLatestMetricValue(ctx context.Context, log *logger.Logger, metricId int, serverId int, databaseName string,
+		value json.RawMessage, labels map[string]string, duration time.Duration, targetTime time.Time) error
+	// WriteMetricValues stores the rows in one transaction, none of them is stored when one fails
+	WriteMetricValues(ctx context.Context, log *logger.Logger, rows []MetricValueRow) error
+	UpsertCollectionStatus(ctx context.Context, log *logger.Logger, serverId int, metricId int, databaseName string,
+		runErr error, nextRun time.Time) error
+	// RecordSkippedRun counts a scheduled run skipped because the previous run was still active
+	RecordSkippedRun(ctx context.Context, log *logger.Logger, serverId int, metricId int, databaseName string,
+		nextRun time.Time) error
+	// MarkCollectionUnavailable records why a task of the server, metric and database is not scheduled
+	MarkCollectionUnavailable(ctx context.Context, log *logger.Logger, serverId int, metricId int, databaseName string,
+		reason string) error
+	InsertServerRoleChange(ctx context.Context, log *logger.Logger, serverId int, clusterName string, role string,
+		previousRole string) error
+
+	// InsertCollectionLog stores a collection attempt started at startTime with its status and error
+	InsertCollectionLog(ctx context.Context, log *logger.Logger, serverId int, metricId int, databaseName string,
+		taskID uint64, startTime time.Time, duration time.Duration, status string, runErr error) error
+	// DeleteCollectionLog deletes attempts started before the time
+	DeleteCollectionLog(ctx context.Context, before time.Time) (int64, error)
+
+	// AcquireLease takes or extends the lease of the name for ttl, false when another holder has it
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+
+	// Servers added through the API
+	SaveManagedServer(server ManagedServer) error
+	DeleteManagedServer(name string) error
+	LoadManagedServers() ([]ManagedServer, error)
+
+	// Configuration every instance started with
+	SaveConfigVersion(ctx context.Context, version ConfigVersion) error
+	LastConfigVersion(ctx context.Context, instance string) (*ConfigVersion, error)
+}
+
+// NewStorage wraps metrics database connection opened with Connect. Writes of collected values and
+// collection status are cancelled after writeTimeout, so a hung metrics DB doesn't block collections.
+func NewStorage(driver string, db *sql.DB, writeTimeout time.Duration) (Storage, error) {
+	switch driver {
+	case "", DriverPostgres:
+		return &PostgresStorage{db: db, writeTimeout: writeTimeout}, nil
+	case DriverSQLite:
+		return &SQLiteStorage{db: db, writeTimeout: writeTimeout}, nil
+	default:
+		return nil, fmt.Errorf("unsupported metrics database driver: '%s'", driver)
+	}
+}
+
+// MetricValueRow is a collected value written by WriteMetricValues
+type MetricValueRow struct {
+	ValueTime    time.Time // zero means now
+	MetricID     int
+	ServerID     int
+	DatabaseName string
+	Value        json.RawMessage
+	Labels       map[string]string
+	Duration     time.Duration
+	TargetTime   time.Time
+	Latest       bool // replaces the value in metric_value_latest, ValueTime is ignored
+}
+
+// insertValueFunc and upsertValueFunc write a value of the metrics DB driver
+type insertValueFunc func(ctx context.Context, log *logger.Logger, db Execer, valueTime time.Time, metricId int,
+	serverId int, databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error
+type upsertValueFunc func(ctx context.Context, log *logger.Logger, db Execer, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error
+
+// writeMetricValues writes the rows in one transaction with the insert and upsert of the driver
+func writeMetricValues(ctx context.Context, log *logger.Logger, db *sql.DB, rows []MetricValueRow,
+	insert insertValueFunc, upsert upsertValueFunc) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return RunInTx(ctx, db, func(tx *sql.Tx) error {
+		for _, row := range rows {
+			var err error
+			if row.Latest {
+				err = upsert(ctx, log, tx, row.MetricID, row.ServerID, row.DatabaseName, row.Value, row.Labels,
+					row.Duration, row.TargetTime)
+			} else {
+				err = insert(ctx, log, tx, row.ValueTime, row.MetricID, row.ServerID, row.DatabaseName, row.Value,
+					row.Labels, row.Duration, row.TargetTime)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeContext limits a write to the write timeout, zero timeout leaves the context as is
+func writeContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// PostgresStorage keeps metrics in PostgreSQL, schema is created by init.sql
+type PostgresStorage struct {
+	db           *sql.DB
+	writeTimeout time.Duration
+}
+
+func (s *PostgresStorage) DB() *sql.DB {
+	return s.db
+}
+
+func (s *PostgresStorage) InitScript() string {
+	return "init.sql"
+}
+
+// UpgradeSchema has nothing to do, init.sql adds new columns with "add column if not exists" and migrates keys
+func (s *PostgresStorage) UpgradeSchema(ctx context.Context) error {
+	return nil
+}
+
+// CreateMetricPartitions creates monthly partitions with create_metric_partitions_between of init.sql,
+// nothing is created when metric_value is a hypertable
+func (s *PostgresStorage) CreateMetricPartitions(ctx context.Context, from time.Time, to time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `select create_metric_partitions_between($1, $2);`, from, to); err != nil {
+		return fmt.Errorf("failed to create metric_value partitions: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresStorage) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRowContext(ctx, "select now()").Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get metrics DB time: %w", err)
+	}
+	return now, nil
+}
+
+func (s *PostgresStorage) SaveMetrics(log *logger.Logger, config *MetricConfigForDB) error {
+	return InsertMetricsToDB(log, config, s.db)
+}
+
+func (s *PostgresStorage) SaveServers(log *logger.Logger, servers []*ServerInfo) error {
+	return SaveAllServersToMetricsDb(log, servers, s.db)
+}
+
+func (s *PostgresStorage) DeactivateServers(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return DeactivateServers(ctx, s.db, keep)
+}
+
+func (s *PostgresStorage) PurgeInactive(ctx context.Context, before time.Time) (int64, int64, error) {
+	return purgeInactive(ctx, s.db, before, "server_role_history", "credential", "availability_report")
+}
+
+func (s *PostgresStorage) InsertMetricValue(ctx context.Context, log *logger.Logger, valueTime time.Time, metricId int,
+	serverId int, databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return InsertMetricValueAt(ctx, log, s.db, valueTime, metricId, serverId, databaseName, value, labels, duration,
+		targetTime)
+}
+
+func (s *PostgresStorage) UpsertLatestMetricValue(ctx context.Context, log *logger.Logger, metricId int, serverId int,
+	databaseName string, value json.RawMessage, labels map[string]string, duration time.Duration,
+	targetTime time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return UpsertLatestMetricValue(ctx, log, s.db, metricId, serverId, databaseName, value, labels, duration, targetTime)
+}
+
+func (s *PostgresStorage) WriteMetricValues(ctx context.Context, log *logger.Logger, rows []MetricValueRow) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return writeMetricValues(ctx, log, s.db, rows, InsertMetricValueAt, UpsertLatestMetricValue)
+}
+
+func (s *PostgresStorage) UpsertCollectionStatus(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, runErr error, nextRun time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return UpsertCollectionStatus(ctx, log, s.db, serverId, metricId, databaseName, runErr, nextRun)
+}
+
+func (s *PostgresStorage) RecordSkippedRun(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, nextRun time.Time) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return RecordSkippedRun(ctx, log, s.db, serverId, metricId, databaseName, nextRun)
+}
+
+func (s *PostgresStorage) MarkCollectionUnavailable(ctx context.Context, log *logger.Logger, serverId int,
+	metricId int, databaseName string, reason string) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return MarkCollectionUnavailable(ctx, log, s.db, serverId, metricId, databaseName, reason)
+}
+
+func (s *PostgresStorage) InsertServerRoleChange(ctx context.Context, log *logger.Logger, serverId int,
+	clusterName string, role string, previousRole string) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return InsertServerRoleChange(ctx, log, s.db, serverId, clusterName, role, previousRole)
+}
+
+func (s *PostgresStorage) InsertCollectionLog(ctx context.Context, log *logger.Logger, serverId int, metricId int,
+	databaseName string, taskID uint64, startTime time.Time, duration time.Duration, status string,
+	runErr error) error {
+	ctx, cancel := writeContext(ctx, s.writeTimeout)
+	defer cancel()
+	return InsertCollectionLog(ctx, log, s.db, serverId, metricId, databaseName, taskID, startTime, duration, status, runErr)
+}
+
+func (s *PostgresStorage) DeleteCollectionLog(ctx context.Context, before time.Time) (int64, error) {
+	return DeleteCollectionLog(ctx, s.db, before)
+}
+
+func (s *PostgresStorage) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	return AcquireLease(ctx, s.db, name, holder, ttl)
+}
+
+func (s *PostgresStorage) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return ReleaseLease(ctx, s.db, name, holder)
+}
+
+func (s *PostgresStorage) SaveManagedServer(server ManagedServer) error {
+	return SaveManagedServer(s.db, server)
+}
+
+func (s *PostgresStorage) DeleteManagedServer(name string) error {
+	return DeleteManagedServer(s.db, name)
+}
+
+func (s *PostgresStorage) LoadManagedServers() ([]ManagedServer, error) {
+	return LoadManagedServers(s.db)
+}
+
+func (s *PostgresStorage) SaveConfigVersion(ctx context.Context, version ConfigVersion) error {
+	return SaveConfigVersion(ctx, s.db, version)
+}
+
+func (s *PostgresStorage) LastConfigVersion(ctx context.Context, instance string) (*ConfigVersion, error) {
+	return LastConfigVersion(ctx, s.db, instance)
+}
@@ -0,0 +1,199 @@
+package sql
+
+import (
+	"database/sql"
+	"elmon/logger"
+	"fmt"
+	"time"
+)
+
+// TimescaleParams defines hypertable settings of metric_value table
+type TimescaleParams struct {
+	ChunkInterval        time.Duration
+	CompressAfter        time.Duration // 0 disables compression
+	ContinuousAggregates bool
+}
+
+// TimescaleAvailable reports whether timescaledb extension can be created in the metrics database
+func TimescaleAvailable(db *sql.DB) (bool, error) {
+	var available bool
+	err := db.QueryRow(`select exists (select 1 from pg_available_extensions where name = 'timescaledb')`).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check timescaledb availability: %w", err)
+	}
+	return available, nil
+}
+
+// InitTimescale creates timescaledb extension and turns metric_value into a hypertable
+// with compression and continuous aggregates. It is idempotent and runs after init.sql.
+// A metric_value table created by init.sql as a partitioned table is migrated to a hypertable
+// in a single transaction, existing values are copied.
+func InitTimescale(log *logger.Logger, db *sql.DB, params TimescaleParams) error {
+	if _, err := db.Exec(`create extension if not exists timescaledb`); err != nil {
+		return fmt.Errorf("failed to create timescaledb extension: %w", err)
+	}
+
+	isHypertable, err := isMetricValueHypertable(db)
+	if err != nil {
+		return err
+	}
+
+	if !isHypertable {
+		if err := migrateToHypertable(log, db, params.ChunkInterval); err != nil {
+			return err
+		}
+	}
+
+	if params.CompressAfter > 0 {
+		if err := enableCompression(db, params.CompressAfter); err != nil {
+			return err
+		}
+	}
+
+	if params.ContinuousAggregates {
+		if err := createContinuousAggregates(db); err != nil {
+			return err
+		}
+	}
+
+	log.Info("TimescaleDB storage initialized", "chunk_interval", params.ChunkInterval,
+		"compress_after", params.CompressAfter, "continuous_aggregates", params.ContinuousAggregates)
+	return nil
+}
+
+// TimescaleInUse reports whether metric_value is already a hypertable, for collectors
+// starting without InitTimescale
+func TimescaleInUse(db *sql.DB) (bool, error) {
+	var installed bool
+	err := db.QueryRow(`select exists (select 1 from pg_extension where extname = 'timescaledb')`).Scan(&installed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check timescaledb extension: %w", err)
+	}
+	if !installed {
+		return false, nil
+	}
+	return isMetricValueHypertable(db)
+}
+
+func isMetricValueHypertable(db *sql.DB) (bool, error) {
+	var isHypertable bool
+	err := db.QueryRow(`
+		select exists (
+			select 1 from timescaledb_information.hypertables
+			where hypertable_schema = current_schema() and hypertable_name = 'metric_value'
+		)`).Scan(&isHypertable)
+	if err != nil {
+		return false, fmt.Errorf("failed to check metric_value hypertable: %w", err)
+	}
+	return isHypertable, nil
+}
+
+// migrateToHypertable replaces partitioned metric_value table with a hypertable of the same structure
+func migrateToHypertable(log *logger.Logger, db *sql.DB, chunkInterval time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin hypertable migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`alter table metric_value rename to metric_value_partitioned`,
+		`alter table metric_value_partitioned rename constraint pk_metric_value to pk_metric_value_partitioned`,
+		`create table metric_value (like metric_value_partitioned including defaults)`,
+		`alter table metric_value add constraint pk_metric_value primary key (server_id, metric_id, database_name, time)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to migrate metric_value to hypertable: %w", err)
+		}
+	}
+
+	// Chunk interval is passed as seconds to avoid interval formatting differences
+	_, err = tx.Exec(`select create_hypertable('metric_value', 'time',
+		chunk_time_interval => make_interval(secs => $1), if_not_exists => true)`, chunkInterval.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to create metric_value hypertable: %w", err)
+	}
+
+	result, err := tx.Exec(`insert into metric_value select * from metric_value_partitioned`)
+	if err != nil {
+		return fmt.Errorf("failed to copy metric values into hypertable: %w", err)
+	}
+	copied, _ := result.RowsAffected()
+
+	if _, err := tx.Exec(`drop table metric_value_partitioned`); err != nil {
+		return fmt.Errorf("failed to drop partitioned metric_value table: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit hypertable migration: %w", err)
+	}
+
+	log.Info("metric_value converted to hypertable", "copied_rows", copied)
+	return nil
+}
+
+// enableCompression enables native compression segmented by series and adds compression policy
+func enableCompression(db *sql.DB, compressAfter time.Duration) error {
+	_, err := db.Exec(`alter table metric_value set (
+		timescaledb.compress,
+		timescaledb.compress_segmentby = 'server_id, metric_id, database_name',
+		timescaledb.compress_orderby = 'time desc'
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to enable metric_value compression: %w", err)
+	}
+
+	_, err = db.Exec(`select add_compression_policy('metric_value', make_interval(secs => $1), if_not_exists => true)`,
+		compressAfter.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to add metric_value compression policy: %w", err)
+	}
+	return nil
+}
+
+// continuousAggregates defines hourly and daily rollups of numeric 'value' keys
+var continuousAggregates = []struct {
+	name           string
+	bucket         string
+	startOffset    string
+	endOffset      string
+	schedulePeriod string
+}{
+	{name: "metric_value_hourly", bucket: "1 hour", startOffset: "3 days", endOffset: "1 hour", schedulePeriod: "30 minutes"},
+	{name: "metric_value_daily", bucket: "1 day", startOffset: "30 days", endOffset: "1 day", schedulePeriod: "12 hours"},
+}
+
+// createContinuousAggregates creates rollup views with refresh policies
+func createContinuousAggregates(db *sql.DB) error {
+	for _, aggregate := range continuousAggregates {
+		createSQL := fmt.Sprintf(`
+			create materialized view if not exists %s
+			with (timescaledb.continuous) as
+			select
+				time_bucket(interval '%s', time) as bucket,
+				server_id,
+				metric_id,
+				database_name,
+				min((metric_value->>'value')::double precision) as min_value,
+				max((metric_value->>'value')::double precision) as max_value,
+				avg((metric_value->>'value')::double precision) as avg_value,
+				count(*) as value_count
+			from metric_value
+			where jsonb_typeof(metric_value->'value') = 'number'
+			group by bucket, server_id, metric_id, database_name
+			with no data`, aggregate.name, aggregate.bucket)
+		if _, err := db.Exec(createSQL); err != nil {
+			return fmt.Errorf("failed to create continuous aggregate %s: %w", aggregate.name, err)
+		}
+
+		_, err := db.Exec(`select add_continuous_aggregate_policy($1::regclass,
+			start_offset => $2::interval, end_offset => $3::interval,
+			schedule_interval => $4::interval, if_not_exists => true)`,
+			aggregate.name, aggregate.startOffset, aggregate.endOffset, aggregate.schedulePeriod)
+		if err != nil {
+			return fmt.Errorf("failed to add refresh policy for %s: %w", aggregate.name, err)
+		}
+	}
+	return nil
+}
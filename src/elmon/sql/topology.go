@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"elmon/logger"
+	"fmt"
+	"time"
+)
+
+// Server roles reported by GetServerRole
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// GetServerRole detects whether the server is a primary or a streaming replica
+func GetServerRole(db *sql.DB, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var inRecovery bool
+	if err := db.QueryRowContext(ctx, "select pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return "", fmt.Errorf("failed to detect server role: %w", err)
+	}
+
+	if inRecovery {
+		return RoleReplica, nil
+	}
+	return RolePrimary, nil
+}
+
+// InsertServerRoleChange records detected server role in server_role_history table
+func InsertServerRoleChange(ctx context.Context, log *logger.Logger, db *sql.DB, serverId int, clusterName string,
+	role string, previousRole string) error {
+	const insertSQL = `
+		insert into server_role_history (time, server_id, cluster_name, role, previous_role)
+		values (now(), $1, $2, $3, nullif($4, ''));
+	`
+
+	if _, err := db.ExecContext(ctx, insertSQL, serverId, clusterName, role, previousRole); err != nil {
+		log.Error(err, fmt.Sprintf("failed to insert server role change: serverId=%d, role=%s", serverId, role))
+		return err
+	}
+
+	return nil
+}
@@ -1,37 +1,56 @@
 package sql
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
 
 // ConnectionParams defines parameters required exclusively for database connection
 type ConnectionParams struct {
 	Name                  string
+	Driver                string // postgres (default) or mysql
+	DSN                   string // Verbatim connection string, overrides composed one
 	Host                  string
 	Port                  int
 	User                  string
 	Password              string
+	Auth                  AuthParams // Token authentication instead of Password
 	DbName                string
 	SslMode               string
+	SslRootCert           string // CA certificate file
+	SslCert               string // Client certificate file
+	SslKey                string // Client private key file
+	KrbSrvName            string // Kerberos service name, lib/pq default: postgres
+	KrbSpn                string // Kerberos service principal name, overrides KrbSrvName
 	MaxOpenConnections    int
 	MaxIdleConnections    int
-	ConnectionMaxLifetime int // in seconds
-	ConnectionMaxIdleTime int // in seconds
+	ConnectionMaxLifetime int           // in seconds
+	ConnectionMaxIdleTime int           // in seconds
+	ApplicationName       string        // Session application name, e.g. elmon
+	StatementTimeout      time.Duration // Server-side statement timeout, 0 - server default
 }
 
 // ServerInfo contains complete server information for saving to metrics DB
 type ServerInfo struct {
 	Name        string
+	Driver      string
 	Environment string
 	Host        string
 	Port        int
 	SslMode     string
+	Timezone    string // timezone of the server clock, empty keeps the stored one, "UTC" for new servers
 	// This field is used to store ID after saving to database
 	ID *int
 }
 
 // MetricInfo represents a metric for saving to database
 type MetricInfo struct {
-	Name        string
-	Description string
+	Name           string
+	Description    string
+	Unit           string        // e.g. seconds or bytes, empty when the value has no unit
+	ValueType      string        // int, int64, float, string, bool or table
+	CollectionType string        // sql, go_func, command, http, expression or bundle
+	Interval       time.Duration // collection interval, servers-metrics-map may override it
 	// This field is used to store ID after saving to database
 	DbMetricID int
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"elmon/api"
+	"elmon/collector"
+	"elmon/config"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// taskInspector reports states of collector schedulers to the API
+type taskInspector struct {
+	collector *collector.Collector
+}
+
+// Tasks converts scheduler states to their API form
+func (inspector taskInspector) Tasks() []api.TaskState {
+	var tasks []api.TaskState
+	for _, state := range inspector.collector.State() {
+		task := api.TaskState{
+			Server:         state.ServerName,
+			Metric:         state.MetricName,
+			Database:       state.DatabaseName,
+			Running:        state.Running,
+			Paused:         state.Paused,
+			Interval:       state.Interval.String(),
+			ActiveRuns:     state.ActiveRuns,
+			PendingRuns:    state.PendingRuns,
+			CurrentTaskID:  state.CurrentTaskID,
+			SkippedRuns:    state.SkippedRuns,
+			FailedRuns:     state.FailedRuns,
+			Panics:         state.Panics,
+			Backoff:        state.Backoff,
+			LastDurationMs: float64(state.LastDuration.Microseconds()) / 1000,
+		}
+		if !state.LastRun.IsZero() {
+			task.LastRun = &state.LastRun
+		}
+		if state.LastError != nil {
+			task.LastError = state.LastError.Error()
+		}
+		if !state.NextRun.IsZero() {
+			task.NextRun = &state.NextRun
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// healthChecker reports metrics DB health checks to the API
+type healthChecker struct {
+	health *collector.MetricsDBHealth // nil without health checks
+}
+
+// Health converts the metrics DB state to its API form, the metrics DB is up without health checks
+func (checker healthChecker) Health() api.Health {
+	health := api.Health{Status: "ok", MetricsDB: api.MetricsDBHealth{Up: true}}
+	if checker.health == nil {
+		return health
+	}
+	state := checker.health.State()
+	health.MetricsDB = api.MetricsDBHealth{
+		Up:        state.Up,
+		Since:     &state.Since,
+		LastError: state.LastError,
+		Outages:   state.Outages,
+	}
+	if !state.Up {
+		health.Status = "unavailable"
+	}
+	return health
+}
+
+// statusCommand prints the state of scheduled tasks of a running collector. It talks to the control
+// socket, or to the API listen address when no socket is configured. The listen address requires the
+// token of ELMON_API_TOKEN when API authentication is enabled.
+func statusCommand(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	socket := flags.String("socket", "", "control socket of the collector, default: api.control-socket")
+	serverName := flags.String("server", "", "show tasks of this server only")
+	metricName := flags.String("metric", "", "show tasks of this metric only")
+	asJSON := flags.Bool("json", false, "print the API response as JSON")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	baseURL := "http://elmon"
+	if *socket == "" {
+		appConfig, err := config.Read(opts.configPath)
+		if err != nil {
+			stdlog.Fatalf("FATAL: %v", err)
+		}
+		*socket = appConfig.API.ControlSocket
+		if *socket == "" && appConfig.API.Listen == "" {
+			stdlog.Fatalf("FATAL: neither api.control-socket nor api.listen is configured")
+		}
+		if *socket == "" {
+			baseURL = "http://" + appConfig.API.Listen
+			if appConfig.API.TLS.CertFile != "" {
+				baseURL = "https://" + appConfig.API.Listen
+			}
+		}
+	}
+	if *socket != "" {
+		path := *socket
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	}
+
+	query := url.Values{}
+	if *serverName != "" {
+		query.Set("server", *serverName)
+	}
+	if *metricName != "" {
+		query.Set("metric", *metricName)
+	}
+	request, err := http.NewRequest(http.MethodGet, baseURL+"/api/tasks?"+query.Encode(), nil)
+	if err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+	if token := os.Getenv("ELMON_API_TOKEN"); token != "" && *socket == "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		stdlog.Fatalf("FATAL: failed to reach the collector: %v", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		stdlog.Fatalf("FATAL: failed to read the collector response: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		stdlog.Fatalf("FATAL: collector returned %s: %s", response.Status, body)
+	}
+	if *asJSON {
+		os.Stdout.Write(body)
+		return
+	}
+
+	var tasks []api.TaskState
+	if err := json.Unmarshal(body, &tasks); err != nil {
+		stdlog.Fatalf("FATAL: invalid collector response: %v", err)
+	}
+	printTasks(os.Stdout, tasks)
+}
+
+// printTasks writes tasks as an aligned table
+func printTasks(output io.Writer, tasks []api.TaskState) {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVER\tMETRIC\tDATABASE\tSTATE\tINTERVAL\tLAST RUN\tDURATION\tNEXT RUN\tSKIPPED\tPANICS\tLAST ERROR")
+	for _, task := range tasks {
+		state := "stopped"
+		switch {
+		case task.ActiveRuns > 0:
+			state = "running"
+		case task.Paused:
+			state = "paused"
+		case task.Backoff:
+			state = "backoff"
+		case task.Running:
+			state = "idle"
+		}
+		lastRun, duration, nextRun := "-", "-", "-"
+		if task.LastRun != nil {
+			lastRun = task.LastRun.Local().Format(time.DateTime)
+			duration = fmt.Sprintf("%.0fms", task.LastDurationMs)
+		}
+		if task.NextRun != nil {
+			nextRun = task.NextRun.Local().Format(time.DateTime)
+		}
+		database := task.Database
+		if database == "" {
+			database = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", task.Server, task.Metric, database, state,
+			task.Interval, lastRun, duration, nextRun, task.SkippedRuns, task.Panics, task.LastError)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"elmon/collector"
+	"elmon/config"
+	"elmon/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+	"sort"
+)
+
+// validationProblem is a single entry of validate-config report
+type validationProblem struct {
+	Section string `json:"section"` // config, sql-files or explain
+	Message string `json:"message"`
+}
+
+// validationReport collects every problem found by validate-config
+type validationReport struct {
+	ConfigFile string              `json:"config_file"`
+	Problems   []validationProblem `json:"problems"`
+}
+
+func (report *validationReport) add(section string, err error) {
+	report.Problems = append(report.Problems, validationProblem{Section: section, Message: err.Error()})
+}
+
+// print writes human readable report grouped by section
+func (report *validationReport) print() {
+	fmt.Printf("Configuration report for %s\n", report.ConfigFile)
+	for _, section := range []string{"config", "sql-files", "explain"} {
+		var messages []string
+		for _, problem := range report.Problems {
+			if problem.Section == section {
+				messages = append(messages, problem.Message)
+			}
+		}
+		if len(messages) == 0 {
+			fmt.Printf("  [OK]    %s\n", section)
+			continue
+		}
+		fmt.Printf("  [ERROR] %s: %d problem(s)\n", section, len(messages))
+		for _, message := range messages {
+			fmt.Printf("          - %s\n", message)
+		}
+	}
+	if len(report.Problems) == 0 {
+		fmt.Println("Configuration is valid")
+	} else {
+		fmt.Printf("%d problem(s) found\n", len(report.Problems))
+	}
+}
+
+// validateConfigCommand validates configuration, SQL files and optionally
+// metric SQL against target servers, and reports every problem at once
+func validateConfigCommand(args []string) {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	explain := flags.Bool("explain", false, "connect to servers and run EXPLAIN for every mapped SQL metric")
+	format := flags.String("format", "text", "report format: text or json")
+	flags.Parse(args)
+	if err := opts.apply(); err != nil {
+		stdlog.Fatalf("FATAL: %v", err)
+	}
+
+	report := &validationReport{ConfigFile: opts.configPath}
+
+	appConfig, err := config.Read(opts.configPath)
+	if err != nil {
+		report.add("config", err)
+	} else {
+		for _, problem := range appConfig.Check() {
+			report.add("config", problem)
+		}
+		checkSQLFiles(appConfig, report)
+	}
+
+	// EXPLAIN requires a consistent configuration to build tasks
+	if *explain && len(report.Problems) == 0 {
+		explainMetrics(opts, report)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		report.print()
+	}
+
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkSQLFiles verifies that every referenced SQL file exists and parses as a template
+func checkSQLFiles(appConfig *config.AppConfig, report *validationReport) {
+	for _, group := range appConfig.Metrics.MetricGroups {
+		for _, metric := range group.Metrics {
+			if metric.CollectionType != "sql" {
+				continue
+			}
+
+			files := []string{metric.SQLFile, metric.BackfillSQLFile}
+			drivers := make([]string, 0, len(metric.SQLFiles))
+			for driver := range metric.SQLFiles {
+				drivers = append(drivers, driver)
+			}
+			sort.Strings(drivers)
+			for _, driver := range drivers {
+				files = append(files, metric.SQLFiles[driver])
+			}
+			for _, versioned := range metric.SQLVersions {
+				files = append(files, versioned.SQLFile)
+			}
+
+			for _, file := range files {
+				if file == "" {
+					continue
+				}
+				if err := collector.CheckSQLFile(file); err != nil {
+					report.add("sql-files", fmt.Errorf("metric '%s': %w", metric.Name, err))
+					continue
+				}
+				if appConfig.Metrics.Global.RejectWriteSQL {
+					content, _ := sql.ReadScript(file)
+					if err := sql.CheckReadOnlySQL(string(content)); err != nil {
+						report.add("sql-files", fmt.Errorf("metric '%s': file '%s': %w", metric.Name, file, err))
+					}
+				}
+			}
+		}
+	}
+}
+
+// explainMetrics connects to every server and runs EXPLAIN for each mapped SQL metric
+func explainMetrics(opts *commonOptions, report *validationReport) {
+	app, err := newApplication(opts)
+	if err != nil {
+		report.add("explain", err)
+		return
+	}
+	defer app.close()
+
+	for _, srvCfg := range app.config.DBServers {
+		if err := app.connectServers(srvCfg.Name); err != nil {
+			report.add("explain", fmt.Errorf("server '%s': %w", srvCfg.Name, err))
+		}
+	}
+
+	for _, task := range app.buildMetricTasks(nil) {
+		if err := explainTask(task); err != nil {
+			report.add("explain", fmt.Errorf("server '%s' metric '%s' database '%s': %w",
+				task.ServerName, task.MetricName, task.DatabaseName, err))
+		}
+	}
+}
+
+// explainTask runs EXPLAIN for SQL of the task, other collection types are not checked
+func explainTask(task *collector.MetricTask) error {
+	// PgBouncer admin console has no EXPLAIN
+	if task.CollectionType != "sql" || task.Driver == sql.DriverPgBouncer {
+		return nil
+	}
+	return sql.ExplainScript(task.TargetDB, task.SQLScript, task.QueryTimeout)
+}
+
+// explainTasks checks SQL of tasks with EXPLAIN before they are scheduled, so syntax and permission
+// errors are reported at once instead of at the first run. Depending on explain-on-start failed tasks
+// are logged and kept (warn), dropped (skip) or fail the start (fail).
+func (app *application) explainTasks(tasks []*collector.MetricTask) ([]*collector.MetricTask, error) {
+	mode := app.config.Metrics.Global.ExplainOnStart
+	if mode == "off" || mode == "" {
+		return tasks, nil
+	}
+
+	var checked []*collector.MetricTask
+	failed := 0
+	for _, task := range tasks {
+		if err := explainTask(task); err != nil {
+			failed++
+			task.Logger.Error(err, "Metric SQL failed EXPLAIN", "file", task.SQLFile)
+			if mode == "skip" {
+				continue
+			}
+		}
+		checked = append(checked, task)
+	}
+	app.log.Info("Metric SQL checked with EXPLAIN", "task_count", len(tasks), "failed_count", failed)
+	if mode == "fail" && failed > 0 {
+		return nil, fmt.Errorf("SQL of %d metric tasks failed EXPLAIN", failed)
+	}
+	return checked, nil
+}
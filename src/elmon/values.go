@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"elmon/api"
+	"elmon/sql"
+	"errors"
+	"fmt"
+)
+
+// valueReader reads collected data of the metrics DB for the API
+type valueReader struct {
+	storage sql.Storage
+}
+
+// Values returns values of a metric of a server in the time range of the query
+func (reader valueReader) Values(ctx context.Context, query api.ValueQuery) ([]api.MetricValue, error) {
+	stored, err := sql.ReadMetricValues(ctx, reader.storage.DB(), sql.ValueQuery{
+		ServerName:   query.Server,
+		MetricName:   query.Metric,
+		DatabaseName: query.Database,
+		From:         query.From,
+		To:           query.To,
+		Limit:        query.Limit,
+		Descending:   query.Latest,
+	})
+	if err != nil {
+		return nil, readError(err)
+	}
+	var values []api.MetricValue
+	for _, value := range stored {
+		values = append(values, api.MetricValue{
+			Time:     value.Time,
+			Database: value.DatabaseName,
+			Value:    value.Value,
+			Labels:   value.Labels,
+		})
+	}
+	return values, nil
+}
+
+// LatestValues returns values of metrics with storage-mode latest of a server
+func (reader valueReader) LatestValues(ctx context.Context, server string, metric string) ([]api.MetricValue, error) {
+	stored, err := sql.ReadLatestValues(ctx, reader.storage.DB(), server, metric)
+	if err != nil {
+		return nil, readError(err)
+	}
+	var values []api.MetricValue
+	for _, value := range stored {
+		values = append(values, api.MetricValue{
+			Time:     value.Time,
+			Metric:   value.MetricName,
+			Database: value.DatabaseName,
+			Value:    value.Value,
+			Labels:   value.Labels,
+		})
+	}
+	return values, nil
+}
+
+// Metrics returns metrics registered in the metrics DB
+func (reader valueReader) Metrics(ctx context.Context) ([]api.StoredMetric, error) {
+	stored, err := sql.ReadMetrics(ctx, reader.storage.DB())
+	if err != nil {
+		return nil, err
+	}
+	var metrics []api.StoredMetric
+	for _, metric := range stored {
+		metrics = append(metrics, api.StoredMetric{
+			Name:            metric.Name,
+			Group:           metric.Group,
+			Description:     metric.Description,
+			Unit:            metric.Unit,
+			ValueType:       metric.ValueType,
+			CollectionType:  metric.CollectionType,
+			IntervalSeconds: metric.Interval.Seconds(),
+			Active:          metric.Active,
+		})
+	}
+	return metrics, nil
+}
+
+// Servers returns servers registered in the metrics DB
+func (reader valueReader) Servers(ctx context.Context) ([]api.StoredServer, error) {
+	stored, err := sql.ReadServers(ctx, reader.storage.DB())
+	if err != nil {
+		return nil, err
+	}
+	var servers []api.StoredServer
+	for _, server := range stored {
+		servers = append(servers, api.StoredServer{
+			Name:        server.Name,
+			Environment: server.Environment,
+			Host:        server.Host,
+			Port:        server.Port,
+			Driver:      server.Driver,
+			Timezone:    server.Timezone,
+			Active:      server.Active,
+		})
+	}
+	return servers, nil
+}
+
+// readError reports servers and metrics missing from the metrics DB as not found
+func readError(err error) error {
+	if errors.Is(err, sql.ErrUnknownName) {
+		return fmt.Errorf("%w: %w", api.ErrNotFound, err)
+	}
+	return err
+}